@@ -1,18 +1,30 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
 
 	"shrmpl"
 )
 
+// envOr returns the environment variable name, or def when it is unset.
+// The shrmpltest harness sets these to point the example at fake servers.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
 func main() {
-	fmt.Println("=== Shrmpl Client Library Example ===\n")
+	fmt.Print("=== Shrmpl Client Library Example ===\n\n")
 
 	// KV Server Example
 	fmt.Println("1. KV Server Example:")
 	config := &shrmpl.KVConfig{
-		HostPort: "127.0.0.1:7171",
+		HostPort: envOr("SHRMPL_KV_ADDR", "127.0.0.1:7171"),
 	}
 	kv := shrmpl.NewKV(config)
 	defer kv.Close()
@@ -47,7 +59,13 @@ func main() {
 	fmt.Println("   Testing BATCH operations:")
 	batchResults, err := kv.Batch([]string{"GET example_key", "GET counter"})
 	if err == nil {
-		fmt.Printf("   ✓ BATCH GET results: %v\n", batchResults)
+		for _, r := range batchResults {
+			if r.Err != nil {
+				fmt.Printf("   ✗ BATCH %s failed: %v\n", r.Command, r.Err)
+			} else {
+				fmt.Printf("   ✓ BATCH %s = %s\n", r.Command, r.Value)
+			}
+		}
 	} else {
 		fmt.Printf("   ✗ BATCH failed: %v\n", err)
 	}
@@ -55,15 +73,24 @@ func main() {
 	// Note: Advanced client features (reconnection, connection pooling) are
 	// used internally by the KVClient for robust operation
 
-	// Note: LIST operation not available in advanced KV interface
-	fmt.Println("   (LIST operation not available in this example)")
+	// List is an optional operation; the client from NewKV implements
+	// every optional interface, such as KVLister
+	items, err := kv.(shrmpl.KVLister).List()
+	if err == nil {
+		fmt.Printf("   ✓ LIST returned %d keys\n", len(items))
+		for _, item := range items {
+			fmt.Printf("     %s = %s (expires %s)\n", item.Key, item.Value, item.Expiration)
+		}
+	} else {
+		fmt.Printf("   ✗ LIST failed: %v\n", err)
+	}
 
 	kv.Close()
 	fmt.Println()
 
 	// Log Server Example
 	fmt.Println("2. Log Server Example:")
-	logger := shrmpl.NewLogger("example-server-name", "127.0.0.1:7379")
+	logger := shrmpl.NewLogger("example-server-name", envOr("SHRMPL_LOG_ADDR", "127.0.0.1:7379"))
 	defer logger.Close()
 
 	fmt.Println("   ✓ Connected to Log server (connection handled internally)")
@@ -81,11 +108,21 @@ func main() {
 	// Vault Server Example
 	fmt.Println("3. Vault Server Example:")
 	vault := shrmpl.NewVaultClient(
-		"https://127.0.0.1:7474",
-		"/path/to/client.crt",
-		"/path/to/client.key",
-		"example_secret",
+		envOr("SHRMPL_VAULT_URL", "https://127.0.0.1:7474"),
+		envOr("SHRMPL_VAULT_CERT", "/path/to/client.crt"),
+		envOr("SHRMPL_VAULT_KEY", "/path/to/client.key"),
+		envOr("SHRMPL_VAULT_SECRET", "example_secret"),
 	)
+	if caPath := os.Getenv("SHRMPL_VAULT_CA"); caPath != "" {
+		ca, err := os.ReadFile(caPath)
+		if err != nil {
+			fmt.Printf("   ✗ Reading CA failed: %v\n", err)
+			os.Exit(1)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca)
+		vault.SetTLSConfig(&tls.Config{RootCAs: roots})
+	}
 
 	success, err := vault.Connect()
 	if !success {
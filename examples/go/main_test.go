@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"shrmpl/shrmpltest"
+)
+
+// buildExample builds the example program into a temporary directory
+func buildExample(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "example")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestExampleAgainstHarness(t *testing.T) {
+	bin := buildExample(t)
+	h := shrmpltest.Start()
+	defer h.Close()
+	h.Vault.SetFile("example-config-file", "example content")
+
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), h.Env()...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("example: %v\n%s", err, out)
+	}
+
+	output := string(out)
+	if strings.Contains(output, "✗") {
+		t.Errorf("example reported a failure:\n%s", output)
+	}
+	for _, want := range []string{
+		"✓ SET example_key = example_value (30s TTL)",
+		"✓ GET example_key = example_value",
+		"✓ INCR counter = 1",
+		"✓ BATCH GET example_key = example_value",
+		"✓ BATCH GET counter = 1",
+		"✓ LIST returned 2 keys",
+		"✓ Sent ERROR log message with structured data",
+		"✓ Connected to Vault server",
+		"✓ Retrieved config file",
+		"Content: example content",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+	if _, err := h.Log.WaitFrames(2, time.Second); err != nil {
+		t.Errorf("log server: %v", err)
+	}
+}
+
+func TestExampleExitsOnUnreadableCA(t *testing.T) {
+	bin := buildExample(t)
+	h := shrmpltest.Start()
+	defer h.Close()
+
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), h.Env()...)
+	cmd.Env = append(cmd.Env, "SHRMPL_VAULT_CA="+filepath.Join(t.TempDir(), "missing.pem"))
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("example = %v, want exit status 1\n%s", err, out)
+	}
+	if output := string(out); !strings.Contains(output, "✗ Reading CA failed") ||
+		strings.Contains(output, "Connected to Vault server") {
+		t.Errorf("example went on after the CA failed to load:\n%s", output)
+	}
+}
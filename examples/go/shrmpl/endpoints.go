@@ -0,0 +1,78 @@
+package shrmpl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Endpoints holds the server addresses of the three clients, as parsed by
+// ParseEndpoints. A service missing from the string is left empty.
+type Endpoints struct {
+	// KV is the KVConfig.HostPort of shrmpl-kv
+	KV string
+	// Log is the host:port of shrmpl-log, for NewLogger or LoggerOptions.Addr
+	Log string
+	// Vault is the server URL of shrmpl-vault, for NewVaultClient
+	Vault string
+}
+
+// ParseEndpoints parses a combined endpoint string such as
+// "kv=host:7171,log=host:7379,vault=https://host:7474" and validates each
+// address: host:port with a numeric port for kv and log, an http or https
+// URL with a host for vault. Services may appear in any order and be left
+// out, but not repeated; unknown services are errors, so a typo is caught
+// at startup rather than leaving a client unconfigured.
+func ParseEndpoints(s string) (Endpoints, error) {
+	var e Endpoints
+	if strings.TrimSpace(s) == "" {
+		return e, fmt.Errorf("no endpoints given")
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		name, addr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		name, addr = strings.TrimSpace(name), strings.TrimSpace(addr)
+		if !ok || name == "" || addr == "" {
+			return Endpoints{}, fmt.Errorf("invalid endpoint %q: want service=address", part)
+		}
+		if seen[name] {
+			return Endpoints{}, fmt.Errorf("duplicate endpoint %s", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "kv":
+			_, _, err = parseAddr(addr)
+			e.KV = addr
+		case "log":
+			_, _, err = parseAddr(addr)
+			e.Log = addr
+		case "vault":
+			err = checkVaultURL(addr)
+			e.Vault = addr
+		default:
+			return Endpoints{}, fmt.Errorf("unknown endpoint %s (kv, log or vault)", name)
+		}
+		if err != nil {
+			return Endpoints{}, fmt.Errorf("%s endpoint: %w", name, err)
+		}
+	}
+	return e, nil
+}
+
+// checkVaultURL reports whether serverURL is usable as a vault server URL
+func checkVaultURL(serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid vault URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("invalid vault URL %q: scheme must be https or http", serverURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid vault URL %q: missing host", serverURL)
+	}
+	return nil
+}
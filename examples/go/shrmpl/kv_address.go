@@ -0,0 +1,49 @@
+package shrmpl
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// parseAddr splits hostPort into a host and numeric port
+func parseAddr(hostPort string) (string, int, error) {
+	host, portStr, err := parseHostPort(hostPort)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port: %s", portStr)
+	}
+	return host, port, nil
+}
+
+// UpdateAddress repoints the client at hostPort, e.g. for a blue/green
+// cutover. The new connection is established while requests continue on
+// the old one, then swapped in once the in-flight request finishes; the
+// old connection is closed afterwards. If hostPort cannot be reached the
+// error is returned and the current connection is left untouched.
+func (kv *KV) UpdateAddress(hostPort string) error {
+	host, port, err := parseAddr(hostPort)
+	if err != nil {
+		return err
+	}
+
+	client := kv.newClient(host, port)
+	caps, err := kv.dial(client)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	kv.resubscribe(client)
+	old := kv.shrmplKVClient
+	kv.shrmplKVClient = client
+	kv.hostPort = hostPort
+	kv.setCapabilities(caps)
+	kv.markConnected()
+	kv.mu.Unlock()
+
+	old.Close()
+	return nil
+}
@@ -0,0 +1,52 @@
+package shrmpl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+)
+
+// SetDrainBatchErrors keeps the connection after a BATCH response that
+// failed without losing its framing. A response over the aggregate limit
+// has the rest of its line read and discarded instead of closing the
+// connection, and a response with the wrong number of results, already
+// read in full, no longer counts as a connection failure. Errors that
+// leave the response in doubt, such as timeouts, still close it. Off by
+// default; KV enables it with KVConfig.DrainBatchErrors.
+func (c *ShrmplKVClient) SetDrainBatchErrors(enabled bool) {
+	c.drainBatchErrors = enabled
+}
+
+// batchCountError is returned when a BATCH or EXEC response does not have
+// one result per command. The response line was read in full.
+type batchCountError struct {
+	got, want int
+}
+
+func (e *batchCountError) Error() string {
+	return fmt.Sprintf("batch returned %d results for %d commands", e.got, e.want)
+}
+
+// drainLine reads and discards the rest of a response line cut off at
+// the aggregate limit, within the operation's read deadline. Nothing is
+// left when the line's end was already buffered, or when the background
+// reader received it whole.
+func (c *ShrmplKVClient) drainLine() error {
+	if !c.lineCut {
+		return nil
+	}
+	for {
+		_, err := c.reader.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// batchDrained reports whether err from a BATCH left the connection in
+// sync because drainBatchErrors kept it
+func (c *ShrmplKVClient) batchDrained(err error) bool {
+	var countErr *batchCountError
+	return c.drainBatchErrors && c.conn != nil &&
+		(errors.Is(err, ErrResponseTooLarge) || errors.As(err, &countErr))
+}
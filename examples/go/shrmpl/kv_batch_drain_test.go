@@ -0,0 +1,61 @@
+package shrmpl_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestBatchTooLargeDrainOrClose(t *testing.T) {
+	// 90-byte values leave the line's end buffered with the cut; 9000-byte
+	// values are longer than the read buffer, so the rest must be read
+	for _, size := range []int{90, 9000} {
+		for _, drain := range []bool{false, true} {
+			name := "close"
+			if drain {
+				name = "drain"
+			}
+			t.Run(fmt.Sprintf("%s/%d", name, size), func(t *testing.T) {
+				srv := shrmpltest.NewKVServer()
+				defer srv.Close()
+				value := strings.Repeat("v", size)
+				for _, key := range []string{"a", "b", "c"} {
+					srv.Put(key, value)
+				}
+				srv.Put("k", "small")
+
+				cfg := srv.Config()
+				cfg.MaxAggregateResponseBytes = 200
+				cfg.DrainBatchErrors = drain
+				kv := shrmpl.NewKV(cfg)
+				defer kv.Close()
+
+				_, err := kv.Batch([]string{"GET a", "GET b", "GET c"})
+				var tooLarge *shrmpl.ResponseTooLargeError
+				if !errors.As(err, &tooLarge) || !errors.Is(err, shrmpl.ErrResponseTooLarge) {
+					t.Fatalf("Batch = %v, want a *ResponseTooLargeError", err)
+				}
+				if tooLarge.Command != "BATCH" || tooLarge.Limit != 200 {
+					t.Errorf("ResponseTooLargeError = %+v", tooLarge)
+				}
+
+				// The next command reads its own response, on the same
+				// connection only when the rest of the batch was drained
+				if got, err := kv.Get("k"); err != nil || got != "small" {
+					t.Fatalf("Get after the batch = %q, %v; want small", got, err)
+				}
+				want := 2
+				if drain {
+					want = 1
+				}
+				if dials := srv.Dials(); dials != want {
+					t.Errorf("dials = %d, want %d", dials, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package shrmpl
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// binaryMarker prefixes values stored by SetBytes, so GetBytes can tell
+// them from text. Like compressedMarker it is not negotiated with the
+// server; other readers see the encoded form.
+const binaryMarker = "~b64~"
+
+// maxValueLength is the longest value the server stores unless it
+// advertises otherwise; see KVLimits
+const maxValueLength = 100
+
+// MaxBinaryValueBytes is the largest value SetBytes can store under the
+// default limit: its base64 encoding and marker fill the 100-character
+// value limit
+const MaxBinaryValueBytes = (maxValueLength - len(binaryMarker)) * 3 / 4
+
+// ErrNotBinary is returned by GetBytes and DecodeBytes for a value that
+// was not stored by SetBytes or EncodeBytes
+var ErrNotBinary = errors.New("value is not binary")
+
+// EncodeBytes returns the text form SetBytes stores for value: the marker
+// followed by unpadded base64url. Use it to write binary values with
+// Batch or a transaction, e.g. "SET blob " + EncodeBytes(data).
+func EncodeBytes(value []byte) string {
+	return binaryMarker + base64.RawURLEncoding.EncodeToString(value)
+}
+
+// DecodeBytes reverses EncodeBytes, returning ErrNotBinary for an unmarked
+// value
+func DecodeBytes(value string) ([]byte, error) {
+	encoded, found := strings.CutPrefix(value, binaryMarker)
+	if !found {
+		return nil, ErrNotBinary
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary value: %w", err)
+	}
+	return data, nil
+}
+
+// encodeBytes encodes value for SetBytes, checking the encoded length
+// against the value limit
+func encodeBytes(value []byte, limit int) (string, error) {
+	encoded := EncodeBytes(value)
+	if len(encoded) > limit {
+		return "", fmt.Errorf("binary value of %d bytes encodes to %d characters, "+
+			"exceeding the %d-character value limit (at most %d bytes fit)",
+			len(value), len(encoded), limit, (limit-len(binaryMarker))*3/4)
+	}
+	return encoded, nil
+}
+
+// SetBytes stores a binary value, base64-encoded behind a marker since the
+// protocol is text only. Values whose encoding exceeds the value limit,
+// MaxBinaryValueBytes by default, are rejected before anything is sent.
+func (c *ShrmplKVClient) SetBytes(key string, value []byte, ttl string) error {
+	encoded, err := encodeBytes(value, c.Limits().MaxValueLength)
+	if err != nil {
+		return err
+	}
+	return c.Set(key, encoded, ttl)
+}
+
+// GetBytes retrieves a value stored by SetBytes. A missing key yields nil,
+// as Get yields "", and a text value yields an error wrapping ErrNotBinary
+// rather than its bytes.
+func (c *ShrmplKVClient) GetBytes(key string) ([]byte, error) {
+	value, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(key, value)
+}
+
+// decodeStored decodes the value stored under key; "" is a missing key
+func decodeStored(key, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := DecodeBytes(value)
+	if errors.Is(err, ErrNotBinary) {
+		return nil, fmt.Errorf("%w: %s", ErrNotBinary, key)
+	}
+	return data, err
+}
+
+// SetBytes stores a binary value; see ShrmplKVClient.SetBytes
+func (kv *KV) SetBytes(key string, value []byte, ttl string) error {
+	encoded, err := encodeBytes(value, kv.Limits().MaxValueLength)
+	if err != nil {
+		return err
+	}
+	return kv.Set(key, encoded, ttl)
+}
+
+// GetBytes retrieves a value stored by SetBytes; see
+// ShrmplKVClient.GetBytes
+func (kv *KV) GetBytes(key string) ([]byte, error) {
+	value, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(key, value)
+}
+
+// Bytes decodes the result of a GET within a Batch as a value stored by
+// SetBytes. It returns r.Err if the command failed, nil for a missing key
+// and an error wrapping ErrNotBinary for a text value.
+func (r BatchResult) Bytes() ([]byte, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return decodeStored(batchKey(r.Command), r.Value)
+}
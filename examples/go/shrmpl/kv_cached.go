@@ -0,0 +1,250 @@
+package shrmpl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedKV wraps KV with a local read cache. Entries are dropped as soon
+// as the server pushes an invalidation for the key, and after maxAge in
+// any case so that servers without SUBSCRIBE support still converge.
+type CachedKV struct {
+	*KV
+	maxAge time.Duration
+	prefix string
+
+	cacheMu sync.Mutex
+	cache   map[string]cachedValue
+	fetches map[string]*cacheFetch
+}
+
+// cacheFetch tracks the GETs of a key in flight; invalidate bumps gen so
+// that a fetch which started earlier does not store a stale value
+type cacheFetch struct {
+	refs int
+	gen  uint64
+}
+
+// cachedValue is a locally cached GET result
+type cachedValue struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewCachedKV creates a cached key-value client for keys starting with
+// prefix (all keys when empty). The returned error reports whether the
+// invalidation subscription failed; the client is usable either way. If
+// the subscription cannot be restored after a reconnect the cache is
+// cleared before config's OnResubscribeError is called.
+func NewCachedKV(config *KVConfig, prefix string,
+	maxAge time.Duration) (*CachedKV, error) {
+	c := &CachedKV{
+		maxAge:  maxAge,
+		prefix:  prefix,
+		cache:   make(map[string]cachedValue),
+		fetches: make(map[string]*cacheFetch),
+	}
+	cfg := *config
+	onResubscribeError := cfg.OnResubscribeError
+	cfg.OnResubscribeError = func(prefix string, err error) {
+		// Without invalidations the cached values cannot be trusted
+		c.clear()
+		if onResubscribeError != nil {
+			onResubscribeError(prefix, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to %s\n", err.Error())
+		}
+	}
+	c.KV = NewKV(&cfg).(*KV)
+	err := c.KV.Subscribe(prefix, c.invalidate)
+	return c, err
+}
+
+// invalidate removes key from the local cache and discards the result of
+// any fetch of it already in flight
+func (c *CachedKV) invalidate(key string) {
+	c.cacheMu.Lock()
+	delete(c.cache, key)
+	if fetch := c.fetches[key]; fetch != nil {
+		fetch.gen++
+	}
+	c.cacheMu.Unlock()
+}
+
+// clear removes every key from the local cache
+func (c *CachedKV) clear() {
+	c.cacheMu.Lock()
+	c.cache = make(map[string]cachedValue)
+	for _, fetch := range c.fetches {
+		fetch.gen++
+	}
+	c.cacheMu.Unlock()
+}
+
+// Get returns the cached value for key, fetching it on a miss
+func (c *CachedKV) Get(key string) (string, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext returns the cached value for key, fetching it under ctx on a
+// miss
+func (c *CachedKV) GetContext(ctx context.Context, key string) (string, error) {
+	c.cacheMu.Lock()
+	entry, ok := c.cache[key]
+	if ok && time.Now().Before(entry.expiresAt) {
+		c.cacheMu.Unlock()
+		return entry.value, nil
+	}
+	fetch := c.fetches[key]
+	if fetch == nil {
+		fetch = &cacheFetch{}
+		c.fetches[key] = fetch
+	}
+	fetch.refs++
+	gen := fetch.gen
+	c.cacheMu.Unlock()
+
+	value, err := c.KV.GetContext(ctx, key)
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	if fetch.refs--; fetch.refs == 0 {
+		delete(c.fetches, key)
+	}
+	if err != nil {
+		return "", err
+	}
+	// A write or invalidation since the fetch started may have made the
+	// value stale; return it but leave the cache to the next Get
+	if fetch.gen == gen {
+		c.cache[key] = cachedValue{value: value, expiresAt: time.Now().Add(c.maxAge)}
+	}
+	return value, nil
+}
+
+// Set stores a key-value pair and drops the local copy
+func (c *CachedKV) Set(key, value, ttl string) error {
+	c.invalidate(key)
+	return c.KV.Set(key, value, ttl)
+}
+
+// SetContext stores a key-value pair under ctx and drops the local copy
+func (c *CachedKV) SetContext(ctx context.Context, key, value, ttl string) error {
+	c.invalidate(key)
+	return c.KV.SetContext(ctx, key, value, ttl)
+}
+
+// SetReturning stores a key-value pair and drops the local copy
+func (c *CachedKV) SetReturning(key, value, ttl string) (string, error) {
+	c.invalidate(key)
+	return c.KV.SetReturning(key, value, ttl)
+}
+
+// SetOpts stores a key-value pair according to opts and drops the local
+// copy
+func (c *CachedKV) SetOpts(key, value string, opts SetOptions) (string, bool, error) {
+	c.invalidate(key)
+	return c.KV.SetOpts(key, value, opts)
+}
+
+// SetAndVerify stores a key-value pair, reads it back from the server and
+// drops the local copy
+func (c *CachedKV) SetAndVerify(key, value, ttl string) error {
+	c.invalidate(key)
+	return c.KV.SetAndVerify(key, value, ttl)
+}
+
+// SetBytes stores a binary value and drops the local copy
+func (c *CachedKV) SetBytes(key string, value []byte, ttl string) error {
+	c.invalidate(key)
+	return c.KV.SetBytes(key, value, ttl)
+}
+
+// Import sets the keys of an export read from r and then clears the
+// local cache, since any of them may have been cached
+func (c *CachedKV) Import(r io.Reader) (int, error) {
+	n, err := c.KV.Import(r)
+	c.clear()
+	return n, err
+}
+
+// CompareAndSwap swaps the value of key and drops the local copy
+func (c *CachedKV) CompareAndSwap(key, oldValue, newValue, ttl string) (bool, error) {
+	c.invalidate(key)
+	return c.KV.CompareAndSwap(key, oldValue, newValue, ttl)
+}
+
+// Update performs a read-modify-write of key against the server, never the
+// local copy, and drops the local copy
+func (c *CachedKV) Update(key string, ttl string,
+	fn func(current string, exists bool) (string, error)) error {
+	c.invalidate(key)
+	return c.KV.Update(key, ttl, fn)
+}
+
+// Incr increments a counter and drops the local copy
+func (c *CachedKV) Incr(key string, ttl string) (int, error) {
+	c.invalidate(key)
+	return c.KV.Incr(key, ttl)
+}
+
+// IncrContext increments a counter under ctx and drops the local copy
+func (c *CachedKV) IncrContext(ctx context.Context, key string, ttl string) (int, error) {
+	c.invalidate(key)
+	return c.KV.IncrContext(ctx, key, ttl)
+}
+
+// Batch executes commands and drops the local copy of any key they modify
+func (c *CachedKV) Batch(commands []string) ([]BatchResult, error) {
+	c.invalidateBatch(commands)
+	return c.KV.Batch(commands)
+}
+
+// BatchContext executes commands under ctx and drops the local copy of
+// any key they modify
+func (c *CachedKV) BatchContext(ctx context.Context, commands []string) ([]BatchResult, error) {
+	c.invalidateBatch(commands)
+	return c.KV.BatchContext(ctx, commands)
+}
+
+// invalidateBatch drops the local copy of every key modified by commands
+func (c *CachedKV) invalidateBatch(commands []string) {
+	for _, cmd := range commands {
+		parts := strings.Fields(cmd)
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "SET", "INCR", "DEL":
+			// Transactions report keys as sent, possibly hashed
+			c.invalidate(c.KV.originalKey(parts[1]))
+		}
+	}
+}
+
+// BatchValues executes commands, dropping the local copy of any key they
+// modify, and returns their raw values
+func (c *CachedKV) BatchValues(commands []string) ([]string, error) {
+	c.invalidateBatch(commands)
+	return c.KV.BatchValues(commands)
+}
+
+// Txn starts a transaction that drops the local copy of every key it
+// modifies when executed
+func (c *CachedKV) Txn() *Txn {
+	txn := c.KV.Txn()
+	txn.onExec = c.invalidateBatch
+	return txn
+}
+
+// Close unsubscribes, clears the cache and closes the connection
+func (c *CachedKV) Close() {
+	_ = c.KV.Unsubscribe(c.prefix)
+	c.clear()
+	c.KV.Close()
+}
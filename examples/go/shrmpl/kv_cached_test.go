@@ -0,0 +1,264 @@
+package shrmpl
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptServer answers each line on each connection with respond(conn,
+// line), where conn counts connections from zero. drop closes the open
+// connections.
+type scriptServer struct {
+	Addr string
+
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newScriptServer(t *testing.T, respond func(conn int, line string) string) *scriptServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &scriptServer{Addr: ln.Addr().String(), ln: ln}
+	t.Cleanup(func() {
+		ln.Close()
+		s.drop()
+	})
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go func(n int, conn net.Conn) {
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					response := respond(n, strings.TrimSpace(line))
+					if _, err := conn.Write([]byte(response + "\n")); err != nil {
+						return
+					}
+				}
+			}(n, conn)
+		}
+	}()
+	return s
+}
+
+func (s *scriptServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func TestCachedKVDropsFetchInvalidatedInFlight(t *testing.T) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	gets := 0
+	srv := newScriptServer(t, func(_ int, line string) string {
+		switch {
+		case strings.HasPrefix(line, "SUBSCRIBE"):
+			return "OK"
+		case line == "GET k":
+			mu.Lock()
+			gets++
+			first := gets == 1
+			mu.Unlock()
+			if first {
+				close(fetching)
+				<-release
+				return "old"
+			}
+			return "new"
+		}
+		return "ERROR unknown command"
+	})
+
+	c, err := NewCachedKV(&KVConfig{HostPort: srv.Addr, Lazy: true}, "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	done := make(chan string)
+	go func() {
+		value, _ := c.Get("k")
+		done <- value
+	}()
+	<-fetching
+	// The server's invalidation arrives while the GET is in flight
+	c.invalidate("k")
+	close(release)
+	if value := <-done; value != "old" {
+		t.Fatalf("in-flight Get = %q, want old", value)
+	}
+
+	if value, err := c.Get("k"); err != nil || value != "new" {
+		t.Errorf("Get after the invalidation = %q, %v; want new from the server", value, err)
+	}
+	if len(c.fetches) != 0 {
+		t.Errorf("%d fetches still tracked", len(c.fetches))
+	}
+}
+
+func TestCachedKVClearsCacheWhenResubscribeFails(t *testing.T) {
+	srv := newScriptServer(t, func(conn int, line string) string {
+		switch {
+		case strings.HasPrefix(line, "SUBSCRIBE"):
+			if conn == 0 {
+				return "OK"
+			}
+			return "ERROR unknown command"
+		case line == "GET k":
+			if conn == 0 {
+				return "v1"
+			}
+			return "v2"
+		case strings.HasPrefix(line, "SET"):
+			return "OK"
+		}
+		return "ERROR unknown command"
+	})
+
+	failed := make(chan error, 1)
+	cfg := &KVConfig{HostPort: srv.Addr, Lazy: true,
+		OnResubscribeError: func(prefix string, err error) { failed <- err }}
+	c, err := NewCachedKV(cfg, "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if value, err := c.Get("k"); err != nil || value != "v1" {
+		t.Fatalf("Get = %q, %v; want v1", value, err)
+	}
+
+	srv.drop()
+	// The first write may see the broken connection; a later one reconnects
+	for i := 0; i < 3; i++ {
+		if c.KV.Set("x", "1", "") == nil {
+			break
+		}
+	}
+	select {
+	case err := <-failed:
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			t.Errorf("OnResubscribeError got %v, want the server's error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnResubscribeError was not called")
+	}
+	if value, err := c.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after the failed resubscribe = %q, %v; want v2 from the server", value, err)
+	}
+}
+
+func TestSubscribeReportsFailureOnFirstConnection(t *testing.T) {
+	srv := newScriptServer(t, func(_ int, line string) string {
+		return "ERROR unknown command"
+	})
+	kv := NewKV(&KVConfig{HostPort: srv.Addr, Lazy: true}).(*KV)
+	defer kv.Close()
+
+	var serverErr *ServerError
+	if err := kv.Subscribe("p", func(string) {}); !errors.As(err, &serverErr) {
+		t.Errorf("Subscribe = %v, want the server's error", err)
+	}
+	if _, ok := kv.subscriptions["p"]; ok {
+		t.Errorf("a rejected subscription is kept for reconnects")
+	}
+}
+
+// newStoreServer is a scriptServer keeping SET values in a map. It accepts
+// SUBSCRIBE but never pushes an invalidation, so a CachedKV only drops
+// its copy of a key it writes itself.
+func newStoreServer(t *testing.T) *scriptServer {
+	var mu sync.Mutex
+	values := make(map[string]string)
+	return newScriptServer(t, func(_ int, line string) string {
+		parts := strings.Fields(line)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case len(parts) <= 2 && parts[0] == "SUBSCRIBE":
+			return "OK"
+		case len(parts) == 2 && parts[0] == "GET":
+			if value, ok := values[parts[1]]; ok {
+				return value
+			}
+			return "*KEY NOT FOUND*"
+		case len(parts) >= 3 && parts[0] == "SET":
+			values[parts[1]] = parts[2]
+			return "OK"
+		}
+		return "ERROR unknown command"
+	})
+}
+
+// newStoreCachedKV returns a CachedKV with k cached as v1
+func newStoreCachedKV(t *testing.T) *CachedKV {
+	t.Helper()
+	srv := newStoreServer(t)
+	c, err := NewCachedKV(&KVConfig{HostPort: srv.Addr, Lazy: true}, "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if err := c.KV.Set("k", "v1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get("k"); err != nil || value != "v1" {
+		t.Fatalf("Get = %q, %v; want v1", value, err)
+	}
+	return c
+}
+
+func TestCachedKVSetAndVerifyInvalidates(t *testing.T) {
+	c := newStoreCachedKV(t)
+	if err := c.SetAndVerify("k", "v2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after SetAndVerify = %q, %v; want v2", value, err)
+	}
+}
+
+func TestCachedKVSetBytesInvalidates(t *testing.T) {
+	c := newStoreCachedKV(t)
+	data := []byte{0, 1, 2, 0xff}
+	if err := c.SetBytes("k", data, ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get("k"); err != nil || value != EncodeBytes(data) {
+		t.Errorf("Get after SetBytes = %q, %v; want %q", value, err, EncodeBytes(data))
+	}
+}
+
+func TestCachedKVImportClears(t *testing.T) {
+	c := newStoreCachedKV(t)
+	n, err := c.Import(strings.NewReader(exportHeader + "\nk - v2\n"))
+	if err != nil || n != 1 {
+		t.Fatalf("Import = %d, %v; want 1 key", n, err)
+	}
+	if value, err := c.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after Import = %q, %v; want v2", value, err)
+	}
+}
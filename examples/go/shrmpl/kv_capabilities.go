@@ -0,0 +1,236 @@
+package shrmpl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxBatch is the batch limit of servers that predate HELLO
+const defaultMaxBatch = 3
+
+// defaultCapabilityTTL is how long DefaultCapabilityCache trusts an entry
+const defaultCapabilityTTL = 5 * time.Minute
+
+// ServerCapabilities describes what a shrmpl-kv server supports. Servers
+// answer HELLO with
+//
+//	HELLO batch=<max commands> commands=<CMD>,<CMD>,... [tags=1] [checksums=1]
+//	      [maxkey=<n>] [maxvalue=<n>]
+//
+// Servers that predate HELLO reject it and are assumed to support the
+// original command set.
+type ServerCapabilities struct {
+	// Handshake is false when the server predates HELLO
+	Handshake bool
+	MaxBatch  int
+	Commands  map[string]bool
+	// Tags is set when the server accepts and logs operation tags; see
+	// WithTag
+	Tags bool
+	// Checksums is set when the server answers GETX and LISTX with
+	// CRC-checked responses; see KVConfig.VerifyChecksums
+	Checksums bool
+	// MaxKeyLength and MaxValueLength are the advertised limits, zero
+	// when not advertised; see KV.Limits
+	MaxKeyLength   int
+	MaxValueLength int
+}
+
+// Supports reports whether the server accepts cmd
+func (c ServerCapabilities) Supports(cmd string) bool {
+	return c.Commands[strings.ToUpper(cmd)]
+}
+
+// defaultCapabilities returns the capabilities of a pre-HELLO server
+func defaultCapabilities() ServerCapabilities {
+	commands := make(map[string]bool)
+	for _, cmd := range []string{"GET", "SET", "INCR", "DEL", "PING", "LIST", "BATCH"} {
+		commands[cmd] = true
+	}
+	return ServerCapabilities{MaxBatch: defaultMaxBatch, Commands: commands}
+}
+
+// parseHello parses a HELLO response
+func parseHello(response string) (ServerCapabilities, error) {
+	fields := strings.Fields(response)
+	if len(fields) == 0 || fields[0] != "HELLO" {
+		return ServerCapabilities{}, fmt.Errorf("unexpected response: %s", response)
+	}
+	caps := ServerCapabilities{
+		Handshake: true,
+		MaxBatch:  defaultMaxBatch,
+		Commands:  make(map[string]bool),
+	}
+	for _, field := range fields[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "batch":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				caps.MaxBatch = n
+			}
+		case "commands":
+			for _, cmd := range strings.Split(value, ",") {
+				if cmd != "" {
+					caps.Commands[strings.ToUpper(cmd)] = true
+				}
+			}
+		case "tags":
+			caps.Tags = value == "1"
+		case "checksums":
+			caps.Checksums = value == "1"
+		case "maxkey":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				caps.MaxKeyLength = n
+			}
+		case "maxvalue":
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				caps.MaxValueLength = n
+			}
+		}
+	}
+	return caps, nil
+}
+
+// Hello asks the server for its capabilities. Servers that do not know
+// HELLO yield the default capabilities rather than an error.
+func (c *ShrmplKVClient) Hello() (ServerCapabilities, error) {
+	response, err := c.send("HELLO")
+	if err != nil {
+		return ServerCapabilities{}, err
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		serverErr := newServerError(response)
+		if serverErr.UnknownCommand() {
+			return defaultCapabilities(), nil
+		}
+		return ServerCapabilities{}, serverErr
+	}
+	return parseHello(response)
+}
+
+// CapabilityCache shares handshake results between clients of the same
+// server, so short-lived clients skip the HELLO round trip
+type CapabilityCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]capabilityEntry
+}
+
+// capabilityEntry is a cached handshake result
+type capabilityEntry struct {
+	caps    ServerCapabilities
+	expires time.Time
+}
+
+// DefaultCapabilityCache is used by clients whose KVConfig does not name
+// a cache
+var DefaultCapabilityCache = NewCapabilityCache(defaultCapabilityTTL)
+
+// NewCapabilityCache creates a cache whose entries live for ttl; a ttl of
+// zero or less disables caching
+func NewCapabilityCache(ttl time.Duration) *CapabilityCache {
+	return &CapabilityCache{ttl: ttl, entries: make(map[string]capabilityEntry)}
+}
+
+// Get returns the unexpired capabilities cached for addr
+func (cc *CapabilityCache) Get(addr string) (ServerCapabilities, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	entry, ok := cc.entries[addr]
+	if !ok || time.Now().After(entry.expires) {
+		delete(cc.entries, addr)
+		return ServerCapabilities{}, false
+	}
+	return entry.caps, true
+}
+
+// Put caches caps for addr
+func (cc *CapabilityCache) Put(addr string, caps ServerCapabilities) {
+	if cc.ttl <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.entries[addr] = capabilityEntry{caps: caps, expires: time.Now().Add(cc.ttl)}
+}
+
+// Invalidate drops the entry for addr, for example after a connection
+// error, so the next client handshakes again
+func (cc *CapabilityCache) Invalidate(addr string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.entries, addr)
+}
+
+// capabilityCache returns the cache configured for kv
+func (kv *KV) capabilityCache() *CapabilityCache {
+	if kv.config.CapabilityCache != nil {
+		return kv.config.CapabilityCache
+	}
+	return DefaultCapabilityCache
+}
+
+// connect dials client and records the server's capabilities
+func (kv *KV) connect(client *ShrmplKVClient) error {
+	caps, err := kv.dial(client)
+	if err != nil {
+		return err
+	}
+	kv.setCapabilities(caps)
+	client.SetVerifyChecksums(kv.config.VerifyChecksums && caps != nil && caps.Checksums)
+	client.SetLimits(kv.Limits())
+	if caps != nil {
+		// The first handshake fixes which keys HashLongKeys hashes
+		kv.keyHashLimit.CompareAndSwap(0, int64(kv.Limits().MaxKeyLength))
+	}
+	return nil
+}
+
+// dial connects client and, when handshakes are enabled, learns the
+// server's capabilities from the cache or a HELLO. Capabilities are nil
+// when handshakes are disabled.
+func (kv *KV) dial(client *ShrmplKVClient) (*ServerCapabilities, error) {
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	if !kv.config.Handshake {
+		return nil, nil
+	}
+
+	addr := net.JoinHostPort(client.host, strconv.Itoa(client.port))
+	cache := kv.capabilityCache()
+	caps, ok := cache.Get(addr)
+	if !ok {
+		var err error
+		caps, err = client.Hello()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		cache.Put(addr, caps)
+	}
+	return &caps, nil
+}
+
+// setCapabilities records the capabilities of the connected server
+func (kv *KV) setCapabilities(caps *ServerCapabilities) {
+	kv.capsMu.Lock()
+	kv.caps = caps
+	kv.capsMu.Unlock()
+}
+
+// Capabilities returns the server's capabilities as learned by the last
+// handshake, or the defaults when handshakes are disabled or none has
+// completed yet
+func (kv *KV) Capabilities() ServerCapabilities {
+	kv.capsMu.Lock()
+	defer kv.capsMu.Unlock()
+	if kv.caps == nil {
+		return defaultCapabilities()
+	}
+	return *kv.caps
+}
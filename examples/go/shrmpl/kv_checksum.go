@@ -0,0 +1,71 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when a checksummed response does not
+// match its CRC, meaning the payload was damaged in transit. The
+// connection is closed, since whatever corrupted it may strike again.
+var ErrChecksumMismatch = errors.New("response checksum mismatch")
+
+// SetVerifyChecksums makes Get, Batch and List request checksummed
+// responses (GETX, and LISTX in place of LIST) and verify them. Only
+// enable it for servers whose HELLO advertises checksums=1; KV does so
+// automatically when KVConfig.VerifyChecksums is set.
+func (c *ShrmplKVClient) SetVerifyChecksums(enabled bool) {
+	c.verifyChecksums = enabled
+}
+
+// checksumPayload returns the CRC-32 (IEEE) of payload as the server
+// writes it: eight lowercase hex digits
+func checksumPayload(payload string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(payload)))
+}
+
+// verifyChecksum checks a "<crc32> <payload>" response to cmd and returns
+// the payload. A mismatch or malformed response is counted, closes the
+// connection and returns ErrChecksumMismatch.
+func (c *ShrmplKVClient) verifyChecksum(cmd, response string) (string, error) {
+	sum, payload, ok := strings.Cut(response, " ")
+	if ok && sum == checksumPayload(payload) {
+		return payload, nil
+	}
+	c.checksumMismatches.Add(1)
+	c.Close()
+	return "", fmt.Errorf("%w: %s", ErrChecksumMismatch, cmd)
+}
+
+// checksummedResult reports whether a BATCH result to cmd carries a
+// checksum: a GET sent as GETX that returned a value
+func checksummedResult(cmd, part string) bool {
+	fields := strings.Fields(cmd)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "GET") &&
+		!strings.HasPrefix(part, "ERROR") &&
+		part != "*KEY NOT FOUND*" && part != evictedResponse
+}
+
+// sendBatch sends commands as one BATCH and parses the results. When
+// checksums are verified, GET sub-commands are sent as GETX and their
+// values checked.
+func (c *ShrmplKVClient) sendBatch(commands []string) ([]BatchResult, error) {
+	wire := commands
+	if c.verifyChecksums {
+		wire = make([]string, len(commands))
+		for i, cmd := range commands {
+			wire[i] = cmd
+			if fields := strings.Fields(cmd); len(fields) > 0 &&
+				strings.EqualFold(fields[0], "GET") {
+				wire[i] = "GETX" + strings.TrimSpace(cmd)[len(fields[0]):]
+			}
+		}
+	}
+	response, err := c.sendAggregate("BATCH", wire...)
+	if err != nil {
+		return nil, err
+	}
+	return c.parseResults(commands, response, c.verifyChecksums)
+}
@@ -2,13 +2,21 @@ package shrmpl
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -17,15 +25,99 @@ type ThisAppKVInterface interface {
 	Get(key string) (string, error)
 	Set(key, value, ttl string) error
 	Incr(key string, ttl string) (int, error)
-	Batch(commands []string) ([]string, error)
+	Batch(commands []string) ([]BatchResult, error)
 	Close()
 }
 
+// The operations added since ThisAppKVInterface are grouped in the small
+// interfaces below rather than added to it, so implementations and mocks
+// of ThisAppKVInterface keep compiling. *KV, CachedKV and
+// shrmpltest.FaultInjector implement all of them; code holding a
+// ThisAppKVInterface checks for one with a type assertion.
+
+// KVContexter runs the basic operations under a context
+type KVContexter interface {
+	GetContext(ctx context.Context, key string) (string, error)
+	SetContext(ctx context.Context, key, value, ttl string) error
+	IncrContext(ctx context.Context, key string, ttl string) (int, error)
+	BatchContext(ctx context.Context, commands []string) ([]BatchResult, error)
+}
+
+// KVConditionalSetter sets values depending on what is stored
+type KVConditionalSetter interface {
+	SetReturning(key, value, ttl string) (string, error)
+	SetOpts(key, value string, opts SetOptions) (string, bool, error)
+	CompareAndSwap(key, oldValue, newValue, ttl string) (bool, error)
+	Update(key string, ttl string, fn func(current string, exists bool) (string, error)) error
+}
+
+// KVToucher reads and renews expirations
+type KVToucher interface {
+	Touch(key string, ttl string) (bool, error)
+	GetWithTTL(key string) (string, time.Duration, bool, error)
+}
+
+// KVLister enumerates and counts the keys
+type KVLister interface {
+	List() ([]KVListItem, error)
+	DBSize() (int, error)
+}
+
+// KVExporter backs keys up and restores them; see KV.Export
+type KVExporter interface {
+	Export(w io.Writer, prefix string) (int, error)
+	Import(r io.Reader) (int, error)
+}
+
+// KVMonitor reports on the connection
+type KVMonitor interface {
+	RTT(samples ...int) (time.Duration, error)
+	Stats() KVStats
+}
+
+// errUnsupported is returned by wrappers of a ThisAppKVInterface, such as
+// TypedKV, for an operation the wrapped client does not implement
+func errUnsupported(kv ThisAppKVInterface, op string) error {
+	return fmt.Errorf("%s: %T does not implement it: %w", op, kv, errors.ErrUnsupported)
+}
+
 // KV wraps shrmpl-kv client for key-value operations
 type KV struct {
 	shrmplKVClient *ShrmplKVClient
 	hostPort       string
+	config         KVConfig
+	subscriptions  map[string]func(key string)
 	mu             sync.Mutex
+
+	// Health snapshot; see Health
+	health       atomic.Pointer[KVHealth]
+	healthMu     sync.Mutex
+	hasConnected bool
+
+	// When the server last sent TERM, in Unix nanoseconds
+	termAt atomic.Int64
+
+	// In-flight Gets shared when CoalesceGets is set
+	flights flightGroup
+
+	// Server capabilities from the handshake; see Capabilities
+	capsMu sync.Mutex
+	caps   *ServerCapabilities
+
+	// Hashed keys and their originals when HashLongKeys is set, and the
+	// key length above which keys are hashed; see hashLimit
+	longKeysMu   sync.Mutex
+	longKeys     map[string]string
+	keyHashLimit atomic.Int64
+
+	// Evictions and checksum mismatches seen on any of the wrapper's
+	// connections; see KVStats
+	evictions          atomic.Int64
+	checksumMismatches atomic.Int64
+
+	// Shared by the wrapper's connections so warnings stay deduplicated
+	// across reconnects; see KVConfig.WarnThreshold
+	limitWarner *limitWarner
 }
 
 // parseHostPort parses a "host:port" string into separate
@@ -40,62 +132,283 @@ func parseHostPort(hostPort string) (string, string, error) {
 
 // NewKV creates a key-value store client
 func NewKV(config *KVConfig) ThisAppKVInterface {
+	kv := &KV{hostPort: config.HostPort, config: *config}
+	kv.limitWarner = newLimitWarner(config.WarnThreshold, config.WarnInterval,
+		config.limitWarningNotify())
+	if config.Lazy {
+		// The first operation dials and reports any connection error
+		return kv
+	}
+
 	// Parse the combined host:port string
 	host, portStr, err := parseHostPort(config.HostPort)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to parse kv_host_port: %s\n", err.Error())
-		return &KV{shrmplKVClient: nil, hostPort: config.HostPort}
+		return kv
 	}
 
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid port in kv_host_port: %s\n", err.Error())
-		return &KV{shrmplKVClient: nil, hostPort: config.HostPort}
+		return kv
 	}
 
-	shrmplKV := NewShrmplKVClient(host, port)
-	if err := shrmplKV.Connect(); err != nil {
+	shrmplKV := kv.newClient(host, port)
+	if err := kv.connect(shrmplKV); err != nil {
 		// If we can't connect, we'll return a client that logs errors
 		// The operations will fail gracefully
 		fmt.Fprintf(os.Stderr, "Failed to connect to shrmpl-kv: %s\n", err.Error())
-		return &KV{shrmplKVClient: nil, hostPort: config.HostPort}
+		return kv
 	}
 
-	return &KV{
-		shrmplKVClient: shrmplKV,
-		hostPort:       config.HostPort,
+	kv.shrmplKVClient = shrmplKV
+	kv.markConnected()
+	return kv
+}
+
+// resubscribe restores the wrapper's subscriptions on a new connection,
+// reporting failures to OnResubscribeError
+func (kv *KV) resubscribe(client *ShrmplKVClient) {
+	for prefix, handler := range kv.subscriptions {
+		if err := client.Subscribe(prefix, handler); err != nil {
+			err = fmt.Errorf("resubscribe %q: %w", prefix, err)
+			if kv.config.OnResubscribeError != nil {
+				kv.config.OnResubscribeError(prefix, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// newClient creates a low-level client with the wrapper's options applied
+func (kv *KV) newClient(host string, port int) *ShrmplKVClient {
+	client := NewShrmplKVClient(host, port)
+	if kv.config.AdaptiveTimeout != nil {
+		client.SetAdaptiveTimeout(*kv.config.AdaptiveTimeout)
 	}
+	client.SetCompression(kv.config.CompressThreshold)
+	client.SetDBSizeListFallback(kv.config.DBSizeListFallback)
+	client.SetMaxAggregateResponseBytes(kv.config.MaxAggregateResponseBytes)
+	client.SetDrainBatchErrors(kv.config.DrainBatchErrors)
+	client.observer = kv.observe
+	client.limitWarner = kv.limitWarner
+	client.evictions = &kv.evictions
+	client.checksumMismatches = &kv.checksumMismatches
+	return client
 }
 
 // tryReconnect attempts to reconnect to the KV server
-func (kv *KV) tryReconnect() {
-	host, portStr, err := parseHostPort(kv.hostPort)
+func (kv *KV) tryReconnect() error {
+	host, port, err := parseAddr(kv.hostPort)
 	if err != nil {
-		return
+		return err
 	}
-	port, err := strconv.Atoi(portStr)
+	client := kv.newClient(host, port)
+	if err := kv.connect(client); err != nil {
+		return err
+	}
+	kv.resubscribe(client)
+	kv.shrmplKVClient = client
+	kv.markConnected()
+	return nil
+}
+
+// ensureConnected reconnects if needed, returning the dial error when the
+// server cannot be reached
+func (kv *KV) ensureConnected() error {
+	if kv.shrmplKVClient != nil {
+		return nil
+	}
+	if err := kv.tryReconnect(); err != nil {
+		err = fmt.Errorf("%w: %w", errKVUnavailable, err)
+		kv.observe(err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe registers handler for server-pushed invalidations of keys
+// starting with prefix. The subscription is restored after reconnects;
+// restores that fail go to KVConfig.OnResubscribeError.
+func (kv *KV) Subscribe(prefix string, handler func(key string)) error {
+	if kv.config.HashLongKeys {
+		notify := handler
+		handler = func(key string) { notify(kv.originalKey(key)) }
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.subscriptions == nil {
+		kv.subscriptions = make(map[string]func(key string))
+	}
+	if err := kv.ensureConnected(); err != nil {
+		// The next connection subscribes
+		kv.subscriptions[prefix] = handler
+		return err
+	}
+	kv.subscriptions[prefix] = handler
+
+	err := kv.shrmplKVClient.Subscribe(prefix, handler)
 	if err != nil {
-		return
+		delete(kv.subscriptions, prefix)
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
 	}
-	client := NewShrmplKVClient(host, port)
-	if err := client.Connect(); err == nil {
-		kv.shrmplKVClient = client
+	return err
+}
+
+// Unsubscribe cancels the subscription for prefix
+func (kv *KV) Unsubscribe(prefix string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	delete(kv.subscriptions, prefix)
+	if kv.shrmplKVClient == nil {
+		return nil
 	}
+	return kv.shrmplKVClient.Unsubscribe(prefix)
+}
+
+// DBSize returns the number of keys in the key-value store
+func (kv *KV) DBSize() (int, error) {
+	return retryOnTerm(context.Background(), kv, kv.dbSize)
+}
+
+// dbSize performs one DBSIZE on the shared connection
+func (kv *KV) dbSize() (int, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	size, err := kv.shrmplKVClient.DBSize()
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// ListFunc streams every key to fn; see ShrmplKVClient.ListFunc. The
+// connection is re-established on the next operation if iteration stopped
+// early.
+func (kv *KV) ListFunc(fn func(item KVListItem) (continueIteration bool, err error)) error {
+	return kv.listFunc(fn, false)
+}
+
+// listFunc is ListFunc, buffered as for ShrmplKVClient.listFunc
+func (kv *KV) listFunc(fn func(item KVListItem) (bool, error), buffered bool) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return err
+	}
+
+	err := kv.shrmplKVClient.listFunc(func(item KVListItem) (bool, error) {
+		item.Key = kv.originalKey(item.Key)
+		return fn(item)
+	}, buffered)
+	var serverErr *ServerError
+	if (err != nil && !errors.As(err, &serverErr)) || kv.shrmplKVClient.conn == nil {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
+	return err
+}
+
+// List returns every key's item in server order, or an empty slice for an
+// empty keyspace; see ShrmplKVClient.ListItems
+func (kv *KV) List() ([]KVListItem, error) {
+	items := []KVListItem{}
+	err := kv.listFunc(func(item KVListItem) (bool, error) {
+		items = append(items, item)
+		return true, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListMap returns every key's item keyed by Key; see
+// ShrmplKVClient.ListMap
+func (kv *KV) ListMap() (map[string]KVListItem, error) {
+	items := make(map[string]KVListItem)
+	err := kv.listFunc(func(item KVListItem) (bool, error) {
+		items[item.Key] = item
+		return true, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Stats returns a snapshot of the current connection's statistics
+func (kv *KV) Stats() KVStats {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.shrmplKVClient == nil {
+		return KVStats{
+			Evictions:          kv.evictions.Load(),
+			ChecksumMismatches: kv.checksumMismatches.Load(),
+		}
+	}
+	return kv.shrmplKVClient.Stats()
 }
 
 // Get retrieves a value from the key-value store
 func (kv *KV) Get(key string) (string, error) {
+	return kv.GetContext(context.Background(), key)
+}
+
+// GetContext retrieves a value by key, tracing the operation under ctx
+// when a Tracer is configured
+func (kv *KV) GetContext(ctx context.Context, key string) (_ string, err error) {
+	span := kv.startSpan(ctx, "kv.get", key)
+	defer func() { span.End(err) }()
+	key = kv.wireKey(key)
+
+	get := func() (string, error) {
+		return retryOnTerm(ctx, kv, func() (string, error) { return kv.get(ctx, key) })
+	}
+	if kv.config.CoalesceGets {
+		return kv.flights.do(key, get)
+	}
+	return get()
+}
+
+// get performs one GET on the shared connection, tagged with the tag in
+// ctx
+func (kv *KV) get(ctx context.Context, key string) (string, error) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	if kv.shrmplKVClient == nil {
-		kv.tryReconnect()
+	if err := kv.ensureConnected(); err != nil {
+		return "", err
 	}
-	if kv.shrmplKVClient == nil {
-		return "", fmt.Errorf("key-value store not available")
+	untag, err := kv.tagCommands(ctx)
+	if err != nil {
+		return "", err
 	}
+	defer untag()
 
 	val, err := kv.shrmplKVClient.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
 	if err != nil {
 		kv.shrmplKVClient.Close()
 		kv.shrmplKVClient = nil
@@ -106,17 +419,38 @@ func (kv *KV) Get(key string) (string, error) {
 
 // Set stores a key-value pair with optional TTL
 func (kv *KV) Set(key, value, ttl string) error {
+	return kv.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext stores a key-value pair, tracing the operation under ctx
+// when a Tracer is configured
+func (kv *KV) SetContext(ctx context.Context, key, value, ttl string) (err error) {
+	span := kv.startSpan(ctx, "kv.set", key)
+	defer func() { span.End(err) }()
+	key = kv.wireKey(key)
+
+	_, err = retryOnTerm(ctx, kv, func() (struct{}, error) {
+		return struct{}{}, kv.set(ctx, key, value, ttl)
+	})
+	return err
+}
+
+// set performs one SET on the shared connection, tagged with the tag in
+// ctx
+func (kv *KV) set(ctx context.Context, key, value, ttl string) error {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	if kv.shrmplKVClient == nil {
-		kv.tryReconnect()
+	if err := kv.ensureConnected(); err != nil {
+		return err
 	}
-	if kv.shrmplKVClient == nil {
-		return fmt.Errorf("key-value store not available")
+	untag, err := kv.tagCommands(ctx)
+	if err != nil {
+		return err
 	}
+	defer untag()
 
-	err := kv.shrmplKVClient.Set(key, value, ttl)
+	err = kv.shrmplKVClient.Set(key, value, ttl)
 	if err != nil {
 		kv.shrmplKVClient.Close()
 		kv.shrmplKVClient = nil
@@ -125,17 +459,80 @@ func (kv *KV) Set(key, value, ttl string) error {
 	return nil
 }
 
+// SetReturning stores a key-value pair and returns any payload the server
+// sent after OK
+func (kv *KV) SetReturning(key, value, ttl string) (string, error) {
+	key = kv.wireKey(key)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return "", err
+	}
+
+	payload, err := kv.shrmplKVClient.SetReturning(key, value, ttl)
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return "", err
+	}
+	return payload, nil
+}
+
+// SetOpts stores a key-value pair according to opts; see
+// ShrmplKVClient.SetOpts
+func (kv *KV) SetOpts(key, value string, opts SetOptions) (string, bool, error) {
+	// Reject bad options before they can cost the connection
+	if err := opts.validate(); err != nil {
+		return "", false, err
+	}
+	key = kv.wireKey(key)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return "", false, err
+	}
+
+	prev, ok, err := kv.shrmplKVClient.SetOpts(key, value, opts)
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return "", false, err
+	}
+	return prev, ok, nil
+}
+
 // Incr increments a counter and returns the new value
 func (kv *KV) Incr(key string, ttl string) (int, error) {
+	return kv.IncrContext(context.Background(), key, ttl)
+}
+
+// IncrContext increments a counter, tracing the operation under ctx when
+// a Tracer is configured
+func (kv *KV) IncrContext(ctx context.Context, key string, ttl string) (_ int, err error) {
+	span := kv.startSpan(ctx, "kv.incr", key)
+	defer func() { span.End(err) }()
+	key = kv.wireKey(key)
+
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	if kv.shrmplKVClient == nil {
-		kv.tryReconnect()
+	if err := kv.ensureConnected(); err != nil {
+		return 0, err
 	}
-	if kv.shrmplKVClient == nil {
-		return 0, fmt.Errorf("key-value store not available")
+	untag, err := kv.tagCommands(ctx)
+	if err != nil {
+		return 0, err
 	}
+	defer untag()
 
 	val, err := kv.shrmplKVClient.Incr(key, ttl)
 	if err != nil {
@@ -146,35 +543,144 @@ func (kv *KV) Incr(key string, ttl string) (int, error) {
 	return val, nil
 }
 
-// Batch executes multiple commands in a single call
-func (kv *KV) Batch(commands []string) ([]string, error) {
-	if len(commands) > 3 {
-		return nil, fmt.Errorf("batch cannot exceed 3 commands")
+// BatchResult is the outcome of one command within a Batch. An evicted key
+// sets NotFound and an Err wrapping ErrKeyEvicted.
+type BatchResult struct {
+	Command  string
+	Value    string
+	NotFound bool
+	Err      error
+}
+
+// Batch executes multiple commands in a single call. A sub-command that
+// fails is reported in its BatchResult; the returned error is reserved for
+// failures of the batch as a whole. Batch used to return the raw values;
+// BatchValues keeps that form for existing callers.
+func (kv *KV) Batch(commands []string) ([]BatchResult, error) {
+	return kv.BatchContext(context.Background(), commands)
+}
+
+// BatchContext executes up to 3 commands in one round trip, tracing the
+// batch under ctx when a Tracer is configured
+func (kv *KV) BatchContext(ctx context.Context, commands []string) (_ []BatchResult, err error) {
+	span := startSpan(ctx, kv.config.Tracer, "kv.batch",
+		map[string]string{"kv.commands": strconv.Itoa(len(commands))})
+	defer func() { span.End(err) }()
+
+	max := kv.Capabilities().MaxBatch
+	if len(commands) > max {
+		return nil, fmt.Errorf("batch cannot exceed %d commands", max)
+	}
+	kv.limitWarner.check("batch", len(commands), max)
+	if kv.config.HashLongKeys {
+		wire := make([]string, len(commands))
+		for i, cmd := range commands {
+			wire[i] = kv.wireCommand(cmd)
+		}
+		commands = wire
+	}
+
+	if idempotentBatch(commands) {
+		return retryOnTerm(ctx, kv, func() ([]BatchResult, error) {
+			return kv.batch(ctx, commands)
+		})
 	}
+	return kv.batch(ctx, commands)
+}
 
+// batch sends one BATCH on the shared connection, tagged with the tag in
+// ctx
+func (kv *KV) batch(ctx context.Context, commands []string) ([]BatchResult, error) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	if kv.shrmplKVClient == nil {
-		kv.tryReconnect()
+	if err := kv.ensureConnected(); err != nil {
+		return nil, err
 	}
-	if kv.shrmplKVClient == nil {
-		return nil, fmt.Errorf("key-value store not available")
+	untag, err := kv.tagCommands(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer untag()
 
-	batchCmd := "BATCH " + strings.Join(commands, ";")
-	response, err := kv.shrmplKVClient.sendCommand(batchCmd)
-	if err != nil {
+	results, err := kv.shrmplKVClient.sendBatch(commands)
+	var serverErr *ServerError
+	if (err != nil && !errors.As(err, &serverErr) && !kv.shrmplKVClient.batchDrained(err)) ||
+		kv.shrmplKVClient.conn == nil {
 		kv.shrmplKVClient.Close()
 		kv.shrmplKVClient = nil
+	}
+	return results, err
+}
+
+// BatchValues executes multiple commands and returns their raw values,
+// failing if any sub-command failed. Missing and evicted keys have empty
+// values.
+func (kv *KV) BatchValues(commands []string) ([]string, error) {
+	results, err := kv.Batch(commands)
+	if err != nil {
 		return nil, err
 	}
+	values := make([]string, len(results))
+	for i, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrKeyNotFound) {
+			return nil, fmt.Errorf("batch command %d (%s): %w", i, r.Command, r.Err)
+		}
+		values[i] = r.Value
+	}
+	return values, nil
+}
 
-	if strings.HasPrefix(response, "ERROR") {
-		return nil, errors.New(response)
+// parseBatch splits a BATCH response into per-command results. The server
+// skips empty commands, so those are dropped before matching responses.
+func (c *ShrmplKVClient) parseBatch(commands []string,
+	response string) ([]BatchResult, error) {
+	return c.parseResults(commands, response, false)
+}
+
+// parseResults is parseBatch for a response whose GET results carry
+// checksums when checksummed is set
+func (c *ShrmplKVClient) parseResults(commands []string, response string,
+	checksummed bool) ([]BatchResult, error) {
+	var sent []string
+	for _, cmd := range commands {
+		if strings.TrimSpace(cmd) != "" {
+			sent = append(sent, strings.TrimSpace(cmd))
+		}
+	}
+
+	parts := strings.Split(strings.TrimSpace(response), ";")
+	if len(parts) != len(sent) {
+		if strings.HasPrefix(response, "ERROR") {
+			return nil, newServerError(response)
+		}
+		return nil, &batchCountError{got: len(parts), want: len(sent)}
 	}
 
-	results := strings.Split(strings.TrimSpace(response), ";")
+	results := make([]BatchResult, len(sent))
+	for i, part := range parts {
+		results[i].Command = sent[i]
+		if checksummed && checksummedResult(sent[i], part) {
+			value, err := c.verifyChecksum("BATCH", part)
+			if err != nil {
+				return nil, err
+			}
+			part = value
+		}
+		switch {
+		case strings.HasPrefix(part, "ERROR"):
+			results[i].Err = newServerError(part)
+		case part == "*KEY NOT FOUND*":
+			results[i].NotFound = true
+		case part == evictedResponse:
+			results[i].NotFound = true
+			results[i].Err = c.evicted(batchKey(sent[i]))
+		case c.compressThreshold > 0:
+			results[i].Value, results[i].Err = decompressValue(part)
+		default:
+			results[i].Value = part
+		}
+	}
 	return results, nil
 }
 
@@ -186,108 +692,441 @@ func (kv *KV) Close() {
 		kv.shrmplKVClient.Close()
 		kv.shrmplKVClient = nil
 	}
+	kv.updateHealth(func(h *KVHealth) { h.Connected = false })
+}
+
+// DropConnection closes the current connection as a network failure
+// would; the next operation reconnects
+func (kv *KV) DropConnection() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.shrmplKVClient != nil {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
 }
 
 // ShrmplKVClient represents a client for the shrmpl-kv service
 type ShrmplKVClient struct {
-	host    string
-	port    int
-	conn    net.Conn
-	timeout time.Duration
+	host        string
+	port        int
+	conn        net.Conn
+	reader      *bufio.Reader
+	connectedAt time.Time
+	timeout     time.Duration
+	adaptive    *adaptiveState
+
+	compressThreshold  int
+	dbSizeListFallback bool
+	listTimeout        time.Duration
+	maxAggregate       int
+
+	// Invalidation subscriptions; see Subscribe
+	subMu      sync.Mutex
+	handlers   map[string]func(key string)
+	lines      chan string
+	readerDone chan struct{}
+	readerQuit chan struct{}
+	readerErr  error
+	stopping   atomic.Bool
+
+	// tag, when set, is appended to every command; see WithTag
+	tag string
+	// verifyChecksums requests checksummed responses; see
+	// SetVerifyChecksums
+	verifyChecksums bool
+	// limits bounds keys and values; see SetLimits
+	limits KVLimits
+	// drainBatchErrors keeps the connection after recoverable BATCH
+	// errors; see SetDrainBatchErrors. lineCut records that a line cut
+	// off by nextLineLimit still has unread bytes.
+	drainBatchErrors bool
+	lineCut          bool
+	// limitWarner reports keys and values close to limits; see
+	// SetLimitWarnings
+	limitWarner *limitWarner
+
+	// observer, when set, is told the outcome of every round trip
+	observer func(err error)
+	// connObserver receives connection events; see SetConnObserver.
+	// heartbeats and heartbeatRead count the UPONG lines skipped by the
+	// round trip in progress.
+	connObserver  ConnObserver
+	heartbeats    int
+	heartbeatRead time.Duration
+	// sharedBuffers reads through the pooled readBuffers, keeping the
+	// bytes received past the last newline in pending; see
+	// SetSharedReadBuffers
+	sharedBuffers bool
+	pending       []byte
+	// evictions counts evicted-key replies and checksumMismatches
+	// corrupted responses; KV shares the counters across reconnects
+	evictions          *atomic.Int64
+	checksumMismatches *atomic.Int64
+}
+
+// ServerErrorKind categorizes ERROR responses from shrmpl-kv
+type ServerErrorKind int
+
+const (
+	ErrKindOther ServerErrorKind = iota
+	ErrKindUnknownCommand
+	ErrKindInvalidArguments
+	ErrKindInvalidLength
+	ErrKindInvalidExpiration
+	ErrKindTooManyCommands
+)
+
+// serverErrorKinds maps ERROR message prefixes to their kind
+var serverErrorKinds = []struct {
+	prefix string
+	kind   ServerErrorKind
+}{
+	{"unknown command", ErrKindUnknownCommand},
+	{"invalid arguments", ErrKindInvalidArguments},
+	{"invalid length", ErrKindInvalidLength},
+	{"invalid expiration", ErrKindInvalidExpiration},
+	{"too many commands", ErrKindTooManyCommands},
+}
+
+// ServerError is an ERROR response returned by shrmpl-kv
+type ServerError struct {
+	Message string
+}
+
+// Kind categorizes the error from its message
+func (e *ServerError) Kind() ServerErrorKind {
+	for _, k := range serverErrorKinds {
+		if strings.HasPrefix(e.Message, k.prefix) {
+			return k.kind
+		}
+	}
+	return ErrKindOther
+}
+
+// newServerError builds a ServerError from an "ERROR <message>" response
+func newServerError(response string) *ServerError {
+	return &ServerError{
+		Message: strings.TrimSpace(strings.TrimPrefix(response, "ERROR")),
+	}
+}
+
+func (e *ServerError) Error() string {
+	return "ERROR " + e.Message
+}
+
+// UnknownCommand reports whether the server rejected the command itself
+func (e *ServerError) UnknownCommand() bool {
+	return e.Kind() == ErrKindUnknownCommand
+}
+
+// ConnError wraps a transport error with the identity of the connection
+// it occurred on and the command that was in flight
+type ConnError struct {
+	LocalAddr  string
+	RemoteAddr string
+	Age        time.Duration
+	Cmd        string
+	Err        error
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("%v (conn %s, age %s, cmd %s)",
+		e.Err, e.RemoteAddr, e.Age.Round(time.Second), e.Cmd)
+}
+
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// ErrConnectionClosed is wrapped by the error of an operation whose
+// connection was closed or reset by the server, or closed locally, as
+// opposed to one that timed out waiting for a slow response. The
+// connection cannot be reused; safe operations (see TermPolicy) are
+// retried once on a new connection straight away.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// connectionClosed reports whether err means the connection is gone
+func connectionClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// connError wraps err with this client's connection identity. Only the
+// command verb is recorded so keys and values never end up in error text.
+func (c *ShrmplKVClient) connError(cmd string, err error) error {
+	if connectionClosed(err) {
+		err = fmt.Errorf("%w: %w", ErrConnectionClosed, err)
+	}
+	verb, _, _ := strings.Cut(cmd, " ")
+	ce := &ConnError{
+		RemoteAddr: net.JoinHostPort(c.host, strconv.Itoa(c.port)),
+		Cmd:        verb,
+		Err:        err,
+	}
+	if c.conn != nil {
+		ce.LocalAddr = c.conn.LocalAddr().String()
+		ce.RemoteAddr = c.conn.RemoteAddr().String()
+		ce.Age = time.Since(c.connectedAt)
+	}
+	return ce
 }
 
 // NewShrmplKVClient creates a new shrmpl-kv client
 func NewShrmplKVClient(host string, port int) *ShrmplKVClient {
 	return &ShrmplKVClient{
-		host:    host,
-		port:    port,
-		timeout: 5 * time.Second,
+		host:               host,
+		port:               port,
+		timeout:            5 * time.Second,
+		evictions:          new(atomic.Int64),
+		checksumMismatches: new(atomic.Int64),
 	}
 }
 
 // Connect establishes connection to shrmpl-kv
 func (c *ShrmplKVClient) Connect() error {
 	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if c.connObserver != nil {
+		c.connObserver.Dialed(err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to connect to shrmpl-kv: %w", err)
 	}
 
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
 		_ = tcpConn.SetNoDelay(true)
-		_ = tcpConn.SetReadDeadline(time.Now().Add(c.timeout))
 	}
+	// No read deadline here: sendCommandLimit sets one before each read,
+	// and one set now would expire on a connection left idle before its
+	// first command
 
 	c.conn = conn
+	c.reader = nil
+	if !c.sharedBuffers {
+		c.reader = bufio.NewReader(conn)
+	}
+	c.pending = nil
+	c.connectedAt = time.Now()
 	return nil
 }
 
-// Get retrieves a value from shrmpl-kv
+// Get retrieves a value from shrmpl-kv, returning "" for missing keys. An
+// evicted key returns an error wrapping ErrKeyEvicted.
 func (c *ShrmplKVClient) Get(key string) (string, error) {
-	if len(key) > 100 {
-		return "", fmt.Errorf("key length exceeds 100 characters")
+	value, _, err := c.lookup(key)
+	return value, err
+}
+
+// lookup retrieves a value and reports whether the key exists
+func (c *ShrmplKVClient) lookup(key string) (string, bool, error) {
+	if err := c.checkKey(key); err != nil {
+		return "", false, err
 	}
 
-	response, err := c.sendCommand(fmt.Sprintf("GET %s", key))
+	op := "GET"
+	if c.verifyChecksums {
+		op = "GETX"
+	}
+	response, err := c.send(op, key)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
 	if response == "*KEY NOT FOUND*" {
-		return "", nil
+		return "", false, nil
+	}
+	if response == evictedResponse {
+		return "", false, c.evicted(key)
 	}
 	if strings.HasPrefix(response, "ERROR") {
-		return "", errors.New(response)
+		return "", false, newServerError(response)
+	}
+	if c.verifyChecksums {
+		if response, err = c.verifyChecksum(op, response); err != nil {
+			return "", false, err
+		}
 	}
 
-	return response, nil
+	if c.compressThreshold > 0 {
+		value, err := decompressValue(response)
+		return value, true, err
+	}
+	return response, true, nil
 }
 
 // Set stores a key-value pair in shrmpl-kv
 func (c *ShrmplKVClient) Set(key, value string, ttl string) error {
-	if len(key) > 100 || len(value) > 100 {
-		return fmt.Errorf("key or value length exceeds 100 characters")
-	}
+	_, _, err := c.SetOpts(key, value, SetOptions{TTL: ttl})
+	return err
+}
 
-	var cmd string
-	if ttl != "" {
-		cmd = fmt.Sprintf("SET %s %s %s", key, value, ttl)
-	} else {
-		cmd = fmt.Sprintf("SET %s %s", key, value)
+// SetReturning stores a key-value pair in shrmpl-kv and returns the payload
+// following OK (e.g. "42" for "OK 42"), or "" for a bare OK
+func (c *ShrmplKVClient) SetReturning(key, value string, ttl string) (string, error) {
+	payload, _, err := c.set(key, value, SetOptions{TTL: ttl})
+	return payload, err
+}
+
+// encodeValue compresses value when compression applies and validates
+// the stored lengths. Compression is deterministic, so encoding the same
+// value twice yields the same stored form.
+func (c *ShrmplKVClient) encodeValue(key, value string) (string, error) {
+	if c.compressThreshold > 0 && len(value) > c.compressThreshold {
+		compressed, err := compressValue(value)
+		if err != nil {
+			return "", err
+		}
+		if len(compressed) < len(value) {
+			value = compressed
+		}
+	}
+
+	// Lengths are validated after compression since that is what the
+	// server stores
+	if err := c.checkKey(key); err != nil {
+		return "", err
 	}
+	limit := c.Limits().MaxValueLength
+	if len(value) > limit {
+		return "", fmt.Errorf("value length exceeds %d characters", limit)
+	}
+	c.limitWarner.check("value", len(value), limit)
+	return value, nil
+}
+
+// notSetResponse is returned by the server when a conditional SET's
+// condition was not met
+const notSetResponse = "*NOT SET*"
+
+// SetOptions controls a SetOpts call
+type SetOptions struct {
+	OnlyIfAbsent  bool   // NX: only set if the key does not exist
+	OnlyIfPresent bool   // XX: only set if the key already exists
+	ReturnOld     bool   // GET: return the previous value
+	KeepTTL       bool   // KEEPTTL: keep the key's current expiration
+	TTL           string // expiration, e.g. "5s"; empty for none
+}
+
+// validate rejects option combinations the server cannot satisfy
+func (o SetOptions) validate() error {
+	if o.OnlyIfAbsent && o.OnlyIfPresent {
+		return fmt.Errorf("OnlyIfAbsent and OnlyIfPresent are mutually exclusive")
+	}
+	if o.KeepTTL && o.TTL != "" {
+		return fmt.Errorf("KeepTTL and TTL are mutually exclusive")
+	}
+	_, err := ParseTTL(o.TTL)
+	return err
+}
 
-	response, err := c.sendCommand(cmd)
+// flags returns the SET command arguments following the value
+func (o SetOptions) flags() []string {
+	var flags []string
+	if o.TTL != "" {
+		flags = append(flags, o.TTL)
+	}
+	if o.OnlyIfAbsent {
+		flags = append(flags, "NX")
+	}
+	if o.OnlyIfPresent {
+		flags = append(flags, "XX")
+	}
+	if o.ReturnOld {
+		flags = append(flags, "GET")
+	}
+	if o.KeepTTL {
+		flags = append(flags, "KEEPTTL")
+	}
+	return flags
+}
+
+// SetOpts stores a key-value pair according to opts. ok reports whether
+// the value was written; it is false when an OnlyIfAbsent or OnlyIfPresent
+// condition was not met. prev holds the previous value when ReturnOld is
+// set, or "" if the key did not exist.
+func (c *ShrmplKVClient) SetOpts(key, value string, opts SetOptions) (prev string, ok bool, err error) {
+	payload, ok, err := c.set(key, value, opts)
+	if err != nil || !ok || !opts.ReturnOld {
+		return "", ok, err
+	}
+	prev, err = decompressValue(payload)
 	if err != nil {
-		return err
+		return "", true, err
 	}
+	return prev, true, nil
+}
 
-	if response != "OK" {
-		return fmt.Errorf("unexpected response: %s", response)
+// set sends a SET command and returns the payload following OK
+func (c *ShrmplKVClient) set(key, value string, opts SetOptions) (string, bool, error) {
+	if err := opts.validate(); err != nil {
+		return "", false, err
 	}
+	ttl, err := normalizeTTL(opts.TTL)
+	if err != nil {
+		return "", false, err
+	}
+	opts.TTL = ttl
 
-	return nil
+	value, err = c.encodeValue(key, value)
+	if err != nil {
+		return "", false, err
+	}
+
+	response, err := c.send("SET", append([]string{key, value}, opts.flags()...)...)
+	if err != nil {
+		return "", false, err
+	}
+	if response == notSetResponse {
+		return "", false, nil
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		return "", false, newServerError(response)
+	}
+
+	payload, ok := parseOK(response)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected response: %s", response)
+	}
+
+	return payload, true, nil
+}
+
+// parseOK reports whether response is "OK" or "OK <payload>" and returns
+// the payload
+func parseOK(response string) (string, bool) {
+	if response == "OK" {
+		return "", true
+	}
+	if payload, found := strings.CutPrefix(response, "OK "); found {
+		return strings.TrimSpace(payload), true
+	}
+	return "", false
 }
 
 // Incr increments a counter in shrmpl-kv
 func (c *ShrmplKVClient) Incr(key string, ttl string) (int, error) {
-	if len(key) > 100 {
-		return 0, fmt.Errorf("key length exceeds 100 characters")
+	if err := c.checkKey(key); err != nil {
+		return 0, err
+	}
+	ttl, err := normalizeTTL(ttl)
+	if err != nil {
+		return 0, err
 	}
 
-	var cmd string
+	args := []string{key}
 	if ttl != "" {
-		cmd = fmt.Sprintf("INCR %s %s", key, ttl)
-	} else {
-		cmd = fmt.Sprintf("INCR %s", key)
+		args = append(args, ttl)
 	}
 
-	response, err := c.sendCommand(cmd)
+	response, err := c.send("INCR", args...)
 	if err != nil {
 		return 0, err
 	}
 
 	if strings.HasPrefix(response, "ERROR") {
-		return 0, errors.New(response)
+		return 0, newServerError(response)
 	}
 
 	result, err := strconv.Atoi(response)
@@ -298,53 +1137,796 @@ func (c *ShrmplKVClient) Incr(key string, ttl string) (int, error) {
 	return result, nil
 }
 
+// Delete removes key from shrmpl-kv and reports whether it existed
+func (c *ShrmplKVClient) Delete(key string) (bool, error) {
+	if err := c.checkKey(key); err != nil {
+		return false, err
+	}
+
+	response, err := c.send("DEL", key)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case response == "OK":
+		return true, nil
+	case response == "*KEY NOT FOUND*":
+		return false, nil
+	case strings.HasPrefix(response, "ERROR"):
+		return false, newServerError(response)
+	}
+	return false, fmt.Errorf("unexpected response: %s", response)
+}
+
+// Batch sends up to the server's batch limit of commands as one BATCH
+// and returns one result per non-empty command; see KV.Batch
+func (c *ShrmplKVClient) Batch(commands []string) ([]BatchResult, error) {
+	return c.sendBatch(commands)
+}
+
+// Ping checks that shrmpl-kv is responsive
+func (c *ShrmplKVClient) Ping() error {
+	response, err := c.send("PING")
+	if err != nil {
+		return err
+	}
+
+	if response != "PONG" {
+		return fmt.Errorf("unexpected response: %s", response)
+	}
+
+	return nil
+}
+
+// defaultListTimeout bounds a whole LIST response
+const defaultListTimeout = 60 * time.Second
+
+// KVListItem is one entry of a LIST response
+type KVListItem struct {
+	Key        string
+	Value      string
+	Expiration string
+}
+
+// String returns the item in the server's "key=value,expiration" form
+func (item KVListItem) String() string {
+	return item.Key + "=" + item.Value + "," + item.Expiration
+}
+
+// parseListItem parses a "key=value,expiration" line
+func parseListItem(line string) (KVListItem, error) {
+	key, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		return KVListItem{}, fmt.Errorf("invalid LIST line: %s", line)
+	}
+	comma := strings.LastIndex(rest, ",")
+	if comma < 0 {
+		return KVListItem{}, fmt.Errorf("invalid LIST line: %s", line)
+	}
+	return KVListItem{Key: key, Value: rest[:comma], Expiration: rest[comma+1:]}, nil
+}
+
+// SetListTimeout bounds the time a whole LIST response may take; zero
+// restores the default of one minute
+func (c *ShrmplKVClient) SetListTimeout(timeout time.Duration) {
+	c.listTimeout = timeout
+}
+
+// List returns the raw "key=value,expiration" lines for every key. It
+// buffers the whole keyspace; use ListFunc for large servers.
+func (c *ShrmplKVClient) List() ([]string, error) {
+	var lines []string
+	err := c.listFunc(func(item KVListItem) (bool, error) {
+		lines = append(lines, item.String())
+		return true, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// ListItems returns every key's item in server order, or an empty slice
+// for an empty keyspace. Like List it buffers the whole keyspace.
+func (c *ShrmplKVClient) ListItems() ([]KVListItem, error) {
+	items := []KVListItem{}
+	err := c.listFunc(func(item KVListItem) (bool, error) {
+		items = append(items, item)
+		return true, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListMap returns every key's item keyed by Key. Like List it buffers the
+// whole keyspace; use ListItems or ListFunc when order matters.
+func (c *ShrmplKVClient) ListMap() (map[string]KVListItem, error) {
+	items := make(map[string]KVListItem)
+	err := c.listFunc(func(item KVListItem) (bool, error) {
+		items[item.Key] = item
+		return true, nil
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ListFunc calls fn for every key as the LIST response arrives, without
+// buffering the keyspace. Iteration stops when fn returns false or an
+// error, or when the list timeout expires. The rest of the response is
+// then still in flight, so the connection is closed rather than drained.
+// The aggregate response limit bounds each line rather than the whole
+// response, since no more than one line is held at a time.
+func (c *ShrmplKVClient) ListFunc(fn func(item KVListItem) (continueIteration bool, err error)) error {
+	return c.listFunc(fn, false)
+}
+
+// listFunc is ListFunc. With buffered set, for callers that keep every
+// item, the lines count against the aggregate response limit together.
+func (c *ShrmplKVClient) listFunc(fn func(item KVListItem) (bool, error), buffered bool) error {
+	timeout := c.listTimeout
+	if timeout <= 0 {
+		timeout = defaultListTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	limit := c.aggregateLimit()
+	parsed, used := 0, 0
+
+	op := "LIST"
+	if c.verifyChecksums {
+		op = "LISTX"
+	}
+	response, err := c.sendLimited(limit, op)
+	if errors.Is(err, errLineLimit) {
+		return c.tooLarge("LIST", limit, parsed)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		return newServerError(response)
+	}
+
+	// The listing is terminated by an empty line
+	for response != "" {
+		// Each line is counted with its newline
+		used += len(response) + 1
+		if c.verifyChecksums {
+			if response, err = c.verifyChecksum(op, response); err != nil {
+				return err
+			}
+		}
+		item, err := parseListItem(response)
+		if err == nil && c.compressThreshold > 0 {
+			item.Value, err = decompressValue(item.Value)
+		}
+		if err != nil {
+			c.Close()
+			return err
+		}
+		more, err := fn(item)
+		if err != nil || !more {
+			c.Close()
+			return err
+		}
+		parsed++
+
+		if time.Now().After(deadline) {
+			c.Close()
+			return c.connError("LIST", os.ErrDeadlineExceeded)
+		}
+		if tcpConn, ok := c.conn.(*net.TCPConn); ok && c.lines == nil {
+			lineDeadline := time.Now().Add(c.opTimeout())
+			if lineDeadline.After(deadline) {
+				lineDeadline = deadline
+			}
+			_ = tcpConn.SetReadDeadline(lineDeadline)
+		}
+		budget := limit
+		if buffered && limit != noLineLimit {
+			budget = limit - used
+		}
+		response, err = c.readLineLimit("LIST", budget)
+		if errors.Is(err, errLineLimit) {
+			return c.tooLarge("LIST", limit, parsed)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DBSize returns the number of keys on the server. If the server does not
+// support DBSIZE and the List fallback is enabled, the keys are counted
+// with LIST instead, which is expensive on large stores.
+func (c *ShrmplKVClient) DBSize() (int, error) {
+	response, err := c.send("DBSIZE")
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.HasPrefix(response, "ERROR") {
+		serverErr := newServerError(response)
+		if !serverErr.UnknownCommand() || !c.dbSizeListFallback {
+			return 0, serverErr
+		}
+		lines, err := c.List()
+		if err != nil {
+			return 0, err
+		}
+		return len(lines), nil
+	}
+
+	size, err := strconv.Atoi(response)
+	if err != nil {
+		return 0, fmt.Errorf("invalid response: %s", response)
+	}
+	return size, nil
+}
+
+// SetDBSizeListFallback enables counting keys with LIST when the server
+// lacks DBSIZE
+func (c *ShrmplKVClient) SetDBSizeListFallback(enabled bool) {
+	c.dbSizeListFallback = enabled
+}
+
 // Close closes the connection to shrmpl-kv
 func (c *ShrmplKVClient) Close() {
 	if c == nil || c.conn == nil {
 		return
 	}
-	c.conn.Close()
+	if c.connObserver != nil {
+		c.connObserver.Closed(time.Since(c.connectedAt))
+	}
+	if c.lines != nil {
+		c.stopping.Store(true)
+		close(c.readerQuit)
+		c.conn.Close()
+		<-c.readerDone
+		c.lines = nil
+	} else {
+		c.conn.Close()
+	}
 	c.conn = nil
 }
 
 // sendCommand sends a command and returns the response
 func (c *ShrmplKVClient) sendCommand(cmd string) (string, error) {
+	return c.sendCommandLimit(cmd, noLineLimit)
+}
+
+// sendCommandLimit sends a command and reads a response of at most max
+// bytes; see nextLineLimit
+func (c *ShrmplKVClient) sendCommandLimit(cmd string, max int) (response string, err error) {
+	start := time.Now()
+	if c.observer != nil {
+		defer func() { c.observer(err) }()
+	}
+	if c.connObserver != nil {
+		c.heartbeats, c.heartbeatRead = 0, 0
+		defer func() { c.observeRoundTrip(start, err) }()
+	}
 	if c.conn == nil {
 		return "", fmt.Errorf("not connected")
 	}
 
-	// Set read deadline for this operation
-	if tcpConn, ok := c.conn.(*net.TCPConn); ok {
-		_ = tcpConn.SetReadDeadline(time.Now().Add(c.timeout))
+	// Set read deadline for this operation. With a background reader
+	// running the deadline is enforced by nextLine instead.
+	if tcpConn, ok := c.conn.(*net.TCPConn); ok && c.lines == nil {
+		_ = tcpConn.SetReadDeadline(start.Add(c.opTimeout()))
+	}
+
+	line := cmd
+	if c.tag != "" {
+		line += " " + tagAnnotation + c.tag
+	}
+	err = writeFull(c.conn, []byte(line+"\n"))
+	if err != nil {
+		return "", c.connError(cmd, err)
 	}
 
-	_, err := c.conn.Write([]byte(cmd + "\n"))
+	response, err = c.readLineLimit(cmd, max)
 	if err != nil {
-		return "", err
+		return response, err
 	}
 
-	reader := bufio.NewReader(c.conn)
+	if c.adaptive != nil {
+		c.adaptive.observe(time.Since(start))
+	}
+	return response, nil
+}
+
+// readLine reads the next response line, skipping heartbeats
+func (c *ShrmplKVClient) readLine(cmd string) (string, error) {
+	return c.readLineLimit(cmd, noLineLimit)
+}
+
+// readLineLimit reads the next response line of at most max bytes,
+// skipping heartbeats. A longer line returns what was read of it with
+// errLineLimit.
+func (c *ShrmplKVClient) readLineLimit(cmd string, max int) (string, error) {
 	for {
-		response, err := reader.ReadString('\n')
+		readStart := time.Now()
+		response, err := c.nextLineLimit(max)
+		if errors.Is(err, errLineLimit) {
+			return response, err
+		}
 		if err != nil {
-			return "", err
+			return "", c.connError(cmd, err)
 		}
 
 		response = strings.TrimSpace(response)
 
 		// Skip heartbeats
 		if response == "UPONG" {
+			c.heartbeats++
+			c.heartbeatRead += time.Since(readStart)
 			continue
 		}
 		if response == "TERM" {
-			return "", fmt.Errorf("server shutting down")
+			return "", c.connError(cmd, ErrServerTerminating)
 		}
 
 		return response, nil
 	}
 }
 
+// nextLine returns the next raw line, either directly from the connection
+// or from the background reader when subscriptions are active
+func (c *ShrmplKVClient) nextLine() (string, error) {
+	if c.lines == nil {
+		return c.readString()
+	}
+
+	timer := time.NewTimer(c.opTimeout())
+	defer timer.Stop()
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return "", c.readerErr
+		}
+		return line, nil
+	case <-timer.C:
+		return "", os.ErrDeadlineExceeded
+	}
+}
+
+// Subscribe asks the server to push "INVALIDATE <key>" lines for keys
+// starting with prefix (all keys when prefix is empty) and calls handler
+// for each. A background reader demultiplexes pushed lines from command
+// responses, so the client stays usable for regular commands. Handlers run
+// on the reader goroutine and must not block or call back into the client.
+func (c *ShrmplKVClient) Subscribe(prefix string, handler func(key string)) error {
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	c.startReader()
+	c.subMu.Lock()
+	if c.handlers == nil {
+		c.handlers = make(map[string]func(key string))
+	}
+	c.handlers[prefix] = handler
+	c.subMu.Unlock()
+
+	response, err := c.send("SUBSCRIBE", prefixArgs(prefix)...)
+	if err == nil {
+		if _, ok := parseOK(response); !ok {
+			if strings.HasPrefix(response, "ERROR") {
+				err = newServerError(response)
+			} else {
+				err = fmt.Errorf("unexpected response: %s", response)
+			}
+		}
+	}
+	if err != nil {
+		c.removeHandler(prefix)
+	}
+	return err
+}
+
+// Unsubscribe cancels the subscription for prefix. The background reader
+// stops once no subscriptions remain.
+func (c *ShrmplKVClient) Unsubscribe(prefix string) error {
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	response, err := c.send("UNSUBSCRIBE", prefixArgs(prefix)...)
+	c.removeHandler(prefix)
+	if err != nil {
+		return err
+	}
+	if _, ok := parseOK(response); !ok {
+		return fmt.Errorf("unexpected response: %s", response)
+	}
+	return nil
+}
+
+// removeHandler drops the handler for prefix and stops the reader when it
+// was the last one
+func (c *ShrmplKVClient) removeHandler(prefix string) {
+	c.subMu.Lock()
+	delete(c.handlers, prefix)
+	remaining := len(c.handlers)
+	c.subMu.Unlock()
+
+	if remaining == 0 {
+		c.stopReader()
+	}
+}
+
+// startReader starts the background reader if it is not running
+func (c *ShrmplKVClient) startReader() {
+	if c.lines != nil {
+		return
+	}
+	_ = c.conn.SetReadDeadline(time.Time{})
+	c.lines = make(chan string, 64)
+	c.readerDone = make(chan struct{})
+	c.readerQuit = make(chan struct{})
+	c.readerErr = nil
+	go c.readLoop(c.lines, c.readerDone, c.readerQuit)
+}
+
+// stopReader stops the background reader so reads go directly to the
+// connection again
+func (c *ShrmplKVClient) stopReader() {
+	if c.lines == nil {
+		return
+	}
+	c.stopping.Store(true)
+	close(c.readerQuit)
+	_ = c.conn.SetReadDeadline(time.Now())
+	<-c.readerDone
+	_ = c.conn.SetReadDeadline(time.Time{})
+	c.stopping.Store(false)
+	c.lines = nil
+}
+
+// readLoop reads every line from the connection, dispatching invalidation
+// pushes to handlers and forwarding everything else to nextLine
+func (c *ShrmplKVClient) readLoop(lines chan<- string, done chan<- struct{},
+	quit <-chan struct{}) {
+	defer close(done)
+	defer close(lines)
+	for {
+		line, err := c.readString()
+		if err != nil {
+			if !c.stopping.Load() {
+				c.readerErr = err
+			}
+			return
+		}
+
+		if key, found := strings.CutPrefix(strings.TrimSpace(line), "INVALIDATE "); found {
+			c.dispatch(key)
+			continue
+		}
+		select {
+		case lines <- line:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// dispatch calls every handler whose prefix matches key
+func (c *ShrmplKVClient) dispatch(key string) {
+	c.subMu.Lock()
+	var matched []func(key string)
+	for prefix, handler := range c.handlers {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, handler)
+		}
+	}
+	c.subMu.Unlock()
+
+	for _, handler := range matched {
+		handler(key)
+	}
+}
+
+// AdaptiveTimeout configures a read deadline derived from observed latency
+// instead of the fixed default. The deadline is Multiplier times the p99
+// of the last Window operations, clamped to [Min, Max].
+type AdaptiveTimeout struct {
+	Multiplier float64
+	Min        time.Duration
+	Max        time.Duration
+	Window     int
+}
+
+// adaptiveState tracks a rolling window of operation latencies
+type adaptiveState struct {
+	cfg     AdaptiveTimeout
+	samples []time.Duration
+	next    int
+	filled  bool
+	p99     time.Duration
+	timeout time.Duration
+}
+
+// KVStats is a snapshot of a client's connection statistics
+type KVStats struct {
+	Timeout    time.Duration
+	LatencyP99 time.Duration
+	Adaptive   bool
+	// Evictions counts reads answered with an evicted-key reply, a sign
+	// the server is short of memory
+	Evictions int64
+	// ChecksumMismatches counts responses that failed verification with
+	// ErrChecksumMismatch; see KVConfig.VerifyChecksums
+	ChecksumMismatches int64
+}
+
+// SetAdaptiveTimeout enables adaptive read deadlines on this client
+func (c *ShrmplKVClient) SetAdaptiveTimeout(cfg AdaptiveTimeout) {
+	if cfg.Multiplier <= 0 {
+		cfg.Multiplier = 4
+	}
+	if cfg.Min <= 0 {
+		cfg.Min = 50 * time.Millisecond
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = c.timeout
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 256
+	}
+	c.adaptive = &adaptiveState{
+		cfg:     cfg,
+		samples: make([]time.Duration, cfg.Window),
+		timeout: cfg.Max,
+	}
+}
+
+// opTimeout returns the read deadline to use for the next operation
+func (c *ShrmplKVClient) opTimeout() time.Duration {
+	if c.adaptive != nil {
+		return c.adaptive.timeout
+	}
+	return c.timeout
+}
+
+// Stats returns a snapshot of this client's statistics
+func (c *ShrmplKVClient) Stats() KVStats {
+	stats := KVStats{
+		Timeout:            c.opTimeout(),
+		Evictions:          c.evictions.Load(),
+		ChecksumMismatches: c.checksumMismatches.Load(),
+	}
+	if c.adaptive != nil {
+		stats.Adaptive = true
+		stats.LatencyP99 = c.adaptive.p99
+	}
+	return stats
+}
+
+// observe records a latency sample and periodically recomputes the timeout
+func (a *adaptiveState) observe(d time.Duration) {
+	a.samples[a.next] = d
+	a.next = (a.next + 1) % len(a.samples)
+	if a.next == 0 {
+		a.filled = true
+	}
+	// Recompute every 16 samples to keep the per-operation cost low
+	if a.next%16 != 0 {
+		return
+	}
+
+	n := a.next
+	if a.filled {
+		n = len(a.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, a.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	a.p99 = sorted[(n*99)/100]
+
+	timeout := time.Duration(float64(a.p99) * a.cfg.Multiplier)
+	if timeout < a.cfg.Min {
+		timeout = a.cfg.Min
+	}
+	if timeout > a.cfg.Max {
+		timeout = a.cfg.Max
+	}
+	a.timeout = timeout
+}
+
+// compressedMarker prefixes values stored by the compression mode. The
+// marker is not negotiated with the server, so every reader and writer of
+// a key must agree on whether compression is enabled.
+const compressedMarker = "~gz~"
+
+// SetCompression enables transparent compression of values longer than
+// threshold bytes; zero disables it. Compressed values are gzipped and
+// base64url encoded behind compressedMarker, and are only used when
+// smaller than the original. Get decompresses marked values and passes
+// unmarked values through untouched. Batch commands are sent verbatim.
+func (c *ShrmplKVClient) SetCompression(threshold int) {
+	c.compressThreshold = threshold
+}
+
+// compressValue gzips value and encodes it with the compression marker
+func compressValue(value string) (string, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return compressedMarker + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decompressValue reverses compressValue, returning unmarked values as is
+func decompressValue(value string) (string, error) {
+	encoded, found := strings.CutPrefix(value, compressedMarker)
+	if !found {
+		return value, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed value: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed value: %w", err)
+	}
+	defer zr.Close()
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed value: %w", err)
+	}
+	return string(plain), nil
+}
+
+// maxConcurrentProbes bounds the number of simultaneous ProbeServers dials
+const maxConcurrentProbes = 16
+
+// ProbeResult reports the health of one server checked by ProbeServers
+type ProbeResult struct {
+	Addr      string
+	Reachable bool
+	Latency   time.Duration
+	Err       error
+}
+
+// ProbeServers concurrently connects to and PINGs each KV "host:port"
+// address, with timeout bounding each probe. Results are returned in the
+// same order as addrs.
+func ProbeServers(addrs []string, timeout time.Duration) []ProbeResult {
+	results := make([]ProbeResult, len(addrs))
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = probeServer(addr, timeout)
+		}(i, addr)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// probeServer connects to a single server and measures a PING round trip
+func probeServer(addr string, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Addr: addr}
+
+	host, portStr, err := parseHostPort(addr)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		result.Err = fmt.Errorf("invalid port: %s", portStr)
+		return result
+	}
+
+	client := NewShrmplKVClient(host, port)
+	client.timeout = timeout
+	if err := client.Connect(); err != nil {
+		result.Err = err
+		return result
+	}
+	defer client.Close()
+
+	start := time.Now()
+	if err := client.Ping(); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Latency = time.Since(start)
+	result.Reachable = true
+	return result
+}
+
 // KVConfig for configuring the KV client
 type KVConfig struct {
 	HostPort string
+	// Lazy defers dialing until the first operation. Construction is then
+	// free and silent, but a bad address or unreachable server is only
+	// noticed when the client is first used, and that operation pays the
+	// connection cost.
+	Lazy            bool
+	AdaptiveTimeout *AdaptiveTimeout
+	// CompressThreshold enables value compression above this many bytes;
+	// zero disables it. See SetCompression.
+	CompressThreshold int
+	// DBSizeListFallback counts keys with LIST when DBSIZE is unsupported
+	DBSizeListFallback bool
+	// Tracer, when set, records a span for every operation made through
+	// the Context methods
+	Tracer Tracer
+	// RedactTraceKeys replaces keys in span attributes with "[redacted]"
+	RedactTraceKeys bool
+	// Handshake sends HELLO on connect to learn the server's limits and
+	// commands; see Capabilities
+	Handshake bool
+	// CapabilityCache shares handshake results between clients; nil uses
+	// DefaultCapabilityCache
+	CapabilityCache *CapabilityCache
+	// CoalesceGets makes concurrent Gets of the same key share a single
+	// request and its result, saving round trips for hot keys
+	CoalesceGets bool
+	// TermPolicy decides how operations interrupted by the server's TERM
+	// notice behave: TermReturnError (the default), TermReconnectSilent
+	// or TermBlock
+	TermPolicy string
+	// TermBlockWindow bounds the wait under TermBlock; zero means 30s
+	TermBlockWindow time.Duration
+	// HashLongKeys replaces keys over the key length limit with
+	// HashLongKey(key) instead of failing; LIST results map them back for
+	// keys this client wrote
+	HashLongKeys bool
+	// MaxAggregateResponseBytes bounds the bytes buffered for one LIST,
+	// BATCH or EXEC response; zero means
+	// DefaultMaxAggregateResponseBytes and a negative value disables the
+	// limit. See SetMaxAggregateResponseBytes.
+	MaxAggregateResponseBytes int
+	// VerifyChecksums has Get, Batch and List request checksummed
+	// responses and verify them, on servers whose HELLO advertises
+	// checksums=1. It needs Handshake; other servers are read unverified.
+	VerifyChecksums bool
+	// MaxKeyLength and MaxValueLength are the limits checked before
+	// sending when the server does not advertise its own; zero means 100.
+	// See KV.Limits.
+	MaxKeyLength   int
+	MaxValueLength int
+	// DrainBatchErrors keeps the connection when a Batch fails in a way
+	// that leaves its framing intact, such as a response over
+	// MaxAggregateResponseBytes, instead of closing it and reconnecting
+	// on the next call. See SetDrainBatchErrors.
+	DrainBatchErrors bool
+	// WarnThreshold, e.g. 0.9, reports keys, values and batches that are
+	// at least this fraction of their limit but within it, once per call
+	// site and limit per WarnInterval (zero means a minute). Warnings go
+	// to OnLimitWarning, or else are logged to LimitWarningLogger; they
+	// never change an operation's outcome. Zero disables them. See
+	// SetLimitWarnings.
+	WarnThreshold      float64
+	WarnInterval       time.Duration
+	OnLimitWarning     func(LimitWarning)
+	LimitWarningLogger ThisAppLoggerInterface
+	// OnResubscribeError is called with the prefix and error when a
+	// subscription cannot be restored on a new connection; until the next
+	// reconnect no invalidations arrive for that prefix. Nil prints the
+	// error to stderr.
+	OnResubscribeError func(prefix string, err error)
 }
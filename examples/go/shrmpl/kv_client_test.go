@@ -0,0 +1,265 @@
+package shrmpl_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestConnectionIdleBeforeFirstCommand(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	host, portStr, err := net.SplitHostPort(srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	c := shrmpl.NewShrmplKVClient(host, port)
+	const timeout = 50 * time.Millisecond
+	c.SetAdaptiveTimeout(shrmpl.AdaptiveTimeout{Min: timeout, Max: timeout})
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The first command comes after the read timeout has passed
+	time.Sleep(3 * timeout)
+	if err := c.Set("idle", "v", ""); err != nil {
+		t.Fatalf("first command after an idle connect: %v", err)
+	}
+	if value, err := c.Get("idle"); err != nil || value != "v" {
+		t.Errorf("Get = %q, %v; want v", value, err)
+	}
+}
+
+// scriptedKVServer serves one connection at a time, answering each
+// command line with respond's result, for responses the fake server does
+// not produce. It returns the listen address.
+func scriptedKVServer(t *testing.T, respond func(line string) string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				if _, err := conn.Write([]byte(respond(strings.TrimSpace(line)) + "\n")); err != nil {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSetReturningPayload(t *testing.T) {
+	addr := scriptedKVServer(t, func(line string) string {
+		if strings.HasPrefix(line, "SET bare ") {
+			return "OK"
+		}
+		return "OK 42"
+	})
+	kv := shrmpl.NewKV(&shrmpl.KVConfig{HostPort: addr}).(*shrmpl.KV)
+	defer kv.Close()
+
+	payload, err := kv.SetReturning("counter", "v", "")
+	if err != nil || payload != "42" {
+		t.Errorf("SetReturning = %q, %v; want 42", payload, err)
+	}
+	if payload, err := kv.SetReturning("bare", "v", ""); err != nil || payload != "" {
+		t.Errorf("SetReturning with a bare OK = %q, %v; want empty", payload, err)
+	}
+	// Callers that do not want the payload still see a success
+	if err := kv.Set("counter", "v", ""); err != nil {
+		t.Errorf("Set with an OK payload: %v", err)
+	}
+}
+
+// mixedBatchServer answers HELLO with a batch limit of 4 and every BATCH
+// with an OK, a value, a server error and a miss, in that order
+func mixedBatchServer(t *testing.T) string {
+	return scriptedKVServer(t, func(line string) string {
+		switch {
+		case line == "HELLO":
+			return "HELLO batch=4 commands=BATCH,GET,SET,INCR,HELLO"
+		case strings.HasPrefix(line, "BATCH "):
+			return "OK;v1;ERROR invalid arguments;*KEY NOT FOUND*"
+		case line == "GET a":
+			return "v1"
+		}
+		return "ERROR unknown command"
+	})
+}
+
+func TestBatchMixedResults(t *testing.T) {
+	kv := shrmpl.NewKV(&shrmpl.KVConfig{HostPort: mixedBatchServer(t), Handshake: true})
+	defer kv.Close()
+
+	commands := []string{"SET a v1", "GET a", "INCR a 5s", "GET missing"}
+	results, err := kv.Batch(commands)
+	if err != nil {
+		t.Fatalf("Batch = %v; a sub-command failure must not fail the batch", err)
+	}
+	if len(results) != len(commands) {
+		t.Fatalf("got %d results, want %d", len(results), len(commands))
+	}
+	for i, r := range results {
+		if r.Command != commands[i] {
+			t.Errorf("result %d command = %q, want %q", i, r.Command, commands[i])
+		}
+	}
+	if r := results[0]; r.Err != nil || r.NotFound || r.Value != "OK" {
+		t.Errorf("SET result = %+v, want OK", r)
+	}
+	if r := results[1]; r.Err != nil || r.NotFound || r.Value != "v1" {
+		t.Errorf("GET result = %+v, want v1", r)
+	}
+	var serverErr *shrmpl.ServerError
+	if r := results[2]; !errors.As(r.Err, &serverErr) ||
+		serverErr.Kind() != shrmpl.ErrKindInvalidArguments || r.NotFound {
+		t.Errorf("INCR result = %+v, want an invalid-arguments ServerError", r)
+	}
+	if r := results[3]; r.Err != nil || !r.NotFound || r.Value != "" {
+		t.Errorf("missing GET result = %+v, want NotFound", r)
+	}
+
+	// The client stays usable after sub-command errors
+	if value, err := kv.Get("a"); err != nil || value != "v1" {
+		t.Errorf("Get after a mixed batch = %q, %v; want v1", value, err)
+	}
+}
+
+func TestBatchValuesFailsOnServerError(t *testing.T) {
+	kv := shrmpl.NewKV(&shrmpl.KVConfig{HostPort: mixedBatchServer(t), Handshake: true}).(*shrmpl.KV)
+	defer kv.Close()
+
+	_, err := kv.BatchValues([]string{"SET a v1", "GET a", "INCR a 5s", "GET missing"})
+	var serverErr *shrmpl.ServerError
+	if !errors.As(err, &serverErr) || !strings.Contains(err.Error(), "batch command 2 (INCR a 5s)") {
+		t.Errorf("BatchValues = %v, want the failing command's ServerError", err)
+	}
+}
+
+func TestBatchValuesTreatsMissesAsEmpty(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config()).(*shrmpl.KV)
+	defer kv.Close()
+
+	values, err := kv.BatchValues([]string{"SET a v1", "GET a", "GET missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"OK", "v1", ""}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("value %d = %q, want %q", i, values[i], want[i])
+		}
+	}
+}
+
+func TestSetReturningKeepsConnectionOnServerError(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	// Tighter than the limit the client assumes without a handshake
+	srv.SetLimits(100, 5)
+	kv := shrmpl.NewKV(srv.Config()).(*shrmpl.KV)
+	defer kv.Close()
+
+	_, err := kv.SetReturning("k", "too-long-value", "")
+	var serverErr *shrmpl.ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("SetReturning = %v, want a ServerError", err)
+	}
+	if _, err := kv.SetReturning("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	if dials := srv.Dials(); dials != 1 {
+		t.Errorf("dials = %d, want 1: a rejected SET must not force a reconnect", dials)
+	}
+}
+
+// connectedClient returns a raw client connected to srv
+func connectedClient(t *testing.T, srv *shrmpltest.KVServer) *shrmpl.ShrmplKVClient {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	c := shrmpl.NewShrmplKVClient(host, port)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestServerCloseReportsConnectionClosed(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	c := connectedClient(t, srv)
+	if err := c.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.DropConnections()
+	_, err := c.Get("k")
+	var connErr *shrmpl.ConnError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Get after the server closed = %v, want a *ConnError", err)
+	}
+	if !errors.Is(connErr, shrmpl.ErrConnectionClosed) {
+		t.Errorf("ConnError.Err = %v, want ErrConnectionClosed", connErr.Err)
+	}
+	if connErr.Cmd == "" || connErr.RemoteAddr != srv.Addr {
+		t.Errorf("ConnError = %+v, want the command and the server's address", connErr)
+	}
+}
+
+func TestKVRetriesSafeOperationAfterServerClose(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+	if err := kv.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Get is safe to repeat, so it is retried on a fresh connection
+	srv.DropConnections()
+	if value, err := kv.Get("k"); err != nil || value != "v" {
+		t.Errorf("Get after the server closed = %q, %v; want v", value, err)
+	}
+
+	// Incr is not, so the caller sees the typed error
+	srv.DropConnections()
+	_, err := kv.Incr("n", "")
+	var connErr *shrmpl.ConnError
+	if !errors.As(err, &connErr) || !errors.Is(err, shrmpl.ErrConnectionClosed) {
+		t.Errorf("Incr after the server closed = %v, want a *ConnError wrapping ErrConnectionClosed", err)
+	}
+	if value, ok := srv.Value("n"); ok {
+		t.Errorf("n = %q, want the failed Incr not applied", value)
+	}
+}
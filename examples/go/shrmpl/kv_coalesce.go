@@ -0,0 +1,45 @@
+package shrmpl
+
+import "sync"
+
+// flightGroup lets concurrent callers for the same key share one call, in
+// the manner of golang.org/x/sync/singleflight. The zero value is ready to
+// use.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is an in-progress or completed call
+type flight struct {
+	done  sync.WaitGroup
+	value string
+	err   error
+}
+
+// do calls fn for key unless a call for key is already in flight, in which
+// case it waits for that call and returns its result
+func (g *flightGroup) do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		f.done.Wait()
+		return f.value, f.err
+	}
+	f := &flight{}
+	f.done.Add(1)
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		f.done.Done()
+	}()
+	f.value, f.err = fn()
+	return f.value, f.err
+}
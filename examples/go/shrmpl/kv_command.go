@@ -0,0 +1,134 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidCommand is returned, before anything is sent, for commands the
+// line protocol cannot carry intact, such as a value containing a space
+var ErrInvalidCommand = errors.New("invalid command")
+
+// commandArity is the number of arguments each command accepts; a max of
+// -1 means no limit
+var commandArity = map[string]struct{ min, max int }{
+	"GET":         {1, 1},
+	"GETX":        {1, 1},
+	"SET":         {2, 6}, // key value [ttl] [NX|XX] [GET] [KEEPTTL]
+	"INCR":        {1, 2},
+	"DEL":         {1, 1},
+	"CAS":         {3, 4},
+	"TOUCH":       {2, 2},
+	"TTL":         {1, 1},
+	"BATCH":       {1, -1},
+	"PING":        {0, 0},
+	"LIST":        {0, 0},
+	"LISTX":       {0, 0},
+	"DBSIZE":      {0, 0},
+	"HELLO":       {0, 0},
+	"MULTI":       {0, 0},
+	"EXEC":        {0, 0},
+	"DISCARD":     {0, 0},
+	"SUBSCRIBE":   {0, 1},
+	"UNSUBSCRIBE": {0, 1},
+}
+
+// encodeCommand builds the wire form of op with args. It is the only place
+// commands are assembled: it checks the argument count for op and that
+// every argument survives the server's whitespace splitting and the ';'
+// BATCH separator. BATCH arguments are whole sub-commands and are checked
+// recursively.
+func encodeCommand(op string, args ...string) (string, error) {
+	arity, ok := commandArity[op]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown command %q", ErrInvalidCommand, op)
+	}
+	if len(args) < arity.min || (arity.max >= 0 && len(args) > arity.max) {
+		return "", fmt.Errorf("%w: %s takes %s arguments, got %d",
+			ErrInvalidCommand, op, arityString(arity.min, arity.max), len(args))
+	}
+
+	if op == "BATCH" {
+		for _, cmd := range args {
+			if err := validateBatchCommand(cmd); err != nil {
+				return "", err
+			}
+		}
+		return "BATCH " + strings.Join(args, ";"), nil
+	}
+
+	for _, arg := range args {
+		if err := validateArg(op, arg); err != nil {
+			return "", err
+		}
+	}
+	if len(args) == 0 {
+		return op, nil
+	}
+	return op + " " + strings.Join(args, " "), nil
+}
+
+// validateArg rejects empty arguments and those containing whitespace,
+// control characters or ';'
+func validateArg(op, arg string) error {
+	if arg == "" {
+		return fmt.Errorf("%w: %s argument is empty", ErrInvalidCommand, op)
+	}
+	for _, r := range arg {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == ';' {
+			return fmt.Errorf("%w: %s argument %q contains %q", ErrInvalidCommand, op, arg, r)
+		}
+	}
+	return nil
+}
+
+// validateBatchCommand checks one BATCH sub-command. Empty sub-commands
+// are allowed since the server skips them.
+func validateBatchCommand(cmd string) error {
+	for _, r := range cmd {
+		if r == ';' || (unicode.IsControl(r) && r != '\t') {
+			return fmt.Errorf("%w: batch command %q contains %q", ErrInvalidCommand, cmd, r)
+		}
+	}
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return nil
+	}
+	if fields[0] == "BATCH" {
+		return fmt.Errorf("%w: BATCH cannot be nested", ErrInvalidCommand)
+	}
+	_, err := encodeCommand(fields[0], fields[1:]...)
+	return err
+}
+
+// arityString describes an argument count range for error messages
+func arityString(min, max int) string {
+	switch {
+	case min == max:
+		return fmt.Sprint(min)
+	case max < 0:
+		return fmt.Sprintf("at least %d", min)
+	default:
+		return fmt.Sprintf("%d to %d", min, max)
+	}
+}
+
+// prefixArgs returns the optional prefix argument of SUBSCRIBE and
+// UNSUBSCRIBE; an empty prefix means every key
+func prefixArgs(prefix string) []string {
+	if prefix = strings.TrimSpace(prefix); prefix == "" {
+		return nil
+	}
+	return []string{prefix}
+}
+
+// send encodes op with args and sends it
+func (c *ShrmplKVClient) send(op string, args ...string) (string, error) {
+	cmd, err := encodeCommand(op, args...)
+	if err != nil {
+		return "", err
+	}
+	return c.sendCommand(cmd)
+}
@@ -0,0 +1,96 @@
+package shrmpl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// okConn answers every write with "OK" and records what was written
+type okConn struct {
+	net.Conn
+	written bytes.Buffer
+	pending []byte
+}
+
+func (c *okConn) Write(p []byte) (int, error) {
+	c.written.Write(p)
+	c.pending = append(c.pending, "OK\n"...)
+	return len(p), nil
+}
+
+func (c *okConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *okConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *okConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *okConn) Close() error                     { return nil }
+func (c *okConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *okConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+
+func FuzzEncodeCommand(f *testing.F) {
+	f.Add("k", "v")
+	f.Add("a b", "v")
+	f.Add("k", "v\nDEL x")
+	f.Add("k;DEL x", "v")
+	f.Add("k\r", "v")
+	f.Add("", "v")
+	f.Add(strings.Repeat("k", 101), "v")
+	f.Add("k", strings.Repeat("v", 101))
+	f.Add("ключ", "значение")
+
+	f.Fuzz(func(t *testing.T, key, value string) {
+		cmd, err := encodeCommand("SET", key, value)
+		if strings.ContainsAny(key+value, " \n") || key == "" || value == "" {
+			if !errors.Is(err, ErrInvalidCommand) {
+				t.Fatalf("encodeCommand(SET, %q, %q) = %q, %v; want ErrInvalidCommand",
+					key, value, cmd, err)
+			}
+		}
+		if err == nil {
+			// The server must split the line back into exactly the
+			// arguments given, as one command
+			if strings.ContainsAny(cmd, "\r\n;") {
+				t.Fatalf("encoded %q, which the server would split", cmd)
+			}
+			if fields := strings.Fields(cmd); len(fields) != 3 ||
+				fields[0] != "SET" || fields[1] != key || fields[2] != value {
+				t.Fatalf("encoded %q, which the server reads as %q", cmd, fields)
+			}
+		}
+
+		// Through the client, anything rejected, including keys and values
+		// over the 100-character limits, never reaches the connection
+		conn := &okConn{}
+		c := NewShrmplKVClient("127.0.0.1", 0)
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+		setErr := c.Set(key, value, "")
+		tooLong := len(key) > 100 || len(value) > 100
+		switch {
+		case err != nil || tooLong:
+			if setErr == nil {
+				t.Fatalf("Set(%q, %q) succeeded", key, value)
+			}
+			if conn.written.Len() > 0 {
+				t.Fatalf("Set(%q, %q) failed with %v but wrote %q",
+					key, value, setErr, conn.written.String())
+			}
+		case setErr != nil:
+			t.Fatalf("Set(%q, %q) = %v", key, value, setErr)
+		case conn.written.String() != cmd+"\n":
+			t.Fatalf("Set wrote %q, want %q", conn.written.String(), cmd+"\n")
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// evictedResponse is the server's reply for a key it dropped under memory
+// pressure, as opposed to one that never existed or expired
+const evictedResponse = "*KEY EVICTED*"
+
+// ErrKeyNotFound matches lookups of missing keys that are reported as
+// errors. A plain miss is not an error: Get returns an empty value and
+// Batch sets BatchResult.NotFound.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrKeyEvicted is returned by Get, and set in BatchResult.Err, when the
+// server evicted the key. It wraps ErrKeyNotFound, so callers that treat
+// every miss alike can test for that, while capacity monitoring can tell
+// evictions apart.
+var ErrKeyEvicted error = keyEvictedError{}
+
+// keyEvictedError is the type of ErrKeyEvicted
+type keyEvictedError struct{}
+
+func (keyEvictedError) Error() string { return "key evicted" }
+func (keyEvictedError) Unwrap() error { return ErrKeyNotFound }
+
+// evicted counts an eviction reply and returns the error for key
+func (c *ShrmplKVClient) evicted(key string) error {
+	c.evictions.Add(1)
+	return fmt.Errorf("%w: %s", ErrKeyEvicted, key)
+}
+
+// batchKey returns the key of a BATCH sub-command such as "GET k"
+func batchKey(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) < 2 {
+		return cmd
+	}
+	return fields[1]
+}
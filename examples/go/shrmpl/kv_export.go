@@ -0,0 +1,129 @@
+package shrmpl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportHeader starts every export, naming the format and its version
+const exportHeader = "shrmpl-kv-export 1"
+
+// exportNoTTL marks exported keys stored without a TTL
+const exportNoTTL = "-"
+
+// listNoExpiration is the expiration LIST reports for keys without a TTL
+const listNoExpiration = "no-expiration"
+
+// Export writes every key whose name starts with prefix to w, one
+// "<key> <ttl> <value>" line each after a header line, and returns the
+// number of keys written. ttl is the time the key had left, in the
+// server's TTL syntax rounded up to the second, or "-" for none; keys
+// that expire while the export runs are skipped. Values are written as
+// Get returns them, so a counter created by INCR is written as its decimal
+// value; Import restores it with SET, which stores an integer value as an
+// integer again, and INCR continues from it. The keyspace is read with one
+// LIST, streamed line by line, so keys written meanwhile may or may not be
+// included. See Import.
+func (kv *KV) Export(w io.Writer, prefix string) (int, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(exportHeader + "\n"); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	now := time.Now()
+	err := kv.ListFunc(func(item KVListItem) (bool, error) {
+		if !strings.HasPrefix(item.Key, prefix) {
+			return true, nil
+		}
+		ttl, live, err := exportTTL(item.Expiration, now)
+		if err != nil || !live {
+			return err == nil, err
+		}
+		if _, err := fmt.Fprintf(bw, "%s %s %s\n", item.Key, ttl, item.Value); err != nil {
+			return false, err
+		}
+		count++
+		return true, nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// exportTTL converts a LIST expiration, in Unix seconds, to the remaining
+// TTL at now. live is false for keys that have already expired.
+func exportTTL(expiration string, now time.Time) (ttl string, live bool, err error) {
+	if expiration == listNoExpiration {
+		return exportNoTTL, true, nil
+	}
+	unix, err := strconv.ParseInt(expiration, 10, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("unexpected LIST expiration: %s", expiration)
+	}
+	remaining := time.Unix(unix, 0).Sub(now)
+	if remaining <= 0 {
+		return "", false, nil
+	}
+	return FormatTTL(remaining), true, nil
+}
+
+// Import reads an export written by Export from r and sets each key with
+// its recorded TTL, which starts again from the time of the import. It
+// returns the number of keys set; on an error, keys before the failing
+// line have been set.
+func (kv *KV) Import(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if strings.TrimRight(header, "\r\n") != exportHeader {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("not a shrmpl-kv export: missing %q header", exportHeader)
+	}
+
+	count := 0
+	for line := 2; err == nil; line++ {
+		var text string
+		text, err = br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return count, err
+		}
+		text = strings.TrimRight(text, "\r\n")
+		if text == "" {
+			continue
+		}
+		key, ttl, value, ok := parseExportLine(text)
+		if !ok {
+			return count, fmt.Errorf("export line %d: expected \"<key> <ttl> <value>\"", line)
+		}
+		if setErr := kv.Set(key, value, ttl); setErr != nil {
+			return count, fmt.Errorf("export line %d: %w", line, setErr)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// parseExportLine splits a "<key> <ttl> <value>" line. The value is the
+// rest of the line and "-" becomes the empty TTL.
+func parseExportLine(line string) (key, ttl, value string, ok bool) {
+	key, rest, ok := strings.Cut(line, " ")
+	if !ok || key == "" {
+		return "", "", "", false
+	}
+	ttl, value, ok = strings.Cut(rest, " ")
+	if !ok || ttl == "" {
+		return "", "", "", false
+	}
+	if ttl == exportNoTTL {
+		ttl = ""
+	}
+	return key, ttl, value, true
+}
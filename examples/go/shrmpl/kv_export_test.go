@@ -0,0 +1,67 @@
+package shrmpl_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := shrmpltest.NewKVServer()
+	defer src.Close()
+	// The listing is well over the aggregate limit, which Export streams past
+	cfg := src.Config()
+	cfg.MaxAggregateResponseBytes = 200
+	from := shrmpl.NewKV(cfg).(*shrmpl.KV)
+	defer from.Close()
+	for i := 0; i < 20; i++ {
+		src.Put(fmt.Sprintf("fill%02d", i), strings.Repeat("v", 20))
+	}
+	if err := from.Set("plain", "value", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Set("session", "token", "1h"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := from.Incr("count", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var export bytes.Buffer
+	if n, err := from.Export(&export, ""); err != nil || n != 23 {
+		t.Fatalf("Export = %d, %v; want 23 keys", n, err)
+	}
+
+	dst := shrmpltest.NewKVServer()
+	defer dst.Close()
+	to := shrmpl.NewKV(dst.Config()).(*shrmpl.KV)
+	defer to.Close()
+	if n, err := to.Import(&export); err != nil || n != 23 {
+		t.Fatalf("Import = %d, %v; want 23 keys", n, err)
+	}
+
+	value, remaining, found, err := to.GetWithTTL("plain")
+	if err != nil || !found || value != "value" || remaining != shrmpl.NoExpiration {
+		t.Errorf("plain = %q, %v, %v, %v; want value without expiration",
+			value, remaining, found, err)
+	}
+	// The exported TTL is rounded up to the second and restarts on import
+	value, remaining, found, err = to.GetWithTTL("session")
+	if err != nil || !found || value != "token" ||
+		remaining <= time.Hour-2*time.Second || remaining > time.Hour {
+		t.Errorf("session = %q, %v, %v, %v; want token with about an hour left",
+			value, remaining, found, err)
+	}
+
+	// A counter comes back as a plain SET of its value and keeps counting
+	if n, err := to.Incr("count", ""); err != nil || n != 3 {
+		t.Errorf("Incr of the imported counter = %d, %v; want 3", n, err)
+	}
+}
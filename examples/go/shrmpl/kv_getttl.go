@@ -0,0 +1,101 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// NoExpiration is the remaining time GetWithTTL reports for a key stored
+// without a TTL
+const NoExpiration time.Duration = -1
+
+// GetWithTTL retrieves a value together with how long it remains valid,
+// so cache-freshness checks need no second round trip. GET and TTL go out
+// as one BATCH and are answered from the same server state. remaining is
+// NoExpiration for a key without a TTL. A missing or expired key yields
+// found false with no error; an evicted key reports ErrKeyEvicted like
+// Get.
+//
+// TTL <key> answers with the remaining time in milliseconds, -1 for no
+// expiration. Servers without the command fail the call with a
+// ServerError.
+func (c *ShrmplKVClient) GetWithTTL(key string) (value string,
+	remaining time.Duration, found bool, err error) {
+	if err := c.checkKey(key); err != nil {
+		return "", 0, false, err
+	}
+
+	results, err := c.sendBatch([]string{"GET " + key, "TTL " + key})
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	get, ttl := results[0], results[1]
+	if get.Err != nil {
+		return "", 0, false, get.Err
+	}
+	if ttl.Err != nil {
+		return "", 0, false, ttl.Err
+	}
+	// The key can expire between the two sub-commands
+	if get.NotFound || ttl.NotFound {
+		return "", 0, false, nil
+	}
+	remaining, err = parseRemainingTTL(ttl.Value)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return get.Value, remaining, true, nil
+}
+
+// parseRemainingTTL parses a TTL response: milliseconds left, or -1 for a
+// key without expiration
+func parseRemainingTTL(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	switch {
+	case err != nil || ms < -1:
+		return 0, fmt.Errorf("unexpected TTL response: %s", s)
+	case ms == -1:
+		return NoExpiration, nil
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+// GetWithTTL retrieves a value and its remaining lifetime in one round
+// trip; see ShrmplKVClient.GetWithTTL
+func (kv *KV) GetWithTTL(key string) (value string, remaining time.Duration,
+	found bool, err error) {
+	span := kv.startSpan(context.Background(), "kv.get_with_ttl", key)
+	defer func() { span.End(err) }()
+	key = kv.wireKey(key)
+
+	type result struct {
+		value     string
+		remaining time.Duration
+		found     bool
+	}
+	r, err := retryOnTerm(context.Background(), kv, func() (result, error) {
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+
+		if err := kv.ensureConnected(); err != nil {
+			return result{}, err
+		}
+		var r result
+		var err error
+		r.value, r.remaining, r.found, err = kv.shrmplKVClient.GetWithTTL(key)
+		if err != nil {
+			var serverErr *ServerError
+			if !errors.Is(err, ErrKeyNotFound) && !errors.As(err, &serverErr) {
+				kv.shrmplKVClient.Close()
+				kv.shrmplKVClient = nil
+			}
+		}
+		return r, err
+	})
+	return r.value, r.remaining, r.found, err
+}
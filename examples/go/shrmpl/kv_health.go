@@ -0,0 +1,189 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KVHealth is a point-in-time view of the key-value layer, suitable for
+// readiness endpoints
+type KVHealth struct {
+	Connected           bool
+	LastSuccessfulOp    time.Time
+	LastError           error
+	ConsecutiveFailures int
+	ReconnectCount      int
+}
+
+// Health returns the latest health snapshot. It never touches the
+// connection and never waits on in-flight operations.
+func (kv *KV) Health() KVHealth {
+	if h := kv.health.Load(); h != nil {
+		return *h
+	}
+	return KVHealth{}
+}
+
+// updateHealth publishes a copy of the current snapshot modified by fn
+func (kv *KV) updateHealth(fn func(h *KVHealth)) {
+	kv.healthMu.Lock()
+	defer kv.healthMu.Unlock()
+	var next KVHealth
+	if current := kv.health.Load(); current != nil {
+		next = *current
+	}
+	fn(&next)
+	kv.health.Store(&next)
+}
+
+// observe records the outcome of a round trip or connection attempt
+func (kv *KV) observe(err error) {
+	if errors.Is(err, ErrServerTerminating) {
+		kv.termAt.Store(time.Now().UnixNano())
+	}
+	kv.updateHealth(func(h *KVHealth) {
+		if err != nil {
+			// A server that broke a connection may have been replaced
+			kv.capabilityCache().Invalidate(kv.hostPort)
+			h.Connected = false
+			h.LastError = err
+			h.ConsecutiveFailures++
+			return
+		}
+		h.Connected = true
+		h.LastSuccessfulOp = time.Now()
+		h.ConsecutiveFailures = 0
+	})
+}
+
+// markConnected records a successful dial; every dial after the first
+// counts as a reconnect
+func (kv *KV) markConnected() {
+	reconnect := kv.hasConnected
+	kv.hasConnected = true
+	kv.updateHealth(func(h *KVHealth) {
+		h.Connected = true
+		if reconnect {
+			h.ReconnectCount++
+		}
+	})
+}
+
+// defaultHealthProbeInterval is the StartHealthProbe interval used when
+// none is given
+const defaultHealthProbeInterval = 10 * time.Second
+
+// StartHealthProbe keeps the health snapshot fresh while the client is
+// idle by sending a PING whenever no operation has succeeded for
+// interval (10 seconds if interval is zero or less). Probes are skipped
+// while other operations hold the connection. The prober stops when ctx
+// is done.
+func (kv *KV) StartHealthProbe(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthProbeInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(kv.Health().LastSuccessfulOp) < interval {
+					continue
+				}
+				kv.probe()
+			}
+		}
+	}()
+}
+
+// probe pings the server unless the connection is busy
+func (kv *KV) probe() {
+	if !kv.mu.TryLock() {
+		return
+	}
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return
+	}
+	if err := kv.shrmplKVClient.Ping(); err != nil {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
+}
+
+// PingOptions controls a PingWithOptions call
+type PingOptions struct {
+	// Retries is how many more PINGs are sent after a failed one; zero
+	// pings once
+	Retries int
+	// RetryDelay pauses before each retry, giving a momentary network
+	// hiccup time to pass
+	RetryDelay time.Duration
+}
+
+// Ping sends a single PING and reports the raw result. A failure that
+// leaves the connection unusable closes it, so the next operation
+// reconnects.
+func (kv *KV) Ping() error {
+	return kv.ping()
+}
+
+// PingWithOptions pings the server, retrying failed PINGs as configured
+// so that a single dropped PING does not flap a health check. A retry
+// after a connection failure dials a fresh connection. Each attempt is
+// bounded by the client timeout, so a server that is down is reported
+// within (Retries+1) timeouts plus the delays. Retries stop when ctx is
+// done. The error of the last attempt is returned, annotated with the
+// number of attempts.
+func (kv *KV) PingWithOptions(ctx context.Context, opts PingOptions) error {
+	err := kv.ping()
+	attempts := 1
+	for err != nil && attempts <= opts.Retries && waitRetry(ctx, opts.RetryDelay) {
+		err = kv.ping()
+		attempts++
+	}
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("ping failed %d times: %w", attempts, err)
+	}
+	return err
+}
+
+// waitRetry pauses for delay, reporting false if ctx ends first
+func waitRetry(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ping sends one PING on the shared connection
+func (kv *KV) ping() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return err
+	}
+	if err := kv.shrmplKVClient.Ping(); err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package shrmpl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// newTestKV returns a connected KV for srv
+func newTestKV(t *testing.T, srv *shrmpltest.KVServer) *shrmpl.KV {
+	t.Helper()
+	kv := shrmpl.NewKV(srv.Config()).(*shrmpl.KV)
+	t.Cleanup(kv.Close)
+	return kv
+}
+
+func TestHealthProbeRefreshesIdleClient(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := newTestKV(t, srv)
+	if err := kv.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	before := kv.Health().LastSuccessfulOp
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kv.StartHealthProbe(ctx, 10*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for !kv.Health().LastSuccessfulOp.After(before) {
+		if time.Now().After(deadline) {
+			t.Fatal("probe did not refresh the health snapshot")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthProbeDefaultsNonPositiveInterval(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := newTestKV(t, srv)
+
+	// A zero or negative interval must not panic the prober's goroutine
+	ctx, cancel := context.WithCancel(context.Background())
+	kv.StartHealthProbe(ctx, 0)
+	kv.StartHealthProbe(ctx, -time.Second)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+}
@@ -0,0 +1,80 @@
+package shrmpl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// fullKV is every optional KV interface
+type fullKV interface {
+	shrmpl.ThisAppKVInterface
+	shrmpl.KVContexter
+	shrmpl.KVConditionalSetter
+	shrmpl.KVToucher
+	shrmpl.KVLister
+	shrmpl.KVExporter
+	shrmpl.KVMonitor
+}
+
+var (
+	_ fullKV = (*shrmpl.KV)(nil)
+	_ fullKV = (*shrmpl.CachedKV)(nil)
+	_ fullKV = (*shrmpltest.FaultInjector)(nil)
+)
+
+// basicKV implements only ThisAppKVInterface, like a mock written
+// against its original five methods
+type basicKV map[string]string
+
+func (m basicKV) Get(key string) (string, error) {
+	if value, ok := m[key]; ok {
+		return value, nil
+	}
+	return "", shrmpl.ErrKeyNotFound
+}
+
+func (m basicKV) Set(key, value, ttl string) error {
+	m[key] = value
+	return nil
+}
+
+func (m basicKV) Incr(key string, ttl string) (int, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (m basicKV) Batch(commands []string) ([]shrmpl.BatchResult, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m basicKV) Close() {}
+
+func TestWrappersOfBasicKV(t *testing.T) {
+	kv := basicKV{}
+	typed := shrmpl.NewTypedKV(kv, func(id string) string { return "user:" + id })
+
+	// The Context methods fall back to the plain ones
+	if err := typed.SetContext(context.Background(), "bob", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := typed.GetContext(context.Background(), "bob"); err != nil || value != "v" {
+		t.Errorf("GetContext = %q, %v; want v", value, err)
+	}
+	if _, err := typed.Touch("bob", "1min"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Touch = %v, want errors.ErrUnsupported", err)
+	}
+
+	faults := shrmpltest.NewFaultInjector(kv, nil, 1)
+	if value, err := faults.GetContext(context.Background(), "user:bob"); err != nil || value != "v" {
+		t.Errorf("FaultInjector.GetContext = %q, %v; want v", value, err)
+	}
+	if _, err := faults.List(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("FaultInjector.List = %v, want errors.ErrUnsupported", err)
+	}
+	if stats := faults.Stats(); stats != (shrmpl.KVStats{}) {
+		t.Errorf("FaultInjector.Stats = %+v, want zero stats", stats)
+	}
+}
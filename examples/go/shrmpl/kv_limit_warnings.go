@@ -0,0 +1,131 @@
+package shrmpl
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLimitWarningInterval is how often one call site may warn about
+// the same limit when no interval is configured
+const defaultLimitWarningInterval = time.Minute
+
+// LimitWarning describes a key, value or batch close to its limit
+type LimitWarning struct {
+	// Limit is "key", "value" or "batch"
+	Limit string
+	// Size is the key or value length, or the number of commands
+	Size int
+	// Max is the limit in effect
+	Max int
+	// Site is the "file.go:line" of the code that called the client
+	Site string
+}
+
+// limitWarner reports keys, values and batches within threshold of their
+// limit, at most once per call site and limit per interval
+type limitWarner struct {
+	threshold float64
+	interval  time.Duration
+	notify    func(LimitWarning)
+
+	mu   sync.Mutex
+	last map[limitWarningSite]time.Time
+}
+
+// limitWarningSite deduplicates warnings by the calling code's PC
+type limitWarningSite struct {
+	pc    uintptr
+	limit string
+}
+
+// newLimitWarner returns nil, which never warns, unless threshold is in
+// (0, 1] and notify is set
+func newLimitWarner(threshold float64, interval time.Duration, notify func(LimitWarning)) *limitWarner {
+	if threshold <= 0 || threshold > 1 || notify == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultLimitWarningInterval
+	}
+	return &limitWarner{
+		threshold: threshold,
+		interval:  interval,
+		notify:    notify,
+		last:      make(map[limitWarningSite]time.Time),
+	}
+}
+
+// SetLimitWarnings calls notify when a key or value is at least threshold
+// (e.g. 0.9) of its limit but still within it, so callers learn about
+// growing sizes before they fail. Each call site warns about each limit
+// at most once per interval; zero means a minute. A threshold outside
+// (0, 1] or a nil notify disables warnings. Warnings never change the
+// outcome of the operation: notify runs synchronously, must not use the
+// client, and a panic in it is recovered.
+func (c *ShrmplKVClient) SetLimitWarnings(threshold float64, interval time.Duration, notify func(LimitWarning)) {
+	c.limitWarner = newLimitWarner(threshold, interval, notify)
+}
+
+// check warns when size is within the threshold of max. It is cheap
+// unless a warning is due, and safe on a nil warner.
+func (w *limitWarner) check(limit string, size, max int) {
+	if w == nil || max <= 0 || size > max || float64(size) < w.threshold*float64(max) {
+		return
+	}
+	pc, site := limitWarningCaller()
+	key := limitWarningSite{pc: pc, limit: limit}
+	now := time.Now()
+
+	w.mu.Lock()
+	if last, ok := w.last[key]; ok && now.Sub(last) < w.interval {
+		w.mu.Unlock()
+		return
+	}
+	w.last[key] = now
+	w.mu.Unlock()
+
+	defer func() { recover() }()
+	w.notify(LimitWarning{Limit: limit, Size: size, Max: max, Site: site})
+}
+
+// shrmplFuncPrefix prefixes the names of this package's functions
+var shrmplFuncPrefix = reflect.TypeOf(LimitWarning{}).PkgPath() + "."
+
+// limitWarningCaller returns the PC and "file.go:line" of the first
+// caller outside this package, so every entry point reports the
+// application's call site, or 0 and "" when there is none
+func limitWarningCaller() (uintptr, string) {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, shrmplFuncPrefix) {
+			// Keep just the filename from the full path
+			file := frame.File[strings.LastIndexByte(frame.File, '/')+1:]
+			return frame.PC, file + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return 0, ""
+		}
+	}
+}
+
+// limitWarningNotify returns the KVConfig's warning callback: its
+// OnLimitWarning, or else a warning logged to LimitWarningLogger
+func (config *KVConfig) limitWarningNotify() func(LimitWarning) {
+	if config.OnLimitWarning != nil {
+		return config.OnLimitWarning
+	}
+	if logger := config.LimitWarningLogger; logger != nil {
+		return func(w LimitWarning) {
+			logger.Warn("KVLM", "kv "+w.Limit+" close to its limit",
+				"size", w.Size, "max", w.Max, "site", w.Site)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package shrmpl
+
+import "fmt"
+
+// KVLimits are the longest key and value a server stores. Zero fields
+// mean the limits of shrmpl-kv-srv, 100 characters each.
+type KVLimits struct {
+	MaxKeyLength   int
+	MaxValueLength int
+}
+
+// withDefaults fills unset limits with the defaults
+func (l KVLimits) withDefaults() KVLimits {
+	if l.MaxKeyLength <= 0 {
+		l.MaxKeyLength = maxKeyLength
+	}
+	if l.MaxValueLength <= 0 {
+		l.MaxValueLength = maxValueLength
+	}
+	return l
+}
+
+// SetLimits sets the key and value lengths Get, Set and the other
+// commands are checked against before anything is sent. Zero fields keep
+// the defaults. KV sets them after each handshake; see KV.Limits.
+func (c *ShrmplKVClient) SetLimits(limits KVLimits) {
+	c.limits = limits.withDefaults()
+}
+
+// Limits returns the key and value lengths the client enforces
+func (c *ShrmplKVClient) Limits() KVLimits {
+	return c.limits.withDefaults()
+}
+
+// checkKey rejects keys over the key length limit and warns about keys
+// close to it
+func (c *ShrmplKVClient) checkKey(key string) error {
+	limit := c.Limits().MaxKeyLength
+	if len(key) > limit {
+		return fmt.Errorf("key length exceeds %d characters", limit)
+	}
+	c.limitWarner.check("key", len(key), limit)
+	return nil
+}
+
+// Limits returns the negotiated key and value limits: those advertised
+// in the server's HELLO response (maxkey=<n> maxvalue=<n>), or else
+// KVConfig.MaxKeyLength and MaxValueLength, or else the defaults of 100
+// characters. Each limit falls back separately.
+func (kv *KV) Limits() KVLimits {
+	caps := kv.Capabilities()
+	limits := KVLimits{
+		MaxKeyLength:   kv.config.MaxKeyLength,
+		MaxValueLength: kv.config.MaxValueLength,
+	}
+	if caps.MaxKeyLength > 0 {
+		limits.MaxKeyLength = caps.MaxKeyLength
+	}
+	if caps.MaxValueLength > 0 {
+		limits.MaxValueLength = caps.MaxValueLength
+	}
+	return limits.withDefaults()
+}
@@ -0,0 +1,56 @@
+package shrmpl_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// newListKV returns a KV with a 200-byte aggregate limit on a server
+// holding 50 keys, whose listing is well over the limit
+func newListKV(t *testing.T) (*shrmpl.KV, *shrmpltest.KVServer) {
+	t.Helper()
+	srv := shrmpltest.NewKVServer()
+	t.Cleanup(srv.Close)
+	for i := 0; i < 50; i++ {
+		srv.Put(fmt.Sprintf("key%02d", i), strings.Repeat("v", 20))
+	}
+	cfg := srv.Config()
+	cfg.MaxAggregateResponseBytes = 200
+	kv := shrmpl.NewKV(cfg).(*shrmpl.KV)
+	t.Cleanup(kv.Close)
+	return kv, srv
+}
+
+func TestListFuncStreamsPastAggregateLimit(t *testing.T) {
+	kv, srv := newListKV(t)
+	seen := 0
+	err := kv.ListFunc(func(item shrmpl.KVListItem) (bool, error) {
+		seen++
+		return true, nil
+	})
+	if err != nil || seen != 50 {
+		t.Fatalf("ListFunc = %v after %d items, want all 50", err, seen)
+	}
+
+	// A single line over the limit still fails
+	srv.Put("big", strings.Repeat("v", 300))
+	err = kv.ListFunc(func(shrmpl.KVListItem) (bool, error) { return true, nil })
+	if !errors.Is(err, shrmpl.ErrResponseTooLarge) {
+		t.Errorf("ListFunc with a 300-byte value = %v, want ErrResponseTooLarge", err)
+	}
+}
+
+func TestListCountsWholeResponse(t *testing.T) {
+	kv, _ := newListKV(t)
+	if _, err := kv.List(); !errors.Is(err, shrmpl.ErrResponseTooLarge) {
+		t.Errorf("List = %v, want ErrResponseTooLarge", err)
+	}
+	if _, err := kv.ListMap(); !errors.Is(err, shrmpl.ErrResponseTooLarge) {
+		t.Errorf("ListMap = %v, want ErrResponseTooLarge", err)
+	}
+}
@@ -0,0 +1,116 @@
+package shrmpl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// maxKeyLength is the server's key length limit
+const maxKeyLength = 100
+
+// hashedKeySeparator joins the readable prefix and the hash of a hashed key
+const hashedKeySeparator = "#"
+
+// maxLongKeyMappings bounds the hashed-to-original map used to restore
+// keys in LIST results; once full, further hashed keys are listed as sent
+const maxLongKeyMappings = 10000
+
+// HashLongKey returns the key HashLongKeys sends in place of key: the
+// first 35 characters, "#" and the SHA-256 hex digest of the full key,
+// exactly 100 characters in total. Keys within the limit are returned
+// unchanged.
+func HashLongKey(key string) string {
+	return hashLongKey(key, maxKeyLength)
+}
+
+// hashLongKey is HashLongKey for a server whose key limit is limit. The
+// result is limit characters long: the readable prefix shrinks with the
+// limit, and below 66 characters only the truncated digest is left.
+func hashLongKey(key string, limit int) string {
+	if len(key) <= limit {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	digest := hex.EncodeToString(sum[:])
+	if limit <= len(digest)+len(hashedKeySeparator) {
+		return digest[:limit]
+	}
+	prefix := key[:limit-len(hashedKeySeparator)-len(digest)]
+	return prefix + hashedKeySeparator + digest
+}
+
+// hashLimit returns the key length above which HashLongKeys hashes keys.
+// It is fixed for the life of the KV so a key always maps to the same
+// wire key, even though Limits changes as capabilities are relearned:
+// without handshakes it is the configured limit, otherwise the limit
+// learned by the first handshake, which is done now if needed. ok is
+// false while the server cannot be reached.
+func (kv *KV) hashLimit() (limit int, ok bool) {
+	if !kv.config.Handshake {
+		return kv.Limits().MaxKeyLength, true
+	}
+	if n := kv.keyHashLimit.Load(); n > 0 {
+		return int(n), true
+	}
+	kv.mu.Lock()
+	err := kv.ensureConnected()
+	kv.mu.Unlock()
+	if err != nil {
+		return 0, false
+	}
+	return int(kv.keyHashLimit.Load()), true
+}
+
+// wireKey returns the key to send for key, hashing it when HashLongKeys
+// is set and it exceeds the limit. Hashed keys are remembered so LIST
+// results can show the original. While the server's limit is unknown the
+// key is sent as is; the operation then fails to connect anyway, or the
+// client rejects the key, rather than storing it under a wire key later
+// calls would not use.
+func (kv *KV) wireKey(key string) string {
+	if !kv.config.HashLongKeys {
+		return key
+	}
+	limit, ok := kv.hashLimit()
+	if !ok || len(key) <= limit {
+		return key
+	}
+	hashed := hashLongKey(key, limit)
+	kv.longKeysMu.Lock()
+	if kv.longKeys == nil {
+		kv.longKeys = make(map[string]string)
+	}
+	if len(kv.longKeys) < maxLongKeyMappings {
+		kv.longKeys[hashed] = key
+	}
+	kv.longKeysMu.Unlock()
+	return hashed
+}
+
+// originalKey maps a key received from the server back to the key this
+// client hashed into it, if any
+func (kv *KV) originalKey(key string) string {
+	if !kv.config.HashLongKeys {
+		return key
+	}
+	kv.longKeysMu.Lock()
+	defer kv.longKeysMu.Unlock()
+	if original, ok := kv.longKeys[key]; ok {
+		return original
+	}
+	return key
+}
+
+// wireCommand hashes the key argument of a command such as "GET key"
+func (kv *KV) wireCommand(cmd string) string {
+	if !kv.config.HashLongKeys {
+		return cmd
+	}
+	fields := strings.Fields(cmd)
+	if limit, ok := kv.hashLimit(); len(fields) < 2 || !ok || len(fields[1]) <= limit {
+		return cmd
+	}
+	fields[1] = kv.wireKey(fields[1])
+	return strings.Join(fields, " ")
+}
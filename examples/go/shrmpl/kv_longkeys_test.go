@@ -0,0 +1,103 @@
+package shrmpl_test
+
+import (
+	"strings"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// newLongKeyKV returns a lazy KV hashing long keys against srv
+func newLongKeyKV(t *testing.T, srv *shrmpltest.KVServer) *shrmpl.KV {
+	t.Helper()
+	cfg := srv.Config()
+	cfg.Lazy = true
+	cfg.Handshake = true
+	cfg.HashLongKeys = true
+	cfg.CapabilityCache = shrmpl.NewCapabilityCache(0)
+	kv := shrmpl.NewKV(cfg).(*shrmpl.KV)
+	t.Cleanup(kv.Close)
+	return kv
+}
+
+func TestHashLongKeysUsesAdvertisedLimitFromFirstCall(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	srv.SetLimits(200, 100)
+	kv := newLongKeyKV(t, srv)
+
+	// The first call comes before any handshake; a 150 character key is
+	// within the server's limit and must go out unhashed
+	key := strings.Repeat("k", 150)
+	if err := kv.Set(key, "v1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Value(key); !ok {
+		t.Fatalf("150 character key was not stored as is")
+	}
+
+	// Dropped connections invalidate the capabilities; the key must still
+	// map to the same wire key
+	srv.DropConnections()
+	for i := 0; i < 3; i++ {
+		if err := kv.Set(key, "v2", ""); err != nil {
+			// The first call after the drop may see the broken connection
+			continue
+		}
+	}
+	if value, ok := srv.Value(key); !ok || value != "v2" {
+		t.Errorf("after reconnecting the key holds %q, %v; want v2", value, ok)
+	}
+	if value, err := kv.Get(key); err != nil || value != "v2" {
+		t.Errorf("Get = %q, %v; want v2", value, err)
+	}
+
+	// Longer keys are hashed to the advertised limit
+	long := strings.Repeat("l", 250)
+	if err := kv.Set(long, "v3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := kv.Get(long); err != nil || value != "v3" {
+		t.Errorf("Get of hashed key = %q, %v; want v3", value, err)
+	}
+	items, err := kv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.Key == long {
+			return
+		}
+	}
+	t.Errorf("List does not map the hashed key back to the original: %v", items)
+}
+
+func TestHashLongKeysBelowDigestLength(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	srv.SetLimits(50, 100)
+	kv := newLongKeyKV(t, srv)
+
+	for _, key := range []string{strings.Repeat("a", 80), strings.Repeat("b", 80)} {
+		if err := kv.Set(key, key[:1], ""); err != nil {
+			t.Fatalf("Set of %d character key: %v", len(key), err)
+		}
+	}
+	for _, key := range []string{strings.Repeat("a", 80), strings.Repeat("b", 80)} {
+		if value, err := kv.Get(key); err != nil || value != key[:1] {
+			t.Errorf("Get = %q, %v; want %q", value, err, key[:1])
+		}
+	}
+}
+
+func TestHashLongKeyFixedLength(t *testing.T) {
+	key := strings.Repeat("x", 300)
+	hashed := shrmpl.HashLongKey(key)
+	if len(hashed) != 100 || !strings.HasPrefix(hashed, strings.Repeat("x", 35)+"#") {
+		t.Errorf("HashLongKey = %q (%d characters)", hashed, len(hashed))
+	}
+	if short := strings.Repeat("y", 100); shrmpl.HashLongKey(short) != short {
+		t.Errorf("keys within the limit must be unchanged")
+	}
+}
@@ -0,0 +1,53 @@
+package shrmpl
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// RoundTrip describes one command and its response line, reported to
+// ConnObserver.RoundTrip. An operation issues one round trip per command
+// it sends, usually one.
+type RoundTrip struct {
+	Duration time.Duration
+	// Heartbeats counts the UPONG lines read and skipped before the
+	// response; HeartbeatRead is the time spent in those reads
+	Heartbeats    int
+	HeartbeatRead time.Duration
+	Err           error
+}
+
+// ConnObserver receives the connection events of a ShrmplKVClient, for
+// load generators and connection metrics. Methods are called on the
+// goroutine using the client and must not block.
+type ConnObserver interface {
+	// Dialed is called after every dial attempt with its error, if any
+	Dialed(err error)
+	// Reset is called when the peer resets an open connection
+	Reset()
+	// Closed is called when an open connection is closed
+	Closed(lifetime time.Duration)
+	// RoundTrip is called after every command sent on the connection
+	RoundTrip(rt RoundTrip)
+}
+
+// SetConnObserver reports this client's dials, resets, closes and round
+// trips to o; nil stops reporting. Set it before Connect to see the dial.
+func (c *ShrmplKVClient) SetConnObserver(o ConnObserver) {
+	c.connObserver = o
+}
+
+// observeRoundTrip reports the round trip that started at start. Only
+// called with a ConnObserver set.
+func (c *ShrmplKVClient) observeRoundTrip(start time.Time, err error) {
+	if errors.Is(err, syscall.ECONNRESET) {
+		c.connObserver.Reset()
+	}
+	c.connObserver.RoundTrip(RoundTrip{
+		Duration:      time.Since(start),
+		Heartbeats:    c.heartbeats,
+		HeartbeatRead: c.heartbeatRead,
+		Err:           err,
+	})
+}
@@ -0,0 +1,102 @@
+package shrmpl_test
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// recordingObserver is a ConnObserver keeping every event
+type recordingObserver struct {
+	mu         sync.Mutex
+	dials      int
+	closed     int
+	roundTrips []shrmpl.RoundTrip
+}
+
+func (o *recordingObserver) Dialed(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err == nil {
+		o.dials++
+	}
+}
+
+func (o *recordingObserver) Reset() {}
+
+func (o *recordingObserver) Closed(time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed++
+}
+
+func (o *recordingObserver) RoundTrip(rt shrmpl.RoundTrip) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.roundTrips = append(o.roundTrips, rt)
+}
+
+// newObservedClient connects a client to srv reporting to a new
+// recordingObserver
+func newObservedClient(t *testing.T, srv *shrmpltest.KVServer,
+	sharedBuffers bool) (*shrmpl.ShrmplKVClient, *recordingObserver) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	obs := &recordingObserver{}
+	c := shrmpl.NewShrmplKVClient(host, port)
+	c.SetConnObserver(obs)
+	c.SetSharedReadBuffers(sharedBuffers)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return c, obs
+}
+
+func TestConnObserverEvents(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		t.Run("shared="+strconv.FormatBool(shared), func(t *testing.T) {
+			srv := shrmpltest.NewKVServer()
+			defer srv.Close()
+			c, obs := newObservedClient(t, srv, shared)
+
+			srv.SetHeartbeats(true)
+			if err := c.Set("k", "v", ""); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := c.Get("k"); err != nil || value != "v" {
+				t.Fatalf("Get = %q, %v; want v", value, err)
+			}
+			if existed, err := c.Delete("k"); err != nil || !existed {
+				t.Fatalf("Delete = %v, %v; want true", existed, err)
+			}
+			if existed, err := c.Delete("k"); err != nil || existed {
+				t.Fatalf("second Delete = %v, %v; want false", existed, err)
+			}
+			c.Close()
+
+			obs.mu.Lock()
+			defer obs.mu.Unlock()
+			if obs.dials != 1 || obs.closed != 1 {
+				t.Errorf("dials = %d, closed = %d; want 1 each", obs.dials, obs.closed)
+			}
+			if len(obs.roundTrips) != 4 {
+				t.Fatalf("got %d round trips, want 4", len(obs.roundTrips))
+			}
+			for i, rt := range obs.roundTrips {
+				if rt.Heartbeats != 1 || rt.Err != nil || rt.Duration < rt.HeartbeatRead {
+					t.Errorf("round trip %d = %+v, want one heartbeat within its duration", i, rt)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package shrmpl
+
+import (
+	"bytes"
+	"sync"
+)
+
+// readBufferSize matches bufio's default so both read paths issue reads
+// of the same size
+const readBufferSize = 4096
+
+// readBuffers holds the read buffers shared by clients with
+// SetSharedReadBuffers. A buffer is checked out only for the duration of
+// one readString call.
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, readBufferSize)
+		return &buf
+	},
+}
+
+// SetSharedReadBuffers makes the next Connect read responses through
+// buffers shared by all clients instead of a bufio.Reader per connection,
+// so idle connections hold no read buffer. Useful with many mostly idle
+// connections, such as a large pool; each read then copies its line out of
+// the shared buffer. Lines are read whole, so the aggregate response limit
+// is applied after reading rather than while reading.
+func (c *ShrmplKVClient) SetSharedReadBuffers(enabled bool) {
+	c.sharedBuffers = enabled
+}
+
+// readString reads one raw response line. Without shared buffers it uses
+// the connection's own bufio.Reader. With them, it reads into a pooled
+// buffer and copies every byte out before returning the buffer, so no
+// response references it; bytes after the newline are kept in pending,
+// trimmed to their own size so a large response does not pin its memory.
+func (c *ShrmplKVClient) readString() (string, error) {
+	if c.reader != nil {
+		return c.reader.ReadString('\n')
+	}
+
+	bufp := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(bufp)
+	buf := *bufp
+
+	for {
+		if i := bytes.IndexByte(c.pending, '\n'); i >= 0 {
+			line := string(c.pending[:i+1])
+			if rest := c.pending[i+1:]; len(rest) > 0 {
+				c.pending = append([]byte(nil), rest...)
+			} else {
+				c.pending = nil
+			}
+			return line, nil
+		}
+		n, err := c.conn.Read(buf)
+		c.pending = append(c.pending, buf[:n]...)
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package shrmpl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxAggregateResponseBytes bounds LIST, BATCH and EXEC responses
+// when KVConfig.MaxAggregateResponseBytes is zero
+const DefaultMaxAggregateResponseBytes = 4 << 20
+
+// ErrResponseTooLarge matches the error returned when a LIST, BATCH or
+// EXEC response exceeds the client's aggregate response limit; see
+// ResponseTooLargeError
+var ErrResponseTooLarge = errors.New("response too large")
+
+// ResponseTooLargeError reports a multi-result response that was abandoned
+// at the aggregate response limit. The rest of the response is still in
+// flight, so the connection is closed, unless it is a BATCH response and
+// SetDrainBatchErrors lets the client discard the rest. Parsed counts the results received
+// in full before the limit was hit: the items passed to a ListFunc
+// callback, or the leading BATCH or EXEC results. A transaction whose EXEC
+// response was too large was still applied.
+type ResponseTooLargeError struct {
+	Command string
+	Limit   int
+	Parsed  int
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("%s response exceeds %d bytes (%d results parsed)",
+		e.Command, e.Limit, e.Parsed)
+}
+
+func (e *ResponseTooLargeError) Unwrap() error { return ErrResponseTooLarge }
+
+// noLineLimit disables the limit of readLineLimit and nextLineLimit
+const noLineLimit = -1
+
+// errLineLimit is returned internally when a line exceeds its limit
+var errLineLimit = errors.New("line exceeds limit")
+
+// SetMaxAggregateResponseBytes bounds the bytes buffered for one LIST,
+// BATCH or EXEC response, so a huge keyspace or a batch of large values
+// cannot exhaust memory. Zero restores DefaultMaxAggregateResponseBytes
+// and a negative value disables the limit. Lines are counted with their
+// newline. ListFunc, which holds one line at a time, applies the limit to
+// each line instead. With subscriptions active, the background reader has
+// already buffered each line when it is counted.
+func (c *ShrmplKVClient) SetMaxAggregateResponseBytes(max int) {
+	c.maxAggregate = max
+}
+
+// aggregateLimit returns the effective aggregate response limit, or
+// noLineLimit when it is disabled
+func (c *ShrmplKVClient) aggregateLimit() int {
+	switch {
+	case c.maxAggregate < 0:
+		return noLineLimit
+	case c.maxAggregate == 0:
+		return DefaultMaxAggregateResponseBytes
+	}
+	return c.maxAggregate
+}
+
+// sendLimited encodes op with args and sends it, reading a response line
+// of at most max bytes
+func (c *ShrmplKVClient) sendLimited(max int, op string, args ...string) (string, error) {
+	cmd, err := encodeCommand(op, args...)
+	if err != nil {
+		return "", err
+	}
+	return c.sendCommandLimit(cmd, max)
+}
+
+// sendAggregate sends a command answered by one line of ';'-joined
+// results, such as BATCH or EXEC, within the aggregate response limit
+func (c *ShrmplKVClient) sendAggregate(op string, args ...string) (string, error) {
+	limit := c.aggregateLimit()
+	response, err := c.sendLimited(limit, op, args...)
+	if errors.Is(err, errLineLimit) {
+		parsed := strings.Count(response, ";")
+		if op == "BATCH" && c.drainBatchErrors && c.drainLine() == nil {
+			return "", &ResponseTooLargeError{Command: op, Limit: limit, Parsed: parsed}
+		}
+		return "", c.tooLarge(op, limit, parsed)
+	}
+	return response, err
+}
+
+// tooLarge closes the connection, whose response is still in flight, and
+// returns the error for a response abandoned at limit
+func (c *ShrmplKVClient) tooLarge(cmd string, limit, parsed int) error {
+	c.Close()
+	return &ResponseTooLargeError{Command: cmd, Limit: limit, Parsed: parsed}
+}
+
+// nextLineLimit is nextLine for a line of at most max bytes including the
+// newline. Reading directly from the connection stops at the limit, so a
+// longer line is never buffered; it returns the bytes read with
+// errLineLimit and leaves the rest unread, recording in lineCut whether
+// any is left.
+func (c *ShrmplKVClient) nextLineLimit(max int) (string, error) {
+	if max == noLineLimit {
+		return c.nextLine()
+	}
+	c.lineCut = false
+	if c.lines != nil || c.reader == nil {
+		// Lines from the background reader or the shared buffers are
+		// already read in full
+		line, err := c.nextLine()
+		if err == nil && len(line) > max {
+			return line[:max], errLineLimit
+		}
+		return line, err
+	}
+
+	var line []byte
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > max {
+			line = append(line, chunk[:max-len(line)]...)
+			// A chunk read without error ends the line
+			c.lineCut = err != nil
+			return string(line), errLineLimit
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package shrmpl
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultRTTSamples is the number of PINGs RTT times when no count is given
+const defaultRTTSamples = 5
+
+// RTT times PING/PONG round trips and returns the median, which smooths
+// out jitter. The optional samples sets the number of PINGs (default 5).
+// PING touches no keys and the server answers it without doing any work,
+// so the result is network and connection latency without operation cost.
+func (c *ShrmplKVClient) RTT(samples ...int) (time.Duration, error) {
+	n := defaultRTTSamples
+	if len(samples) > 0 && samples[0] > 0 {
+		n = samples[0]
+	}
+
+	rtts := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if err := c.Ping(); err != nil {
+			return 0, fmt.Errorf("measuring RTT: %w", err)
+		}
+		rtts = append(rtts, time.Since(start))
+	}
+	return medianDuration(rtts), nil
+}
+
+// medianDuration returns the median of ds, averaging the middle pair for
+// an even count. It sorts ds in place.
+func medianDuration(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	mid := len(ds) / 2
+	if len(ds)%2 == 0 {
+		return (ds[mid-1] + ds[mid]) / 2
+	}
+	return ds[mid]
+}
+
+// RTT measures the round trip time on the shared connection; see
+// ShrmplKVClient.RTT. Other operations wait while the PINGs are in
+// flight.
+func (kv *KV) RTT(samples ...int) (time.Duration, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	rtt, err := kv.shrmplKVClient.RTT(samples...)
+	if err != nil {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+		return 0, err
+	}
+	return rtt, nil
+}
@@ -0,0 +1,60 @@
+package shrmpl
+
+import (
+	"context"
+	"fmt"
+)
+
+// tagAnnotation starts the trailing token that carries an operation tag
+const tagAnnotation = "#tag="
+
+// maxTagLength bounds tags so an annotation cannot crowd out the command
+const maxTagLength = 64
+
+// tagKey is the context key of the operation tag
+type tagKey struct{}
+
+// WithTag returns a context whose KV operations carry tag, such as a
+// request ID, so they can be found in the server's logs. The Context
+// methods of KV send it as a trailing "#tag=<tag>" annotation on each
+// command when the server advertises tags=1 in its HELLO response; with
+// other servers, or without KVConfig.Handshake, the tag is not sent.
+// Tags must be at most 64 characters without whitespace, control
+// characters or ';', or the operation fails with ErrInvalidCommand.
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// TagFromContext returns the tag set by WithTag
+func TagFromContext(ctx context.Context) (string, bool) {
+	tag, ok := ctx.Value(tagKey{}).(string)
+	return tag, ok && tag != ""
+}
+
+// validateTag rejects tags the annotation cannot carry intact
+func validateTag(tag string) error {
+	if len(tag) > maxTagLength {
+		return fmt.Errorf("%w: tag exceeds %d characters", ErrInvalidCommand, maxTagLength)
+	}
+	return validateArg("tag", tag)
+}
+
+// tagCommands annotates the commands of the connected client with the
+// tag in ctx, if the server accepts tags, until the returned function is
+// called. An invalid tag fails the operation whether or not it would be
+// sent. kv.mu must be held.
+func (kv *KV) tagCommands(ctx context.Context) (untag func(), err error) {
+	tag, ok := TagFromContext(ctx)
+	if !ok {
+		return func() {}, nil
+	}
+	if err := validateTag(tag); err != nil {
+		return nil, err
+	}
+	if !kv.Capabilities().Tags {
+		return func() {}, nil
+	}
+	client := kv.shrmplKVClient
+	client.tag = tag
+	return func() { client.tag = "" }, nil
+}
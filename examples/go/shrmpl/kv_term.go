@@ -0,0 +1,107 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrServerTerminating is wrapped by the error of an operation interrupted
+// by the server's TERM shutdown notice
+var ErrServerTerminating = errors.New("server shutting down")
+
+// errKVUnavailable is wrapped by errors from operations that could not
+// (re)connect to the server
+var errKVUnavailable = errors.New("key-value store not available")
+
+// TermPolicy values for KVConfig.TermPolicy, deciding what an operation
+// interrupted by TERM does. Only operations that are safe to repeat are
+// retried: Get, Set and DBSize, and Batch when it consists only of GET,
+// DEL and plain SET commands. Incr, SetOpts, SetReturning,
+// CompareAndSwap, Update, transactions and listings may have been applied
+// before the server went away, so they always return the error.
+const (
+	// TermReturnError returns the error to the caller (the default)
+	TermReturnError = "returnError"
+	// TermReconnectSilent reconnects and retries once, immediately
+	TermReconnectSilent = "reconnectSilent"
+	// TermBlock keeps retrying until the server is back or
+	// KVConfig.TermBlockWindow has passed, for rolling restarts. Safe
+	// operations started while the server is down after a TERM wait too.
+	TermBlock = "block"
+)
+
+// defaultTermBlockWindow is how long TermBlock waits for a restart
+const defaultTermBlockWindow = 30 * time.Second
+
+// termRetryInterval spaces out reconnect attempts under TermBlock
+const termRetryInterval = 250 * time.Millisecond
+
+// termBlockWindow returns the configured TermBlock window
+func (kv *KV) termBlockWindow() time.Duration {
+	if kv.config.TermBlockWindow > 0 {
+		return kv.config.TermBlockWindow
+	}
+	return defaultTermBlockWindow
+}
+
+// restarting reports whether err means the server is going away or has
+// not come back from a recent TERM
+func (kv *KV) restarting(err error) bool {
+	if errors.Is(err, ErrServerTerminating) {
+		return true
+	}
+	termAt := kv.termAt.Load()
+	return errors.Is(err, errKVUnavailable) && termAt != 0 &&
+		time.Since(time.Unix(0, termAt)) < kv.termBlockWindow()
+}
+
+// retryOnTerm runs the idempotent op, repeating it according to the
+// configured TermPolicy if the server shuts down under it. A connection
+// the server closed without notice, such as one dropped while idle, is
+// replaced and the op retried once under every policy.
+func retryOnTerm[T any](ctx context.Context, kv *KV, op func() (T, error)) (T, error) {
+	value, err := op()
+	if errors.Is(err, ErrConnectionClosed) {
+		value, err = op()
+	}
+	switch kv.config.TermPolicy {
+	case TermReconnectSilent:
+		if errors.Is(err, ErrServerTerminating) {
+			return op()
+		}
+	case TermBlock:
+		deadline := time.Now().Add(kv.termBlockWindow())
+		for kv.restarting(err) && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return value, err
+			case <-time.After(termRetryInterval):
+			}
+			value, err = op()
+		}
+	}
+	return value, err
+}
+
+// idempotentBatch reports whether commands can safely be sent twice
+func idempotentBatch(commands []string) bool {
+	for _, cmd := range commands {
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "GET", "DEL":
+		case "SET":
+			// Flags such as NX or GET change the result of a repeat
+			if len(fields) > 4 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Touch sets the expiration of an existing key to ttl from now, reporting
+// whether the key was touched. A missing, expired or evicted key is not
+// created and yields false with no error. The value is not sent or
+// rewritten, so Touch stays cheap enough to renew leases and sliding
+// sessions on every request; pair it with an OnlyIfAbsent SetOpts to take
+// the lease in the first place. ttl is required.
+func (c *ShrmplKVClient) Touch(key string, ttl string) (bool, error) {
+	if ttl == "" {
+		return false, fmt.Errorf("%w: Touch requires a TTL", ErrInvalidTTL)
+	}
+	ttl, err := normalizeTTL(ttl)
+	if err != nil {
+		return false, err
+	}
+
+	response, err := c.send("TOUCH", key, ttl)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case response == "OK":
+		return true, nil
+	case response == "*KEY NOT FOUND*", response == evictedResponse:
+		return false, nil
+	case strings.HasPrefix(response, "ERROR"):
+		return false, newServerError(response)
+	default:
+		return false, fmt.Errorf("unexpected response: %s", response)
+	}
+}
+
+// Touch renews the expiration of an existing key; see
+// ShrmplKVClient.Touch
+func (kv *KV) Touch(key string, ttl string) (bool, error) {
+	key = kv.wireKey(key)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return false, err
+	}
+
+	touched, err := kv.shrmplKVClient.Touch(key, ttl)
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return false, err
+	}
+	return touched, nil
+}
@@ -0,0 +1,86 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTTL is returned, before anything is sent, for TTL strings the
+// server would reject
+var ErrInvalidTTL = errors.New("invalid TTL")
+
+// ttlUnits maps the accepted TTL suffixes to their duration. The server
+// itself only understands "s", "min" and "h"; the other spellings are
+// normalized to those.
+var ttlUnits = map[string]time.Duration{
+	"s":       time.Second,
+	"sec":     time.Second,
+	"secs":    time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hrs":     time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+}
+
+// ParseTTL parses a TTL such as "30s", "5min" or "1h" into a duration.
+// The empty string means no expiration and parses to zero.
+func ParseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	digits := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if digits <= 0 {
+		return 0, fmt.Errorf("%w %q: expected a number followed by s, min or h", ErrInvalidTTL, s)
+	}
+	unit, ok := ttlUnits[strings.ToLower(s[digits:])]
+	if !ok {
+		return 0, fmt.Errorf("%w %q: unknown unit %q", ErrInvalidTTL, s, s[digits:])
+	}
+	n, err := strconv.ParseInt(s[:digits], 10, 64)
+	if err != nil || n > int64(1<<63-1)/int64(unit) {
+		return 0, fmt.Errorf("%w %q: out of range", ErrInvalidTTL, s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// FormatTTL formats d in the server's TTL syntax using the largest unit
+// that represents it exactly. Fractions of a second are rounded up.
+func FormatTTL(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return ""
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "min"
+	default:
+		return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10) + "s"
+	}
+}
+
+// normalizeTTL validates ttl and rewrites it in the server's syntax
+func normalizeTTL(ttl string) (string, error) {
+	if ttl == "" {
+		return "", nil
+	}
+	d, err := ParseTTL(ttl)
+	if err != nil {
+		return "", err
+	}
+	if d == 0 {
+		// "0s" is valid on the server and expires the key at once
+		return "0s", nil
+	}
+	return FormatTTL(d), nil
+}
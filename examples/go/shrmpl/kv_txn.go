@@ -0,0 +1,199 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTxnAborted is returned by Txn.Exec when none of the queued commands
+// were applied: the server rejected one while queueing, aborted the
+// transaction itself or the connection dropped before EXEC was answered
+var ErrTxnAborted = errors.New("transaction aborted")
+
+// ErrTxnUnsupported is returned by Txn.Exec when the server did not
+// advertise MULTI in its HELLO response
+var ErrTxnUnsupported = errors.New("server does not support transactions")
+
+// errTxnDone is returned when a finished transaction is reused
+var errTxnDone = errors.New("transaction already executed or discarded")
+
+// txnAbortedResponse is the EXEC response of a transaction the server
+// refused to apply
+const txnAbortedResponse = "*ABORTED*"
+
+// Txn queues commands to apply atomically with MULTI ... EXEC. Commands are
+// buffered locally and only sent by Exec, which holds the connection for
+// the whole exchange so no other command can interleave with it.
+type Txn struct {
+	kv     *KV
+	ops    []txnOp
+	done   bool
+	onExec func(commands []string)
+}
+
+// txnOp is one queued transaction command
+type txnOp struct {
+	verb  string
+	key   string
+	value string
+	ttl   string
+}
+
+// Txn starts a transaction. It requires a server that lists MULTI in its
+// capabilities, so KVConfig.Handshake must be enabled.
+func (kv *KV) Txn() *Txn {
+	return &Txn{kv: kv}
+}
+
+// Set queues a SET of key to value
+func (t *Txn) Set(key, value, ttl string) *Txn {
+	t.ops = append(t.ops, txnOp{verb: "SET", key: t.kv.wireKey(key), value: value, ttl: ttl})
+	return t
+}
+
+// Incr queues an INCR of key
+func (t *Txn) Incr(key, ttl string) *Txn {
+	t.ops = append(t.ops, txnOp{verb: "INCR", key: t.kv.wireKey(key), ttl: ttl})
+	return t
+}
+
+// Delete queues a DEL of key
+func (t *Txn) Delete(key string) *Txn {
+	t.ops = append(t.ops, txnOp{verb: "DEL", key: t.kv.wireKey(key)})
+	return t
+}
+
+// Discard drops the queued commands without sending anything
+func (t *Txn) Discard() {
+	t.ops = nil
+	t.done = true
+}
+
+// Exec sends the queued commands inside MULTI ... EXEC and returns one
+// result per command. A per-command error in a result means the server
+// applied the transaction but that command failed; any error returned
+// alongside nil results means nothing was applied, and wraps ErrTxnAborted
+// unless the transaction was never started. The exception is
+// ErrResponseTooLarge: the transaction was applied but its results
+// exceeded the aggregate response limit.
+func (t *Txn) Exec() ([]BatchResult, error) {
+	if t.done {
+		return nil, errTxnDone
+	}
+	t.done = true
+	if len(t.ops) == 0 {
+		return nil, nil
+	}
+
+	kv := t.kv
+	if !kv.Capabilities().Supports("MULTI") {
+		return nil, ErrTxnUnsupported
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return nil, err
+	}
+	client := kv.shrmplKVClient
+
+	commands := make([]string, len(t.ops))
+	for i, op := range t.ops {
+		cmd, err := client.txnCommand(op)
+		if err != nil {
+			return nil, fmt.Errorf("transaction command %d: %w", i, err)
+		}
+		commands[i] = cmd
+	}
+	if t.onExec != nil {
+		t.onExec(commands)
+	}
+
+	results, err := client.execTxn(commands)
+	var connErr *ConnError
+	if errors.As(err, &connErr) || errors.Is(err, ErrResponseTooLarge) {
+		// The server discards a transaction whose connection drops, but
+		// this connection may still be in MULTI state: never reuse it
+		client.Close()
+		kv.shrmplKVClient = nil
+	}
+	return results, err
+}
+
+// txnCommand builds the wire command for op
+func (c *ShrmplKVClient) txnCommand(op txnOp) (string, error) {
+	if err := c.checkKey(op.key); err != nil {
+		return "", err
+	}
+	ttl, err := normalizeTTL(op.ttl)
+	if err != nil {
+		return "", err
+	}
+	args := []string{op.key}
+	if op.verb == "SET" {
+		value, err := c.encodeValue(op.key, op.value)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, value)
+	}
+	if ttl != "" {
+		args = append(args, ttl)
+	}
+	return encodeCommand(op.verb, args...)
+}
+
+// execTxn runs commands inside MULTI ... EXEC. The server answers QUEUED
+// to each command and EXEC with the results joined like a BATCH response.
+// A command rejected while queueing makes the client DISCARD the
+// transaction.
+func (c *ShrmplKVClient) execTxn(commands []string) ([]BatchResult, error) {
+	response, err := c.send("MULTI")
+	if err != nil {
+		return nil, err
+	}
+	if response != "OK" {
+		if strings.HasPrefix(response, "ERROR") {
+			return nil, newServerError(response)
+		}
+		return nil, fmt.Errorf("unexpected response: %s", response)
+	}
+
+	for i, cmd := range commands {
+		response, err := c.sendCommand(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTxnAborted, err)
+		}
+		if response == "QUEUED" {
+			continue
+		}
+
+		var cause error = fmt.Errorf("unexpected response: %s", response)
+		if strings.HasPrefix(response, "ERROR") {
+			cause = newServerError(response)
+		}
+		if _, err := c.send("DISCARD"); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTxnAborted, err)
+		}
+		return nil, fmt.Errorf("%w: command %d (%s): %w",
+			ErrTxnAborted, i, strings.Fields(cmd)[0], cause)
+	}
+
+	response, err = c.sendAggregate("EXEC")
+	if errors.Is(err, ErrResponseTooLarge) {
+		// The transaction was applied; only its results are lost
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTxnAborted, err)
+	}
+	if response == txnAbortedResponse {
+		return nil, ErrTxnAborted
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		return nil, fmt.Errorf("%w: %w", ErrTxnAborted, newServerError(response))
+	}
+	return c.parseBatch(commands, response)
+}
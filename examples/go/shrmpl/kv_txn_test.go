@@ -0,0 +1,101 @@
+package shrmpl_test
+
+import (
+	"errors"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// newTxnKV returns a KV that has completed its handshake with srv
+func newTxnKV(t *testing.T, srv *shrmpltest.KVServer) *shrmpl.KV {
+	t.Helper()
+	cfg := srv.Config()
+	cfg.Handshake = true
+	kv := shrmpl.NewKV(cfg).(*shrmpl.KV)
+	t.Cleanup(kv.Close)
+	if _, err := kv.DBSize(); err != nil {
+		t.Fatal(err)
+	}
+	return kv
+}
+
+func TestTxnExecAbort(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := newTxnKV(t, srv)
+
+	srv.AbortNextTxn()
+	results, err := kv.Txn().Set("a", "1", "").Incr("n", "").Exec()
+	if !errors.Is(err, shrmpl.ErrTxnAborted) || results != nil {
+		t.Fatalf("Exec = %v, %v; want ErrTxnAborted", results, err)
+	}
+	if _, ok := srv.Value("a"); ok {
+		t.Error("an aborted transaction was applied")
+	}
+
+	// The connection is out of MULTI state and usable
+	results, err = kv.Txn().Set("a", "2", "").Exec()
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Exec after an abort = %v, %v", results, err)
+	}
+	if value, _ := srv.Value("a"); value != "2" {
+		t.Errorf("a = %q, want 2", value)
+	}
+	if dials := srv.Dials(); dials != 1 {
+		t.Errorf("dials = %d, want 1", dials)
+	}
+}
+
+func TestTxnQueueErrorDiscards(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := newTxnKV(t, srv)
+	srv.Put("a", "old")
+
+	// Tighten the key limit after the client learned it, so the server
+	// rejects the second command while the first is already queued
+	srv.SetLimits(3, 100)
+	_, err := kv.Txn().Set("a", "new", "").Set("long-key", "v", "").Exec()
+	var serverErr *shrmpl.ServerError
+	if !errors.Is(err, shrmpl.ErrTxnAborted) || !errors.As(err, &serverErr) ||
+		serverErr.Kind() != shrmpl.ErrKindInvalidLength {
+		t.Fatalf("Exec = %v, want ErrTxnAborted wrapping an invalid-length ServerError", err)
+	}
+	if value, _ := srv.Value("a"); value != "old" {
+		t.Errorf("a = %q, want the queued SET discarded", value)
+	}
+
+	// DISCARD left the connection outside the transaction
+	if err := kv.Set("b", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := srv.Value("b"); value != "v" {
+		t.Errorf("b = %q, want a SET after the discard applied at once", value)
+	}
+}
+
+func TestTxnWithHeartbeats(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := newTxnKV(t, srv)
+
+	srv.SetHeartbeats(true)
+	results, err := kv.Txn().Set("a", "1", "").Incr("n", "").Delete("missing").Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if results[0].Value != "OK" || results[1].Value != "1" || !results[2].NotFound {
+		t.Errorf("results = %+v", results)
+	}
+
+	// Nothing is left unread on the connection
+	srv.SetHeartbeats(false)
+	if value, err := kv.Get("a"); err != nil || value != "1" {
+		t.Errorf("Get after the transaction = %q, %v; want 1", value, err)
+	}
+}
@@ -0,0 +1,189 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidKey is returned by TypedKV, before anything is sent, when a
+// KeyFunc produces a key the server cannot store
+var ErrInvalidKey = errors.New("invalid key")
+
+// KeyFunc turns a structured key into the string stored in shrmpl-kv
+type KeyFunc[K comparable] func(K) string
+
+// TypedKV is a ThisAppKVInterface addressed by structured keys, so the
+// format of keys such as user ID plus resource is defined once instead of
+// at every call site:
+//
+//	type sessionKey struct{ User, Device string }
+//	sessions := shrmpl.NewTypedKV(kv, func(k sessionKey) string {
+//		return "session:" + k.User + ":" + k.Device
+//	})
+//	err := sessions.Set(sessionKey{"bob", "phone"}, token, "30min")
+//
+// Every key is serialized with the KeyFunc and validated before the call
+// reaches the wrapped client. Operations beyond ThisAppKVInterface use the
+// optional interfaces, such as KVToucher, and fail with an error matching
+// errors.ErrUnsupported when the client lacks them; the Context methods
+// fall back to ignoring ctx.
+type TypedKV[K comparable] struct {
+	kv       ThisAppKVInterface
+	keyFunc  KeyFunc[K]
+	longKeys bool
+}
+
+// NewTypedKV wraps kv. Keys over the key length limit (KV.Limits, 100
+// characters for other clients) are rejected unless kv is a client with
+// KVConfig.HashLongKeys set.
+func NewTypedKV[K comparable](kv ThisAppKVInterface, keyFunc KeyFunc[K]) *TypedKV[K] {
+	t := &TypedKV[K]{kv: kv, keyFunc: keyFunc}
+	if client, ok := kv.(*KV); ok {
+		t.longKeys = client.config.HashLongKeys
+	}
+	return t
+}
+
+// Key returns the string key for k, or an error wrapping ErrInvalidKey
+// if the server could not store it
+func (t *TypedKV[K]) Key(k K) (string, error) {
+	key := t.keyFunc(k)
+	if key == "" {
+		return "", fmt.Errorf("%w: empty key for %v", ErrInvalidKey, k)
+	}
+	for _, r := range key {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == ';' {
+			return "", fmt.Errorf("%w %q: contains %q", ErrInvalidKey, key, r)
+		}
+	}
+	limit := maxKeyLength
+	if client, ok := t.kv.(*KV); ok {
+		limit = client.Limits().MaxKeyLength
+	}
+	if len(key) > limit && !t.longKeys {
+		return "", fmt.Errorf("%w %q: exceeds %d characters", ErrInvalidKey, key, limit)
+	}
+	return key, nil
+}
+
+// Get retrieves the value stored under k
+func (t *TypedKV[K]) Get(k K) (string, error) {
+	return t.GetContext(context.Background(), k)
+}
+
+// GetContext retrieves the value stored under k; see KV.GetContext
+func (t *TypedKV[K]) GetContext(ctx context.Context, k K) (string, error) {
+	key, err := t.Key(k)
+	if err != nil {
+		return "", err
+	}
+	if kv, ok := t.kv.(KVContexter); ok {
+		return kv.GetContext(ctx, key)
+	}
+	return t.kv.Get(key)
+}
+
+// Set stores value under k
+func (t *TypedKV[K]) Set(k K, value, ttl string) error {
+	return t.SetContext(context.Background(), k, value, ttl)
+}
+
+// SetContext stores value under k; see KV.SetContext
+func (t *TypedKV[K]) SetContext(ctx context.Context, k K, value, ttl string) error {
+	key, err := t.Key(k)
+	if err != nil {
+		return err
+	}
+	if kv, ok := t.kv.(KVContexter); ok {
+		return kv.SetContext(ctx, key, value, ttl)
+	}
+	return t.kv.Set(key, value, ttl)
+}
+
+// SetOpts stores value under k according to opts; see KV.SetOpts
+func (t *TypedKV[K]) SetOpts(k K, value string, opts SetOptions) (string, bool, error) {
+	kv, ok := t.kv.(KVConditionalSetter)
+	if !ok {
+		return "", false, errUnsupported(t.kv, "SetOpts")
+	}
+	key, err := t.Key(k)
+	if err != nil {
+		return "", false, err
+	}
+	return kv.SetOpts(key, value, opts)
+}
+
+// CompareAndSwap sets k to newValue only if its current value is
+// oldValue; see KV.CompareAndSwap
+func (t *TypedKV[K]) CompareAndSwap(k K, oldValue, newValue, ttl string) (bool, error) {
+	kv, ok := t.kv.(KVConditionalSetter)
+	if !ok {
+		return false, errUnsupported(t.kv, "CompareAndSwap")
+	}
+	key, err := t.Key(k)
+	if err != nil {
+		return false, err
+	}
+	return kv.CompareAndSwap(key, oldValue, newValue, ttl)
+}
+
+// Update performs a read-modify-write of k; see KV.Update
+func (t *TypedKV[K]) Update(k K, ttl string,
+	fn func(current string, exists bool) (string, error)) error {
+	kv, ok := t.kv.(KVConditionalSetter)
+	if !ok {
+		return errUnsupported(t.kv, "Update")
+	}
+	key, err := t.Key(k)
+	if err != nil {
+		return err
+	}
+	return kv.Update(key, ttl, fn)
+}
+
+// Incr increments the counter stored under k
+func (t *TypedKV[K]) Incr(k K, ttl string) (int, error) {
+	return t.IncrContext(context.Background(), k, ttl)
+}
+
+// IncrContext increments the counter stored under k; see KV.IncrContext
+func (t *TypedKV[K]) IncrContext(ctx context.Context, k K, ttl string) (int, error) {
+	key, err := t.Key(k)
+	if err != nil {
+		return 0, err
+	}
+	if kv, ok := t.kv.(KVContexter); ok {
+		return kv.IncrContext(ctx, key, ttl)
+	}
+	return t.kv.Incr(key, ttl)
+}
+
+// Touch renews the expiration of k; see KV.Touch
+func (t *TypedKV[K]) Touch(k K, ttl string) (bool, error) {
+	kv, ok := t.kv.(KVToucher)
+	if !ok {
+		return false, errUnsupported(t.kv, "Touch")
+	}
+	key, err := t.Key(k)
+	if err != nil {
+		return false, err
+	}
+	return kv.Touch(key, ttl)
+}
+
+// GetWithTTL retrieves the value of k and its remaining lifetime; see
+// KV.GetWithTTL
+func (t *TypedKV[K]) GetWithTTL(k K) (string, time.Duration, bool, error) {
+	kv, ok := t.kv.(KVToucher)
+	if !ok {
+		return "", 0, false, errUnsupported(t.kv, "GetWithTTL")
+	}
+	key, err := t.Key(k)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return kv.GetWithTTL(key)
+}
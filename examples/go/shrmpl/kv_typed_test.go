@@ -0,0 +1,117 @@
+package shrmpl_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestTypedKVIntKeys(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+
+	counters := shrmpl.NewTypedKV(kv, func(id int) string {
+		return "counter:" + strconv.Itoa(id)
+	})
+	for id := 1; id <= 3; id++ {
+		for i := 0; i < id; i++ {
+			if _, err := counters.Incr(id, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for id := 1; id <= 3; id++ {
+		if value, ok := srv.Value("counter:" + strconv.Itoa(id)); !ok || value != strconv.Itoa(id) {
+			t.Errorf("counter:%d = %q, %v; want %d", id, value, ok, id)
+		}
+		if value, err := counters.Get(id); err != nil || value != strconv.Itoa(id) {
+			t.Errorf("Get(%d) = %q, %v; want %d", id, value, err, id)
+		}
+	}
+	if key, err := counters.Key(-7); err != nil || key != "counter:-7" {
+		t.Errorf("Key(-7) = %q, %v", key, err)
+	}
+}
+
+// profileKey and profile are a struct key and a struct value stored as
+// JSON
+type profileKey struct {
+	Tenant string
+	User   int
+}
+
+type profile struct {
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+func TestTypedKVStructKeysAndValues(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+
+	profiles := shrmpl.NewTypedKV(kv, func(k profileKey) string {
+		return "profile:" + k.Tenant + ":" + strconv.Itoa(k.User)
+	})
+	want := map[profileKey]profile{
+		{"acme", 1}:  {Name: "bob", Admin: true},
+		{"acme", 2}:  {Name: "alice"},
+		{"other", 1}: {Name: "carol"},
+	}
+	for k, p := range want {
+		value, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := profiles.Set(k, string(value), "1h"); err != nil {
+			t.Fatalf("Set(%v): %v", k, err)
+		}
+	}
+	for k, p := range want {
+		value, err := profiles.Get(k)
+		if err != nil {
+			t.Fatalf("Get(%v): %v", k, err)
+		}
+		var got profile
+		if err := json.Unmarshal([]byte(value), &got); err != nil {
+			t.Fatalf("Get(%v) = %q: %v", k, value, err)
+		}
+		if got != p {
+			t.Errorf("Get(%v) = %+v, want %+v", k, got, p)
+		}
+	}
+	if _, ok := srv.Value("profile:acme:2"); !ok {
+		t.Error("profile:acme:2 not stored under its serialized key")
+	}
+}
+
+func TestTypedKVRejectsInvalidStructKeys(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+
+	profiles := shrmpl.NewTypedKV(kv, func(k profileKey) string {
+		return k.Tenant + ":" + strconv.Itoa(k.User)
+	})
+	for _, k := range []profileKey{
+		{"two words", 1},
+		{"semi;colon", 1},
+		{strings.Repeat("t", 100), 1},
+	} {
+		if err := profiles.Set(k, "v", ""); !errors.Is(err, shrmpl.ErrInvalidKey) {
+			t.Errorf("Set(%v) = %v, want ErrInvalidKey", k, err)
+		}
+		if _, err := profiles.Get(k); !errors.Is(err, shrmpl.ErrInvalidKey) {
+			t.Errorf("Get(%v) = %v, want ErrInvalidKey", k, err)
+		}
+	}
+}
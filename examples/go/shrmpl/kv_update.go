@@ -0,0 +1,130 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTooManyRetries is returned by Update when every attempt lost a race
+// with a concurrent writer
+var ErrTooManyRetries = errors.New("too many retries: key is under contention")
+
+// maxUpdateRetries bounds the attempts Update makes before giving up
+const maxUpdateRetries = 5
+
+// CompareAndSwap sets key to newValue only if its current value is
+// oldValue, reporting whether the swap happened
+func (c *ShrmplKVClient) CompareAndSwap(key, oldValue, newValue, ttl string) (bool, error) {
+	ttl, err := normalizeTTL(ttl)
+	if err != nil {
+		return false, err
+	}
+	oldValue, err = c.encodeValue(key, oldValue)
+	if err != nil {
+		return false, err
+	}
+	newValue, err = c.encodeValue(key, newValue)
+	if err != nil {
+		return false, err
+	}
+
+	args := []string{key, oldValue, newValue}
+	if ttl != "" {
+		args = append(args, ttl)
+	}
+
+	response, err := c.send("CAS", args...)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case response == "OK":
+		return true, nil
+	case response == notSetResponse:
+		return false, nil
+	case strings.HasPrefix(response, "ERROR"):
+		return false, newServerError(response)
+	default:
+		return false, fmt.Errorf("unexpected response: %s", response)
+	}
+}
+
+// Update reads key, computes its new value with fn and writes it back,
+// retrying if another writer changed the key in between. Existing keys are
+// written with CompareAndSwap and missing keys with an OnlyIfAbsent set.
+// An error from fn aborts the update and is returned as is.
+func (c *ShrmplKVClient) Update(key string, ttl string,
+	fn func(current string, exists bool) (string, error)) error {
+	for attempt := 0; attempt < maxUpdateRetries; attempt++ {
+		// An evicted key is written back like a missing one
+		current, exists, err := c.lookup(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		next, err := fn(current, exists)
+		if err != nil {
+			return err
+		}
+
+		var written bool
+		if exists {
+			written, err = c.CompareAndSwap(key, current, next, ttl)
+		} else {
+			_, written, err = c.SetOpts(key, next, SetOptions{OnlyIfAbsent: true, TTL: ttl})
+		}
+		if err != nil {
+			return err
+		}
+		if written {
+			return nil
+		}
+	}
+	return ErrTooManyRetries
+}
+
+// CompareAndSwap sets key to newValue only if its current value is
+// oldValue; see ShrmplKVClient.CompareAndSwap
+func (kv *KV) CompareAndSwap(key, oldValue, newValue, ttl string) (bool, error) {
+	key = kv.wireKey(key)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return false, err
+	}
+
+	swapped, err := kv.shrmplKVClient.CompareAndSwap(key, oldValue, newValue, ttl)
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			kv.shrmplKVClient.Close()
+			kv.shrmplKVClient = nil
+		}
+		return false, err
+	}
+	return swapped, nil
+}
+
+// Update performs a read-modify-write of key; see ShrmplKVClient.Update.
+// The connection is held for the whole update, so fn should be quick.
+func (kv *KV) Update(key string, ttl string,
+	fn func(current string, exists bool) (string, error)) error {
+	key = kv.wireKey(key)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return err
+	}
+
+	err := kv.shrmplKVClient.Update(key, ttl, fn)
+	var connErr *ConnError
+	if errors.As(err, &connErr) {
+		// Only transport failures cost the connection; errors from fn
+		// and the server leave it usable
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrWriteNotVisible is returned by SetAndVerify when a read right after
+// the write does not return the written value
+var ErrWriteNotVisible = errors.New("write not visible")
+
+// SetAndVerify stores a key-value pair, then reads the key back and
+// returns an error wrapping ErrWriteNotVisible unless it holds value.
+// It is a diagnostic for write-read consistency tests: it costs an extra
+// round trip and a concurrent writer to the same key makes it fail, so do
+// not use it on hot paths.
+func (c *ShrmplKVClient) SetAndVerify(key, value, ttl string) error {
+	if err := c.Set(key, value, ttl); err != nil {
+		return err
+	}
+	got, exists, err := c.lookup(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s not found after SET", ErrWriteNotVisible, key)
+	}
+	if got != value {
+		return fmt.Errorf("%w: %s is %q after SET of %q", ErrWriteNotVisible, key, got, value)
+	}
+	return nil
+}
+
+// SetAndVerify stores a key-value pair and reads it back on the same
+// connection; see ShrmplKVClient.SetAndVerify. It adds a round trip and
+// is meant for tests and diagnostics, not hot paths.
+func (kv *KV) SetAndVerify(key, value, ttl string) error {
+	key = kv.wireKey(key)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if err := kv.ensureConnected(); err != nil {
+		return err
+	}
+
+	err := kv.shrmplKVClient.SetAndVerify(key, value, ttl)
+	var serverErr *ServerError
+	if err != nil && !errors.Is(err, ErrWriteNotVisible) && !errors.As(err, &serverErr) {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
+	return err
+}
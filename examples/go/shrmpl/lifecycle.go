@@ -0,0 +1,18 @@
+package shrmpl
+
+import (
+	"context"
+)
+
+// Closer is implemented by every client in this package
+type Closer interface {
+	Close()
+}
+
+// CloseOnContext closes c once ctx is done, tying the client lifetime to a
+// service's root context. The KV and Log wrappers let in-flight operations
+// finish before the connection is torn down. The returned stop function
+// detaches c from ctx and reports whether the close was still pending.
+func CloseOnContext(ctx context.Context, c Closer) (stop func() bool) {
+	return context.AfterFunc(ctx, c.Close)
+}
@@ -0,0 +1,81 @@
+package shrmpl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestCloseOnContextFlushesLogger(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	async := true
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr, Async: &async})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := shrmpl.CloseOnContext(ctx, l)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		l.Info("T001", fmt.Sprintf("message %02d", i))
+	}
+	cancel()
+
+	// The close runs in its own goroutine; wait for it to take effect
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := l.Entry().Code("LATE").Msg("after close")
+		if errors.Is(err, shrmpl.ErrLoggerClosed) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Msg = %v, want nil or ErrLoggerClosed", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("logger was not closed after the context was cancelled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stop() {
+		t.Error("stop() = true, want false once the close has run")
+	}
+
+	// Everything queued before the cancel reached the server
+	frames, err := srv.WaitFrames(n, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := 0
+	for _, frame := range frames {
+		if frame.Code == "T001" {
+			got++
+		}
+	}
+	if got != n {
+		t.Errorf("received %d of %d messages logged before the cancel", got, n)
+	}
+	if err := l.Entry().Msg("still closed"); !errors.Is(err, shrmpl.ErrLoggerClosed) {
+		t.Errorf("Msg after close = %v, want ErrLoggerClosed", err)
+	}
+}
+
+func TestCloseOnContextStopDetaches(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if !shrmpl.CloseOnContext(ctx, l)() {
+		t.Error("stop() = false, want true before the context is done")
+	}
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	if err := l.Entry().Msg("still open"); err != nil {
+		t.Errorf("Msg after a detached cancel = %v, want nil", err)
+	}
+}
@@ -0,0 +1,81 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLoggerClosed is returned by EntryBuilder.Msg once the logger is
+// closed; the message is then only echoed to the console
+var ErrLoggerClosed = errors.New("logger closed")
+
+// EntryBuilder assembles one log message field by field; see Logger.Entry.
+// The first invalid value is remembered and reported by Msg.
+type EntryBuilder struct {
+	logger  *Logger
+	level   string
+	code    string
+	keyvals []interface{}
+	err     error
+}
+
+// Entry starts a message at INFO level with code "0000":
+//
+//	logger.Entry().Level("WARN").Code("T001").Field("host", "x").Msg("started")
+func (l *Logger) Entry() *EntryBuilder {
+	return &EntryBuilder{logger: l, level: "INFO", code: "0000"}
+}
+
+// fail records the first validation error
+func (b *EntryBuilder) fail(format string, args ...interface{}) {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+}
+
+// Level sets the level, accepting the wire names and their long forms
+// (DEBUG, WARNING, ERROR)
+func (b *EntryBuilder) Level(level string) *EntryBuilder {
+	if parsed, ok := parseLogLevel(level); ok {
+		b.level = parsed
+	} else {
+		b.fail("invalid log level %q", level)
+	}
+	return b
+}
+
+// Code sets the 4 character message code
+func (b *EntryBuilder) Code(code string) *EntryBuilder {
+	if len(code) == 4 {
+		b.code = code
+	} else {
+		b.fail("code must be exactly 4 characters: %q", code)
+	}
+	return b
+}
+
+// Field adds a key/value pair
+func (b *EntryBuilder) Field(key string, value interface{}) *EntryBuilder {
+	if key == "" {
+		b.fail("field key must not be empty")
+	} else {
+		b.keyvals = append(b.keyvals, key, value)
+	}
+	return b
+}
+
+// Msg sends the message through the logger's usual level filtering and
+// connection. Nothing is sent if any builder call was invalid; the first
+// problem is returned instead. After Close it returns ErrLoggerClosed.
+func (b *EntryBuilder) Msg(message string) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.logger.log(b.level, b.code, message, 2, b.keyvals...)
+	b.logger.mu.Lock()
+	defer b.logger.mu.Unlock()
+	if b.logger.closed {
+		return ErrLoggerClosed
+	}
+	return nil
+}
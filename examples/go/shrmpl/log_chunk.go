@@ -0,0 +1,84 @@
+package shrmpl
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPartDigits bounds the digits of i and n in "part=i/n"; splitRecord
+// reserves room for them in every frame
+const maxPartDigits = 6
+
+// chunkPrefixReserve is the longest "msgid=<uuid> part=i/n " prefix
+const chunkPrefixReserve = len("msgid=") + 36 + len(" part=") + 2*maxPartDigits + 1 + 1
+
+// splitRecord returns rec as the records to send. A message too long for
+// one frame is split at rune boundaries into parts that each start with
+// "msgid=<uuid> part=i/n ", so a consumer can reassemble them by stripping
+// the prefix and concatenating the parts of one msgid in part order. Every
+// part leaves room for the replay marker. The fields go with the last
+// part, which also leaves room for them being flattened into the message
+// when the server only speaks v1; they then end up after the message text
+// as they would unsplit.
+func splitRecord(rec logRecord) []logRecord {
+	fieldsLen := 0
+	if len(rec.fields) > 0 {
+		// Fields too long to fit beside a message are truncated by
+		// V1Encoder anyway, so they do not shrink the parts to nothing
+		fieldsLen = min(len(flattenFields("", rec.fields)), maxLogMessage/2)
+	}
+	if len(replayMarker)+len(rec.message)+fieldsLen <= maxLogMessage {
+		return []logRecord{rec}
+	}
+
+	budget := maxLogMessage - len(replayMarker) - chunkPrefixReserve
+	var parts []string
+	for rest := rec.message; rest != ""; {
+		if len(rest) <= budget-fieldsLen {
+			parts = append(parts, rest)
+			break
+		}
+		n := budget
+		if n >= len(rest) {
+			// rest fits but the fields would not; move its tail on
+			n = budget - fieldsLen
+		}
+		for n > 0 && !utf8.RuneStart(rest[n]) {
+			n--
+		}
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+
+	id := newMessageID()
+	total := strconv.Itoa(len(parts))
+	records := make([]logRecord, len(parts))
+	for i, part := range parts {
+		var b strings.Builder
+		b.Grow(chunkPrefixReserve + len(part))
+		b.WriteString("msgid=")
+		b.WriteString(id)
+		b.WriteString(" part=")
+		b.WriteString(strconv.Itoa(i + 1))
+		b.WriteByte('/')
+		b.WriteString(total)
+		b.WriteByte(' ')
+		b.WriteString(part)
+		records[i] = logRecord{level: rec.level, code: rec.code, message: b.String()}
+	}
+	records[len(records)-1].fields = rec.fields
+	return records
+}
+
+// newMessageID returns a random version 4 UUID correlating the parts of a
+// split message
+func newMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
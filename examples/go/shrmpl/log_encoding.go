@@ -0,0 +1,287 @@
+package shrmpl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Wire protocols for shrmpl-log
+const (
+	// ProtocolV1 flattens fields into the message text (the default)
+	ProtocolV1 = "v1"
+	// ProtocolV2 sends fields as a length-prefixed JSON object after the
+	// message. Only use it explicitly against servers known to accept it.
+	ProtocolV2 = "v2"
+	// ProtocolAuto sends HELLO on connect and uses the version the server
+	// acknowledges, v1 if it does not answer
+	ProtocolAuto = "auto"
+)
+
+// helloTimeout bounds the wait for a HELLO reply. Servers that predate
+// v2 ignore the line, so silence means v1.
+const helloTimeout = 500 * time.Millisecond
+
+// Wire limits shared by both encodings
+const (
+	maxLogMessage = 4096
+	maxLogFields  = 4096
+)
+
+// Entry is one log message as handed to an Encoder
+type Entry struct {
+	Level   string
+	Host    string
+	Code    string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// Encoder writes an Entry as one wire frame
+type Encoder interface {
+	Encode(buf *bytes.Buffer, e Entry) error
+}
+
+// V1Encoder writes the fixed-width text frame, appending fields to the
+// message as sorted key=value pairs
+type V1Encoder struct{}
+
+// V2Encoder writes the v1 header and message followed by the fields as a
+// length-prefixed JSON object:
+//
+//	LVL HOST CODE LEN: MSG FLEN: {"key":"value"}
+type V2Encoder struct{}
+
+// frameBufferPool holds the buffers frames are encoded into, so sending a
+// message does not allocate intermediate strings
+var frameBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer keeps unusually large frames from pinning memory
+const maxPooledBuffer = 64 * 1024
+
+// Encode implements Encoder
+func (V1Encoder) Encode(buf *bytes.Buffer, e Entry) error {
+	if len(e.Fields) > 0 {
+		// Fields must not push an otherwise valid message over the limit
+		e.Message = truncateRunes(flattenFields(e.Message, e.Fields), maxLogMessage)
+	}
+	if err := validateEntry(e); err != nil {
+		return err
+	}
+	writeFrameHeader(buf, e)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// Encode implements Encoder
+func (V2Encoder) Encode(buf *bytes.Buffer, e Entry) error {
+	if err := validateEntry(e); err != nil {
+		return err
+	}
+	if len(e.Fields) == 0 {
+		writeFrameHeader(buf, e)
+		buf.WriteByte('\n')
+		return nil
+	}
+	fields, err := json.Marshal(e.Fields)
+	if err != nil {
+		return fmt.Errorf("encoding fields: %w", err)
+	}
+	if len(fields) > maxLogFields {
+		return fmt.Errorf("fields must be <= %d bytes", maxLogFields)
+	}
+	writeFrameHeader(buf, e)
+	buf.WriteByte(' ')
+	writeZeroPadded(buf, len(fields), 5)
+	buf.WriteString(": ")
+	buf.Write(fields)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// validateEntry checks the fixed-width header limits
+func validateEntry(e Entry) error {
+	if len(e.Level) != 4 {
+		return fmt.Errorf("level must be exactly 4 characters")
+	}
+	if len(e.Host) > 32 {
+		return fmt.Errorf("host must be <= 32 characters")
+	}
+	if len(e.Code) != 4 {
+		return fmt.Errorf("code must be exactly 4 characters")
+	}
+	if len(e.Message) > maxLogMessage {
+		return fmt.Errorf("message must be <= %d characters", maxLogMessage)
+	}
+	return nil
+}
+
+// writeFrameHeader writes [LVL(4)] [HOST(32)] [CODE(12)] [LEN(5)]: [MSG]
+func writeFrameHeader(buf *bytes.Buffer, e Entry) {
+	buf.WriteString(e.Level[:4])
+	buf.WriteByte(' ')
+	writePadded(buf, e.Host[:min(32, len(e.Host))], 32)
+	buf.WriteByte(' ')
+	writePadded(buf, e.Code[:min(12, len(e.Code))], 12)
+	buf.WriteByte(' ')
+	writeZeroPadded(buf, len(e.Message), 5)
+	buf.WriteString(": ")
+	buf.WriteString(e.Message)
+}
+
+// writePadded writes s left-aligned in width characters. Like fmt's %-Ns
+// the width counts runes, not bytes.
+func writePadded(buf *bytes.Buffer, s string, width int) {
+	buf.WriteString(s)
+	for n := utf8.RuneCountInString(s); n < width; n++ {
+		buf.WriteByte(' ')
+	}
+}
+
+// writeZeroPadded writes n as a decimal zero-padded to width digits
+func writeZeroPadded(buf *bytes.Buffer, n int, width int) {
+	var digits [20]byte
+	b := strconv.AppendInt(digits[:0], int64(n), 10)
+	for i := len(b); i < width; i++ {
+		buf.WriteByte('0')
+	}
+	buf.Write(b)
+}
+
+// flattenFields appends fields to message as sorted key=value pairs
+func flattenFields(message string, fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return message
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(message)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+// missingKeyvalValue is the value of a trailing key given without one
+const missingKeyvalValue = "(MISSING)"
+
+// keyvalString coerces a keyval key or value to its field text. Strings
+// are used as is; every other type, including nil, ints, structs and
+// pointers, is formatted with fmt.Sprint, so a fmt.Stringer uses its
+// String method.
+func keyvalString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// keyvalFields converts alternating key/value arguments to a field map.
+// Keys and values are coerced to strings with keyvalString, so fields
+// look the same in v1 text and v2 JSON and no value type can fail
+// encoding. Errors are expanded into message, type and cause fields (see
+// addErrorFields). A trailing key without a value is kept with the value
+// "(MISSING)" rather than dropped.
+func keyvalFields(level string, keyvals []interface{}) map[string]interface{} {
+	if len(keyvals) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := keyvalString(keyvals[i])
+		if i+1 == len(keyvals) {
+			fields[key] = missingKeyvalValue
+			break
+		}
+		if err, ok := keyvals[i+1].(error); ok {
+			addErrorFields(fields, key, err, level)
+			continue
+		}
+		fields[key] = keyvalString(keyvals[i+1])
+	}
+	return fields
+}
+
+// logProtocol is a wire format version that can be negotiated
+type logProtocol struct {
+	version int
+	name    string
+	encoder Encoder
+}
+
+// logProtocols lists the wire format versions this client speaks, oldest
+// first. HELLO offers the last one; a new format is added here with the
+// next version number.
+var logProtocols = []logProtocol{
+	{1, ProtocolV1, V1Encoder{}},
+	{2, ProtocolV2, V2Encoder{}},
+}
+
+// protocolByVersion returns the wire format with the given version
+func protocolByVersion(version int) (logProtocol, bool) {
+	for _, p := range logProtocols {
+		if p.version == version {
+			return p, true
+		}
+	}
+	return logProtocol{}, false
+}
+
+// protocolByName returns the wire format with the given name
+func protocolByName(name string) logProtocol {
+	for _, p := range logProtocols {
+		if p.name == name {
+			return p
+		}
+	}
+	return logProtocols[0]
+}
+
+// negotiate offers the newest wire format with "HELLO <version>" and
+// returns the one the server acknowledges with "OK <version>", which may
+// be any version up to the offered one. Silence within helloTimeout, an
+// ERROR or a version this client does not speak selects v1, the format
+// of servers that predate HELLO.
+func (c *ShrmplLogClient) negotiate() (logProtocol, error) {
+	offered := logProtocols[len(logProtocols)-1]
+	hello := "HELLO " + strconv.Itoa(offered.version) + "\n"
+	if err := writeFull(c.conn, []byte(hello)); err != nil {
+		return logProtocol{}, fmt.Errorf("failed to send HELLO: %w", err)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return logProtocols[0], nil
+		}
+		line = strings.TrimSpace(line)
+		if ack, ok := strings.CutPrefix(line, "OK "); ok {
+			version, err := strconv.Atoi(ack)
+			if p, known := protocolByVersion(version); err == nil && known &&
+				version <= offered.version {
+				return p, nil
+			}
+			return logProtocols[0], nil
+		}
+		if strings.HasPrefix(line, "ERROR") {
+			return logProtocols[0], nil
+		}
+		// Anything else is a keepalive; keep waiting
+	}
+}
@@ -0,0 +1,175 @@
+package shrmpl
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestV1EncoderTruncatesAtRuneBoundary(t *testing.T) {
+	// The flattened fields run past the limit in the middle of a
+	// two-byte rune
+	e := Entry{Level: "INFO", Host: "h", Code: "0000", Message: "m",
+		Fields: map[string]interface{}{"k": strings.Repeat("é", maxLogMessage)}}
+	var buf bytes.Buffer
+	if err := (V1Encoder{}).Encode(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	frame := strings.TrimSuffix(buf.String(), "\n")
+	message := frame[strings.Index(frame, ": ")+2:]
+	if !utf8.ValidString(message) {
+		t.Errorf("message ends in a split rune: %q", message[len(message)-4:])
+	}
+	if len(message) > maxLogMessage || len(message) < maxLogMessage-1 {
+		t.Errorf("message is %d bytes, want %d or one less", len(message), maxLogMessage)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 2, "ab"},
+		{"aé", 2, "a"},
+		{"aé", 3, "aé"},
+		{"日本", 4, "日"},
+		{"日本", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateRunes(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestEncodersGolden(t *testing.T) {
+	withFields := Entry{Level: "INFO", Host: "orders", Code: "A100", Message: "user login",
+		Fields: map[string]interface{}{"user": "bob", "attempt": "2"}}
+	plain := Entry{Level: "WARN", Host: "orders", Code: "A101", Message: "slow"}
+
+	tests := []struct {
+		name    string
+		encoder Encoder
+		entry   Entry
+		want    string
+	}{
+		{"v1 fields", V1Encoder{}, withFields,
+			"INFO orders                           A100         00029: user login attempt=2 user=bob\n"},
+		{"v2 fields", V2Encoder{}, withFields,
+			"INFO orders                           A100         00010: user login 00028: {\"attempt\":\"2\",\"user\":\"bob\"}\n"},
+		// Without fields both versions write the same frame
+		{"v1 plain", V1Encoder{}, plain,
+			"WARN orders                           A101         00004: slow\n"},
+		{"v2 plain", V2Encoder{}, plain,
+			"WARN orders                           A101         00004: slow\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.encoder.Encode(&buf, tt.entry); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// captureConn records everything written to it; only Write and
+// SetWriteDeadline are usable
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *captureConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func (c *captureConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func TestLogEntryFrameGolden(t *testing.T) {
+	// The enrichment path adds the username and call site before framing
+	message := formatMessage("bob", "saved order", "orders.go:42")
+	tests := []struct {
+		name    string
+		version int
+		entry   Entry
+		want    string
+	}{
+		{"enriched", 1,
+			Entry{Level: "INFO", Host: "orders", Code: "A100", Message: message},
+			"INFO orders                           A100         00032: [bob] saved order (orders.go:42)\n"},
+		{"v1 flattened fields", 1,
+			Entry{Level: "ERRO", Host: "orders", Code: "A101", Message: message,
+				Fields: map[string]interface{}{"err": "disk full"}},
+			"ERRO orders                           A101         00046: [bob] saved order (orders.go:42) err=disk full\n"},
+		{"v2 fields", 2,
+			Entry{Level: "ERRO", Host: "orders", Code: "A101", Message: message,
+				Fields: map[string]interface{}{"err": "disk full"}},
+			"ERRO orders                           A101         00032: [bob] saved order (orders.go:42) 00019: {\"err\":\"disk full\"}\n"},
+		// Widths count runes, lengths count bytes
+		{"multi-byte host", 1,
+			Entry{Level: "WARN", Host: "café", Code: "A102", Message: "né"},
+			"WARN café                             A102         00003: né\n"},
+	}
+	for _, tt := range tests {
+		conn := &captureConn{}
+		p, _ := protocolByVersion(tt.version)
+		c := &ShrmplLogClient{conn: conn, version: p.version, encoder: p.encoder}
+		if err := c.LogEntry(tt.entry); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := conn.buf.String(); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// discardConn accepts and drops every write
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// sendRecord runs one record through enrichment, framing and the write
+func sendRecord(c *ShrmplLogClient) error {
+	message := formatMessage("bob", "saved order", callerSite(0))
+	return c.LogEntry(Entry{Level: "INFO", Host: "orders", Code: "A100", Message: message})
+}
+
+func TestLogEntryAllocations(t *testing.T) {
+	c := &ShrmplLogClient{conn: discardConn{}, writeTimeout: time.Second}
+	allocs := testing.AllocsPerRun(1000, func() {
+		if err := sendRecord(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 2 {
+		t.Errorf("%.1f allocations per record, want at most 2", allocs)
+	}
+}
+
+func BenchmarkLogEntry(b *testing.B) {
+	c := &ShrmplLogClient{conn: discardConn{}, writeTimeout: time.Second}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := sendRecord(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
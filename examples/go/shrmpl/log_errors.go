@@ -0,0 +1,196 @@
+package shrmpl
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// maxErrorDepth bounds how far an error's Unwrap chain is followed
+const maxErrorDepth = 32
+
+// maxStackFrames bounds the stack logged for an error
+const maxStackFrames = 10
+
+// StackTracer is implemented by errors that record where they were
+// created, as program counters from runtime.Callers. Their stack is
+// logged at DEBG level as "<key>_stack". Errors from github.com/pkg/errors
+// are recognized too: their StackTrace returns errors.StackTrace, which
+// no interface here can name, so any StackTrace method returning a slice
+// of uintptr-based program counters is accepted.
+type StackTracer interface {
+	StackTrace() []uintptr
+}
+
+// ErrKeyval is a keyval that stands for the pair "err", Err; see Err
+type ErrKeyval struct {
+	Err error
+}
+
+// Err returns a single keyval standing for the pair "err", err, so call
+// sites read
+//
+//	logger.Error("E001", "save failed", shrmpl.Err(err), "username", user)
+//
+// A nil err adds nothing.
+func Err(err error) ErrKeyval {
+	return ErrKeyval{Err: err}
+}
+
+// expandKeyvals replaces ErrKeyval entries with their "err" pair
+func expandKeyvals(keyvals []interface{}) []interface{} {
+	found := false
+	for _, kv := range keyvals {
+		if _, ok := kv.(ErrKeyval); ok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return keyvals
+	}
+	expanded := make([]interface{}, 0, len(keyvals)+1)
+	for _, kv := range keyvals {
+		if e, ok := kv.(ErrKeyval); ok {
+			if e.Err != nil {
+				expanded = append(expanded, "err", e.Err)
+			}
+			continue
+		}
+		expanded = append(expanded, kv)
+	}
+	return expanded
+}
+
+// addErrorFields stores err under key along with "<key>_type", the
+// concrete type, and "<key>_cause", the message at the end of the Unwrap
+// chain when err wraps another error. At DEBG level a trimmed stack is
+// added as "<key>_stack" if any error in the chain is a StackTracer.
+func addErrorFields(fields map[string]interface{}, key string, err error, level string) {
+	fields[key] = errorMessage(err)
+	fields[key+"_type"] = fmt.Sprintf("%T", err)
+
+	root := err
+	for depth := 0; depth < maxErrorDepth; depth++ {
+		next := unwrapOne(root)
+		if next == nil {
+			break
+		}
+		root = next
+	}
+	if root != err {
+		fields[key+"_cause"] = errorMessage(root)
+	}
+
+	if level != "DEBG" {
+		return
+	}
+	if stack := formatStack(errorStack(err)); stack != "" {
+		fields[key+"_stack"] = stack
+	}
+}
+
+// errorFields returns the fields of the error keyvals alone, or nil when
+// there are none. v1 messages carry no other keyvals but still get these.
+func errorFields(level string, keyvals []interface{}) map[string]interface{} {
+	var fields map[string]interface{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if err, ok := keyvals[i+1].(error); ok {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+			addErrorFields(fields, keyvalString(keyvals[i]), err, level)
+		}
+	}
+	return fields
+}
+
+// errorStack returns the stack of the first error in err's Unwrap chain
+// that records one; see StackTracer
+func errorStack(err error) []uintptr {
+	for depth := 0; err != nil && depth < maxErrorDepth; depth++ {
+		if tracer, ok := err.(StackTracer); ok {
+			return tracer.StackTrace()
+		}
+		if pcs, ok := reflectStackTrace(err); ok {
+			return pcs
+		}
+		err = unwrapOne(err)
+	}
+	return nil
+}
+
+// reflectStackTrace calls a StackTrace method returning a slice of a
+// uintptr type, such as pkg/errors' StackTrace of Frame
+func reflectStackTrace(err error) (pcs []uintptr, ok bool) {
+	defer func() {
+		if recover() != nil {
+			pcs, ok = nil, false
+		}
+	}()
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() {
+		return nil, false
+	}
+	typ := method.Type()
+	if typ.NumIn() != 0 || typ.NumOut() != 1 || typ.Out(0).Kind() != reflect.Slice ||
+		typ.Out(0).Elem().Kind() != reflect.Uintptr {
+		return nil, false
+	}
+	trace := method.Call(nil)[0]
+	pcs = make([]uintptr, trace.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(trace.Index(i).Uint())
+	}
+	return pcs, true
+}
+
+// unwrapOne returns the error wrapped by err, following the first error
+// of a multi-error
+func unwrapOne(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if inner != nil {
+				return inner
+			}
+		}
+	}
+	return nil
+}
+
+// errorMessage returns err.Error(), tolerating typed nil errors whose
+// Error method panics
+func errorMessage(err error) (msg string) {
+	defer func() {
+		if recover() != nil {
+			msg = "<nil>"
+		}
+	}()
+	return err.Error()
+}
+
+// formatStack renders up to maxStackFrames frames as "func file:line"
+// entries separated by "; "
+func formatStack(pcs []uintptr) string {
+	if len(pcs) == 0 {
+		return ""
+	}
+	frames := runtime.CallersFrames(pcs)
+	var parts []string
+	for len(parts) < maxStackFrames {
+		frame, more := frames.Next()
+		file := frame.File
+		if i := strings.LastIndexByte(file, '/'); i >= 0 {
+			file = file[i+1:]
+		}
+		parts = append(parts, fmt.Sprintf("%s %s:%d", frame.Function, file, frame.Line))
+		if !more {
+			break
+		}
+	}
+	return strings.Join(parts, "; ")
+}
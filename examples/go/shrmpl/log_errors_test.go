@@ -0,0 +1,138 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// pkgFrame and pkgStackTrace mirror github.com/pkg/errors' Frame and
+// StackTrace
+type pkgFrame uintptr
+type pkgStackTrace []pkgFrame
+
+// pkgError has the shape of a pkg/errors error with a stack
+type pkgError struct {
+	msg   string
+	stack []uintptr
+}
+
+func (e *pkgError) Error() string { return e.msg }
+
+func (e *pkgError) StackTrace() pkgStackTrace {
+	trace := make(pkgStackTrace, len(e.stack))
+	for i, pc := range e.stack {
+		trace[i] = pkgFrame(pc)
+	}
+	return trace
+}
+
+// newPkgError records the caller's stack like pkg/errors.New
+func newPkgError(msg string) *pkgError {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	return &pkgError{msg: msg, stack: pcs[:n]}
+}
+
+// tracedError implements StackTracer directly
+type tracedError struct{ stack []uintptr }
+
+func (e *tracedError) Error() string         { return "traced" }
+func (e *tracedError) StackTrace() []uintptr { return e.stack }
+
+// nilPanicError panics in Error when nil, like many pointer errors
+type nilPanicError struct{ msg string }
+
+func (e *nilPanicError) Error() string { return e.msg }
+
+func TestErrorFieldsUnwrapChain(t *testing.T) {
+	root := errors.New("disk full")
+	err := fmt.Errorf("save: %w", fmt.Errorf("write: %w", root))
+	fields := keyvalFields("ERRO", []interface{}{"err", err})
+
+	if fields["err"] != "save: write: disk full" {
+		t.Errorf("err = %q", fields["err"])
+	}
+	if fields["err_cause"] != "disk full" {
+		t.Errorf("err_cause = %q", fields["err_cause"])
+	}
+	if fields["err_type"] != "*fmt.wrapError" {
+		t.Errorf("err_type = %q", fields["err_type"])
+	}
+	if _, ok := fields["err_stack"]; ok {
+		t.Errorf("stack added above DEBG")
+	}
+
+	// Unwrapped errors have no cause
+	fields = keyvalFields("ERRO", []interface{}{"err", root})
+	if _, ok := fields["err_cause"]; ok {
+		t.Errorf("err_cause set for an unwrapped error: %v", fields)
+	}
+}
+
+func TestErrorFieldsDepthLimit(t *testing.T) {
+	err := errors.New("root")
+	for i := 1; i <= maxErrorDepth+5; i++ {
+		err = fmt.Errorf("level %d: %w", i, err)
+	}
+	fields := keyvalFields("ERRO", []interface{}{"err", err})
+
+	// The chain is followed maxErrorDepth steps and no further
+	cause, _ := fields["err_cause"].(string)
+	if cause == "root" || !strings.HasPrefix(cause, "level 5: ") {
+		t.Errorf("err_cause = %q, want the error %d levels down", cause, maxErrorDepth)
+	}
+}
+
+func TestErrorFieldsNil(t *testing.T) {
+	if got := expandKeyvals([]interface{}{Err(nil), "k", "v"}); len(got) != 2 {
+		t.Errorf("Err(nil) expanded to %v, want nothing", got)
+	}
+
+	var typedNil *nilPanicError
+	fields := keyvalFields("DEBG", []interface{}{"err", error(typedNil)})
+	if fields["err"] != "<nil>" || fields["err_type"] != "*shrmpl.nilPanicError" {
+		t.Errorf("typed nil error fields = %v", fields)
+	}
+
+	// A nil interface value is not an error and logs as a plain value
+	fields = keyvalFields("ERRO", []interface{}{"err", nil})
+	if fields["err"] != "<nil>" || len(fields) != 1 {
+		t.Errorf("nil error fields = %v", fields)
+	}
+}
+
+func TestErrorFieldsStack(t *testing.T) {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(1, pcs)
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"StackTracer", &tracedError{stack: pcs[:n]}},
+		{"pkg/errors shape", newPkgError("boom")},
+		{"wrapped pkg/errors shape", fmt.Errorf("context: %w", newPkgError("boom"))},
+	}
+	for _, tt := range tests {
+		fields := keyvalFields("DEBG", []interface{}{"err", tt.err})
+		stack, _ := fields["err_stack"].(string)
+		if !strings.Contains(stack, "TestErrorFieldsStack log_errors_test.go:") {
+			t.Errorf("%s: err_stack = %q", tt.name, stack)
+		}
+		if frames := strings.Count(stack, "; ") + 1; frames > maxStackFrames {
+			t.Errorf("%s: %d frames, want at most %d", tt.name, frames, maxStackFrames)
+		}
+	}
+}
+
+func TestErrorFieldsOnly(t *testing.T) {
+	if fields := errorFields("INFO", []interface{}{"user", "bob"}); fields != nil {
+		t.Errorf("fields without errors = %v, want nil", fields)
+	}
+	fields := errorFields("INFO", []interface{}{"user", "bob", "failure", errors.New("x")})
+	if len(fields) != 2 || fields["failure"] != "x" || fields["failure_type"] != "*errors.errorString" {
+		t.Errorf("fields = %v", fields)
+	}
+}
@@ -0,0 +1,119 @@
+package shrmpl
+
+import "fmt"
+
+// Full-queue policies for LoggerOptions.QueuePolicy and SetQueuePolicy
+const (
+	// QueueDropNewest drops the message being logged (the default)
+	QueueDropNewest = "drop-newest"
+	// QueueDropOldest drops the oldest queued message to make room, so the
+	// most recent messages survive an outage
+	QueueDropOldest = "drop-oldest"
+	// QueueBlock makes the logging goroutine wait for room, so nothing is
+	// lost but a slow log server slows the application down
+	QueueBlock = "block"
+)
+
+// SetQueuePolicy changes what happens to messages logged while the async
+// queue is full, e.g. switching from QueueBlock to QueueDropNewest while
+// the log server is overwhelmed and back once it recovers. It is safe to
+// call while other goroutines log and takes effect for the next message;
+// goroutines already waiting under QueueBlock keep waiting for room.
+// Loggers without an async queue only record the policy.
+func (l *Logger) SetQueuePolicy(policy string) error {
+	switch policy {
+	case QueueDropNewest, QueueDropOldest, QueueBlock:
+	default:
+		return fmt.Errorf("invalid queue policy %q", policy)
+	}
+	l.queuePolicy.Store(policy)
+	return nil
+}
+
+// QueuePolicy returns the current full-queue policy
+func (l *Logger) QueuePolicy() string {
+	return l.queuePolicy.Load().(string)
+}
+
+// enqueue queues rec for the writer according to the queue policy. l.mu
+// must be held; it is released before enqueue returns.
+func (l *Logger) enqueue(rec logRecord) {
+	switch l.QueuePolicy() {
+	case QueueBlock:
+		// The writer needs l.mu to send, so wait for room without it.
+		// Close waits for inflight before closing the queue.
+		l.queued++
+		l.inflight.Add(1)
+		l.mu.Unlock()
+		l.queue <- rec
+		l.inflight.Done()
+		return
+	case QueueDropOldest:
+		for {
+			select {
+			case l.queue <- rec:
+				l.queued++
+				l.mu.Unlock()
+				return
+			default:
+			}
+			select {
+			case <-l.queue:
+				// Counted as handled so Flush does not wait for it
+				l.dropped++
+				l.handled++
+				l.queueDrained.Broadcast()
+			default:
+			}
+		}
+	default:
+		select {
+		case l.queue <- rec:
+			l.queued++
+		default:
+			l.dropped++
+		}
+		l.mu.Unlock()
+	}
+}
+
+// LoggerStats is a snapshot of a Logger's delivery counters
+type LoggerStats struct {
+	// Sent counts messages written to shrmpl-log, replays included
+	Sent int64
+	// QueuePolicy is the current full-queue policy
+	QueuePolicy string
+	// QueueLength and QueueCapacity describe the async queue, summed over
+	// the shards of a sharded logger; both are zero for synchronous loggers
+	QueueLength   int
+	QueueCapacity int
+	// DroppedQueueFull counts messages dropped by the queue policy
+	DroppedQueueFull int
+	// DroppedReplay counts messages lost to replay buffer overflow or
+	// failed replays
+	DroppedReplay int
+	// DroppedDisconnected counts messages that could not be sent while
+	// disconnected, including those still held for replay
+	DroppedDisconnected int
+}
+
+// Stats returns the logger's current delivery counters
+func (l *Logger) Stats() LoggerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := LoggerStats{
+		Sent:                l.sent.Load(),
+		QueuePolicy:         l.QueuePolicy(),
+		QueueLength:         len(l.queue),
+		QueueCapacity:       cap(l.queue),
+		DroppedQueueFull:    l.dropped,
+		DroppedReplay:       l.replayDropped,
+		DroppedDisconnected: l.unsent + len(l.replay),
+	}
+	for _, shard := range l.shards {
+		stats.QueueLength += len(shard.queue)
+		stats.QueueCapacity += cap(shard.queue)
+		stats.DroppedQueueFull += int(shard.dropped.Load())
+	}
+	return stats
+}
@@ -0,0 +1,258 @@
+package shrmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces the values of redacted keyvals
+const redactedValue = "[REDACTED]"
+
+// maxAdminBody bounds the request body LoggerAdminHandler reads
+const maxAdminBody = 64 * 1024
+
+// LoggerRuntimeConfig is the part of a Logger's configuration that can be
+// changed while it runs; see Logger.ApplyConfig
+type LoggerRuntimeConfig struct {
+	// Level is the minimum level logged (DEBG, INFO, WARN or ERRO; long
+	// forms such as DEBUG are accepted)
+	Level string `json:"level"`
+	// Sampling keeps only this fraction (0 to 1) of the messages at each
+	// listed level; levels not listed are not sampled
+	Sampling map[string]float64 `json:"sampling"`
+	// Console echoes messages to stderr
+	Console bool `json:"console"`
+	// ConsoleFormat is ConsoleFull, ConsoleCompact or ConsoleJSON
+	ConsoleFormat string `json:"console_format"`
+	// RedactKeys lists keyval keys whose values are replaced with
+	// "[REDACTED]". Error fields derived from a key (key_type, key_cause,
+	// key_stack) are redacted with it, and redacting "username" hides the
+	// message prefix.
+	RedactKeys []string `json:"redact_keys"`
+}
+
+// loggerRuntime is a validated LoggerRuntimeConfig in the form the
+// logging path reads. It is never modified once published, so loggers read
+// it without locking.
+type loggerRuntime struct {
+	config   LoggerRuntimeConfig
+	minLevel int
+	sampling map[string]float64
+	redact   map[string]bool
+}
+
+// newLoggerRuntime validates cfg and normalizes level names and the
+// console format
+func newLoggerRuntime(cfg LoggerRuntimeConfig) (*loggerRuntime, error) {
+	level, ok := parseLogLevel(cfg.Level)
+	if !ok {
+		return nil, fmt.Errorf("invalid level %q", cfg.Level)
+	}
+	rt := &loggerRuntime{
+		config: LoggerRuntimeConfig{
+			Level:         level,
+			Sampling:      make(map[string]float64, len(cfg.Sampling)),
+			Console:       cfg.Console,
+			ConsoleFormat: cfg.ConsoleFormat,
+			RedactKeys:    []string{},
+		},
+		minLevel: logLevels[level],
+		sampling: make(map[string]float64, len(cfg.Sampling)),
+		redact:   make(map[string]bool, len(cfg.RedactKeys)),
+	}
+
+	for name, rate := range cfg.Sampling {
+		level, ok := parseLogLevel(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid sampling level %q", name)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sampling rate for %s must be between 0 and 1, got %v", level, rate)
+		}
+		rt.config.Sampling[level] = rate
+		if rate < 1 {
+			rt.sampling[level] = rate
+		}
+	}
+
+	switch cfg.ConsoleFormat {
+	case "":
+		rt.config.ConsoleFormat = ConsoleFull
+	case ConsoleFull, ConsoleCompact, ConsoleJSON:
+	default:
+		return nil, fmt.Errorf("invalid console format %q", cfg.ConsoleFormat)
+	}
+
+	for _, key := range cfg.RedactKeys {
+		if key == "" {
+			return nil, fmt.Errorf("redact keys must not be empty")
+		}
+		if !rt.redact[key] {
+			rt.redact[key] = true
+			rt.config.RedactKeys = append(rt.config.RedactKeys, key)
+		}
+	}
+	sort.Strings(rt.config.RedactKeys)
+	return rt, nil
+}
+
+// keep reports whether a message at level passes level filtering and
+// sampling
+func (rt *loggerRuntime) keep(level string) bool {
+	if logLevels[level] < rt.minLevel {
+		return false
+	}
+	rate, sampled := rt.sampling[level]
+	return !sampled || rand.Float64() < rate
+}
+
+// redacted reports whether the field key is hidden: it is a redact key or
+// an error field derived from one
+func (rt *loggerRuntime) redacted(key string) bool {
+	if rt.redact[key] {
+		return true
+	}
+	for _, suffix := range []string{"_type", "_cause", "_stack"} {
+		if base, ok := strings.CutSuffix(key, suffix); ok && rt.redact[base] {
+			return true
+		}
+	}
+	return false
+}
+
+// redactFields replaces the values of redacted fields in place
+func (rt *loggerRuntime) redactFields(fields map[string]interface{}) {
+	if len(rt.redact) == 0 {
+		return
+	}
+	for key := range fields {
+		if rt.redacted(key) {
+			fields[key] = redactedValue
+		}
+	}
+}
+
+// Config returns the logger's current runtime configuration
+func (l *Logger) Config() LoggerRuntimeConfig {
+	cfg := l.runtime.Load().config
+	sampling := make(map[string]float64, len(cfg.Sampling))
+	for level, rate := range cfg.Sampling {
+		sampling[level] = rate
+	}
+	cfg.Sampling = sampling
+	cfg.RedactKeys = append([]string{}, cfg.RedactKeys...)
+	return cfg
+}
+
+// ApplyConfig replaces the logger's runtime configuration, e.g. to lower
+// the level to DEBG during an incident without a restart. The whole
+// configuration is validated first and an invalid one changes nothing;
+// a valid one takes effect atomically for every message logged after
+// ApplyConfig returns. It is safe to call while other goroutines log.
+func (l *Logger) ApplyConfig(cfg LoggerRuntimeConfig) error {
+	l.runtimeMu.Lock()
+	defer l.runtimeMu.Unlock()
+	return l.applyConfigLocked(cfg)
+}
+
+// applyConfigLocked validates and publishes cfg; runtimeMu must be held
+func (l *Logger) applyConfigLocked(cfg LoggerRuntimeConfig) error {
+	rt, err := newLoggerRuntime(cfg)
+	if err != nil {
+		return err
+	}
+	l.runtime.Store(rt)
+	return nil
+}
+
+// loggerConfigPatch is a POST body for LoggerAdminHandler. Fields left out
+// keep their current value.
+type loggerConfigPatch struct {
+	Level         *string             `json:"level"`
+	Sampling      *map[string]float64 `json:"sampling"`
+	Console       *bool               `json:"console"`
+	ConsoleFormat *string             `json:"console_format"`
+	RedactKeys    *[]string           `json:"redact_keys"`
+}
+
+// patchConfig applies p on top of the current configuration
+func (l *Logger) patchConfig(p loggerConfigPatch) (LoggerRuntimeConfig, error) {
+	l.runtimeMu.Lock()
+	defer l.runtimeMu.Unlock()
+
+	cfg := l.Config()
+	if p.Level != nil {
+		cfg.Level = *p.Level
+	}
+	if p.Sampling != nil {
+		cfg.Sampling = *p.Sampling
+	}
+	if p.Console != nil {
+		cfg.Console = *p.Console
+	}
+	if p.ConsoleFormat != nil {
+		cfg.ConsoleFormat = *p.ConsoleFormat
+	}
+	if p.RedactKeys != nil {
+		cfg.RedactKeys = *p.RedactKeys
+	}
+	if err := l.applyConfigLocked(cfg); err != nil {
+		return LoggerRuntimeConfig{}, err
+	}
+	return l.Config(), nil
+}
+
+// LoggerAdminHandler returns an http.Handler for changing l at runtime,
+// meant to be mounted on a service's internal admin mux:
+//
+//	mux.Handle("/admin/logger", shrmpl.LoggerAdminHandler(logger))
+//
+// GET returns the current LoggerRuntimeConfig as JSON. POST takes a JSON
+// object with any of its fields, applies it over the current configuration
+// and returns the result; an invalid body or value is rejected with 400
+// and changes nothing:
+//
+//	curl -X POST -d '{"level":"DEBG"}' localhost:8081/admin/logger
+//
+// The handler does no authentication; only expose it where the admin mux
+// is protected.
+func LoggerAdminHandler(l *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeLoggerConfig(w, l.Config())
+		case http.MethodPost:
+			var patch loggerConfigPatch
+			dec := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&patch); err != nil {
+				http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if dec.More() {
+				http.Error(w, "invalid config: trailing data after JSON object", http.StatusBadRequest)
+				return
+			}
+			cfg, err := l.patchConfig(patch)
+			if err != nil {
+				http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeLoggerConfig(w, cfg)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeLoggerConfig writes cfg as the JSON response
+func writeLoggerConfig(w http.ResponseWriter, cfg LoggerRuntimeConfig) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(cfg)
+}
@@ -0,0 +1,49 @@
+package shrmpl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+// Strategies for service names longer than the 32-byte wire field
+const (
+	// ServiceNameHash keeps a prefix of the name and replaces the rest
+	// with "-" and a 6-character hash of the full name (the default), so
+	// long names sharing a prefix stay distinct
+	ServiceNameHash = "hash"
+	// ServiceNameTruncate cuts the name at the field width
+	ServiceNameTruncate = "truncate"
+)
+
+// maxServiceName is the width of the host field in a log frame
+const maxServiceName = 32
+
+// serviceHashLen is the number of hash characters kept by ServiceNameHash
+const serviceHashLen = 6
+
+// wireServiceName returns the name sent for service. Names that fit are
+// sent unchanged; the result only depends on service and strategy, so it
+// is stable across restarts.
+func wireServiceName(service, strategy string) string {
+	if len(service) <= maxServiceName {
+		return service
+	}
+	if strategy == ServiceNameTruncate {
+		return truncateRunes(service, maxServiceName)
+	}
+	sum := sha256.Sum256([]byte(service))
+	suffix := "-" + hex.EncodeToString(sum[:])[:serviceHashLen]
+	return truncateRunes(service, maxServiceName-len(suffix)) + suffix
+}
+
+// truncateRunes cuts s to at most n bytes without splitting a rune
+func truncateRunes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,107 @@
+package shrmpl_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// serviceOfLen returns a service name of n bytes
+func serviceOfLen(n int) string {
+	return strings.Repeat("s", n-1) + "x"
+}
+
+// hashedService is the ServiceNameHash form of a name over 32 bytes
+func hashedService(service string) string {
+	sum := sha256.Sum256([]byte(service))
+	return service[:25] + "-" + hex.EncodeToString(sum[:])[:6]
+}
+
+func TestServiceNameBoundaries(t *testing.T) {
+	tests := []struct {
+		size     int
+		strategy string
+		want     func(service string) string
+	}{
+		{31, shrmpl.ServiceNameHash, func(s string) string { return s }},
+		{32, shrmpl.ServiceNameHash, func(s string) string { return s }},
+		{33, shrmpl.ServiceNameHash, hashedService},
+		{64, shrmpl.ServiceNameHash, hashedService},
+		{31, shrmpl.ServiceNameTruncate, func(s string) string { return s }},
+		{32, shrmpl.ServiceNameTruncate, func(s string) string { return s }},
+		{33, shrmpl.ServiceNameTruncate, func(s string) string { return s[:32] }},
+		{64, shrmpl.ServiceNameTruncate, func(s string) string { return s[:32] }},
+	}
+	for _, tt := range tests {
+		service := serviceOfLen(tt.size)
+		t.Run(fmt.Sprintf("%s/%d", tt.strategy, tt.size), func(t *testing.T) {
+			srv := shrmpltest.NewLogServer()
+			defer srv.Close()
+			l := shrmpl.NewLoggerWithOptions(service, shrmpl.LoggerOptions{
+				Addr:                srv.Addr,
+				Console:             &quiet,
+				NoShutdownRecord:    true,
+				ServiceNameStrategy: tt.strategy,
+			})
+			defer l.Close()
+
+			l.Info("T001", "hello")
+			want := tt.want(service)
+			// A shortened name is announced with its full form first
+			n := 1
+			if want != service {
+				n = 2
+			}
+			frames, err := srv.WaitFrames(n, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, frame := range frames {
+				if frame.Host != want {
+					t.Errorf("host = %q (%d bytes), want %q", frame.Host, len(frame.Host), want)
+				}
+			}
+			if n == 2 && !strings.Contains(frames[0].Message, service) {
+				t.Errorf("first frame %q does not announce the full name", frames[0].Raw)
+			}
+		})
+	}
+}
+
+func TestServiceNameHashKeepsNamesDistinct(t *testing.T) {
+	// Two 33-byte names sharing their first 32 bytes
+	a, b := serviceOfLen(32)+"a", serviceOfLen(32)+"b"
+	if hashedService(a) == hashedService(b) {
+		t.Fatal("hashed names collide")
+	}
+
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	for _, service := range []string{a, b} {
+		l := shrmpl.NewLoggerWithOptions(service, shrmpl.LoggerOptions{
+			Addr: srv.Addr, Console: &quiet, NoShutdownRecord: true,
+		})
+		l.Info("T001", "hello")
+		l.Close()
+	}
+	// Each logger announces its shortened name before logging
+	frames, err := srv.WaitFrames(4, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts []string
+	for _, frame := range frames {
+		if frame.Code == "T001" {
+			hosts = append(hosts, frame.Host)
+		}
+	}
+	if len(hosts) != 2 || hosts[0] == hosts[1] {
+		t.Errorf("services sent as %q, want two distinct names", hosts)
+	}
+}
@@ -0,0 +1,203 @@
+package shrmpl
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// logShard is one of the independent queues of a sharded async logger
+// (LoggerOptions.Shards). Logging goroutines only take the mutex of the
+// shard they log to, so they contend with each other N times less than on
+// Logger.mu. BenchmarkLoggerShards compares sharded and single queues.
+type logShard struct {
+	mu       sync.Mutex
+	queue    chan logRecord
+	closed   bool
+	inflight sync.WaitGroup
+	queued   atomic.Uint64
+	handled  atomic.Uint64
+	dropped  atomic.Int64
+}
+
+// LogHandle logs through one shard of a sharded logger, so everything
+// logged through it reaches shrmpl-log in order. Take one per goroutine,
+// e.g. per worker or request, with Logger.Handle. On loggers without
+// shards it logs exactly like the Logger.
+type LogHandle struct {
+	l     *Logger
+	shard *logShard
+}
+
+// Handle returns a LogHandle pinned to the next shard in turn
+func (l *Logger) Handle() *LogHandle {
+	return &LogHandle{l: l, shard: l.nextLogShard()}
+}
+
+// Debug logs at debug level
+func (h *LogHandle) Debug(code, message string, keyvals ...interface{}) {
+	h.l.logOn(h.shard, "DEBG", code, message, 2, keyvals...)
+}
+
+// Info logs at info level
+func (h *LogHandle) Info(code, message string, keyvals ...interface{}) {
+	h.l.logOn(h.shard, "INFO", code, message, 2, keyvals...)
+}
+
+// Warn logs at warn level
+func (h *LogHandle) Warn(code, message string, keyvals ...interface{}) {
+	h.l.logOn(h.shard, "WARN", code, message, 2, keyvals...)
+}
+
+// Error logs at error level
+func (h *LogHandle) Error(code, message string, keyvals ...interface{}) {
+	h.l.logOn(h.shard, "ERRO", code, message, 2, keyvals...)
+}
+
+// newLogShards creates n shard queues of size records each
+func newLogShards(n, size int) []*logShard {
+	shards := make([]*logShard, n)
+	for i := range shards {
+		shards[i] = &logShard{queue: make(chan logRecord, size)}
+	}
+	return shards
+}
+
+// nextLogShard picks a shard round-robin with a counter rather than by
+// goroutine, which Go does not expose. It returns nil for loggers
+// without shards.
+func (l *Logger) nextLogShard() *logShard {
+	if len(l.shards) == 0 {
+		return nil
+	}
+	return l.shards[l.nextShard.Add(1)%uint64(len(l.shards))]
+}
+
+// enqueueSharded queues the parts of one message on shard according to
+// the queue policy and wakes the writer. All parts go to the same shard so
+// they stay in order.
+func (l *Logger) enqueueSharded(shard *logShard, parts []logRecord) {
+	for _, part := range parts {
+		shard.mu.Lock()
+		if shard.closed {
+			// Closed loggers only echo to the console
+			shard.mu.Unlock()
+			return
+		}
+		l.enqueueShard(shard, part)
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// enqueueShard is enqueue for one shard. shard.mu must be held; it is
+// released before enqueueShard returns.
+func (l *Logger) enqueueShard(shard *logShard, rec logRecord) {
+	switch l.QueuePolicy() {
+	case QueueBlock:
+		// Close waits for inflight before closing the queue
+		shard.queued.Add(1)
+		shard.inflight.Add(1)
+		shard.mu.Unlock()
+		shard.queue <- rec
+		shard.inflight.Done()
+		return
+	case QueueDropOldest:
+		for {
+			select {
+			case shard.queue <- rec:
+				shard.queued.Add(1)
+				shard.mu.Unlock()
+				return
+			default:
+			}
+			select {
+			case <-shard.queue:
+				// Counted as handled so Flush does not wait for it
+				shard.dropped.Add(1)
+				shard.handled.Add(1)
+				l.mu.Lock()
+				l.queueDrained.Broadcast()
+				l.mu.Unlock()
+			default:
+			}
+		}
+	default:
+		select {
+		case shard.queue <- rec:
+			shard.queued.Add(1)
+		default:
+			shard.dropped.Add(1)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// runShardedWriter sends records from the shards, taking one from each in
+// turn, until every shard queue is closed
+func (l *Logger) runShardedWriter() {
+	defer close(l.writerDone)
+	for {
+		open, sent := 0, 0
+		for _, shard := range l.shards {
+			select {
+			case rec, ok := <-shard.queue:
+				if !ok {
+					continue
+				}
+				open++
+				sent++
+				l.send(rec)
+				shard.handled.Add(1)
+				l.mu.Lock()
+				l.queueDrained.Broadcast()
+				l.mu.Unlock()
+			default:
+				open++
+			}
+		}
+		if open == 0 {
+			return
+		}
+		if sent == 0 {
+			<-l.wake
+		}
+	}
+}
+
+// flushShards waits until every record queued on the shards so far has
+// been handled
+func (l *Logger) flushShards() {
+	targets := make([]uint64, len(l.shards))
+	for i, shard := range l.shards {
+		targets[i] = shard.queued.Load()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, shard := range l.shards {
+		for shard.handled.Load() < targets[i] {
+			l.queueDrained.Wait()
+		}
+	}
+}
+
+// closeShards stops the shards accepting records, waits for blocked
+// enqueues and closes the queues so the writer drains them and exits
+func (l *Logger) closeShards() {
+	for _, shard := range l.shards {
+		shard.mu.Lock()
+		shard.closed = true
+		shard.mu.Unlock()
+	}
+	for _, shard := range l.shards {
+		shard.inflight.Wait()
+		close(shard.queue)
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	<-l.writerDone
+}
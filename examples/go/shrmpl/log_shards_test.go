@@ -0,0 +1,68 @@
+package shrmpl_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"shrmpl"
+)
+
+// discardLogServer accepts log connections and drains them without
+// parsing, so a benchmark measures the logger rather than the server
+func discardLogServer(b *testing.B) string {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// BenchmarkLoggerShards logs from parallel goroutines, each through its
+// own LogHandle, to a single async queue and to sharded queues
+func BenchmarkLoggerShards(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		name := "single"
+		if shards > 1 {
+			name = fmt.Sprintf("shards=%d", shards)
+		}
+		b.Run(name, func(b *testing.B) {
+			async := true
+			l := shrmpl.NewLoggerWithOptions("bench", shrmpl.LoggerOptions{
+				Addr:             discardLogServer(b),
+				Console:          &quiet,
+				NoShutdownRecord: true,
+				Async:            &async,
+				Shards:           shards,
+				QueueSize:        1024,
+				QueuePolicy:      shrmpl.QueueBlock,
+			})
+			defer l.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				h := l.Handle()
+				for pb.Next() {
+					h.Info("T001", "request handled", "status", 200)
+				}
+			})
+			l.Flush()
+		})
+	}
+}
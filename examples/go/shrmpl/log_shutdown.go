@@ -0,0 +1,58 @@
+package shrmpl
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ShutdownCode is the reserved code of the record Close sends last, so a
+// clean exit can be told apart from a crash on the server side. The
+// record carries the logger's uptime, the number of messages sent and the
+// number dropped by reason.
+const ShutdownCode = "LBYE"
+
+// shutdownRecordTimeout bounds the shutdown record's write so a dead log
+// server cannot hold up process exit
+const shutdownRecordTimeout = 500 * time.Millisecond
+
+// shutdownFields returns the counters reported by the shutdown record:
+// messages dropped because the async queue was full, because the replay
+// buffer overflowed or a replayed record failed, and because they could
+// not be sent (or were still waiting for replay) while disconnected
+func (l *Logger) shutdownFields() map[string]interface{} {
+	stats := l.Stats()
+	return map[string]interface{}{
+		"uptime":               time.Since(l.started).Round(time.Millisecond).String(),
+		"sent":                 stats.Sent,
+		"dropped_queue_full":   stats.DroppedQueueFull,
+		"dropped_replay":       stats.DroppedReplay,
+		"dropped_disconnected": stats.DroppedDisconnected,
+	}
+}
+
+// sendShutdownRecord sends the ShutdownCode record on the current
+// connection. It is best effort: nothing is sent when the logger is not
+// connected, and a slow server is given shutdownRecordTimeout. The record
+// is sent regardless of the configured minimum level.
+func (l *Logger) sendShutdownRecord() {
+	if l.hostPort == "" {
+		return
+	}
+	fields := l.shutdownFields()
+
+	l.mu.Lock()
+	client := l.shrmplLogClient
+	l.mu.Unlock()
+	if client == nil || client.conn == nil {
+		return
+	}
+
+	err := client.logEntryWithin(Entry{Level: "INFO", Host: l.wireService,
+		Code: ShutdownCode, Message: "logger shutting down", Fields: fields},
+		shutdownRecordTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: Failed to send shutdown record to shrmpl-log: %s\n",
+			err.Error())
+	}
+}
@@ -0,0 +1,61 @@
+package shrmpl_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestShutdownRecordIsLastFrame(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			srv := shrmpltest.NewLogServer()
+			defer srv.Close()
+			srv.AcceptV2(true)
+
+			// A small blocking queue keeps the async writer busy up to
+			// the Close without dropping anything
+			async := async
+			l := shrmpl.NewLoggerWithOptions("test", shrmpl.LoggerOptions{
+				Addr:        srv.Addr,
+				Console:     &quiet,
+				Async:       &async,
+				QueueSize:   4,
+				QueuePolicy: shrmpl.QueueBlock,
+				Protocol:    shrmpl.ProtocolV2,
+			})
+			const n = 100
+			for i := 0; i < n; i++ {
+				l.Info("T001", fmt.Sprintf("message %03d", i))
+			}
+			l.Close()
+
+			frames, err := srv.WaitFrames(n+1, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Nothing may follow the shutdown record
+			time.Sleep(50 * time.Millisecond)
+			frames = srv.Frames()
+			if len(frames) != n+1 {
+				t.Fatalf("got %d frames, want %d messages and the shutdown record", len(frames), n)
+			}
+			last := frames[len(frames)-1]
+			if last.Code != shrmpl.ShutdownCode {
+				t.Fatalf("last frame = %q, want the %s record", last.Raw, shrmpl.ShutdownCode)
+			}
+			if sent := last.Fields["sent"]; sent != float64(n) {
+				t.Errorf("shutdown record reports sent = %v, want %d", sent, n)
+			}
+			for i, frame := range frames[:n] {
+				if want := fmt.Sprintf("message %03d", i); !strings.Contains(frame.Message, want) {
+					t.Fatalf("frame %d = %q, want %q", i, frame.Message, want)
+				}
+			}
+		})
+	}
+}
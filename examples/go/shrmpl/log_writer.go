@@ -0,0 +1,146 @@
+package shrmpl
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// stdlibLogPrefix matches the date and time the standard log package adds
+// with its default flags, optionally with microseconds:
+// "2009/01/23 01:23:23 " or "2009/01/23 01:23:23.123123 "
+var stdlibLogPrefix = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} )?\d{2}:\d{2}:\d{2}(\.\d{6})? `)
+
+// maxLogWriterPartial bounds the bytes buffered while waiting for a
+// newline; a longer partial line is forwarded as is
+const maxLogWriterPartial = 64 * 1024
+
+// logWriterCallerSkip makes the reported call site the caller of Write;
+// see writerCallerSkip
+const logWriterCallerSkip = 4
+
+// LogWriterOptions controls a writer created by NewLogWriterWithOptions
+type LogWriterOptions struct {
+	// StripPrefix is removed from the start of every line; nil uses the
+	// date and time prefix of the standard log package
+	StripPrefix *regexp.Regexp
+	// KeepPrefix forwards lines unchanged
+	KeepPrefix bool
+}
+
+// logWriter forwards written lines to a logger; see NewLogWriter
+type logWriter struct {
+	logger  ThisAppLoggerInterface
+	level   string
+	code    string
+	strip   *regexp.Regexp
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewLogWriter returns an io.Writer that sends every line written to it
+// to l at level with code, so output of code using the standard log
+// package reaches shrmpl-log:
+//
+//	log.SetOutput(shrmpl.NewLogWriter(logger, "WARN", "LEGC"))
+//
+// The date and time the log package adds are stripped since shrmpl-log
+// timestamps messages itself. A write may hold several lines; a trailing
+// partial line is kept until a later write completes it. Empty lines are
+// dropped. The reported call site is the code that called log.Printf (or
+// fmt.Fprint, or Write). An invalid level falls back to INFO.
+func NewLogWriter(l ThisAppLoggerInterface, level, code string) io.Writer {
+	return NewLogWriterWithOptions(l, level, code, LogWriterOptions{})
+}
+
+// NewLogWriterWithOptions is NewLogWriter with a configurable prefix
+func NewLogWriterWithOptions(l ThisAppLoggerInterface, level, code string,
+	opts LogWriterOptions) io.Writer {
+	w := &logWriter{logger: l, level: "INFO", code: code, strip: opts.StripPrefix}
+	if parsed, ok := parseLogLevel(level); ok {
+		w.level = parsed
+	}
+	if w.strip == nil {
+		w.strip = stdlibLogPrefix
+	}
+	if opts.KeepPrefix {
+		w.strip = nil
+	}
+	return w
+}
+
+// Write forwards each complete line in p and buffers the rest. It never
+// fails, so a logging outage does not break the writing code.
+func (w *logWriter) Write(p []byte) (int, error) {
+	skip := writerCallerSkip()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buf := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.forward(buf[:i], skip)
+		buf = buf[i+1:]
+	}
+	if len(buf) > maxLogWriterPartial {
+		w.forward(buf, skip)
+		buf = nil
+	}
+	// Copy the partial line so a large write's buffer is not kept
+	w.partial = nil
+	if len(buf) > 0 {
+		w.partial = append([]byte(nil), buf...)
+	}
+	return len(p), nil
+}
+
+// writerCallerSkip returns the caller skip that reports the code calling
+// Write, looking through the standard log and fmt packages so that
+// log.Printf reports its own caller. It must be called from Write.
+func writerCallerSkip() int {
+	var pcs [8]uintptr
+	// Skip runtime.Callers, writerCallerSkip and Write
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	skip := logWriterCallerSkip
+	for {
+		frame, more := frames.Next()
+		if !more || !(strings.HasPrefix(frame.Function, "log.") ||
+			strings.HasPrefix(frame.Function, "fmt.")) {
+			return skip
+		}
+		skip++
+	}
+}
+
+// forward sends one line without its prefix and line ending, reporting
+// the call site skip frames up
+func (w *logWriter) forward(line []byte, skip int) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if w.strip != nil {
+		if loc := w.strip.FindIndex(line); loc != nil && loc[0] == 0 {
+			line = line[loc[1]:]
+		}
+	}
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+
+	message := string(line)
+	switch w.level {
+	case "DEBG":
+		w.logger.DebugWithCallerSkip(w.code, message, skip)
+	case "WARN":
+		w.logger.WarnWithCallerSkip(w.code, message, skip)
+	case "ERRO":
+		w.logger.ErrorWithCallerSkip(w.code, message, skip)
+	default:
+		w.logger.InfoWithCallerSkip(w.code, message, skip)
+	}
+}
@@ -0,0 +1,127 @@
+package shrmpl_test
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// recordedLog is one message received by recordingLogger
+type recordedLog struct {
+	level, code, message string
+}
+
+// recordingLogger records messages instead of sending them
+type recordingLogger struct {
+	shrmpl.ThisAppLoggerInterface
+	mu   sync.Mutex
+	logs []recordedLog
+}
+
+func (r *recordingLogger) record(level, code, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, recordedLog{level, code, message})
+}
+
+func (r *recordingLogger) DebugWithCallerSkip(code, message string, skip int, keyvals ...interface{}) {
+	r.record("DEBG", code, message)
+}
+
+func (r *recordingLogger) InfoWithCallerSkip(code, message string, skip int, keyvals ...interface{}) {
+	r.record("INFO", code, message)
+}
+
+func (r *recordingLogger) WarnWithCallerSkip(code, message string, skip int, keyvals ...interface{}) {
+	r.record("WARN", code, message)
+}
+
+func (r *recordingLogger) ErrorWithCallerSkip(code, message string, skip int, keyvals ...interface{}) {
+	r.record("ERRO", code, message)
+}
+
+func (r *recordingLogger) messages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var messages []string
+	for _, l := range r.logs {
+		messages = append(messages, l.message)
+	}
+	return messages
+}
+
+func TestLogWriterWithSetOutput(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr})
+
+	saved, flags := log.Writer(), log.Flags()
+	defer func() {
+		log.SetOutput(saved)
+		log.SetFlags(flags)
+	}()
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	log.SetOutput(shrmpl.NewLogWriter(l, "WARN", "LEGC"))
+	log.Printf("legacy %d", 42)
+
+	frames, err := srv.WaitFrames(1, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := frames[0]
+	if frame.Level != "WARN" || frame.Code != "LEGC" {
+		t.Errorf("level, code = %s, %s; want WARN, LEGC", frame.Level, frame.Code)
+	}
+	// The date is stripped and the call site is log.Printf's caller
+	if !strings.HasPrefix(frame.Message, "[unknown] legacy 42 (log_writer_test.go:") {
+		t.Errorf("message = %q", frame.Message)
+	}
+}
+
+func TestLogWriterMultiLineWrites(t *testing.T) {
+	r := &recordingLogger{}
+	w := shrmpl.NewLogWriter(r, "ERROR", "MULT")
+
+	fmt.Fprint(w, "first\nsecond\r\n\npart")
+	if got := r.messages(); len(got) != 2 {
+		t.Fatalf("after first write got %q, want the two complete lines", got)
+	}
+	fmt.Fprint(w, "ial\nlast")
+	fmt.Fprint(w, "\n")
+
+	want := []string{"first", "second", "partial", "last"}
+	got := r.messages()
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+	for _, l := range r.logs {
+		if l.level != "ERRO" || l.code != "MULT" {
+			t.Errorf("level, code = %s, %s; want ERRO, MULT", l.level, l.code)
+		}
+	}
+}
+
+func TestLogWriterStripPrefix(t *testing.T) {
+	r := &recordingLogger{}
+	stdlib := log.New(shrmpl.NewLogWriter(r, "INFO", "STDL"), "", log.LstdFlags)
+	stdlib.Print("one\ntwo")
+
+	// Only the first line of a multi-line Print carries the prefix
+	want := []string{"one", "two"}
+	if got := r.messages(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	r = &recordingLogger{}
+	w := shrmpl.NewLogWriterWithOptions(r, "INFO", "STDL", shrmpl.LogWriterOptions{KeepPrefix: true})
+	fmt.Fprint(w, "2009/01/23 01:23:23 kept\n")
+	if got := r.messages(); len(got) != 1 || got[0] != "2009/01/23 01:23:23 kept" {
+		t.Errorf("messages = %q, want the line unchanged", got)
+	}
+}
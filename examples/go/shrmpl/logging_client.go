@@ -1,6 +1,8 @@
 package shrmpl
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -8,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,79 +31,559 @@ type ThisAppLoggerInterface interface {
 type Logger struct {
 	shrmplLogClient *ShrmplLogClient
 	service         string
+	wireService     string
 	hostPort        string
+	runtime         atomic.Pointer[loggerRuntime]
+	runtimeMu       sync.Mutex
+	consoleMaxLen   int
+	consoleColor    bool
+	protocol        string
+	queue           chan logRecord
+	shards          []*logShard
+	nextShard       atomic.Uint64
+	wake            chan struct{}
+	queuePolicy     atomic.Value
+	queued          uint64
+	handled         uint64
+	queueDrained    *sync.Cond
+	writerDone      chan struct{}
+	dropped         int
+	replay          []logRecord
+	replayCap       int
+	replayDropped   int
+	unsent          int
+	sent            atomic.Int64
+	started         time.Time
+	shutdownRecord  bool
+	writeTimeout    time.Duration
 	mu              sync.Mutex
+	inflight        sync.WaitGroup
+	closed          bool
+	// sendMu is held for a whole send, so replayed records always reach
+	// the server before newer ones and frames never interleave
+	sendMu sync.Mutex
+}
+
+// logRecord is a message waiting to be sent by the async writer
+type logRecord struct {
+	level   string
+	code    string
+	message string
+	fields  map[string]interface{}
+}
+
+// LoggerOptions configures a Logger. Nil pointer fields mean "not set" so
+// that NewLoggerFromEnvWithOptions can tell explicit values from defaults.
+type LoggerOptions struct {
+	// Addr is the shrmpl-log host:port; empty means stderr only
+	Addr string
+	// Level is the minimum level sent (DEBG, INFO, WARN or ERRO)
+	Level string
+	// Console echoes every message to stderr
+	Console *bool
+	// Async queues messages and sends them from a background goroutine
+	Async *bool
+	// QueueSize bounds the async queue, or each shard's queue with Shards
+	QueueSize int
+	// Shards splits the async queue into this many independent queues to
+	// reduce lock contention when many goroutines log at high volume. Each
+	// message goes to one shard, round-robin or pinned with Logger.Handle,
+	// and the writer takes from the shards in turn. Order is kept within a
+	// shard but not across shards. Zero or one keeps the single queue;
+	// ignored unless Async is set.
+	Shards int
+	// QueuePolicy decides what happens to a message when the async queue
+	// is full: QueueDropNewest (the default), QueueDropOldest or
+	// QueueBlock. See SetQueuePolicy.
+	QueuePolicy string
+	// ConsoleFormat selects ConsoleFull, ConsoleCompact or ConsoleJSON
+	ConsoleFormat string
+	// ConsoleMaxLen is the compact format truncation length
+	ConsoleMaxLen int
+	// ConsoleColor forces colored levels on or off; by default color is
+	// used when stderr is a terminal
+	ConsoleColor *bool
+	// Lazy defers connecting to shrmpl-log until the first message is
+	// sent, avoiding the dial and any connection noise at construction.
+	// Connection problems then surface on first use instead.
+	Lazy bool
+	// ReplayBuffer holds up to this many messages while disconnected and
+	// replays them in order on reconnect; zero disables replay
+	ReplayBuffer int
+	// Protocol selects the wire protocol: ProtocolV1 (the default),
+	// ProtocolV2 or ProtocolAuto. With v2 keyvals are sent as structured
+	// fields; with auto they are flattened into the message when the
+	// server does not accept v2.
+	Protocol string
+	// ServiceNameStrategy shortens service names longer than the 32-byte
+	// wire field: ServiceNameHash (the default) or ServiceNameTruncate
+	ServiceNameStrategy string
+	// WriteTimeout bounds each write to shrmpl-log. A write that does not
+	// finish in time fails instead of stalling other logging goroutines,
+	// and the connection is dropped and re-established. Zero means 2s; a
+	// negative value disables the deadline.
+	WriteTimeout time.Duration
+	// NoShutdownRecord skips the final ShutdownCode record sent by Close,
+	// e.g. for short-lived jobs where it would only add noise
+	NoShutdownRecord bool
+}
+
+// replayMarker prefixes replayed messages so consumers know they were
+// delayed
+const replayMarker = "[replayed] "
+
+// Console formats for the stderr echo
+const (
+	// ConsoleFull prints "[LEVEL] service: message" (the default)
+	ConsoleFull = "full"
+	// ConsoleCompact prints "LEVEL CODE message" with the message truncated
+	// to ConsoleMaxLen characters
+	ConsoleCompact = "compact"
+	// ConsoleJSON prints one consoleRecord JSON object per line
+	ConsoleJSON = "json"
+)
+
+// defaultConsoleMaxLen is the compact format truncation length
+const defaultConsoleMaxLen = 200
+
+// consoleRecord is the JSON console representation of a log message.
+// Any other JSON sink should use the same structure so that downstream
+// parsing is uniform.
+type consoleRecord struct {
+	Time    string `json:"ts"`
+	Level   string `json:"level"`
+	Service string `json:"service"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// levelColors maps levels to ANSI color codes for TTY output
+var levelColors = map[string]string{
+	"DEBG": "90",
+	"INFO": "36",
+	"WARN": "33",
+	"ERRO": "31",
+}
+
+// stderrIsTerminal reports whether stderr is attached to a terminal
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// logLevels orders the wire level names from least to most severe
+var logLevels = map[string]int{"DEBG": 0, "INFO": 1, "WARN": 2, "ERRO": 3}
+
+// parseLogLevel maps a level name, including common long forms, to its
+// wire name
+func parseLogLevel(s string) (string, bool) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBG", "DEBUG":
+		return "DEBG", true
+	case "INFO":
+		return "INFO", true
+	case "WARN", "WARNING":
+		return "WARN", true
+	case "ERRO", "ERROR":
+		return "ERRO", true
+	}
+	return "", false
 }
 
 // NewLogger creates a logger that uses shrmpl-log
 func NewLogger(serverName, logReceiverHostPort string) *Logger {
 	fmt.Fprintf(os.Stderr, "DEBUG: Creating shrmpl-log client for %s\n",
 		logReceiverHostPort)
-	// Create shrmpl-log client internally
-	shrmplLogClient, err := NewShrmplLogClient(logReceiverHostPort)
-	if err != nil {
-		// If we can't create the client, we'll log to console and continue
-		// The Log method will handle the case where shrmplLogClient is nil
-		fmt.Fprintf(os.Stderr, "Failed to create shrmpl-log client: %s\n",
-			err.Error())
-		return &Logger{
-			shrmplLogClient: nil,
-			service:         serverName,
-			hostPort:        logReceiverHostPort,
+	l, _ := newLogger(serverName, LoggerOptions{Addr: logReceiverHostPort}, true)
+	return l
+}
+
+// NewLoggerWithOptions creates a logger from explicit options
+func NewLoggerWithOptions(service string, opts LoggerOptions) *Logger {
+	l, problems := newLogger(service, opts, false)
+	if len(problems) > 0 {
+		l.Warn("LCFG", "Ignoring invalid logger configuration: "+
+			strings.Join(problems, ", "))
+	}
+	return l
+}
+
+// NewLoggerFromEnv creates a logger configured from SHRMPL_LOG_ADDR,
+// SHRMPL_LOG_LEVEL, SHRMPL_LOG_CONSOLE and SHRMPL_LOG_ASYNC. When
+// SHRMPL_LOG_ADDR is unset the logger writes to stderr only.
+func NewLoggerFromEnv(service string) *Logger {
+	return NewLoggerFromEnvWithOptions(service, LoggerOptions{})
+}
+
+// NewLoggerFromEnvWithOptions combines environment and explicit
+// configuration. Explicitly set options take precedence over the
+// environment, which takes precedence over the defaults. Invalid
+// environment values are reported as a single WARN through the returned
+// logger rather than failing construction.
+func NewLoggerFromEnvWithOptions(service string, opts LoggerOptions) *Logger {
+	var problems []string
+
+	if opts.Addr == "" {
+		opts.Addr = os.Getenv("SHRMPL_LOG_ADDR")
+	}
+	if v := os.Getenv("SHRMPL_LOG_LEVEL"); opts.Level == "" && v != "" {
+		if _, ok := parseLogLevel(v); ok {
+			opts.Level = v
+		} else {
+			problems = append(problems, fmt.Sprintf("SHRMPL_LOG_LEVEL=%q", v))
+		}
+	}
+	if v := os.Getenv("SHRMPL_LOG_CONSOLE"); opts.Console == nil && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			opts.Console = &b
+		} else {
+			problems = append(problems, fmt.Sprintf("SHRMPL_LOG_CONSOLE=%q", v))
+		}
+	}
+	if v := os.Getenv("SHRMPL_LOG_ASYNC"); opts.Async == nil && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			opts.Async = &b
+		} else {
+			problems = append(problems, fmt.Sprintf("SHRMPL_LOG_ASYNC=%q", v))
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "DEBUG: Connecting to shrmpl-log\n")
-	if err := shrmplLogClient.Connect(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to shrmpl-log: %s\n", err.Error())
-		return &Logger{
-			shrmplLogClient: nil,
-			service:         serverName,
-			hostPort:        logReceiverHostPort,
+	l, optProblems := newLogger(service, opts, false)
+	problems = append(problems, optProblems...)
+	if len(problems) > 0 {
+		l.Warn("LCFG", "Ignoring invalid logger configuration: "+
+			strings.Join(problems, ", "))
+	}
+	return l
+}
+
+// newLogger builds a Logger from opts and returns descriptions of any
+// invalid options. Connection failures are printed only when verbose.
+func newLogger(service string, opts LoggerOptions,
+	verbose bool) (*Logger, []string) {
+	var problems []string
+
+	l := &Logger{
+		service:        service,
+		hostPort:       opts.Addr,
+		replayCap:      opts.ReplayBuffer,
+		started:        time.Now(),
+		shutdownRecord: !opts.NoShutdownRecord,
+		writeTimeout:   opts.WriteTimeout,
+	}
+	if l.writeTimeout == 0 {
+		l.writeTimeout = defaultLogWriteTimeout
+	}
+	cfg := LoggerRuntimeConfig{Level: "DEBG", Console: true}
+	if opts.Level != "" {
+		if level, ok := parseLogLevel(opts.Level); ok {
+			cfg.Level = level
+		} else {
+			problems = append(problems, fmt.Sprintf("level %q", opts.Level))
 		}
 	}
-	fmt.Fprintf(os.Stderr, "DEBUG: Connected to shrmpl-log successfully\n")
-	return &Logger{
-		shrmplLogClient: shrmplLogClient,
-		service:         serverName,
-		hostPort:        logReceiverHostPort,
+	if opts.Console != nil {
+		cfg.Console = *opts.Console
+	}
+	switch opts.ConsoleFormat {
+	case "", ConsoleFull, ConsoleCompact, ConsoleJSON:
+		cfg.ConsoleFormat = opts.ConsoleFormat
+	default:
+		problems = append(problems,
+			fmt.Sprintf("console format %q", opts.ConsoleFormat))
+	}
+	// cfg only holds validated values, so this cannot fail
+	_ = l.ApplyConfig(cfg)
+	l.consoleMaxLen = opts.ConsoleMaxLen
+	if l.consoleMaxLen <= 0 {
+		l.consoleMaxLen = defaultConsoleMaxLen
+	}
+	switch opts.Protocol {
+	case "", ProtocolV1, ProtocolV2, ProtocolAuto:
+		l.protocol = opts.Protocol
+	default:
+		problems = append(problems, fmt.Sprintf("protocol %q", opts.Protocol))
+	}
+	switch opts.ServiceNameStrategy {
+	case "", ServiceNameHash, ServiceNameTruncate:
+		l.wireService = wireServiceName(service, opts.ServiceNameStrategy)
+	default:
+		problems = append(problems,
+			fmt.Sprintf("service name strategy %q", opts.ServiceNameStrategy))
+		l.wireService = wireServiceName(service, ServiceNameHash)
+	}
+	l.consoleColor = stderrIsTerminal()
+	if opts.ConsoleColor != nil {
+		l.consoleColor = *opts.ConsoleColor
+	}
+
+	if l.hostPort != "" {
+		// Create shrmpl-log client internally
+		shrmplLogClient, err := NewShrmplLogClient(l.hostPort)
+		if err != nil {
+			// If we can't create the client, we'll log to console and continue
+			// The Log method will handle the case where shrmplLogClient is nil
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Failed to create shrmpl-log client: %s\n",
+					err.Error())
+			} else {
+				problems = append(problems, err.Error())
+			}
+			l.hostPort = ""
+		} else if !opts.Lazy {
+			// Lazy loggers connect in send on the first message
+			if verbose {
+				fmt.Fprintf(os.Stderr, "DEBUG: Connecting to shrmpl-log\n")
+			}
+			shrmplLogClient.SetProtocol(l.protocol)
+			shrmplLogClient.SetWriteTimeout(l.writeTimeout)
+			if err := shrmplLogClient.Connect(); err != nil {
+				if verbose {
+					fmt.Fprintf(os.Stderr, "Failed to connect to shrmpl-log: %s\n",
+						err.Error())
+				}
+			} else {
+				if verbose {
+					fmt.Fprintf(os.Stderr,
+						"DEBUG: Connected to shrmpl-log successfully\n")
+				}
+				l.shrmplLogClient = shrmplLogClient
+			}
+		}
+	}
+
+	if opts.Async != nil && *opts.Async && l.hostPort != "" {
+		size := opts.QueueSize
+		if size <= 0 {
+			size = 1024
+		}
+		l.queueDrained = sync.NewCond(&l.mu)
+		l.writerDone = make(chan struct{})
+		if opts.Shards > 1 {
+			l.shards = newLogShards(opts.Shards, size)
+			l.wake = make(chan struct{}, 1)
+			go l.runShardedWriter()
+		} else {
+			l.queue = make(chan logRecord, size)
+			go l.runWriter()
+		}
+	}
+	if opts.Shards < 0 {
+		problems = append(problems, fmt.Sprintf("shards %d", opts.Shards))
+	}
+	l.queuePolicy.Store(QueueDropNewest)
+	if opts.QueuePolicy != "" {
+		if err := l.SetQueuePolicy(opts.QueuePolicy); err != nil {
+			problems = append(problems, fmt.Sprintf("queue policy %q", opts.QueuePolicy))
+		}
+	}
+
+	if l.wireService != l.service {
+		// Logged once so operators can map the shortened name back
+		l.log("INFO", "0000", fmt.Sprintf("service name %q is logged as %q",
+			l.service, l.wireService), 1,
+			"service", l.service, "wire_service", l.wireService)
+	}
+
+	return l, problems
+}
+
+// runWriter sends queued records until the queue is closed
+func (l *Logger) runWriter() {
+	defer close(l.writerDone)
+	for rec := range l.queue {
+		l.send(rec)
+		l.mu.Lock()
+		l.handled++
+		l.queueDrained.Broadcast()
+		l.mu.Unlock()
 	}
 }
 
 // log sends a log message to shrmpl-log with caller information
 func (l *Logger) log(level string, code string, message string, skip int,
 	keyvals ...interface{}) {
-	// Parse key-value pairs for username
+	l.logOn(nil, level, code, message, skip+1, keyvals...)
+}
+
+// logOn is log with the shard of a LogHandle; a nil shard means the next
+// one in turn on sharded loggers
+func (l *Logger) logOn(shard *logShard, level string, code string, message string,
+	skip int, keyvals ...interface{}) {
+	// One snapshot per message, so a concurrent ApplyConfig never mixes
+	// old and new settings within it
+	rt := l.runtime.Load()
+	if !rt.keep(level) {
+		return
+	}
+	keyvals = expandKeyvals(keyvals)
+
+	// Parse key-value pairs for username, coerced like other fields so
+	// that e.g. numeric user IDs are shown
 	username := "unknown"
-	for i := 0; i < len(keyvals); i += 2 {
-		if i+1 < len(keyvals) && keyvals[i] == "username" {
-			if u, ok := keyvals[i+1].(string); ok {
-				username = u
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvalString(keyvals[i]) == "username" {
+			username = keyvalString(keyvals[i+1])
+		}
+	}
+	if rt.redact["username"] {
+		username = redactedValue
+	}
+
+	// Add caller information with configurable skip
+	fullMessage := formatMessage(username, message, callerSite(skip))
+	// A code that does not fit the 4 character wire field would fail the
+	// whole frame, so it is sent as "0000" instead
+	wireCode := code
+	if len(wireCode) != 4 {
+		wireCode = "0000"
+	}
+	rec := logRecord{level: level, code: wireCode, message: fullMessage}
+	if l.protocol != "" && l.protocol != ProtocolV1 {
+		// Structured fields are only sent when a newer protocol is opted
+		// into, keeping v1 output unchanged
+		rec.fields = keyvalFields(level, keyvals)
+	} else {
+		// Except for errors, which v1 appends to the message
+		rec.fields = errorFields(level, keyvals)
+	}
+	rt.redactFields(rec.fields)
+
+	if l.hostPort != "" && l.shards != nil {
+		if shard == nil {
+			shard = l.nextLogShard()
+		}
+		l.enqueueSharded(shard, splitRecord(rec))
+	} else if l.hostPort != "" {
+		// Oversized messages go out as continuation frames; sampling
+		// above keeps or drops all of them together
+		for _, part := range splitRecord(rec) {
+			l.mu.Lock()
+			switch {
+			case l.closed:
+				// Closed loggers only echo to the console
+				l.mu.Unlock()
+			case l.queue != nil:
+				l.enqueue(part)
+			default:
+				l.inflight.Add(1)
+				l.mu.Unlock()
+				l.send(part)
+				l.inflight.Done()
 			}
 		}
 	}
 
-	// Format message with username
-	formattedMsg := fmt.Sprintf("[%s] %s", username, message)
+	// Log to console for local debugging
+	if rt.config.Console {
+		l.echo(rt.config.ConsoleFormat, level, code, fullMessage)
+	}
+}
 
-	// Add caller information with configurable skip
-	_, file, line, ok := runtime.Caller(skip)
-	callerInfo := ""
+// callerCache maps call-site PCs to "file.go:line" so that only the first
+// message from each call site pays for symbolizing it
+var (
+	callerMu    sync.RWMutex
+	callerCache = make(map[uintptr]string)
+)
+
+// callerSite returns "file.go:line" for the caller skip frames above
+// callerSite's caller, as runtime.Caller(skip) would from there, or ""
+// when the stack is not that deep
+func callerSite(skip int) string {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return ""
+	}
+
+	callerMu.RLock()
+	site, ok := callerCache[pcs[0]]
+	callerMu.RUnlock()
 	if ok {
-		// Extract just the filename from the full path
-		parts := strings.Split(file, "/")
-		filename := parts[len(parts)-1]
-		callerInfo = fmt.Sprintf(" (%s:%d)", filename, line)
+		return site
+	}
+
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	// Keep just the filename from the full path
+	file := frame.File[strings.LastIndexByte(frame.File, '/')+1:]
+	site = file + ":" + strconv.Itoa(frame.Line)
+
+	callerMu.Lock()
+	callerCache[pcs[0]] = site
+	callerMu.Unlock()
+	return site
+}
+
+// formatMessage builds "[username] message (site)" with a single
+// allocation; the suffix is omitted when site is empty
+func formatMessage(username, message, site string) string {
+	var b strings.Builder
+	b.Grow(len(username) + len(message) + len(site) + 6)
+	b.WriteByte('[')
+	b.WriteString(username)
+	b.WriteString("] ")
+	b.WriteString(message)
+	if site != "" {
+		b.WriteString(" (")
+		b.WriteString(site)
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// echo writes a message to stderr in the given console format
+func (l *Logger) echo(format, level, code, message string) {
+	switch format {
+	case ConsoleJSON:
+		line, err := json.Marshal(consoleRecord{
+			Time:    time.Now().UTC().Format(time.RFC3339Nano),
+			Level:   level,
+			Service: l.service,
+			Code:    code,
+			Message: message,
+		})
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "%s\n", line)
+		}
+	case ConsoleCompact:
+		if l.consoleMaxLen > 0 && len(message) > l.consoleMaxLen {
+			message = message[:l.consoleMaxLen] + "..."
+		}
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", l.colorize(level), code, message)
+	default:
+		fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", l.colorize(level), l.service, message)
 	}
+}
+
+// colorize wraps level in an ANSI color when color output is enabled
+func (l *Logger) colorize(level string) string {
+	if !l.consoleColor {
+		return level
+	}
+	color, ok := levelColors[level]
+	if !ok {
+		return level
+	}
+	return "\x1b[" + color + "m" + level + "\x1b[0m"
+}
 
-	// Append caller info to message
-	fullMessage := formattedMsg + callerInfo
+// send writes one record to shrmpl-log, reconnecting if needed. With a
+// replay buffer configured, records that cannot be sent are held and
+// replayed in order ahead of the next record once reconnected.
+func (l *Logger) send(current logRecord) {
+	l.sendMu.Lock()
+	defer l.sendMu.Unlock()
 
 	// Ensure connection to shrmpl-log (thread-safe)
 	l.mu.Lock()
 	if l.shrmplLogClient == nil {
 		shrmplLogClient, err := NewShrmplLogClient(l.hostPort)
 		if err == nil {
+			shrmplLogClient.SetProtocol(l.protocol)
+			shrmplLogClient.SetWriteTimeout(l.writeTimeout)
 			if err := shrmplLogClient.Connect(); err == nil {
 				l.shrmplLogClient = shrmplLogClient
 				fmt.Fprintf(os.Stderr, "WARN: Reconnected to shrmpl-log\n")
@@ -108,28 +591,74 @@ func (l *Logger) log(level string, code string, message string, skip int,
 		}
 	}
 	shrmplLogClient := l.shrmplLogClient
+	var pending []logRecord
+	if shrmplLogClient != nil {
+		pending = l.replay
+		l.replay = nil
+	}
 	l.mu.Unlock()
 
-	// Send to shrmpl-log
-	if shrmplLogClient != nil {
-		// fmt.Fprintf(os.Stderr, "DEBUG: Sending log to shrmpl-log: [%s] %s\n",
-		//	level, fullMessage)
-		if err := shrmplLogClient.Log(level, l.service, "0000",
-			fullMessage); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to send log to shrmpl-log: %s\n",
-				err.Error())
-			shrmplLogClient.Close()
-			// Thread-safe: set to nil while holding lock
+	if shrmplLogClient == nil {
+		l.bufferForReplay(current)
+		return
+	}
+
+	// Replay records held while disconnected, oldest first
+	for i, rec := range pending {
+		msg := truncateRunes(replayMarker+rec.message, maxLogMessage)
+		if err := shrmplLogClient.LogEntry(Entry{Level: rec.level, Host: l.wireService,
+			Code: rec.code, Message: msg, Fields: rec.fields}); err != nil {
+			l.sendFailed(shrmplLogClient, err)
+			// The failed record is dropped so a bad record cannot block replay
 			l.mu.Lock()
-			if l.shrmplLogClient == shrmplLogClient {
-				l.shrmplLogClient = nil
-			}
+			l.replayDropped++
 			l.mu.Unlock()
+			l.bufferForReplay(append(pending[i+1:], current)...)
+			return
 		}
+		l.sent.Add(1)
+	}
+
+	// Send to shrmpl-log
+	// fmt.Fprintf(os.Stderr, "DEBUG: Sending log to shrmpl-log: [%s] %s\n",
+	//	level, fullMessage)
+	if err := shrmplLogClient.LogEntry(Entry{Level: current.level, Host: l.wireService,
+		Code: current.code, Message: current.message, Fields: current.fields}); err != nil {
+		l.sendFailed(shrmplLogClient, err)
+		l.bufferForReplay(current)
+		return
 	}
+	l.sent.Add(1)
+}
+
+// sendFailed reports a send error and drops the broken connection
+func (l *Logger) sendFailed(shrmplLogClient *ShrmplLogClient, err error) {
+	fmt.Fprintf(os.Stderr, "ERROR: Failed to send log to shrmpl-log: %s\n",
+		err.Error())
+	shrmplLogClient.Close()
+	// Thread-safe: set to nil while holding lock
+	l.mu.Lock()
+	if l.shrmplLogClient == shrmplLogClient {
+		l.shrmplLogClient = nil
+	}
+	l.mu.Unlock()
+}
 
-	// Always log to console for local debugging
-	fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", level, l.service, fullMessage)
+// bufferForReplay holds records for replay, dropping the oldest once the
+// buffer is full. When replay is disabled the records are only counted as
+// lost.
+func (l *Logger) bufferForReplay(records ...logRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.replayCap <= 0 {
+		l.unsent += len(records)
+		return
+	}
+	l.replay = append(l.replay, records...)
+	if over := len(l.replay) - l.replayCap; over > 0 {
+		l.replay = l.replay[over:]
+		l.replayDropped += over
+	}
 }
 
 // Debug logs at debug level
@@ -188,18 +717,86 @@ func (l *Logger) WarnWithCallerSkip(
 	l.log("WARN", code, message, skip, keyvals...)
 }
 
-// Close closes the underlying log client connection
+// Flush blocks until every message logged before it was called has been
+// handled: written to shrmpl-log, held for replay or counted as dropped.
+// Messages are always delivered in the order they were logged, replayed
+// ones included, so Flush is also a barrier: nothing logged after it
+// returns reaches the server before what was logged before it. Flush does
+// not wait for held messages to be replayed; that happens ahead of the
+// next message sent after a reconnect.
+func (l *Logger) Flush() {
+	if l.hostPort == "" {
+		return
+	}
+	if l.shards != nil {
+		l.flushShards()
+		return
+	}
+	if l.queue == nil {
+		// Synchronous sends hold sendMu until they are done
+		l.sendMu.Lock()
+		l.sendMu.Unlock()
+		return
+	}
+
+	// The writer handles records in queue order, so once it has handled
+	// as many as were queued so far every one of them is done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	target := l.queued
+	for l.handled < target {
+		l.queueDrained.Wait()
+	}
+}
+
+// Close waits for in-flight sends to finish, sends the shutdown record
+// (see ShutdownCode) and then closes the underlying log client
+// connection. Messages logged after Close are only echoed to the console.
 func (l *Logger) Close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	// Nothing is queued once closed is set, but synchronous sends and
+	// QueueBlock enqueues may still be in progress
+	l.inflight.Wait()
+	if l.queue != nil {
+		close(l.queue)
+		<-l.writerDone
+	}
+	if l.shards != nil {
+		l.closeShards()
+	}
+	// Every other record has been sent or dropped by now, so this one is
+	// the last on the wire
+	if l.shutdownRecord {
+		l.sendShutdownRecord()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if l.shrmplLogClient != nil {
 		l.shrmplLogClient.Close()
+		l.shrmplLogClient = nil
 	}
 }
 
+// defaultLogWriteTimeout bounds a log write when LoggerOptions does not
+const defaultLogWriteTimeout = 2 * time.Second
+
 // ShrmplLogClient represents a client for the shrmpl-log service
 type ShrmplLogClient struct {
-	host string
-	port int
-	conn net.Conn
+	host         string
+	port         int
+	conn         net.Conn
+	protocol     string
+	version      int
+	encoder      Encoder
+	writeTimeout time.Duration
 }
 
 // NewShrmplLogClient creates a new shrmpl-log client
@@ -233,35 +830,81 @@ func (c *ShrmplLogClient) Connect() error {
 	}
 
 	c.conn = conn
+	wire := protocolByName(c.protocol)
+	if c.protocol == ProtocolAuto {
+		wire, err = c.negotiate()
+		if err != nil {
+			c.Close()
+			return err
+		}
+	}
+	c.version = wire.version
+	c.encoder = wire.encoder
 	return nil
 }
 
-// Log sends a log message to shrmpl-log
-func (c *ShrmplLogClient) Log(level, host, code, message string) error {
-	// Validate inputs
-	if len(level) != 4 {
-		return fmt.Errorf("level must be exactly 4 characters")
-	}
-	if len(host) > 32 {
-		return fmt.Errorf("host must be <= 32 characters")
-	}
-	if len(code) != 4 {
-		return fmt.Errorf("code must be exactly 4 characters")
+// SetProtocol selects ProtocolV1 (the default), ProtocolV2 or ProtocolAuto
+// for the next Connect
+func (c *ShrmplLogClient) SetProtocol(protocol string) {
+	c.protocol = protocol
+}
+
+// SetWriteTimeout bounds each LogEntry write; zero or less means no
+// deadline
+func (c *ShrmplLogClient) SetWriteTimeout(timeout time.Duration) {
+	c.writeTimeout = timeout
+}
+
+// Protocol returns the protocol in use on the current connection
+func (c *ShrmplLogClient) Protocol() string {
+	if p, ok := protocolByVersion(c.version); ok {
+		return p.name
 	}
-	if len(message) > 4096 {
-		return fmt.Errorf("message must be <= 4096 characters")
+	return ProtocolV1
+}
+
+// ProtocolVersion returns the wire format version in use on the current
+// connection: the one acknowledged by the server with ProtocolAuto, 1
+// before the first Connect
+func (c *ShrmplLogClient) ProtocolVersion() int {
+	if c.version == 0 {
+		return 1
 	}
+	return c.version
+}
 
-	// Format: [LVL(4)] [HOST(32)] [CODE(12)] [LEN(5)]: [MSG]\n
-	paddedHost := fmt.Sprintf("%-32s", host[:min(32, len(host))])
-	paddedLevel := fmt.Sprintf("%-4s", level[:4])
-	paddedCode := fmt.Sprintf("%-12s", code[:min(12, len(code))])
-	msgLen := fmt.Sprintf("%05d", len(message))
+// Log sends a log message to shrmpl-log
+func (c *ShrmplLogClient) Log(level, host, code, message string) error {
+	return c.LogEntry(Entry{Level: level, Host: host, Code: code, Message: message})
+}
 
-	logLine := fmt.Sprintf("%s %s %s %s: %s\n", paddedLevel, paddedHost, paddedCode, msgLen, message)
+// LogEntry sends an entry using the connection's encoder
+func (c *ShrmplLogClient) LogEntry(e Entry) error {
+	return c.logEntryWithin(e, c.writeTimeout)
+}
 
-	_, err := c.conn.Write([]byte(logLine))
-	return err
+// logEntryWithin sends an entry, failing if the write takes longer than
+// timeout; zero or less means no deadline
+func (c *ShrmplLogClient) logEntryWithin(e Entry, timeout time.Duration) error {
+	encoder := c.encoder
+	if encoder == nil {
+		encoder = V1Encoder{}
+	}
+	buf := frameBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			frameBufferPool.Put(buf)
+		}
+	}()
+	if err := encoder.Encode(buf, e); err != nil {
+		return err
+	}
+
+	if timeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return writeFull(c.conn, buf.Bytes())
 }
 
 // Close closes the connection to shrmpl-log
@@ -0,0 +1,446 @@
+package shrmpl_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// quiet keeps test loggers off stderr
+var quiet = false
+
+func newTestLogger(t *testing.T, opts shrmpl.LoggerOptions) *shrmpl.Logger {
+	t.Helper()
+	opts.Console = &quiet
+	opts.NoShutdownRecord = true
+	l := shrmpl.NewLoggerWithOptions("test", opts)
+	t.Cleanup(l.Close)
+	return l
+}
+
+func TestLoggerSendsCode(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr})
+
+	l.Info("T001", "started")
+	if err := l.Entry().Level("WARN").Code("ABCD").Msg("built"); err != nil {
+		t.Fatal(err)
+	}
+	// Codes that do not fit the wire field still send the message
+	l.Error("TOOLONG", "bad code")
+
+	frames, err := srv.WaitFrames(3, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"T001", "ABCD", "0000"}
+	for i, frame := range frames {
+		if frame.Code != want[i] {
+			t.Errorf("frame %d code = %q, want %q", i, frame.Code, want[i])
+		}
+	}
+}
+
+func TestLoggerSendsCodeOfSplitMessage(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr})
+
+	l.Info("LONG", strings.Repeat("x", 10000))
+	l.Flush()
+	frames, err := srv.WaitFrames(2, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frame := range frames {
+		if frame.Code != "LONG" {
+			t.Errorf("part %d code = %q, want LONG", i, frame.Code)
+		}
+	}
+}
+
+// reassemble strips the "msgid=<id> part=i/n " prefix of split frames and
+// joins their parts, checking that they belong to one message and arrive
+// complete and in order
+func reassemble(t *testing.T, frames []shrmpltest.LogFrame) string {
+	t.Helper()
+	var id string
+	var b strings.Builder
+	for i, frame := range frames {
+		var msgid string
+		var part, total int
+		if _, err := fmt.Sscanf(frame.Message, "msgid=%s part=%d/%d ", &msgid, &part, &total); err != nil {
+			t.Fatalf("frame %d = %q: %v", i, frame.Message, err)
+		}
+		if i == 0 {
+			id = msgid
+		}
+		if msgid != id || part != i+1 || total != len(frames) {
+			t.Fatalf("frame %d is part %d/%d of %s, want part %d/%d of %s",
+				i, part, total, msgid, i+1, len(frames), id)
+		}
+		prefix := fmt.Sprintf("msgid=%s part=%d/%d ", msgid, part, total)
+		b.WriteString(strings.TrimPrefix(frame.Message, prefix))
+	}
+	return b.String()
+}
+
+func TestLoggerSplitMessageReassembles(t *testing.T) {
+	// Multi-byte runes make sure the parts split on rune boundaries
+	original := strings.Repeat("abé世😀 ", 1500)
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	srv.AcceptV2(true)
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr, Protocol: shrmpl.ProtocolV2})
+
+	if err := l.Entry().Code("LONG").Field("order", "42").Msg(original); err != nil {
+		t.Fatal(err)
+	}
+	l.Flush()
+
+	// Wait for the final part, then read every frame sent
+	deadline := time.Now().Add(2 * time.Second)
+	var frames []shrmpltest.LogFrame
+	for {
+		frames = srv.Frames()
+		if n := len(frames); n > 0 && frames[n-1].Fields != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the last part did not arrive; got %d frames", len(frames))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(frames) < 2 {
+		t.Fatalf("got %d frame, want the message split", len(frames))
+	}
+	for i, frame := range frames {
+		if !utf8.ValidString(frame.Message) {
+			t.Errorf("part %d is not valid UTF-8", i)
+		}
+		if i < len(frames)-1 && frame.Fields != nil {
+			t.Errorf("part %d carries the fields, want only the last", i)
+		}
+	}
+	if got := frames[len(frames)-1].Fields["order"]; got != "42" {
+		t.Errorf("last part fields = %v, want order=42", frames[len(frames)-1].Fields)
+	}
+
+	// The parts join into the message as it would have been sent whole:
+	// the original text between the username and the call site
+	got := reassemble(t, frames)
+	if !strings.HasPrefix(got, "[unknown] "+original+" (") || !strings.HasSuffix(got, ")") {
+		t.Errorf("reassembled %d bytes, want the %d-byte original between the username and call site",
+			len(got), len(original))
+	}
+}
+
+func TestLoggerReplaysCode(t *testing.T) {
+	// Reserve an address, then log to it while nothing listens
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: addr, Lazy: true, ReplayBuffer: 10})
+	l.Warn("HELD", "while down")
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("address %s was taken meanwhile: %v", addr, err)
+	}
+	defer ln.Close()
+	go l.Info("LIVE", "after reconnect")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"HELD", "LIVE"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		// LVL HOST(32) CODE(12) LEN: MSG
+		if code := strings.TrimRight(line[38:50], " "); code != want {
+			t.Errorf("code = %q, want %q in %q", code, want, line)
+		}
+	}
+}
+
+func TestLoggerSendsErrorFieldsOverV1(t *testing.T) {
+	srv := shrmpltest.NewLogServer()
+	defer srv.Close()
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: srv.Addr})
+
+	err := fmt.Errorf("save: %w", errors.New("disk full"))
+	l.Error("E001", "save failed", shrmpl.Err(err), "username", "bob")
+	l.Info("I001", "no errors", "username", "bob")
+
+	frames, waitErr := srv.WaitFrames(2, 2*time.Second)
+	if waitErr != nil {
+		t.Fatal(waitErr)
+	}
+	if frames[0].Fields != nil || !strings.Contains(frames[0].Message, "err=save: disk full") ||
+		!strings.Contains(frames[0].Message, "err_cause=disk full") {
+		t.Errorf("v1 message = %q, want the error fields flattened into it", frames[0].Message)
+	}
+	// Other keyvals stay out of v1 messages
+	if strings.Contains(frames[1].Message, "username=") {
+		t.Errorf("v1 message = %q, want no keyvals", frames[1].Message)
+	}
+}
+
+// keyvalPoint is a struct keyval value, formatted by fmt.Sprint as {1 2}
+type keyvalPoint struct{ X, Y int }
+
+func TestLoggerCoercesKeyvals(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			srv := shrmpltest.NewLogServer()
+			defer srv.Close()
+			opts := shrmpl.LoggerOptions{Addr: srv.Addr}
+			if version == 2 {
+				srv.AcceptV2(true)
+				opts.Protocol = shrmpl.ProtocolV2
+			}
+			l := newTestLogger(t, opts)
+
+			_, _, line, _ := runtime.Caller(0)
+			l.Info("K001", "coerced", 42, "answer", "point", keyvalPoint{1, 2}, "none", nil, "username", 7)
+
+			frames, err := srv.WaitFrames(1, 2*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The numeric username reaches the prefix; v1 leaves other
+			// keyvals out of the message
+			message := fmt.Sprintf("[7] coerced (logging_client_test.go:%d)", line+1)
+			want := fmt.Sprintf("INFO %-32s %-12s %05d: %s", "test", "K001", len(message), message)
+			if version == 2 {
+				// encoding/json escapes the angle brackets of <nil>
+				fields := `{"42":"answer","none":"\u003cnil\u003e","point":"{1 2}","username":"7"}`
+				want += fmt.Sprintf(" %05d: %s", len(fields), fields)
+			}
+			if got := frames[0].Raw; got != want {
+				t.Errorf("frame:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLoggerReplaysBeforeConcurrentSends(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	const held, live = 20, 20
+	l := newTestLogger(t, shrmpl.LoggerOptions{Addr: addr, Lazy: true, ReplayBuffer: held})
+	for i := 0; i < held; i++ {
+		l.Info("HELD", fmt.Sprintf("held %02d %s", i, strings.Repeat("é", 10)))
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("address %s was taken meanwhile: %v", addr, err)
+	}
+	defer ln.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < live; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Info("LIVE", fmt.Sprintf("live %02d", i))
+		}(i)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < held+live; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		replayed := strings.Contains(line, "[replayed] ")
+		if i < held {
+			// Replayed records come first and in their original order
+			want := fmt.Sprintf("held %02d ", i)
+			if !replayed || !strings.Contains(line, want) {
+				t.Fatalf("frame %d = %q, want replayed %q", i, line, want)
+			}
+		} else if replayed {
+			t.Fatalf("frame %d = %q, a replayed record after live ones", i, line)
+		}
+	}
+	wg.Wait()
+}
+
+// stalledLogServer accepts connections and never reads from them, like a
+// log server that has stopped draining its sockets
+func stalledLogServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestLogClientWriteTimesOutOnStalledServer(t *testing.T) {
+	c, err := shrmpl.NewShrmplLogClient(stalledLogServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteTimeout(50 * time.Millisecond)
+
+	// Writes succeed until the socket buffers fill, then must time out
+	// instead of blocking
+	message := strings.Repeat("x", 4096)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 100000; i++ {
+			if err := c.Log("INFO", "test", "T001", message); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("write error = %v, want a timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes to a server that stopped reading blocked")
+	}
+}
+
+func TestLoggerDoesNotBlockOnStalledServer(t *testing.T) {
+	l := newTestLogger(t, shrmpl.LoggerOptions{
+		Addr:         stalledLogServer(t),
+		WriteTimeout: 50 * time.Millisecond,
+	})
+
+	message := strings.Repeat("x", 4096)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3000; i++ {
+			l.Info("T001", message)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("logging to a server that stopped reading blocked")
+	}
+	if stats := l.Stats(); stats.DroppedDisconnected == 0 {
+		t.Errorf("stats = %+v, want messages dropped once writes failed", stats)
+	}
+}
+
+func TestLoggerKeepsPerGoroutineOrder(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			srv := shrmpltest.NewLogServer()
+			defer srv.Close()
+			async := async
+			l := newTestLogger(t, shrmpl.LoggerOptions{
+				Addr:        srv.Addr,
+				Async:       &async,
+				QueueSize:   16,
+				QueuePolicy: shrmpl.QueueBlock,
+			})
+
+			const goroutines, perGoroutine = 8, 200
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for seq := 0; seq < perGoroutine; seq++ {
+						l.Info("T001", fmt.Sprintf("g=%d seq=%d", g, seq))
+					}
+				}(g)
+			}
+			wg.Wait()
+			l.Flush()
+
+			frames, err := srv.WaitFrames(goroutines*perGoroutine, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := make([]int, goroutines)
+			for _, frame := range frames {
+				var g, seq int
+				i := strings.Index(frame.Message, "g=")
+				if i < 0 {
+					t.Fatalf("unexpected frame %q", frame.Raw)
+				}
+				if _, err := fmt.Sscanf(frame.Message[i:], "g=%d seq=%d", &g, &seq); err != nil {
+					t.Fatalf("frame %q: %v", frame.Raw, err)
+				}
+				if seq != next[g] {
+					t.Fatalf("goroutine %d: got seq %d, want %d", g, seq, next[g])
+				}
+				next[g]++
+			}
+			for g, n := range next {
+				if n != perGoroutine {
+					t.Errorf("goroutine %d: %d of %d records arrived", g, n, perGoroutine)
+				}
+			}
+		})
+	}
+}
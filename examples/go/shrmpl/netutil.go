@@ -0,0 +1,26 @@
+package shrmpl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrShortWrite is returned when a connection accepted only part of a
+// command or frame without reporting an error
+var ErrShortWrite = errors.New("short write")
+
+// writeFull writes data to w, reporting ErrShortWrite if fewer bytes than
+// len(data) were written without an error. net.Conn implementations loop
+// internally, so this only fires for misbehaving wrappers, but a partial
+// frame would otherwise desynchronize the protocol silently.
+func writeFull(w io.Writer, data []byte) error {
+	n, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	if n < len(data) {
+		return fmt.Errorf("%w: wrote %d of %d bytes", ErrShortWrite, n, len(data))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package shrmpl
+
+import (
+	"errors"
+	"testing"
+)
+
+// shortConn accepts at most limit bytes per write without an error
+type shortConn struct {
+	captureConn
+	limit int
+}
+
+func (c *shortConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		p = p[:c.limit]
+	}
+	return c.captureConn.Write(p)
+}
+
+func TestWriteFullReportsShortWrite(t *testing.T) {
+	conn := &shortConn{limit: 3}
+	err := writeFull(conn, []byte("GET key\n"))
+	if !errors.Is(err, ErrShortWrite) {
+		t.Fatalf("writeFull = %v, want ErrShortWrite", err)
+	}
+	if err.Error() != "short write: wrote 3 of 8 bytes" {
+		t.Errorf("error = %q", err)
+	}
+
+	conn = &shortConn{limit: 8}
+	if err := writeFull(conn, []byte("GET key\n")); err != nil {
+		t.Errorf("complete write: %v", err)
+	}
+}
+
+func TestLogEntrySurfacesShortWrite(t *testing.T) {
+	conn := &shortConn{limit: 10}
+	c := &ShrmplLogClient{conn: conn}
+	err := c.LogEntry(Entry{Level: "INFO", Host: "h", Code: "0000", Message: "partial"})
+	if !errors.Is(err, ErrShortWrite) {
+		t.Errorf("LogEntry = %v, want ErrShortWrite", err)
+	}
+}
@@ -0,0 +1,422 @@
+package shrmpltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shrmpl"
+)
+
+// Fault kinds accepted by ParseFaults
+const (
+	FaultDisconnect = "disconnect"
+	FaultSlow       = "slow"
+	FaultError      = "error"
+)
+
+// ErrInjected is returned by FaultInjector for synthetic errors
+var ErrInjected = errors.New("injected fault")
+
+// Fault is one kind of failure injected with the given probability per
+// client call. Delay applies to FaultSlow.
+type Fault struct {
+	Kind        string
+	Probability float64
+	Delay       time.Duration
+}
+
+// String returns the fault in ParseFaults syntax
+func (f Fault) String() string {
+	s := fmt.Sprintf("%s:%s%%", f.Kind, strconv.FormatFloat(f.Probability*100, 'f', -1, 64))
+	if f.Kind == FaultSlow {
+		s += ":" + f.Delay.String()
+	}
+	return s
+}
+
+// ParseFaults parses a spec such as "disconnect:0.1%,slow:1%:500ms,error:0.5%".
+// Probabilities are percentages when suffixed with % and fractions
+// otherwise.
+func ParseFaults(spec string) ([]Fault, error) {
+	var faults []Fault
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("fault %q: expected kind:probability", part)
+		}
+		f := Fault{Kind: fields[0]}
+
+		prob := fields[1]
+		scale := 1.0
+		if p, ok := strings.CutSuffix(prob, "%"); ok {
+			prob, scale = p, 0.01
+		}
+		p, err := strconv.ParseFloat(prob, 64)
+		if err != nil || p < 0 || p*scale > 1 {
+			return nil, fmt.Errorf("fault %q: invalid probability %q", part, fields[1])
+		}
+		f.Probability = p * scale
+
+		switch f.Kind {
+		case FaultSlow:
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("fault %q: slow needs a delay, e.g. slow:1%%:500ms", part)
+			}
+			if f.Delay, err = time.ParseDuration(fields[2]); err != nil || f.Delay <= 0 {
+				return nil, fmt.Errorf("fault %q: invalid delay %q", part, fields[2])
+			}
+		case FaultDisconnect, FaultError:
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("fault %q: unexpected %q", part, fields[2])
+			}
+		default:
+			return nil, fmt.Errorf("fault %q: unknown kind %q", part, f.Kind)
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+// FaultInjector wraps a client and randomly disconnects it, delays calls
+// or fails them with ErrInjected, for testing how applications cope with a
+// degraded KV client. Every call rolls each fault independently. Stats
+// and Close are passed through without faults. Operations from the
+// optional interfaces, such as shrmpl.KVLister, fail with an error
+// matching errors.ErrUnsupported when the wrapped client lacks them; the
+// Context methods fall back to ignoring ctx.
+//
+// Wrappers of other client types can use Inject directly: create the
+// injector with a nil client and set Disconnect.
+type FaultInjector struct {
+	next   shrmpl.ThisAppKVInterface
+	faults []Fault
+
+	// Disconnect closes the client's connection for a FaultDisconnect.
+	// NewFaultInjector sets it to the client's DropConnection method, if
+	// it has one, such as *shrmpl.KV's.
+	Disconnect func()
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	counts   map[string]int
+	recorded []string
+}
+
+// valueBatcher is implemented by clients with KV.BatchValues
+type valueBatcher interface {
+	BatchValues(commands []string) ([]string, error)
+}
+
+// unsupported is returned for an operation next does not implement
+func unsupported(next shrmpl.ThisAppKVInterface, op string) error {
+	return fmt.Errorf("%s: %T does not implement it: %w", op, next, errors.ErrUnsupported)
+}
+
+// NewFaultInjector wraps next; seed makes the injected sequence repeatable
+func NewFaultInjector(next shrmpl.ThisAppKVInterface, faults []Fault, seed int64) *FaultInjector {
+	f := &FaultInjector{
+		next:   next,
+		faults: faults,
+		rng:    rand.New(rand.NewSource(seed)),
+		counts: make(map[string]int),
+	}
+	if d, ok := next.(interface{ DropConnection() }); ok {
+		f.Disconnect = d.DropConnection
+	}
+	return f
+}
+
+// Inject draws the faults for one call and applies them, returning
+// ErrInjected when the call must fail without reaching the server
+func (f *FaultInjector) Inject() error {
+	f.mu.Lock()
+	var drawn []Fault
+	for _, fault := range f.faults {
+		if f.rng.Float64() < fault.Probability {
+			drawn = append(drawn, fault)
+			f.counts[fault.Kind]++
+			f.recorded = append(f.recorded, fault.Kind)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, fault := range drawn {
+		switch fault.Kind {
+		case FaultDisconnect:
+			if f.Disconnect != nil {
+				f.Disconnect()
+			}
+		case FaultSlow:
+			time.Sleep(fault.Delay)
+		case FaultError:
+			return ErrInjected
+		}
+	}
+	return nil
+}
+
+// TakeInjected returns the kinds injected since the previous call, so
+// callers can tag the operations they affected
+func (f *FaultInjector) TakeInjected() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kinds := f.recorded
+	f.recorded = nil
+	return kinds
+}
+
+// Counts returns how often each kind was injected
+func (f *FaultInjector) Counts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int, len(f.counts))
+	for k, v := range f.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// Get retrieves a value, subject to injected faults
+func (f *FaultInjector) Get(key string) (string, error) {
+	if err := f.Inject(); err != nil {
+		return "", err
+	}
+	return f.next.Get(key)
+}
+
+// GetContext retrieves a value, subject to injected faults
+func (f *FaultInjector) GetContext(ctx context.Context, key string) (string, error) {
+	if err := f.Inject(); err != nil {
+		return "", err
+	}
+	if kv, ok := f.next.(shrmpl.KVContexter); ok {
+		return kv.GetContext(ctx, key)
+	}
+	return f.next.Get(key)
+}
+
+// Set stores a key-value pair, subject to injected faults
+func (f *FaultInjector) Set(key, value, ttl string) error {
+	if err := f.Inject(); err != nil {
+		return err
+	}
+	return f.next.Set(key, value, ttl)
+}
+
+// SetContext stores a key-value pair, subject to injected faults
+func (f *FaultInjector) SetContext(ctx context.Context, key, value, ttl string) error {
+	if err := f.Inject(); err != nil {
+		return err
+	}
+	if kv, ok := f.next.(shrmpl.KVContexter); ok {
+		return kv.SetContext(ctx, key, value, ttl)
+	}
+	return f.next.Set(key, value, ttl)
+}
+
+// SetReturning stores a key-value pair, subject to injected faults
+func (f *FaultInjector) SetReturning(key, value, ttl string) (string, error) {
+	if err := f.Inject(); err != nil {
+		return "", err
+	}
+	kv, ok := f.next.(shrmpl.KVConditionalSetter)
+	if !ok {
+		return "", unsupported(f.next, "SetReturning")
+	}
+	return kv.SetReturning(key, value, ttl)
+}
+
+// SetOpts stores a key-value pair, subject to injected faults
+func (f *FaultInjector) SetOpts(key, value string, opts shrmpl.SetOptions) (string, bool, error) {
+	if err := f.Inject(); err != nil {
+		return "", false, err
+	}
+	kv, ok := f.next.(shrmpl.KVConditionalSetter)
+	if !ok {
+		return "", false, unsupported(f.next, "SetOpts")
+	}
+	return kv.SetOpts(key, value, opts)
+}
+
+// CompareAndSwap swaps a value, subject to injected faults
+func (f *FaultInjector) CompareAndSwap(key, oldValue, newValue, ttl string) (bool, error) {
+	if err := f.Inject(); err != nil {
+		return false, err
+	}
+	kv, ok := f.next.(shrmpl.KVConditionalSetter)
+	if !ok {
+		return false, unsupported(f.next, "CompareAndSwap")
+	}
+	return kv.CompareAndSwap(key, oldValue, newValue, ttl)
+}
+
+// Update applies fn to a value, subject to injected faults
+func (f *FaultInjector) Update(key string, ttl string, fn func(current string, exists bool) (string, error)) error {
+	if err := f.Inject(); err != nil {
+		return err
+	}
+	kv, ok := f.next.(shrmpl.KVConditionalSetter)
+	if !ok {
+		return unsupported(f.next, "Update")
+	}
+	return kv.Update(key, ttl, fn)
+}
+
+// Touch resets a key's TTL, subject to injected faults
+func (f *FaultInjector) Touch(key string, ttl string) (bool, error) {
+	if err := f.Inject(); err != nil {
+		return false, err
+	}
+	kv, ok := f.next.(shrmpl.KVToucher)
+	if !ok {
+		return false, unsupported(f.next, "Touch")
+	}
+	return kv.Touch(key, ttl)
+}
+
+// GetWithTTL retrieves a value and its TTL, subject to injected faults
+func (f *FaultInjector) GetWithTTL(key string) (string, time.Duration, bool, error) {
+	if err := f.Inject(); err != nil {
+		return "", 0, false, err
+	}
+	kv, ok := f.next.(shrmpl.KVToucher)
+	if !ok {
+		return "", 0, false, unsupported(f.next, "GetWithTTL")
+	}
+	return kv.GetWithTTL(key)
+}
+
+// Incr increments a counter, subject to injected faults
+func (f *FaultInjector) Incr(key string, ttl string) (int, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	return f.next.Incr(key, ttl)
+}
+
+// IncrContext increments a counter, subject to injected faults
+func (f *FaultInjector) IncrContext(ctx context.Context, key string, ttl string) (int, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	if kv, ok := f.next.(shrmpl.KVContexter); ok {
+		return kv.IncrContext(ctx, key, ttl)
+	}
+	return f.next.Incr(key, ttl)
+}
+
+// Batch executes multiple commands, subject to injected faults
+func (f *FaultInjector) Batch(commands []string) ([]shrmpl.BatchResult, error) {
+	if err := f.Inject(); err != nil {
+		return nil, err
+	}
+	return f.next.Batch(commands)
+}
+
+// BatchContext executes multiple commands, subject to injected faults
+func (f *FaultInjector) BatchContext(ctx context.Context, commands []string) ([]shrmpl.BatchResult, error) {
+	if err := f.Inject(); err != nil {
+		return nil, err
+	}
+	if kv, ok := f.next.(shrmpl.KVContexter); ok {
+		return kv.BatchContext(ctx, commands)
+	}
+	return f.next.Batch(commands)
+}
+
+// BatchValues executes multiple commands, subject to injected faults
+func (f *FaultInjector) BatchValues(commands []string) ([]string, error) {
+	if err := f.Inject(); err != nil {
+		return nil, err
+	}
+	kv, ok := f.next.(valueBatcher)
+	if !ok {
+		return nil, unsupported(f.next, "BatchValues")
+	}
+	return kv.BatchValues(commands)
+}
+
+// DBSize counts the keys, subject to injected faults
+func (f *FaultInjector) DBSize() (int, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	kv, ok := f.next.(shrmpl.KVLister)
+	if !ok {
+		return 0, unsupported(f.next, "DBSize")
+	}
+	return kv.DBSize()
+}
+
+// List lists the keys, subject to injected faults
+func (f *FaultInjector) List() ([]shrmpl.KVListItem, error) {
+	if err := f.Inject(); err != nil {
+		return nil, err
+	}
+	kv, ok := f.next.(shrmpl.KVLister)
+	if !ok {
+		return nil, unsupported(f.next, "List")
+	}
+	return kv.List()
+}
+
+// Export writes the keys under prefix, subject to injected faults
+func (f *FaultInjector) Export(w io.Writer, prefix string) (int, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	kv, ok := f.next.(shrmpl.KVExporter)
+	if !ok {
+		return 0, unsupported(f.next, "Export")
+	}
+	return kv.Export(w, prefix)
+}
+
+// Import loads exported keys, subject to injected faults
+func (f *FaultInjector) Import(r io.Reader) (int, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	kv, ok := f.next.(shrmpl.KVExporter)
+	if !ok {
+		return 0, unsupported(f.next, "Import")
+	}
+	return kv.Import(r)
+}
+
+// RTT measures the round trip, subject to injected faults
+func (f *FaultInjector) RTT(samples ...int) (time.Duration, error) {
+	if err := f.Inject(); err != nil {
+		return 0, err
+	}
+	kv, ok := f.next.(shrmpl.KVMonitor)
+	if !ok {
+		return 0, unsupported(f.next, "RTT")
+	}
+	return kv.RTT(samples...)
+}
+
+// Stats returns the wrapped client's statistics, or zero ones if it has
+// none
+func (f *FaultInjector) Stats() shrmpl.KVStats {
+	if kv, ok := f.next.(shrmpl.KVMonitor); ok {
+		return kv.Stats()
+	}
+	return shrmpl.KVStats{}
+}
+
+// Close closes the wrapped client
+func (f *FaultInjector) Close() {
+	f.next.Close()
+}
@@ -0,0 +1,100 @@
+package shrmpltest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := shrmpltest.ParseFaults("disconnect:0.1%, slow:1%:500ms,error:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []shrmpltest.Fault{
+		{Kind: shrmpltest.FaultDisconnect, Probability: 0.001},
+		{Kind: shrmpltest.FaultSlow, Probability: 0.01, Delay: 500 * time.Millisecond},
+		{Kind: shrmpltest.FaultError, Probability: 0.5},
+	}
+	if len(faults) != len(want) {
+		t.Fatalf("faults = %v", faults)
+	}
+	for i := range want {
+		if faults[i] != want[i] {
+			t.Errorf("fault %d = %+v, want %+v", i, faults[i], want[i])
+		}
+	}
+	if got := faults[1].String(); got != "slow:1%:500ms" {
+		t.Errorf("String = %q", got)
+	}
+
+	for _, spec := range []string{"error", "error:2", "error:-1%", "slow:1%", "slow:1%:0s", "drop:1%", "error:1%:x"} {
+		if _, err := shrmpltest.ParseFaults(spec); err == nil {
+			t.Errorf("ParseFaults(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestFaultInjectorErrors(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+
+	f := shrmpltest.NewFaultInjector(kv, []shrmpltest.Fault{{Kind: shrmpltest.FaultError, Probability: 1}}, 1)
+	if err := f.Set("k", "v", ""); !errors.Is(err, shrmpltest.ErrInjected) {
+		t.Errorf("Set = %v, want ErrInjected", err)
+	}
+	if _, ok := srv.Value("k"); ok {
+		t.Errorf("an injected error reached the server")
+	}
+	if kinds := f.TakeInjected(); len(kinds) != 1 || kinds[0] != shrmpltest.FaultError {
+		t.Errorf("TakeInjected = %v", kinds)
+	}
+	if kinds := f.TakeInjected(); len(kinds) != 0 {
+		t.Errorf("second TakeInjected = %v, want nothing", kinds)
+	}
+}
+
+func TestFaultInjectorDisconnects(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := shrmpl.NewKV(srv.Config())
+	defer kv.Close()
+
+	f := shrmpltest.NewFaultInjector(kv, []shrmpltest.Fault{{Kind: shrmpltest.FaultDisconnect, Probability: 1}}, 1)
+	dials := srv.Dials()
+	for i := 0; i < 3; i++ {
+		if err := f.Set("k", "v", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every call dropped the connection and reconnected
+	if got := srv.Dials() - dials; got != 3 {
+		t.Errorf("%d dials, want 3", got)
+	}
+	if counts := f.Counts(); counts[shrmpltest.FaultDisconnect] != 3 {
+		t.Errorf("Counts = %v", counts)
+	}
+}
+
+func TestFaultInjectorSeedRepeats(t *testing.T) {
+	faults := []shrmpltest.Fault{{Kind: shrmpltest.FaultError, Probability: 0.5}}
+	draw := func() []bool {
+		f := shrmpltest.NewFaultInjector(nil, faults, 42)
+		var failed []bool
+		for i := 0; i < 50; i++ {
+			failed = append(failed, f.Inject() != nil)
+		}
+		return failed
+	}
+	first, second := draw(), draw()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("draw %d differs between runs with the same seed", i)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package shrmpltest provides in-process fakes of the shrmpl-kv,
+// shrmpl-log and shrmpl-vault servers, so code using the shrmpl client
+// library can be tested end to end without the server binaries:
+//
+//	h := shrmpltest.Start()
+//	defer h.Close()
+//	kv := shrmpl.NewKV(h.KV.Config())
+//
+// The fakes follow the servers' wire protocols and error responses, and
+// expose hooks such as DropConnections to exercise reconnection.
+package shrmpltest
+
+// HarnessSecret is the vault secret accepted by Start's vault server
+const HarnessSecret = "shrmpltest-secret"
+
+// Harness runs all three fake servers side by side
+type Harness struct {
+	KV    *KVServer
+	Log   *LogServer
+	Vault *VaultServer
+}
+
+// Start starts a KV, log and vault server
+func Start() *Harness {
+	return &Harness{
+		KV:    NewKVServer(),
+		Log:   NewLogServer(),
+		Vault: NewVaultServer(HarnessSecret),
+	}
+}
+
+// Env returns environment variables pointing the example program and
+// NewLoggerFromEnv at the servers, in os/exec's "KEY=value" form
+func (h *Harness) Env() []string {
+	return []string{
+		"SHRMPL_KV_ADDR=" + h.KV.Addr,
+		"SHRMPL_LOG_ADDR=" + h.Log.Addr,
+		"SHRMPL_VAULT_URL=" + h.Vault.URL,
+		"SHRMPL_VAULT_CERT=" + h.Vault.CertPath,
+		"SHRMPL_VAULT_KEY=" + h.Vault.KeyPath,
+		"SHRMPL_VAULT_CA=" + h.Vault.CAPath,
+		"SHRMPL_VAULT_SECRET=" + h.Vault.Secret,
+	}
+}
+
+// Close stops all servers
+func (h *Harness) Close() {
+	h.KV.Close()
+	h.Log.Close()
+	h.Vault.Close()
+}
@@ -0,0 +1,567 @@
+package shrmpltest
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shrmpl"
+)
+
+// kvMaxBatch is the BATCH limit of shrmpl-kv-srv
+const kvMaxBatch = 3
+
+// kvMaxLength is the key and value length limit of shrmpl-kv-srv
+const kvMaxLength = 100
+
+// kvTagAnnotation starts the trailing token that carries an operation tag
+const kvTagAnnotation = " #tag="
+
+// kvCommands are the commands KVServer advertises in its HELLO response
+var kvCommands = []string{"BATCH", "CAS", "DBSIZE", "DEL", "DISCARD", "EXEC",
+	"GET", "GETX", "HELLO", "INCR", "LIST", "LISTX", "MULTI", "PING", "SET",
+	"TOUCH", "TTL"}
+
+// KVServer is an in-memory shrmpl-kv server. It speaks the text protocol
+// of shrmpl-kv-srv plus the optional commands the client library knows
+// (HELLO, DBSIZE, CAS, TOUCH, TTL, MULTI, the checksummed GETX and LISTX and
+// the SET flags). It accepts operation tags and records them in place of a
+// server log, advertises its key and value limits, and lets tests drop
+// connections, corrupt responses, abort transactions, interleave
+// heartbeats or announce a shutdown to exercise recovery.
+type KVServer struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener net.Listener
+	dials    atomic.Int64
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	data     map[string]kvEntry
+	evicted  map[string]bool
+	conns    map[*kvConn]struct{}
+	tags     []string
+	corrupt  bool
+	abortTxn bool
+	beats    bool
+	maxKey   int
+	maxValue int
+	closed   bool
+}
+
+// kvEntry is a stored value; expires is zero for keys without a TTL
+type kvEntry struct {
+	value   string
+	expires time.Time
+}
+
+// kvConn is one client connection and its transaction state
+type kvConn struct {
+	conn  net.Conn
+	wmu   sync.Mutex
+	multi bool
+	queue []string
+}
+
+// write sends response lines to the client
+func (c *kvConn) write(s string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write([]byte(s))
+	return err
+}
+
+// NewKVServer starts a KV server on a random loopback port. It panics if
+// it cannot listen, like httptest.NewServer.
+func NewKVServer() *KVServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("shrmpltest: failed to listen: %v", err))
+	}
+	s := &KVServer{
+		Addr:     ln.Addr().String(),
+		listener: ln,
+		data:     make(map[string]kvEntry),
+		evicted:  make(map[string]bool),
+		conns:    make(map[*kvConn]struct{}),
+		maxKey:   kvMaxLength,
+		maxValue: kvMaxLength,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Config returns a client configuration pointing at the server
+func (s *KVServer) Config() *shrmpl.KVConfig {
+	return &shrmpl.KVConfig{HostPort: s.Addr}
+}
+
+// Dials returns the number of connections accepted so far
+func (s *KVServer) Dials() int {
+	return int(s.dials.Load())
+}
+
+// Put stores value under key, for seeding test data
+func (s *KVServer) Put(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = kvEntry{value: value}
+}
+
+// Value returns the unexpired value stored under key
+func (s *KVServer) Value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	return e.value, ok
+}
+
+// Tags returns the operation tags received so far, one per tagged command,
+// in arrival order
+func (s *KVServer) Tags() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tags...)
+}
+
+// SetLimits changes the key and value length limits the server enforces
+// and advertises in its HELLO response (100 each by default), as a server
+// configured for longer keys or values would. Clients learn them on their
+// next handshake.
+func (s *KVServer) SetLimits(maxKey, maxValue int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxKey, s.maxValue = maxKey, maxValue
+}
+
+// SetCorruption makes checksummed responses carry a damaged payload under
+// the checksum of the original, as a faulty NIC would, until it is turned
+// off again
+func (s *KVServer) SetCorruption(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corrupt = on
+}
+
+// checksummed returns payload prefixed with its CRC-32, damaging the
+// payload when corruption is on. s.mu must be held.
+func (s *KVServer) checksummed(payload string) string {
+	sum := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(payload)))
+	if s.corrupt {
+		payload = payload[:len(payload)-1] + string(payload[len(payload)-1]^0x01)
+	}
+	return sum + " " + payload
+}
+
+// AbortNextTxn makes the next EXEC answer "*ABORTED*" without applying
+// the queued commands, as the server does when it refuses a transaction
+func (s *KVServer) AbortNextTxn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abortTxn = true
+}
+
+// SetHeartbeats makes every response start with an "UPONG" heartbeat
+// line, as if the server's heartbeat timer fired between each command and
+// its answer, until it is turned off again
+func (s *KVServer) SetHeartbeats(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beats = on
+}
+
+// Evict removes key as if the server had run out of memory: GET answers
+// "*KEY EVICTED*" until the key is written again
+func (s *KVServer) Evict(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	s.evicted[key] = true
+}
+
+// DropConnections closes every client connection without notice, like a
+// network failure. The server keeps accepting new connections.
+func (s *KVServer) DropConnections() {
+	for _, c := range s.takeConns() {
+		c.conn.Close()
+	}
+}
+
+// Terminate sends TERM to every client, as shrmpl-kv-srv does when it
+// shuts down, and closes their connections. The server keeps accepting
+// new connections, so clients can reconnect as if it had restarted.
+func (s *KVServer) Terminate() {
+	for _, c := range s.takeConns() {
+		_ = c.write("TERM\n")
+		c.conn.Close()
+	}
+}
+
+// Close stops the server and closes all connections
+func (s *KVServer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// takeConns removes and returns all tracked connections
+func (s *KVServer) takeConns() []*kvConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*kvConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.conns = make(map[*kvConn]struct{})
+	return conns
+}
+
+// serve accepts connections until the listener is closed
+func (s *KVServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.dials.Add(1)
+		c := &kvConn{conn: conn}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle answers one connection's commands until it closes
+func (s *KVServer) handle(c *kvConn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if i := strings.LastIndex(line, kvTagAnnotation); i > 0 &&
+			!strings.ContainsAny(line[i+1:], " \t") {
+			s.mu.Lock()
+			s.tags = append(s.tags, line[i+len(kvTagAnnotation):])
+			s.mu.Unlock()
+			line = line[:i]
+		}
+		if err := c.write(s.respond(c, line)); err != nil {
+			return
+		}
+	}
+}
+
+// respond returns the complete response to line, including newlines
+func (s *KVServer) respond(c *kvConn, line string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.beats {
+		return "UPONG\n" + s.respondLocked(c, line)
+	}
+	return s.respondLocked(c, line)
+}
+
+// respondLocked is respond without heartbeats. s.mu must be held.
+func (s *KVServer) respondLocked(c *kvConn, line string) string {
+	parts := strings.Fields(line)
+	verb := strings.ToUpper(parts[0])
+	if c.multi {
+		switch verb {
+		case "EXEC":
+			c.multi = false
+			if s.abortTxn {
+				s.abortTxn = false
+				c.queue = nil
+				return "*ABORTED*\n"
+			}
+			results := make([]string, len(c.queue))
+			for i, cmd := range c.queue {
+				results[i] = s.exec(strings.Fields(cmd))
+			}
+			c.queue = nil
+			return strings.Join(results, ";") + "\n"
+		case "DISCARD":
+			c.multi = false
+			c.queue = nil
+			return "OK\n"
+		case "GET", "SET", "INCR", "DEL", "CAS", "TOUCH", "TTL":
+			if len(parts) > 1 && len(parts[1]) > s.maxKey {
+				return "ERROR invalid length\n"
+			}
+			c.queue = append(c.queue, line)
+			return "QUEUED\n"
+		default:
+			return "ERROR unknown command\n"
+		}
+	}
+
+	switch verb {
+	case "BATCH":
+		var commands []string
+		for _, cmd := range strings.Split(strings.TrimSpace(line[len("BATCH"):]), ";") {
+			if cmd = strings.TrimSpace(cmd); cmd != "" {
+				commands = append(commands, cmd)
+			}
+		}
+		if len(commands) > kvMaxBatch {
+			return "ERROR too many commands\n"
+		}
+		results := make([]string, len(commands))
+		for i, cmd := range commands {
+			results[i] = s.exec(strings.Fields(cmd))
+		}
+		return strings.Join(results, ";") + "\n"
+	case "MULTI":
+		c.multi = true
+		return "OK\n"
+	case "LIST":
+		return s.list(false)
+	case "LISTX":
+		return s.list(true)
+	default:
+		return s.exec(parts) + "\n"
+	}
+}
+
+// exec runs a single command and returns its response line. s.mu must
+// be held.
+func (s *KVServer) exec(parts []string) string {
+	verb := strings.ToUpper(parts[0])
+	args := parts[1:]
+	if len(args) > 0 && len(args[0]) > s.maxKey {
+		return "ERROR invalid length"
+	}
+
+	switch verb {
+	case "PING":
+		return "PONG"
+	case "HELLO":
+		return fmt.Sprintf("HELLO batch=%d commands=%s tags=1 checksums=1 maxkey=%d maxvalue=%d",
+			kvMaxBatch, strings.Join(kvCommands, ","), s.maxKey, s.maxValue)
+	case "DBSIZE":
+		n := 0
+		for key := range s.data {
+			if _, ok := s.lookup(key); ok {
+				n++
+			}
+		}
+		return strconv.Itoa(n)
+	case "GET", "GETX":
+		if len(args) != 1 {
+			return "ERROR invalid arguments"
+		}
+		e, ok := s.lookup(args[0])
+		switch {
+		case !ok && s.evicted[args[0]]:
+			return "*KEY EVICTED*"
+		case !ok:
+			return "*KEY NOT FOUND*"
+		case verb == "GETX":
+			return s.checksummed(e.value)
+		}
+		return e.value
+	case "SET":
+		return s.set(args)
+	case "INCR":
+		if len(args) < 1 || len(args) > 2 {
+			return "ERROR invalid arguments"
+		}
+		e, _ := s.lookup(args[0])
+		n, _ := strconv.Atoi(e.value)
+		e.value = strconv.Itoa(n + 1)
+		if len(args) == 2 {
+			expires, ok := expiry(args[1])
+			if !ok {
+				return "ERROR invalid expiration"
+			}
+			e.expires = expires
+		}
+		s.data[args[0]] = e
+		delete(s.evicted, args[0])
+		return e.value
+	case "DEL":
+		if len(args) != 1 {
+			return "ERROR invalid arguments"
+		}
+		if _, ok := s.lookup(args[0]); !ok {
+			return "*KEY NOT FOUND*"
+		}
+		delete(s.data, args[0])
+		return "OK"
+	case "CAS":
+		if len(args) < 3 || len(args) > 4 {
+			return "ERROR invalid arguments"
+		}
+		e, ok := s.lookup(args[0])
+		if !ok || e.value != args[1] {
+			return "*NOT SET*"
+		}
+		e.value = args[2]
+		if len(args) == 4 {
+			expires, ok := expiry(args[3])
+			if !ok {
+				return "ERROR invalid expiration"
+			}
+			e.expires = expires
+		}
+		s.data[args[0]] = e
+		return "OK"
+	case "TOUCH":
+		if len(args) != 2 {
+			return "ERROR invalid arguments"
+		}
+		e, ok := s.lookup(args[0])
+		if !ok {
+			return "*KEY NOT FOUND*"
+		}
+		expires, ok := expiry(args[1])
+		if !ok {
+			return "ERROR invalid expiration"
+		}
+		e.expires = expires
+		s.data[args[0]] = e
+		return "OK"
+	case "TTL":
+		if len(args) != 1 {
+			return "ERROR invalid arguments"
+		}
+		e, ok := s.lookup(args[0])
+		switch {
+		case !ok:
+			return "*KEY NOT FOUND*"
+		case e.expires.IsZero():
+			return "-1"
+		}
+		return strconv.FormatInt(time.Until(e.expires).Milliseconds(), 10)
+	default:
+		return "ERROR unknown command"
+	}
+}
+
+// set handles SET key value [ttl] [NX] [XX] [GET] [KEEPTTL]
+func (s *KVServer) set(args []string) string {
+	if len(args) < 2 {
+		return "ERROR invalid arguments"
+	}
+	key, value := args[0], args[1]
+	if len(value) > s.maxValue {
+		return "ERROR invalid length"
+	}
+	var nx, xx, get, keepTTL bool
+	var expires time.Time
+	for _, flag := range args[2:] {
+		switch strings.ToUpper(flag) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		default:
+			var ok bool
+			if expires, ok = expiry(flag); !ok {
+				return "ERROR invalid expiration"
+			}
+		}
+	}
+
+	old, exists := s.lookup(key)
+	if (nx && exists) || (xx && !exists) {
+		return "*NOT SET*"
+	}
+	if keepTTL {
+		expires = old.expires
+	}
+	s.data[key] = kvEntry{value: value, expires: expires}
+	delete(s.evicted, key)
+	if get && exists {
+		return "OK " + old.value
+	}
+	return "OK"
+}
+
+// list returns the LIST response: one "key=value,expiration" line per
+// key, terminated by an empty line. LISTX prefixes each line with its
+// checksum.
+func (s *KVServer) list(checksummed bool) string {
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if _, ok := s.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		e := s.data[key]
+		expiration := "no-expiration"
+		if !e.expires.IsZero() {
+			expiration = strconv.FormatInt(e.expires.Unix(), 10)
+		}
+		line := fmt.Sprintf("%s=%s,%s", key, e.value, expiration)
+		if checksummed {
+			line = s.checksummed(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// lookup returns the entry for key, deleting it if it has expired. s.mu
+// must be held.
+func (s *KVServer) lookup(key string) (kvEntry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(s.data, key)
+		return kvEntry{}, false
+	}
+	return e, ok
+}
+
+// expiry parses a TTL the way the server does
+func expiry(ttl string) (time.Time, bool) {
+	d, err := shrmpl.ParseTTL(ttl)
+	if err != nil || d <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(d), true
+}
@@ -0,0 +1,240 @@
+package shrmpltest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogFrame is one message received by LogServer
+type LogFrame struct {
+	Level   string
+	Host    string
+	Code    string
+	Message string
+	// Fields holds the v2 JSON fields; nil for v1 frames
+	Fields map[string]interface{}
+	// Raw is the frame as received, without the newline
+	Raw string
+}
+
+// logHeaderWidth is the width in runes of "LVL HOST CODE LEN" before ": "
+const logHeaderWidth = 4 + 1 + 32 + 1 + 12 + 1 + 5
+
+// LogServer is a shrmpl-log server that records every frame it receives.
+// Like shrmpl-log-srv it ignores HELLO unless AcceptV2 is enabled.
+type LogServer struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener net.Listener
+	dials    atomic.Int64
+	acceptV2 atomic.Bool
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	frames  []LogFrame
+	invalid []string
+	conns   map[net.Conn]struct{}
+	notify  chan struct{}
+	closed  bool
+}
+
+// NewLogServer starts a log server on a random loopback port. It panics
+// if it cannot listen, like httptest.NewServer.
+func NewLogServer() *LogServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("shrmpltest: failed to listen: %v", err))
+	}
+	s := &LogServer{
+		Addr:     ln.Addr().String(),
+		listener: ln,
+		conns:    make(map[net.Conn]struct{}),
+		notify:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// AcceptV2 makes the server answer "HELLO 2" with "OK 2", so clients using
+// ProtocolAuto switch to v2 frames
+func (s *LogServer) AcceptV2(accept bool) {
+	s.acceptV2.Store(accept)
+}
+
+// Dials returns the number of connections accepted so far
+func (s *LogServer) Dials() int {
+	return int(s.dials.Load())
+}
+
+// Frames returns the frames received so far, in arrival order
+func (s *LogServer) Frames() []LogFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LogFrame(nil), s.frames...)
+}
+
+// Invalid returns the lines that could not be parsed as frames
+func (s *LogServer) Invalid() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.invalid...)
+}
+
+// WaitFrames waits until at least n frames have arrived and returns them.
+// Frames travel asynchronously, so tests should wait rather than read
+// Frames right after logging.
+func (s *LogServer) WaitFrames(n int, timeout time.Duration) ([]LogFrame, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.frames) >= n {
+			frames := append([]LogFrame(nil), s.frames...)
+			s.mu.Unlock()
+			return frames, nil
+		}
+		notify := s.notify
+		got := len(s.frames)
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return nil, fmt.Errorf("received %d of %d log frames within %s", got, n, timeout)
+		}
+	}
+}
+
+// DropConnections closes every client connection without notice
+func (s *LogServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = make(map[net.Conn]struct{})
+}
+
+// Close stops the server and closes all connections
+func (s *LogServer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// serve accepts connections until the listener is closed
+func (s *LogServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.dials.Add(1)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle records one connection's frames until it closes
+func (s *LogServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "HELLO ") {
+			if s.acceptV2.Load() && line == "HELLO 2" {
+				_, _ = conn.Write([]byte("OK 2\n"))
+			}
+			continue
+		}
+		s.record(line)
+	}
+}
+
+// record parses line and wakes WaitFrames callers
+func (s *LogServer) record(line string) {
+	frame, err := parseLogFrame(line)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.invalid = append(s.invalid, line)
+		return
+	}
+	s.frames = append(s.frames, frame)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// parseLogFrame parses a v1 or v2 frame:
+//
+//	LVL HOST CODE LEN: MSG [FLEN: {"key":"value"}]
+//
+// Header widths count runes; LEN and FLEN count bytes.
+func parseLogFrame(line string) (LogFrame, error) {
+	frame := LogFrame{Raw: line}
+	runes := []rune(line)
+	if len(runes) < logHeaderWidth+2 || string(runes[logHeaderWidth:logHeaderWidth+2]) != ": " {
+		return frame, fmt.Errorf("short or malformed header")
+	}
+	frame.Level = string(runes[0:4])
+	frame.Host = strings.TrimRight(string(runes[5:37]), " ")
+	frame.Code = strings.TrimRight(string(runes[38:50]), " ")
+	n, err := strconv.Atoi(string(runes[51:56]))
+	if err != nil {
+		return frame, fmt.Errorf("invalid message length: %w", err)
+	}
+
+	rest := string(runes[logHeaderWidth+2:])
+	if n > len(rest) {
+		return frame, fmt.Errorf("message shorter than its length %d", n)
+	}
+	frame.Message, rest = rest[:n], rest[n:]
+	if rest == "" {
+		return frame, nil
+	}
+
+	flen, fields, ok := strings.Cut(strings.TrimPrefix(rest, " "), ": ")
+	if !ok {
+		return frame, fmt.Errorf("malformed fields")
+	}
+	if size, err := strconv.Atoi(flen); err != nil || size != len(fields) {
+		return frame, fmt.Errorf("fields length %q does not match %d bytes", flen, len(fields))
+	}
+	if err := json.Unmarshal([]byte(fields), &frame.Fields); err != nil {
+		return frame, fmt.Errorf("invalid fields: %w", err)
+	}
+	return frame, nil
+}
@@ -0,0 +1,232 @@
+package shrmpltest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shrmpl"
+)
+
+// VaultServer is an HTTPS shrmpl-vault server built on httptest. Like
+// shrmpl-vault-srv it requires a client certificate and a known secret
+// and only answers GET.
+type VaultServer struct {
+	*httptest.Server
+
+	// Secret is the only secret the server accepts
+	Secret string
+	// CertPath and KeyPath hold a client certificate the server accepts
+	CertPath string
+	KeyPath  string
+	// CAPath holds the server's certificate in PEM form, for clients that
+	// load their trusted roots from a file
+	CAPath string
+
+	dir      string
+	requests atomic.Int64
+	resumed  atomic.Int64
+
+	mu     sync.Mutex
+	files  map[string]string
+	signer ed25519.PrivateKey
+	delay  time.Duration
+	etags  bool
+}
+
+// NewVaultServer starts a vault server accepting secret. The client
+// certificate and CA files live in a temporary directory removed by
+// Close. It panics on setup failures, like httptest.NewTLSServer.
+func NewVaultServer(secret string) *VaultServer {
+	dir, err := os.MkdirTemp("", "shrmpltest-vault-")
+	if err != nil {
+		panic(fmt.Sprintf("shrmpltest: %v", err))
+	}
+	s := &VaultServer{
+		Secret:   secret,
+		CertPath: filepath.Join(dir, "client.crt"),
+		KeyPath:  filepath.Join(dir, "client.key"),
+		CAPath:   filepath.Join(dir, "ca.crt"),
+		dir:      dir,
+		files:    make(map[string]string),
+	}
+	if err := writeClientCert(s.CertPath, s.KeyPath); err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("shrmpltest: %v", err))
+	}
+
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.Server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.Server.StartTLS()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(s.CAPath, ca, 0o600); err != nil {
+		s.Close()
+		panic(fmt.Sprintf("shrmpltest: %v", err))
+	}
+	return s
+}
+
+// ServeETags makes the server send an ETag with every file and answer 304
+// to a matching If-None-Match, as a caching proxy in front of
+// shrmpl-vault-srv would
+func (s *VaultServer) ServeETags(enable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etags = enable
+}
+
+// SetFile serves content under name
+func (s *VaultServer) SetFile(name, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = content
+}
+
+// RemoveFile stops serving name, which is then answered with 404
+func (s *VaultServer) RemoveFile(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, name)
+}
+
+// SignWith signs every file served from now on with key, as a vault
+// configured for signatures does
+func (s *VaultServer) SignWith(key ed25519.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signer = key
+}
+
+// SetDelay holds every response for d, so tests can overlap requests
+func (s *VaultServer) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Requests returns the number of requests that completed a TLS handshake
+func (s *VaultServer) Requests() int {
+	return int(s.requests.Load())
+}
+
+// Resumed returns the number of requests that arrived on a connection
+// whose TLS handshake resumed an earlier session
+func (s *VaultServer) Resumed() int {
+	return int(s.resumed.Load())
+}
+
+// TLSConfig returns a client TLS configuration trusting the server
+func (s *VaultServer) TLSConfig() *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	return &tls.Config{RootCAs: roots}
+}
+
+// NewClient returns a vault client for the server with the accepted
+// certificate and secret. It is not yet connected.
+func (s *VaultServer) NewClient() *shrmpl.VaultClient {
+	client := shrmpl.NewVaultClient(s.URL, s.CertPath, s.KeyPath, s.Secret)
+	client.SetTLSConfig(s.TLSConfig())
+	return client
+}
+
+// Close stops the server and removes its certificate files
+func (s *VaultServer) Close() {
+	s.Server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// serveHTTP answers like shrmpl-vault-srv: 405 for anything but GET, 401
+// for a missing or unknown secret and 404 for unknown files
+func (s *VaultServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if r.TLS != nil && r.TLS.DidResume {
+		s.resumed.Add(1)
+	}
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("secret") != s.Secret {
+		http.Error(w, "Invalid secret key", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	s.mu.Lock()
+	content, ok := s.files[name]
+	signer := s.signer
+	etags := s.etags
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if etags {
+		sum := sha256.Sum256([]byte(content))
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if signer != nil {
+		msg := append([]byte(name+"\n"), content...)
+		w.Header().Set("X-Shrmpl-Signature",
+			base64.StdEncoding.EncodeToString(ed25519.Sign(signer, msg)))
+	}
+	_, _ = w.Write([]byte(content))
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+func writeClientCert(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shrmpltest client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
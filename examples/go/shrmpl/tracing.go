@@ -0,0 +1,46 @@
+package shrmpl
+
+import "context"
+
+// Tracer starts spans around KV and vault operations. It is a thin seam
+// for tracing systems such as OpenTelemetry so that this package does not
+// depend on any of them; see the README for an OpenTelemetry adapter.
+type Tracer interface {
+	// StartSpan starts a span for operation name as a child of the trace
+	// context in ctx, if any
+	StartSpan(ctx context.Context, name string, attrs map[string]string) Span
+}
+
+// Span is an operation in progress
+type Span interface {
+	// End finishes the span, marking it failed when err is not nil
+	End(err error)
+}
+
+// redactedKey replaces keys in span attributes when redaction is enabled
+const redactedKey = "[redacted]"
+
+// noopSpan is used when no Tracer is configured
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+// startSpan starts a span with t, or returns a no-op span when t is nil
+func startSpan(ctx context.Context, t Tracer, name string,
+	attrs map[string]string) Span {
+	if t == nil {
+		return noopSpan{}
+	}
+	return t.StartSpan(ctx, name, attrs)
+}
+
+// startSpan starts a span for a KV operation on key
+func (kv *KV) startSpan(ctx context.Context, name, key string) Span {
+	if kv.config.Tracer == nil {
+		return noopSpan{}
+	}
+	if kv.config.RedactTraceKeys {
+		key = redactedKey
+	}
+	return kv.config.Tracer.StartSpan(ctx, name, map[string]string{"kv.key": key})
+}
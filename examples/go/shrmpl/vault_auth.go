@@ -0,0 +1,141 @@
+package shrmpl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Vault authentication modes for VaultClientConfig.AuthMode
+const (
+	// VaultAuthMTLSSecret authenticates with a client certificate and the
+	// secret query parameter, as shrmpl-vault-srv expects (the default)
+	VaultAuthMTLSSecret = "mtls-secret"
+	// VaultAuthBearer sends "Authorization: Bearer <token>" instead, for
+	// vaults behind a TLS-terminating proxy that client certificates
+	// cannot reach
+	VaultAuthBearer = "bearer"
+	// VaultAuthBoth sends the client certificate, the secret and the
+	// bearer token
+	VaultAuthBoth = "both"
+)
+
+// TokenProvider supplies bearer tokens. Token is called for every request,
+// so implementations should cache the token. refresh is true when the
+// server rejected the previous token as invalid or expired; the provider
+// should then obtain a new one instead of returning the cached token.
+type TokenProvider interface {
+	Token(ctx context.Context, refresh bool) (string, error)
+}
+
+// TokenProviderFunc adapts a function to TokenProvider
+type TokenProviderFunc func(ctx context.Context, refresh bool) (string, error)
+
+// Token calls f
+func (f TokenProviderFunc) Token(ctx context.Context, refresh bool) (string, error) {
+	return f(ctx, refresh)
+}
+
+// VaultClientConfig configures a VaultClient created by
+// NewVaultClientWithConfig
+type VaultClientConfig struct {
+	ServerURL string
+	// CertPath and KeyPath hold the client certificate; unused in
+	// VaultAuthBearer mode
+	CertPath string
+	KeyPath  string
+	// Secret is sent as the secret query parameter; unused in
+	// VaultAuthBearer mode
+	Secret string
+	// AuthMode is VaultAuthMTLSSecret (the default), VaultAuthBearer or
+	// VaultAuthBoth
+	AuthMode string
+	// TokenProvider supplies the bearer token; required by the bearer
+	// modes
+	TokenProvider TokenProvider
+	// InsecureAllowHTTP lets the bearer modes use an http:// ServerURL.
+	// Without it Connect refuses to send tokens in clear text.
+	InsecureAllowHTTP bool
+	// Cache, if set, persists fetched configs across restarts; see
+	// SetCache
+	Cache *VaultCacheOptions
+}
+
+// NewVaultClientWithConfig creates a vault client from cfg, loading the
+// cache if one is configured. The configuration is checked by Connect, so
+// an invalid one, or a cache directory that cannot be used, fails at
+// startup (or on first use with SetLazy).
+func NewVaultClientWithConfig(cfg VaultClientConfig) *VaultClient {
+	c := NewVaultClient(cfg.ServerURL, cfg.CertPath, cfg.KeyPath, cfg.Secret)
+	c.authMode = cfg.AuthMode
+	c.tokens = cfg.TokenProvider
+	c.insecureHTTP = cfg.InsecureAllowHTTP
+	if cfg.Cache != nil {
+		c.cacheErr = c.SetCache(*cfg.Cache)
+	}
+	return c
+}
+
+// checkAuth validates the authentication settings
+func (c *VaultClient) checkAuth() error {
+	switch c.authMode {
+	case "", VaultAuthMTLSSecret:
+		return nil
+	case VaultAuthBearer, VaultAuthBoth:
+	default:
+		return fmt.Errorf("invalid vault auth mode %q", c.authMode)
+	}
+	if c.tokens == nil {
+		return fmt.Errorf("vault auth mode %s requires a TokenProvider", c.authMode)
+	}
+	u, err := url.Parse(c.serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid vault URL: %w", err)
+	}
+	if u.Scheme != "https" && !c.insecureHTTP {
+		return fmt.Errorf("vault auth mode %s requires an https URL, got %q; "+
+			"set InsecureAllowHTTP to send tokens without TLS", c.authMode, c.serverURL)
+	}
+	return nil
+}
+
+// usesCertAndSecret reports whether the client certificate and secret are
+// sent
+func (c *VaultClient) usesCertAndSecret() bool {
+	return c.authMode != VaultAuthBearer
+}
+
+// usesBearer reports whether a bearer token is sent
+func (c *VaultClient) usesBearer() bool {
+	return c.authMode == VaultAuthBearer || c.authMode == VaultAuthBoth
+}
+
+// authorize adds the bearer token to req, asking the provider for a new
+// one when refresh is set
+func (c *VaultClient) authorize(req *http.Request, refresh bool) error {
+	if !c.usesBearer() {
+		return nil
+	}
+	token, err := c.tokens.Token(req.Context(), refresh)
+	if err != nil {
+		return fmt.Errorf("bearer token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// tokenRejected reports whether a 401 response rejected the bearer token
+// itself (RFC 6750 invalid_token: expired, revoked or malformed), which a
+// refreshed token may fix, rather than the credentials as a whole
+func tokenRejected(resp *http.Response) bool {
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		if strings.EqualFold(scheme, "Bearer") &&
+			strings.Contains(params, `error="invalid_token"`) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package shrmpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ThisAppVaultInterface defines the config access used by this
+// application. It is satisfied by both the online VaultClient and a
+// BundleVaultClient reading an exported bundle.
+type ThisAppVaultInterface interface {
+	GetConfig(filename string) (string, error)
+	GetConfigContext(ctx context.Context, filename string) (string, error)
+	StatConfig(filename string) (ConfigInfo, error)
+	StatConfigContext(ctx context.Context, filename string) (ConfigInfo, error)
+	ListConfigs() ([]string, error)
+	Close()
+}
+
+// ErrVaultNotFound is returned when a config file does not exist
+var ErrVaultNotFound = errors.New("file not found")
+
+// bundleVersion is the format version written by ExportBundle
+const bundleVersion = 1
+
+// BundleManifest describes the files in an exported bundle
+type BundleManifest struct {
+	Version   int          `json:"version"`
+	Source    string       `json:"source"`
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []BundleFile `json:"files"`
+}
+
+// BundleFile is one manifest entry
+type BundleFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+	// Signature is the vault's X-Shrmpl-Signature for the file, if any
+	Signature string `json:"signature,omitempty"`
+}
+
+// bundleDocument is the on-disk JSON bundle
+type bundleDocument struct {
+	Manifest BundleManifest    `json:"manifest"`
+	Contents map[string]string `json:"contents"`
+}
+
+// ExportBundle fetches filenames and writes them, with a manifest of
+// SHA-256 digests, to a single JSON bundle at destPath for use with
+// NewVaultClientFromBundle. Nothing is written unless every file is
+// fetched.
+func (c *VaultClient) ExportBundle(filenames []string, destPath string) error {
+	doc := bundleDocument{
+		Manifest: BundleManifest{
+			Version:   bundleVersion,
+			Source:    c.serverURL,
+			CreatedAt: time.Now().UTC(),
+		},
+		Contents: make(map[string]string, len(filenames)),
+	}
+	for _, name := range filenames {
+		content, signature, err := c.fetch(context.Background(), name)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", name, err)
+		}
+		doc.Manifest.Files = append(doc.Manifest.Files, BundleFile{
+			Name:      name,
+			SHA256:    digest(content),
+			Size:      len(content),
+			Signature: signature,
+		})
+		doc.Contents[name] = content
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename so a failed export never leaves a partial bundle,
+	// nor the temporary file behind
+	tmp := destPath + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0o600)
+	if err == nil {
+		err = os.Rename(tmp, destPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// digest returns the hex SHA-256 of content
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListConfigs is not supported by shrmpl-vault, which has no listing
+// endpoint; it is provided to satisfy ThisAppVaultInterface
+func (c *VaultClient) ListConfigs() ([]string, error) {
+	return nil, fmt.Errorf("shrmpl-vault does not support listing configs")
+}
+
+// BundleVaultClient serves configs from a bundle written by ExportBundle
+type BundleVaultClient struct {
+	manifest BundleManifest
+	contents map[string]string
+}
+
+// NewVaultClientFromBundle loads the bundle at path. Every file is checked
+// against its manifest digest, so a corrupted or edited bundle is rejected
+// here rather than served.
+func NewVaultClientFromBundle(path string) (*BundleVaultClient, error) {
+	return NewVaultClientFromBundleVerified(path, nil)
+}
+
+// NewVaultClientFromBundleVerified is NewVaultClientFromBundle that also
+// checks every file's stored vault signature with v, rejecting the bundle
+// with an error wrapping ErrSignatureInvalid if any file fails. A nil v
+// skips signature checks.
+func NewVaultClientFromBundleVerified(path string, v Verifier) (*BundleVaultClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc bundleDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", path, err)
+	}
+	if doc.Manifest.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", doc.Manifest.Version)
+	}
+
+	contents := make(map[string]string, len(doc.Manifest.Files))
+	for _, f := range doc.Manifest.Files {
+		content, ok := doc.Contents[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle %s: %s listed in manifest but missing", path, f.Name)
+		}
+		if digest(content) != f.SHA256 {
+			return nil, fmt.Errorf("bundle %s: digest mismatch for %s", path, f.Name)
+		}
+		if v != nil {
+			if err := v.Verify(f.Name, []byte(content), f.Signature); err != nil {
+				return nil, fmt.Errorf("bundle %s: %w", path, err)
+			}
+		}
+		contents[f.Name] = content
+	}
+
+	return &BundleVaultClient{manifest: doc.Manifest, contents: contents}, nil
+}
+
+// Manifest returns the bundle's manifest
+func (b *BundleVaultClient) Manifest() BundleManifest {
+	return b.manifest
+}
+
+// GetConfig returns a config file from the bundle
+func (b *BundleVaultClient) GetConfig(filename string) (string, error) {
+	content, ok := b.contents[filename]
+	if !ok {
+		return "", ErrVaultNotFound
+	}
+	return content, nil
+}
+
+// GetConfigContext returns a config file from the bundle; ctx is unused
+// since no I/O is involved
+func (b *BundleVaultClient) GetConfigContext(_ context.Context,
+	filename string) (string, error) {
+	return b.GetConfig(filename)
+}
+
+// ListConfigs returns the names of the files in the bundle, sorted
+func (b *BundleVaultClient) ListConfigs() ([]string, error) {
+	names := make([]string, 0, len(b.contents))
+	for name := range b.contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Close does nothing; it is provided to satisfy ThisAppVaultInterface
+func (b *BundleVaultClient) Close() {}
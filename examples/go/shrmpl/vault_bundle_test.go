@@ -0,0 +1,48 @@
+package shrmpl_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestExportBundleRemovesTempFileOnFailure(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	srv.SetFile("app.conf", "A=1")
+	client := srv.NewClient()
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// A non-empty directory at the destination makes the rename fail
+	dest := filepath.Join(dir, "configs.bundle.json")
+	if err := os.MkdirAll(filepath.Join(dest, "occupied"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ExportBundle([]string{"app.conf"}, dest); err == nil {
+		t.Fatal("ExportBundle over a directory succeeded")
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	dest = filepath.Join(dir, "ok.bundle.json")
+	if err := client.ExportBundle([]string{"app.conf"}, dest); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := shrmpl.NewVaultClientFromBundle(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := bundle.GetConfig("app.conf"); err != nil || content != "A=1" {
+		t.Errorf("GetConfig from the bundle = %q, %v; want A=1", content, err)
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
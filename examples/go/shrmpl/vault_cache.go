@@ -0,0 +1,295 @@
+package shrmpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheMaxBytes bounds the cache when VaultCacheOptions.MaxBytes is
+// zero
+const defaultCacheMaxBytes = 64 << 20
+
+// cacheTouchInterval limits how often a revalidated entry's fetch time is
+// written back, so frequent GetConfig calls do not rewrite it every time
+const cacheTouchInterval = time.Minute
+
+// cacheEntryExt is the extension of cache entry files
+const cacheEntryExt = ".json"
+
+// VaultCacheOptions configures the persistent response cache; see SetCache
+type VaultCacheOptions struct {
+	// Dir holds one file per cached config. Several clients and processes
+	// may share it.
+	Dir string
+	// MaxBytes bounds the total size of the cached bodies; the entries
+	// fetched longest ago are evicted first. Zero means 64 MiB.
+	MaxBytes int64
+	// MaxAge discards entries not fetched or revalidated for this long;
+	// zero keeps them until evicted by size
+	MaxAge time.Duration
+	// Logger, if set, is warned about every corrupt entry discarded
+	Logger ThisAppLoggerInterface
+}
+
+// vaultCacheEntry is the content of one cache entry file
+type vaultCacheEntry struct {
+	Name         string    `json:"name"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Signature    string    `json:"signature,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	SHA256       string    `json:"sha256"`
+	Body         string    `json:"body"`
+}
+
+// vaultCache is the persistent cache of a VaultClient
+type vaultCache struct {
+	opts VaultCacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*vaultCacheEntry
+	size    int64
+}
+
+// SetCache persists fetched configs with their ETag in opts.Dir, so
+// GetConfig revalidates them with If-None-Match after a restart instead
+// of downloading them again; a 304 answer serves the cached body. The
+// directory is created if needed and the entries already in it are
+// loaded now. Each entry is checked against its SHA-256 digest; corrupt
+// entries are deleted with a warning to opts.Logger. Only responses with
+// an ETag or Last-Modified are cached. Entries are written to a temporary
+// file and renamed into place, so clients sharing the directory never see
+// a partial entry; the last writer of an entry wins. Call it before the
+// client is shared.
+func (c *VaultClient) SetCache(opts VaultCacheOptions) error {
+	if opts.Dir == "" {
+		return fmt.Errorf("vault cache directory is required")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultCacheMaxBytes
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return fmt.Errorf("vault cache: %w", err)
+	}
+	cache := &vaultCache{opts: opts, entries: make(map[string]*vaultCacheEntry)}
+	if err := cache.load(); err != nil {
+		return fmt.Errorf("vault cache: %w", err)
+	}
+	c.cache = cache
+	return nil
+}
+
+// fetchCached is fetchOnce revalidating against the cache
+func (c *VaultClient) fetchCached(ctx context.Context, filename string) (string, string, error) {
+	var prev configVersion
+	if entry := c.cache.get(filename); entry != nil {
+		prev = configVersion{
+			content:      entry.Body,
+			etag:         entry.ETag,
+			lastModified: entry.LastModified,
+			signature:    entry.Signature,
+		}
+		// The digest only catches corruption; the signature also catches
+		// a cache file rewritten by someone else
+		if c.verifier != nil &&
+			c.verifier.Verify(filename, []byte(entry.Body), entry.Signature) != nil {
+			c.cache.remove(filename)
+			prev = configVersion{}
+		}
+	}
+	next, _, err := c.fetchIfChanged(ctx, filename, prev)
+	if err != nil {
+		if errors.Is(err, ErrVaultNotFound) {
+			c.cache.remove(filename)
+		}
+		return "", "", err
+	}
+	if next.etag != "" || next.lastModified != "" {
+		c.cache.put(filename, next)
+	}
+	return next.content, next.signature, nil
+}
+
+// path returns the entry file of filename. Names are hashed so that any
+// vault file name maps to a plain file name.
+func (vc *vaultCache) path(filename string) string {
+	return filepath.Join(vc.opts.Dir, digest(filename)+cacheEntryExt)
+}
+
+// load reads every entry in the directory, discarding corrupt and expired
+// ones, and evicts down to MaxBytes
+func (vc *vaultCache) load() error {
+	files, err := os.ReadDir(vc.opts.Dir)
+	if err != nil {
+		return err
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), cacheEntryExt) ||
+			strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(vc.opts.Dir, f.Name())
+		entry, err := readCacheEntry(path)
+		if err == nil && vc.path(entry.Name) != path {
+			err = fmt.Errorf("entry for %q stored under the wrong name", entry.Name)
+		}
+		if err != nil {
+			vc.discard(path, err)
+			continue
+		}
+		if vc.expired(entry) {
+			os.Remove(path)
+			continue
+		}
+		vc.entries[entry.Name] = entry
+		vc.size += int64(len(entry.Body))
+	}
+	vc.evict()
+	return nil
+}
+
+// readCacheEntry reads and verifies the entry file at path
+func readCacheEntry(path string) (*vaultCacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry vaultCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if digest(entry.Body) != entry.SHA256 {
+		return nil, fmt.Errorf("digest mismatch for %q", entry.Name)
+	}
+	return &entry, nil
+}
+
+// discard deletes a corrupt entry file
+func (vc *vaultCache) discard(path string, err error) {
+	os.Remove(path)
+	if vc.opts.Logger != nil {
+		vc.opts.Logger.Warn("VCCH", "discarding corrupt vault cache entry",
+			"path", path, "err", err)
+	}
+}
+
+// expired reports whether entry is older than MaxAge
+func (vc *vaultCache) expired(entry *vaultCacheEntry) bool {
+	return vc.opts.MaxAge > 0 && time.Since(entry.FetchedAt) > vc.opts.MaxAge
+}
+
+// get returns the entry of filename, reading it from the directory if
+// another client stored it since load
+func (vc *vaultCache) get(filename string) *vaultCacheEntry {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	entry, ok := vc.entries[filename]
+	if !ok {
+		path := vc.path(filename)
+		var err error
+		entry, err = readCacheEntry(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil
+		case err == nil && entry.Name != filename:
+			err = fmt.Errorf("entry for %q stored under the wrong name", entry.Name)
+		}
+		if err != nil {
+			vc.discard(path, err)
+			return nil
+		}
+		vc.entries[filename] = entry
+		vc.size += int64(len(entry.Body))
+	}
+	if vc.expired(entry) {
+		vc.drop(filename)
+		return nil
+	}
+	return entry
+}
+
+// put stores the fetched version of filename. An unchanged entry is only
+// rewritten to record its revalidation every cacheTouchInterval.
+func (vc *vaultCache) put(filename string, v configVersion) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	now := time.Now().UTC()
+	if old, ok := vc.entries[filename]; ok && old.Body == v.content &&
+		old.ETag == v.etag && old.LastModified == v.lastModified &&
+		now.Sub(old.FetchedAt) < cacheTouchInterval {
+		return
+	}
+	if int64(len(v.content)) > vc.opts.MaxBytes {
+		vc.drop(filename)
+		return
+	}
+
+	entry := &vaultCacheEntry{
+		Name:         filename,
+		ETag:         v.etag,
+		LastModified: v.lastModified,
+		Signature:    v.signature,
+		FetchedAt:    now,
+		SHA256:       digest(v.content),
+		Body:         v.content,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil || writeFileAtomic(vc.path(filename), data, 0o600) != nil {
+		// The cache is an optimization; the fetch itself succeeded
+		return
+	}
+	if old, ok := vc.entries[filename]; ok {
+		vc.size -= int64(len(old.Body))
+	}
+	vc.entries[filename] = entry
+	vc.size += int64(len(entry.Body))
+	vc.evict()
+}
+
+// remove deletes the entry of filename
+func (vc *vaultCache) remove(filename string) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.drop(filename)
+}
+
+// drop deletes the entry of filename. vc.mu must be held.
+func (vc *vaultCache) drop(filename string) {
+	if entry, ok := vc.entries[filename]; ok {
+		vc.size -= int64(len(entry.Body))
+		delete(vc.entries, filename)
+	}
+	os.Remove(vc.path(filename))
+}
+
+// evict drops the entries fetched longest ago until the cache fits
+// MaxBytes. vc.mu must be held.
+func (vc *vaultCache) evict() {
+	if vc.size <= vc.opts.MaxBytes {
+		return
+	}
+	byAge := make([]*vaultCacheEntry, 0, len(vc.entries))
+	for _, entry := range vc.entries {
+		byAge = append(byAge, entry)
+	}
+	sort.Slice(byAge, func(i, j int) bool {
+		return byAge[i].FetchedAt.Before(byAge[j].FetchedAt)
+	})
+	for _, entry := range byAge {
+		if vc.size <= vc.opts.MaxBytes {
+			return
+		}
+		vc.drop(entry.Name)
+	}
+}
@@ -1,21 +1,62 @@
 package shrmpl
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// VaultClient represents a client for the shrmpl-vault service
+// VaultClient represents a client for the shrmpl-vault service. It is
+// safe for concurrent use once configured; the Set* methods should be
+// called before the client is shared. Concurrent fetches of the same file
+// share a single request.
 type VaultClient struct {
 	serverURL string
 	certPath  string
 	keyPath   string
 	secret    string
-	client    *http.Client
+	lazy      bool
+	tracer    Tracer
+	// Retries of retryable failures; see SetRetries
+	retries    int
+	retryDelay time.Duration
+	tlsConfig  *tls.Config
+	verifier   Verifier
+
+	// Authentication; see VaultClientConfig
+	authMode     string
+	tokens       TokenProvider
+	insecureHTTP bool
+
+	// HTTP client and limiter; guarded by mu
+	mu      sync.Mutex
+	client  *http.Client
+	limiter *rateLimiter
+
+	// In-flight fetches shared by filename
+	fetches fetchGroup
+
+	// Set once the server answered 405 to a HEAD; see StatConfigContext
+	headUnsupported atomic.Bool
+
+	// Persistent response cache; see SetCache
+	cache    *vaultCache
+	cacheErr error
+
+	// Server-reported budget; see RateLimitStatus
+	statusMu    sync.Mutex
+	status      RateLimitStatus
+	lowFraction float64
+	onLow       func(status RateLimitStatus)
 }
 
 // NewVaultClient creates a new vault client
@@ -28,22 +69,72 @@ func NewVaultClient(serverURL, certPath, keyPath, secret string) *VaultClient {
 	}
 }
 
-// Connect establishes TLS connection to shrmpl-vault
+// SetLazy lets the first request call Connect instead of requiring an
+// explicit Connect. Certificate problems then surface on first use rather
+// than at startup.
+func (c *VaultClient) SetLazy(lazy bool) {
+	c.lazy = lazy
+}
+
+// SetTracer records a span for every GetConfigContext call
+func (c *VaultClient) SetTracer(tracer Tracer) {
+	c.tracer = tracer
+}
+
+// SetTLSConfig sets the TLS configuration used by Connect, e.g. to add
+// RootCAs or share a ClientSessionCache between clients. The client
+// certificate is added unless cfg already has one, and a session cache is
+// added if cfg has none. Call it before Connect.
+func (c *VaultClient) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// vaultIdleConns is the number of idle connections kept to the vault
+const vaultIdleConns = 4
+
+// Connect establishes TLS connection to shrmpl-vault. TLS sessions are
+// cached and connections kept alive, so frequent polling resumes sessions
+// instead of repeating full mTLS handshakes.
 func (c *VaultClient) Connect() (bool, error) {
-	// Load client certificates
-	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
-	if err != nil {
-		return false, fmt.Errorf("failed to load certificates: %v", err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect()
+}
+
+// connect builds the HTTP client. c.mu must be held.
+func (c *VaultClient) connect() (bool, error) {
+	if err := c.checkAuth(); err != nil {
+		return false, err
+	}
+	if c.cacheErr != nil {
+		return false, c.cacheErr
+	}
+
+	var tlsConfig *tls.Config
+	if c.tlsConfig != nil {
+		tlsConfig = c.tlsConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{}
 	}
 
-	// Create TLS config
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+	// Load client certificates
+	if c.usesCertAndSecret() && len(tlsConfig.Certificates) == 0 &&
+		tlsConfig.GetClientCertificate == nil {
+		cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+		if err != nil {
+			return false, fmt.Errorf("failed to load certificates: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
 	}
 
 	// Create HTTP client
 	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: vaultIdleConns,
+		IdleConnTimeout:     90 * time.Second,
 	}
 	c.client = &http.Client{
 		Transport: transport,
@@ -56,33 +147,599 @@ func (c *VaultClient) Connect() (bool, error) {
 
 // GetConfig retrieves a configuration file from shrmpl-vault
 func (c *VaultClient) GetConfig(filename string) (string, error) {
-	if c.client == nil {
-		return "", fmt.Errorf("not connected")
-	}
+	return c.GetConfigContext(context.Background(), filename)
+}
+
+// GetConfigContext retrieves a configuration file from shrmpl-vault,
+// aborting the request when ctx is done
+func (c *VaultClient) GetConfigContext(ctx context.Context,
+	filename string) (_ string, err error) {
+	span := startSpan(ctx, c.tracer, "vault.get_config",
+		map[string]string{"vault.file": filename})
+	defer func() { span.End(err) }()
+
+	content, _, err := c.fetch(ctx, filename)
+	return content, err
+}
+
+// fetch downloads filename and returns it with its signature header.
+// Concurrent fetches of the same file share one request; see fetchGroup.
+func (c *VaultClient) fetch(ctx context.Context, filename string) (string, string, error) {
+	return c.fetches.do(ctx, filename, c.fetchOnce)
+}
 
-	url := fmt.Sprintf("%s/%s?secret=%s", c.serverURL, filename, c.secret)
-	req, err := http.NewRequest("GET", url, nil)
+// fetchOnce downloads filename and returns it with its signature header,
+// verifying the signature when a Verifier is set
+func (c *VaultClient) fetchOnce(ctx context.Context, filename string) (string, string, error) {
+	if c.cache != nil {
+		return c.fetchCached(ctx, filename)
+	}
+	resp, err := c.do(ctx, http.MethodGet, filename, nil)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
+	defer resp.Body.Close()
 
-	resp, err := c.client.Do(req)
+	if resp.StatusCode != 200 {
+		// Drain short error bodies so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return "", "", vaultStatusError(resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case 200:
-		content, err := io.ReadAll(resp.Body)
-		return string(content), err
+	signature := resp.Header.Get(signatureHeader)
+	if c.verifier != nil {
+		if err := c.verifier.Verify(filename, content, signature); err != nil {
+			return "", "", err
+		}
+	}
+	return string(content), signature, nil
+}
+
+// do sends a request for filename, retrying transport failures and error
+// statuses whose class is Retryable as configured by SetRetries. A retried error response is
+// drained and closed; the last one is returned to the caller as is.
+func (c *VaultClient) do(ctx context.Context, method, filename string,
+	header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doOnce(ctx, method, filename, header)
+		var (
+			class    VaultErrorClass
+			vaultErr *VaultError
+		)
+		switch {
+		case errors.As(err, &vaultErr):
+			class = vaultErr.Class
+		case err != nil:
+			// Not a transport failure: the limiter, the credentials or
+			// the connection setup refused the request
+			return nil, err
+		case resp.StatusCode >= 400:
+			class = HTTPStatus(resp.StatusCode)
+		default:
+			return resp, nil
+		}
+		if attempt >= c.retries || !class.Retryable() || !waitRetry(ctx, c.retryDelay) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+	}
+}
+
+// doOnce sends one request for filename, applying the client-side rate
+// limit and recording the server's rate-limit headers
+func (c *VaultClient) doOnce(ctx context.Context, method, filename string,
+	header http.Header) (*http.Response, error) {
+	c.mu.Lock()
+	if c.client == nil && c.lazy {
+		if _, err := c.connect(); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+	client, limiter := c.client, c.limiter
+	c.mu.Unlock()
+	if client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	url := fmt.Sprintf("%s/%s", c.serverURL, filename)
+	if c.usesCertAndSecret() {
+		url += "?secret=" + c.secret
+	}
+
+	// A rejected bearer token is refreshed and the request sent once more;
+	// a second 401 means the credentials themselves are refused
+	for refresh := false; ; refresh = true {
+		if limiter != nil {
+			if err := limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if err := c.authorize(req, refresh); err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, &VaultError{Class: classifyTransportError(err), Err: err}
+		}
+
+		if limiter != nil {
+			if resp.StatusCode == 429 {
+				limiter.tighten()
+			} else {
+				limiter.relax()
+			}
+		}
+		c.observeRateLimit(resp.Header)
+
+		if !refresh && resp.StatusCode == 401 && c.usesBearer() && tokenRejected(resp) {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// vaultStatusError maps a non-success HTTP status to the VaultError
+// returned by GetConfig and StatConfig
+func vaultStatusError(status int) error {
+	var err error
+	switch status {
 	case 404:
-		return "", fmt.Errorf("file not found")
+		err = ErrVaultNotFound
 	case 401:
-		return "", fmt.Errorf("unauthorized - invalid certificate or secret")
+		err = fmt.Errorf("%w - invalid certificate, secret or token", ErrUnauthorized)
 	case 429:
-		return "", fmt.Errorf("rate limit exceeded")
+		err = fmt.Errorf("rate limit exceeded")
 	default:
-		return "", fmt.Errorf("HTTP error: %d", resp.StatusCode)
+		err = fmt.Errorf("HTTP error: %d", status)
+	}
+	return &VaultError{Class: HTTPStatus(status), Err: err}
+}
+
+// ErrUnauthorized is returned when the vault rejects the client
+// certificate or secret
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrRateLimited is returned by fail-fast rate limiters when no request
+// budget is available
+var ErrRateLimited = errors.New("client-side rate limit exceeded")
+
+// RateLimit configures the client-side request limiter. The server allows
+// about 10 requests per second per client certificate, so
+// RequestsPerSecond should be set a little below that, shared by every
+// client using the certificate.
+type RateLimit struct {
+	// RequestsPerSecond is the steady-state rate; it must be positive
+	RequestsPerSecond float64
+	// Burst is the number of requests allowed back to back
+	Burst int
+	// FailFast returns ErrRateLimited instead of waiting for budget
+	FailFast bool
+}
+
+// VaultStats is a snapshot of the client's limiter state
+type VaultStats struct {
+	RateLimited    bool
+	CurrentRate    float64
+	ConfiguredRate float64
+	Tokens         float64
+	Throttled      int
+	ServerRejected int
+}
+
+// rateLimiter is a token bucket whose rate halves on every 429 and
+// recovers gradually on successful responses
+type rateLimiter struct {
+	mu             sync.Mutex
+	cfg            RateLimit
+	rate           float64
+	tokens         float64
+	last           time.Time
+	throttled      int
+	serverRejected int
+}
+
+// SetRateLimit enables the client-side rate limiter for all requests. A
+// RequestsPerSecond that is not a positive finite number is rejected and
+// leaves the current limiter in place.
+func (c *VaultClient) SetRateLimit(cfg RateLimit) error {
+	if !(cfg.RequestsPerSecond > 0) || math.IsInf(cfg.RequestsPerSecond, 1) {
+		return fmt.Errorf("vault rate limit must be a positive number of requests per second, not %v",
+			cfg.RequestsPerSecond)
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	limiter := &rateLimiter{
+		cfg:    cfg,
+		rate:   cfg.RequestsPerSecond,
+		tokens: float64(cfg.Burst),
+		last:   time.Now(),
+	}
+	c.mu.Lock()
+	c.limiter = limiter
+	c.mu.Unlock()
+	return nil
+}
+
+// rateLimiter returns the configured limiter, or nil
+func (c *VaultClient) rateLimiter() *rateLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limiter
+}
+
+// Stats returns a snapshot of the client's limiter state
+func (c *VaultClient) Stats() VaultStats {
+	l := c.rateLimiter()
+	if l == nil {
+		return VaultStats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return VaultStats{
+		RateLimited:    true,
+		CurrentRate:    l.rate,
+		ConfiguredRate: l.cfg.RequestsPerSecond,
+		Tokens:         l.tokens,
+		Throttled:      l.throttled,
+		ServerRejected: l.serverRejected,
+	}
+}
+
+// refill adds the tokens accrued since the last refill
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if burst := float64(l.cfg.Burst); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+}
+
+// wait takes a token, blocking until one is available unless the limiter
+// is fail-fast
+func (l *rateLimiter) wait(ctx context.Context) error {
+	counted := false
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		if !counted {
+			l.throttled++
+			counted = true
+		}
+		if l.cfg.FailFast {
+			l.mu.Unlock()
+			return ErrRateLimited
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tighten halves the rate after the server rejected a request
+func (l *rateLimiter) tighten() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.serverRejected++
+	l.rate = math.Max(l.rate/2, l.cfg.RequestsPerSecond/64)
+	l.tokens = 0
+}
+
+// relax moves the rate back towards the configured rate
+func (l *rateLimiter) relax() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate < l.cfg.RequestsPerSecond {
+		l.refill(time.Now())
+		l.rate = math.Min(l.rate*1.1, l.cfg.RequestsPerSecond)
+	}
+}
+
+// RateLimitStatus is the server's request budget as last reported in the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers
+type RateLimitStatus struct {
+	// Known is false until a response carried the headers
+	Known     bool
+	Limit     int
+	Remaining int
+	// Reset is when the budget refills; zero if the server did not say
+	Reset     time.Time
+	UpdatedAt time.Time
+}
+
+// RateLimitStatus returns the budget reported by the latest response that
+// carried rate-limit headers
+func (c *VaultClient) RateLimitStatus() RateLimitStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.status
+}
+
+// SetOnRateLimitLow calls fn after any response leaving less than
+// fraction of the server's budget, so the application can shed optional
+// fetches. fn runs on the requesting goroutine.
+func (c *VaultClient) SetOnRateLimitLow(fraction float64, fn func(status RateLimitStatus)) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.lowFraction = fraction
+	c.onLow = fn
+}
+
+// parseRateLimitHeaders reads the rate-limit headers, reporting false when
+// the limit or remaining count is missing or malformed. Reset is accepted
+// both as seconds from now and as a Unix timestamp.
+func parseRateLimitHeaders(h http.Header, now time.Time) (RateLimitStatus, bool) {
+	limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return RateLimitStatus{}, false
+	}
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimitStatus{}, false
+	}
+	status := RateLimitStatus{
+		Known:     true,
+		Limit:     limit,
+		Remaining: remaining,
+		UpdatedAt: now,
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		if reset > 1e9 {
+			status.Reset = time.Unix(reset, 0)
+		} else {
+			status.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	return status, true
+}
+
+// observeRateLimit records the headers of a response, if present, and
+// feeds them to the limiter. Responses without them change nothing.
+func (c *VaultClient) observeRateLimit(h http.Header) {
+	now := time.Now()
+	status, ok := parseRateLimitHeaders(h, now)
+	if !ok {
+		return
+	}
+
+	c.statusMu.Lock()
+	c.status = status
+	onLow := c.onLow
+	low := status.Limit > 0 &&
+		float64(status.Remaining) < c.lowFraction*float64(status.Limit)
+	c.statusMu.Unlock()
+
+	if limiter := c.rateLimiter(); limiter != nil {
+		limiter.observeBudget(status, now)
+	}
+	if low && onLow != nil {
+		onLow(status)
+	}
+}
+
+// observeBudget slows the limiter to what the server says it can sustain
+// until the budget resets, and never holds more tokens than remain
+func (l *rateLimiter) observeBudget(status RateLimitStatus, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	if remaining := float64(status.Remaining); l.tokens > remaining {
+		l.tokens = remaining
+	}
+	if window := status.Reset.Sub(now).Seconds(); window > 0 {
+		sustainable := float64(status.Remaining) / window
+		if sustainable < l.rate {
+			l.rate = math.Max(sustainable, l.cfg.RequestsPerSecond/64)
+		}
+	}
+}
+
+// Close releases idle connections held by the vault HTTP client
+func (c *VaultClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
+}
+
+// ParseFunc converts the raw content of a config file into a typed value
+type ParseFunc func(content string) (interface{}, error)
+
+// Bundle is an immutable snapshot of parsed config files keyed by filename
+type Bundle map[string]interface{}
+
+// ConfigBundle fetches a set of config files, parses them and publishes
+// the parsed results atomically. Readers always see a complete bundle in
+// which every file parsed successfully.
+type ConfigBundle struct {
+	client  ThisAppVaultInterface
+	files   []string
+	parsers map[string]ParseFunc
+	raw     map[string]string
+	current atomic.Value
+	mu      sync.Mutex
+
+	// OnSwap is called with the changed filenames after a new bundle is
+	// published
+	OnSwap func(changed []string)
+	// OnError is called when a poll fails; the previous bundle stays live
+	OnError func(err error)
+}
+
+// NewConfigBundle creates an empty bundle backed by client
+func NewConfigBundle(client ThisAppVaultInterface) *ConfigBundle {
+	return &ConfigBundle{
+		client:  client,
+		parsers: make(map[string]ParseFunc),
+		raw:     make(map[string]string),
+	}
+}
+
+// Register adds a file and the function used to parse it. Register must
+// be called before Load.
+func (b *ConfigBundle) Register(filename string, parse ParseFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.parsers[filename]; !ok {
+		b.files = append(b.files, filename)
+	}
+	b.parsers[filename] = parse
+}
+
+// Get returns the current bundle, or nil before a successful Load
+func (b *ConfigBundle) Get() Bundle {
+	bundle, _ := b.current.Load().(Bundle)
+	return bundle
+}
+
+// Load fetches and parses every registered file. Nothing is published
+// unless all files succeed. The first Load checks that every file exists
+// before downloading any, so all misnamed files are reported together.
+func (b *ConfigBundle) Load(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Get() == nil {
+		if err := b.checkExists(ctx); err != nil {
+			return err
+		}
+	}
+
+	raw, err := b.fetchAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	bundle := make(Bundle, len(b.files))
+	for _, name := range b.files {
+		value, err := b.parsers[name](raw[name])
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", name, err)
+		}
+		bundle[name] = value
+	}
+
+	b.raw = raw
+	b.current.Store(bundle)
+	return nil
+}
+
+// Start polls the vault every interval until ctx is done, publishing a new
+// bundle whenever any file changes. A non-positive interval is reported to
+// OnError without polling.
+func (b *ConfigBundle) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		if b.OnError != nil {
+			b.OnError(fmt.Errorf("config bundle: interval must be positive, got %s", interval))
+		}
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := b.poll(ctx); err != nil && b.OnError != nil {
+					b.OnError(err)
+				}
+			}
+		}
+	}()
+}
+
+// poll refetches every file and swaps in a new bundle if any changed
+func (b *ConfigBundle) poll(ctx context.Context) error {
+	b.mu.Lock()
+
+	raw, err := b.fetchAll(ctx)
+	if err != nil {
+		b.mu.Unlock()
+		return err
+	}
+
+	previous := b.Get()
+	bundle := make(Bundle, len(b.files))
+	var changed []string
+	var parseErrs []error
+	for _, name := range b.files {
+		if old, ok := b.raw[name]; ok && old == raw[name] {
+			bundle[name] = previous[name]
+			continue
+		}
+		value, err := b.parsers[name](raw[name])
+		if err != nil {
+			parseErrs = append(parseErrs, fmt.Errorf("parse %s: %w", name, err))
+			continue
+		}
+		bundle[name] = value
+		changed = append(changed, name)
+	}
+
+	if len(parseErrs) > 0 {
+		b.mu.Unlock()
+		return errors.Join(parseErrs...)
+	}
+	if len(changed) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+
+	b.raw = raw
+	b.current.Store(bundle)
+	b.mu.Unlock()
+
+	if b.OnSwap != nil {
+		b.OnSwap(changed)
+	}
+	return nil
+}
+
+// fetchAll retrieves the raw content of every registered file
+func (b *ConfigBundle) fetchAll(ctx context.Context) (map[string]string, error) {
+	raw := make(map[string]string, len(b.files))
+	for _, name := range b.files {
+		content, err := b.client.GetConfigContext(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s: %w", name, err)
+		}
+		raw[name] = content
 	}
+	return raw, nil
 }
@@ -0,0 +1,112 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSetRateLimitRejectsNonPositiveRates(t *testing.T) {
+	c := &VaultClient{}
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := c.SetRateLimit(RateLimit{RequestsPerSecond: rate}); err == nil {
+			t.Errorf("SetRateLimit(%v) succeeded", rate)
+		}
+	}
+	if c.rateLimiter() != nil {
+		t.Errorf("a rejected rate installed a limiter")
+	}
+
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 5, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 0}); err == nil {
+		t.Fatal("SetRateLimit(0) succeeded")
+	}
+	if got := c.Stats().ConfiguredRate; got != 5 {
+		t.Errorf("a rejected rate replaced the limiter: configured rate %v", got)
+	}
+}
+
+func TestRateLimiterWaitsForTokens(t *testing.T) {
+	c := &VaultClient{}
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 50, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	l := c.rateLimiter()
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two from the burst, then two at 20ms each
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > time.Second {
+		t.Errorf("4 requests at 50/s with burst 2 took %s", elapsed)
+	}
+	if throttled := c.Stats().Throttled; throttled != 2 {
+		t.Errorf("throttled = %d, want 2", throttled)
+	}
+}
+
+func TestRateLimiterFailFastAndCancel(t *testing.T) {
+	c := &VaultClient{}
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 0.01, FailFast: true}); err != nil {
+		t.Fatal(err)
+	}
+	l := c.rateLimiter()
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.wait(context.Background()); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("fail-fast wait = %v, want ErrRateLimited", err)
+	}
+
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 0.01}); err != nil {
+		t.Fatal(err)
+	}
+	l = c.rateLimiter()
+	_ = l.wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait = %v, want the context's error", err)
+	}
+}
+
+func TestRateLimiterTightensAndRelaxes(t *testing.T) {
+	c := &VaultClient{}
+	if err := c.SetRateLimit(RateLimit{RequestsPerSecond: 8}); err != nil {
+		t.Fatal(err)
+	}
+	l := c.rateLimiter()
+	l.tighten()
+	if got := c.Stats().CurrentRate; got != 4 {
+		t.Errorf("rate after a 429 = %v, want 4", got)
+	}
+	for i := 0; i < 100; i++ {
+		l.relax()
+	}
+	if got := c.Stats().CurrentRate; got != 8 {
+		t.Errorf("rate after recovering = %v, want 8", got)
+	}
+}
+
+func TestConfigBundleStartRejectsNonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		b := NewConfigBundle(nil)
+		var reported error
+		b.OnError = func(err error) { reported = err }
+		// A nil client would panic if Start polled
+		b.Start(context.Background(), interval)
+		if reported == nil {
+			t.Errorf("Start(%s) reported no error", interval)
+		}
+	}
+	// Without OnError the interval is ignored silently
+	NewConfigBundle(nil).Start(context.Background(), 0)
+}
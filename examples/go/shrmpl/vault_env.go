@@ -0,0 +1,167 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envKeyPattern matches the variable names accepted in env files
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envEntry is one KEY=VALUE assignment and the line it was read from
+type envEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// parseEnv parses env-format content: KEY=VALUE lines with an optional
+// "export " prefix, blank lines and # comments. Values may be wrapped in
+// single or double quotes, which are removed. A key assigned twice is an
+// error. Errors carry the line number.
+func parseEnv(content string) ([]envEntry, error) {
+	var entries []envEntry
+	seen := make(map[string]int)
+	for i, line := range strings.Split(content, "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNo, key)
+		}
+		if first, dup := seen[key]; dup {
+			return nil, fmt.Errorf("line %d: %s already set on line %d", lineNo, key, first)
+		}
+		seen[key] = lineNo
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			if value[len(value)-1] != value[0] {
+				return nil, fmt.Errorf("line %d: unterminated quote in %s", lineNo, key)
+			}
+			value = value[1 : len(value)-1]
+		}
+		entries = append(entries, envEntry{key: key, value: value, line: lineNo})
+	}
+	return entries, nil
+}
+
+// ParseEnv parses env-format content (see BootstrapEnv) into a map
+func ParseEnv(content string) (map[string]string, error) {
+	entries, err := parseEnv(content)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(entries))
+	for _, e := range entries {
+		values[e.key] = e.value
+	}
+	return values, nil
+}
+
+// BootstrapEnvOptions controls BootstrapEnv
+type BootstrapEnvOptions struct {
+	// Apply exports every merged value with os.Setenv once all files
+	// have been fetched and parsed
+	Apply bool
+}
+
+// BootstrapEnv fetches the named env-format files in order and merges
+// them, later files overriding keys set by earlier ones. It returns the
+// merged values and, for debugging, the "file:line" each final value came
+// from. Files hold KEY=VALUE lines with an optional "export " prefix,
+// blank lines and # comments; values may be quoted. A key assigned twice
+// within one file and any parse error are reported with file and line.
+// Nothing is applied unless every file was fetched and parsed.
+func (c *VaultClient) BootstrapEnv(ctx context.Context, opts BootstrapEnvOptions,
+	filenames ...string) (values, sources map[string]string, err error) {
+	values, sources, err = c.mergeEnv(ctx, filenames, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Apply {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := os.Setenv(key, values[key]); err != nil {
+				return nil, nil, fmt.Errorf("setting %s from %s: %w", key, sources[key], err)
+			}
+		}
+	}
+	return values, sources, nil
+}
+
+// MergedConfigOptions controls GetMergedConfigWithOptions
+type MergedConfigOptions struct {
+	// SkipMissing treats every file as optional: files the vault reports
+	// as not found are skipped instead of failing the call
+	SkipMissing bool
+}
+
+// GetMergedConfig fetches the named env-format files in order and merges
+// them into one map, later files overriding keys set by earlier ones, e.g.
+// a base config overlaid by an environment-specific one:
+//
+//	cfg, err := vault.GetMergedConfig([]string{"app.env", "app.prod.env"})
+//
+// The files are parsed like BootstrapEnv's. Every file must exist; see
+// GetMergedConfigWithOptions for optional overlays.
+func (c *VaultClient) GetMergedConfig(filenames []string) (map[string]string, error) {
+	return c.GetMergedConfigWithOptions(context.Background(), filenames, MergedConfigOptions{})
+}
+
+// GetMergedConfigWithOptions is GetMergedConfig with a context and
+// options. Errors other than a skipped missing file, including a parse
+// error in any file, fail the whole call.
+func (c *VaultClient) GetMergedConfigWithOptions(ctx context.Context, filenames []string,
+	opts MergedConfigOptions) (map[string]string, error) {
+	values, _, err := c.mergeEnv(ctx, filenames, opts.SkipMissing)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// mergeEnv fetches and parses filenames in order, merging their entries
+// and recording the "file:line" each final value came from
+func (c *VaultClient) mergeEnv(ctx context.Context, filenames []string,
+	skipMissing bool) (values, sources map[string]string, err error) {
+	values = make(map[string]string)
+	sources = make(map[string]string)
+	for _, filename := range filenames {
+		content, err := c.GetConfigContext(ctx, filename)
+		if skipMissing && errors.Is(err, ErrVaultNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("fetching %s: %w", filename, err)
+		}
+		entries, err := parseEnv(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, e := range entries {
+			values[e.key] = e.value
+			sources[e.key] = fmt.Sprintf("%s:%d", filename, e.line)
+		}
+	}
+	return values, sources, nil
+}
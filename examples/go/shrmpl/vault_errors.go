@@ -0,0 +1,165 @@
+package shrmpl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// VaultErrorClass says why a vault request failed. HTTP statuses are
+// positive classes made with HTTPStatus; the other classes are negative
+// constants, and the zero value means the error was not recognized.
+type VaultErrorClass int
+
+// Vault error classes returned by ClassifyVaultError
+const (
+	// Unclassified errors are not transport or HTTP failures, or of a
+	// kind ClassifyVaultError does not know
+	Unclassified VaultErrorClass = 0
+	// DNSFailure means the vault host name could not be resolved
+	DNSFailure VaultErrorClass = -1
+	// DialFailure means the TCP connection could not be established,
+	// e.g. because the connection was refused
+	DialFailure VaultErrorClass = -2
+	// TLSHandshakeFailure means the TLS handshake failed for a reason
+	// other than the server's certificate, e.g. the server does not speak
+	// TLS or rejected the client certificate
+	TLSHandshakeFailure VaultErrorClass = -3
+	// CertVerificationFailure means the server's certificate could not be
+	// verified: an unknown authority, the wrong host name or expired
+	CertVerificationFailure VaultErrorClass = -4
+	// Timeout means the request, or a dial or read within it, timed out
+	Timeout VaultErrorClass = -5
+)
+
+// HTTPStatus returns the class of a request answered with status
+func HTTPStatus(status int) VaultErrorClass {
+	return VaultErrorClass(status)
+}
+
+// Status returns the HTTP status of an HTTPStatus class, or 0
+func (c VaultErrorClass) Status() int {
+	if c > 0 {
+		return int(c)
+	}
+	return 0
+}
+
+// String returns the class name, e.g. "dns" or "http-503"
+func (c VaultErrorClass) String() string {
+	switch c {
+	case Unclassified:
+		return "unclassified"
+	case DNSFailure:
+		return "dns"
+	case DialFailure:
+		return "dial"
+	case TLSHandshakeFailure:
+		return "tls-handshake"
+	case CertVerificationFailure:
+		return "cert-verification"
+	case Timeout:
+		return "timeout"
+	}
+	if c > 0 {
+		return fmt.Sprintf("http-%d", int(c))
+	}
+	return fmt.Sprintf("VaultErrorClass(%d)", int(c))
+}
+
+// Retryable reports whether a request that failed this way may succeed
+// if sent again; SetRetries and WatchConfig retry only such failures. DNS, dial and timeout failures, 408, 429 and 5xx
+// statuses and unrecognized errors are retryable; TLS and certificate
+// failures are misconfiguration and other statuses are the server's
+// answer, so retrying them is pointless.
+func (c VaultErrorClass) Retryable() bool {
+	switch c {
+	case TLSHandshakeFailure, CertVerificationFailure:
+		return false
+	case Unclassified, DNSFailure, DialFailure, Timeout:
+		return true
+	}
+	status := c.Status()
+	return status == 408 || status == 429 || status >= 500
+}
+
+// SetRetries makes every vault request retry a transport failure or an
+// error status whose class is Retryable up to retries more times, pausing
+// delay before each attempt. Other failures, such as a certificate error
+// or a 404, are returned at once, as is the last failure when the retries
+// run out or the context ends. Zero, the default, sends each request
+// once. Retries go through the rate limiter like the first attempt.
+func (c *VaultClient) SetRetries(retries int, delay time.Duration) {
+	c.retries = retries
+	c.retryDelay = delay
+}
+
+// VaultError is returned by vault requests that failed in transport or
+// with an error status. It wraps the underlying error, so errors.Is still
+// matches ErrVaultNotFound and ErrUnauthorized, and adds its class.
+type VaultError struct {
+	Class VaultErrorClass
+	Err   error
+}
+
+// Error returns the underlying error's message
+func (e *VaultError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *VaultError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyVaultError returns the class of err: that of a VaultError in
+// its chain, or else the class of the transport error it wraps. It
+// returns Unclassified for nil and errors it does not recognize.
+func ClassifyVaultError(err error) VaultErrorClass {
+	var vaultErr *VaultError
+	if errors.As(err, &vaultErr) {
+		return vaultErr.Class
+	}
+	return classifyTransportError(err)
+}
+
+// classifyTransportError classifies an error from http.Client.Do. DNS
+// errors come first since a lookup timeout is still a DNS failure. The
+// transport reports a plain HTTP server's tls.RecordHeaderError as
+// http.ErrSchemeMismatch.
+func classifyTransportError(err error) VaultErrorClass {
+	var (
+		dnsErr       *net.DNSError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		netErr       net.Error
+		opErr        *net.OpError
+	)
+	switch {
+	case err == nil:
+		return Unclassified
+	case errors.As(err, &dnsErr):
+		return DNSFailure
+	case errors.As(err, &verifyErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return CertVerificationFailure
+	case errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.Is(err, http.ErrSchemeMismatch):
+		return TLSHandshakeFailure
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return DialFailure
+	}
+	return Unclassified
+}
@@ -0,0 +1,126 @@
+package shrmpl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// transportError wraps err the way http.Client.Do reports it
+func transportError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://vault:7474/app.conf", Err: err}
+}
+
+func TestClassifyVaultError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      VaultErrorClass
+		retryable bool
+	}{
+		{"nil", nil, Unclassified, true},
+		{"unrecognized", errors.New("boom"), Unclassified, true},
+		{"dns", transportError(&net.OpError{Op: "dial", Net: "tcp",
+			Err: &net.DNSError{Err: "no such host", Name: "vault", IsNotFound: true}}), DNSFailure, true},
+		{"connection refused", transportError(&net.OpError{Op: "dial", Net: "tcp",
+			Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), DialFailure, true},
+		{"context deadline", transportError(context.DeadlineExceeded), Timeout, true},
+		{"read timeout", transportError(&net.OpError{Op: "read", Net: "tcp",
+			Err: os.ErrDeadlineExceeded}), Timeout, true},
+		{"unknown authority", transportError(x509.UnknownAuthorityError{}), CertVerificationFailure, false},
+		{"plain HTTP server", transportError(tls.RecordHeaderError{}), TLSHandshakeFailure, false},
+		{"401", vaultStatusError(401), HTTPStatus(401), false},
+		{"403", vaultStatusError(403), HTTPStatus(403), false},
+		{"404", vaultStatusError(404), HTTPStatus(404), false},
+		{"429", vaultStatusError(429), HTTPStatus(429), true},
+		{"500", vaultStatusError(500), HTTPStatus(500), true},
+		{"503", vaultStatusError(503), HTTPStatus(503), true},
+		{"wrapped status", fmt.Errorf("load app.conf: %w", vaultStatusError(503)), HTTPStatus(503), true},
+		{"wrapped VaultError", fmt.Errorf("load app.conf: %w", &VaultError{Class: DialFailure,
+			Err: errors.New("connection refused")}), DialFailure, true},
+		{"wrapped transport error", fmt.Errorf("watch: %w",
+			transportError(x509.HostnameError{})), CertVerificationFailure, false},
+	}
+	for _, tt := range tests {
+		got := ClassifyVaultError(tt.err)
+		if got != tt.want {
+			t.Errorf("%s: class = %s, want %s", tt.name, got, tt.want)
+		}
+		if got.Retryable() != tt.retryable {
+			t.Errorf("%s: %s.Retryable() = %v, want %v", tt.name, got, got.Retryable(), tt.retryable)
+		}
+	}
+
+	// The status sentinels still match through the VaultError
+	if err := vaultStatusError(404); !errors.Is(err, ErrVaultNotFound) {
+		t.Errorf("404 error %v does not match ErrVaultNotFound", err)
+	}
+	if err := vaultStatusError(401); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("401 error %v does not match ErrUnauthorized", err)
+	}
+}
+
+func TestVaultRetriesRetryableFailures(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		mu.Lock()
+		hits[name]++
+		n := hits[name]
+		mu.Unlock()
+		switch {
+		case name == "flaky" && n <= 2, name == "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case name == "missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("content"))
+		}
+	}))
+	defer srv.Close()
+
+	client := NewVaultClient(srv.URL, "", "", "secret")
+	// The plain HTTP server needs no client certificate
+	client.SetTLSConfig(&tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		},
+	})
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetRetries(2, 0)
+
+	if content, err := client.GetConfig("flaky"); err != nil || content != "content" {
+		t.Errorf("GetConfig(flaky) = %q, %v; want the content after two 503s", content, err)
+	}
+	_, err := client.GetConfig("missing")
+	if !errors.Is(err, ErrVaultNotFound) {
+		t.Errorf("GetConfig(missing) = %v, want ErrVaultNotFound", err)
+	}
+	_, err = client.GetConfig("down")
+	if class := ClassifyVaultError(err); class != HTTPStatus(503) {
+		t.Errorf("GetConfig(down) = %v (%s), want the last 503", err, class)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"flaky": 3, "missing": 1, "down": 3}
+	for name, n := range want {
+		if hits[name] != n {
+			t.Errorf("%s requested %d times, want %d", name, hits[name], n)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package shrmpl
+
+import (
+	"context"
+	"sync"
+)
+
+// fetchGroup lets concurrent fetches of the same file share one request.
+// Unlike flightGroup, waiters honor their own context: a cancelled caller
+// stops waiting, but the shared request runs on with its values and
+// without its cancellation, so the other callers still get the result.
+// The request is cancelled once every caller has stopped waiting.
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+// fetchCall is an in-progress fetch; done is closed once it completes.
+// waiters counts the callers still waiting, guarded by fetchGroup.mu.
+type fetchCall struct {
+	done      chan struct{}
+	cancel    context.CancelFunc
+	waiters   int
+	content   string
+	signature string
+	err       error
+}
+
+// do runs fn for filename unless a fetch of it is already in flight, and
+// returns that fetch's result, including its error, to every caller
+func (g *fetchGroup) do(ctx context.Context, filename string,
+	fn func(ctx context.Context, filename string) (string, string, error)) (string, string, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*fetchCall)
+	}
+	call, ok := g.calls[filename]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &fetchCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[filename] = call
+		go func() {
+			call.content, call.signature, call.err = fn(fetchCtx, filename)
+			g.mu.Lock()
+			if g.calls[filename] == call {
+				delete(g.calls, filename)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.content, call.signature, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody wants the result any more; later callers start afresh
+			call.cancel()
+			if g.calls[filename] == call {
+				delete(g.calls, filename)
+			}
+		}
+		g.mu.Unlock()
+		return "", "", ctx.Err()
+	}
+}
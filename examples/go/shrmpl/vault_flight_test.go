@@ -0,0 +1,153 @@
+package shrmpl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingFetch returns a fetch function that waits for release or its
+// context, reporting its context on started
+func blockingFetch(calls *atomic.Int32, started chan<- context.Context, release <-chan struct{}) func(context.Context, string) (string, string, error) {
+	return func(ctx context.Context, filename string) (string, string, error) {
+		calls.Add(1)
+		started <- ctx
+		select {
+		case <-release:
+			return "content of " + filename, "sig", nil
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		}
+	}
+}
+
+// waitForWaiters blocks until n callers wait on the fetch of filename
+func waitForWaiters(t *testing.T, g *fetchGroup, filename string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		g.mu.Lock()
+		waiters := 0
+		if call := g.calls[filename]; call != nil {
+			waiters = call.waiters
+		}
+		g.mu.Unlock()
+		if waiters == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d callers joined the fetch", waiters, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentGetConfigSharesOneFetch(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		_, _ = w.Write([]byte("content of " + strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer srv.Close()
+
+	client := NewVaultClient(srv.URL, "", "", "secret")
+	// The plain HTTP server needs no client certificate
+	client.SetTLSConfig(&tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		},
+	})
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, err := client.GetConfig("app.conf")
+			if err == nil && content != "content of app.conf" {
+				err = fmt.Errorf("got %q", content)
+			}
+			errs <- err
+		}()
+	}
+	// Hold the response until every caller has joined the fetch
+	waitForWaiters(t, &client.fetches, "app.conf", callers)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server handled %d requests for %d callers, want 1", n, callers)
+	}
+}
+
+func TestFetchGroupCancelsWhenLastWaiterLeaves(t *testing.T) {
+	var g fetchGroup
+	var calls atomic.Int32
+	started := make(chan context.Context, 2)
+	fn := blockingFetch(&calls, started, make(chan struct{}))
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := g.do(ctx1, "app.conf", fn)
+		errs <- err
+	}()
+	fetchCtx := <-started
+	go func() {
+		_, _, err := g.do(ctx2, "app.conf", fn)
+		errs <- err
+	}()
+	waitForWaiters(t, &g, "app.conf", 2)
+
+	// One caller leaving keeps the request running for the other
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller = %v, want context.Canceled", err)
+	}
+	select {
+	case <-fetchCtx.Done():
+		t.Fatal("shared request cancelled while a caller still waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The last one leaving cancels it
+	cancel2()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("second caller = %v, want context.Canceled", err)
+	}
+	select {
+	case <-fetchCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("shared request not cancelled after every caller left")
+	}
+
+	// A later caller starts a fresh request
+	ctx3, cancel3 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel3()
+	_, _, _ = g.do(ctx3, "app.conf", fn)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d fetches, want a second one after the cancellation", n)
+	}
+}
@@ -0,0 +1,65 @@
+package shrmpl_test
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"shrmpl/shrmpltest"
+)
+
+// noSessionCache is a tls.ClientSessionCache that keeps nothing, so every
+// handshake is a full one
+type noSessionCache struct{}
+
+func (noSessionCache) Get(string) (*tls.ClientSessionState, bool) { return nil, false }
+func (noSessionCache) Put(string, *tls.ClientSessionState)        {}
+
+// benchmarkHandshake fetches a file b.N times, closing the connection
+// after each fetch so every request starts with a TLS handshake
+func benchmarkHandshake(b *testing.B, resume bool) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	srv.SetFile("app.conf", "v")
+
+	client := srv.NewClient()
+	cfg := srv.TLSConfig()
+	if !resume {
+		cfg.ClientSessionCache = noSessionCache{}
+	}
+	client.SetTLSConfig(cfg)
+	if _, err := client.Connect(); err != nil {
+		b.Fatal(err)
+	}
+	// Prime the session cache outside the timed loop
+	if _, err := client.GetConfig("app.conf"); err != nil {
+		b.Fatal(err)
+	}
+	srv.CloseClientConnections()
+	before := srv.Resumed()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.GetConfig("app.conf"); err != nil {
+			b.Fatal(err)
+		}
+		srv.CloseClientConnections()
+	}
+	b.StopTimer()
+
+	resumed := srv.Resumed() - before
+	b.ReportMetric(float64(resumed)/float64(b.N), "resumed/op")
+	if resume && resumed == 0 {
+		b.Error("no handshake resumed a session")
+	}
+	if !resume && resumed != 0 {
+		b.Errorf("%d handshakes resumed without a session cache", resumed)
+	}
+}
+
+func BenchmarkVaultHandshakeCold(b *testing.B) {
+	benchmarkHandshake(b, false)
+}
+
+func BenchmarkVaultHandshakeResumed(b *testing.B) {
+	benchmarkHandshake(b, true)
+}
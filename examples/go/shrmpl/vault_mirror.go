@@ -0,0 +1,344 @@
+package shrmpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MirrorManifestName is the manifest MirrorConfigs keeps next to the
+// mirrored files
+const MirrorManifestName = "manifest.json"
+
+// Default modes of mirrored files and of the mirror directory
+const (
+	defaultMirrorFileMode os.FileMode = 0o444
+	defaultMirrorDirMode  os.FileMode = 0o755
+)
+
+// MirrorOptions controls MirrorConfigs
+type MirrorOptions struct {
+	// FileMode is the mode mirrored files and the manifest are written
+	// with (default 0444, read-only)
+	FileMode os.FileMode
+	// DirMode is the mode of the directory when it has to be created
+	// (default 0755)
+	DirMode os.FileMode
+	// RemoveMissing deletes the local copy of a file that the vault no
+	// longer has. By default the last copy is kept and the miss is
+	// reported in MirrorSync.Errors.
+	RemoveMissing bool
+	// OnSync, if set, is called after every cycle, including cycles that
+	// changed nothing
+	OnSync func(MirrorSync)
+}
+
+// MirrorSync reports what one MirrorConfigs cycle did
+type MirrorSync struct {
+	Time time.Time
+	// Updated lists the files written because they were new or changed
+	Updated []string
+	// Removed lists the files deleted because the vault no longer has them
+	Removed []string
+	// Errors holds the files that could not be synced, which keep their
+	// previous local copy. A failed manifest write is reported under
+	// MirrorManifestName.
+	Errors map[string]error
+}
+
+// Changed reports whether any mirrored file was written or removed, i.e.
+// whether consumers of the directory need to reload
+func (s MirrorSync) Changed() bool {
+	return len(s.Updated) > 0 || len(s.Removed) > 0
+}
+
+// fail records err for name
+func (s *MirrorSync) fail(name string, err error) {
+	if s.Errors == nil {
+		s.Errors = make(map[string]error)
+	}
+	s.Errors[name] = err
+}
+
+// MirrorManifest is the content of the manifest.json MirrorConfigs writes
+type MirrorManifest struct {
+	Source    string       `json:"source"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Files     []MirrorFile `json:"files"`
+}
+
+// MirrorFile is one mirrored file in a MirrorManifest
+type MirrorFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+	// ETag and LastModified are the vault's validators, if it sent any
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// UpdatedAt is when the local copy was last written
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// mirror is the state of one MirrorConfigs
+type mirror struct {
+	client   *VaultClient
+	dir      string
+	files    []string
+	opts     MirrorOptions
+	versions map[string]configVersion
+	entries  map[string]MirrorFile
+	// dirty is set when the manifest on disk is out of date
+	dirty bool
+}
+
+// MirrorConfigs keeps copies of filenames in dir for processes that
+// cannot use this client, such as nginx or a sidecar in another language.
+// It syncs once immediately and then every interval, with the same
+// conditional requests as WatchConfig. Each file is written to a
+// temporary file and renamed into place, so readers never see a partial
+// file, and dir/manifest.json records the digest, ETag and times of every
+// mirrored file. Files that are already up to date from an earlier run
+// are not rewritten.
+//
+// A file that cannot be fetched or written keeps its previous copy and is
+// reported in MirrorSync.Errors; use OnSync to act on each cycle, e.g. to
+// send SIGHUP to a sidecar only when MirrorSync.Changed. Mirroring stops
+// when ctx is done or the vault answers ErrUnauthorized, which is sent on
+// the returned channel along with setup errors such as an invalid file
+// name, an unwritable dir or a non-positive interval. The channel is closed when mirroring stops.
+func (c *VaultClient) MirrorConfigs(ctx context.Context, filenames []string,
+	dir string, interval time.Duration, opts MirrorOptions) <-chan error {
+	errs := make(chan error, 1)
+	if interval <= 0 {
+		errs <- fmt.Errorf("mirror %s: interval must be positive, got %s", dir, interval)
+		close(errs)
+		return errs
+	}
+	m, err := c.newMirror(filenames, dir, opts)
+	if err != nil {
+		errs <- fmt.Errorf("mirror %s: %w", dir, err)
+		close(errs)
+		return errs
+	}
+
+	go func() {
+		defer close(errs)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			result, err := m.sync(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				errs <- fmt.Errorf("mirror %s: %w", dir, err)
+				return
+			}
+			if m.opts.OnSync != nil {
+				m.opts.OnSync(result)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return errs
+}
+
+// newMirror validates the file names, creates dir and picks up the files
+// a previous run left there, as long as they still match its manifest
+func (c *VaultClient) newMirror(filenames []string, dir string,
+	opts MirrorOptions) (*mirror, error) {
+	if opts.FileMode == 0 {
+		opts.FileMode = defaultMirrorFileMode
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = defaultMirrorDirMode
+	}
+	m := &mirror{
+		client:   c,
+		dir:      dir,
+		opts:     opts,
+		versions: make(map[string]configVersion),
+		entries:  make(map[string]MirrorFile),
+		dirty:    true,
+	}
+
+	seen := make(map[string]bool, len(filenames))
+	for _, name := range filenames {
+		switch {
+		case name == "" || name == "." || name == ".." || filepath.Base(name) != name:
+			return nil, fmt.Errorf("invalid file name %q: must be a plain file name", name)
+		case name == MirrorManifestName:
+			return nil, fmt.Errorf("file name %q is reserved for the manifest", name)
+		case seen[name]:
+			return nil, fmt.Errorf("file %q listed twice", name)
+		}
+		seen[name] = true
+		m.files = append(m.files, name)
+	}
+
+	if err := os.MkdirAll(dir, opts.DirMode); err != nil {
+		return nil, err
+	}
+
+	// An unreadable or foreign manifest only costs one full sync
+	data, err := os.ReadFile(filepath.Join(dir, MirrorManifestName))
+	if err != nil {
+		return m, nil
+	}
+	var manifest MirrorManifest
+	if json.Unmarshal(data, &manifest) != nil {
+		return m, nil
+	}
+	for _, entry := range manifest.Files {
+		if !seen[entry.Name] {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name))
+		if err != nil || digest(string(content)) != entry.SHA256 {
+			continue
+		}
+		m.versions[entry.Name] = configVersion{
+			content:      string(content),
+			etag:         entry.ETag,
+			lastModified: entry.LastModified,
+		}
+		m.entries[entry.Name] = entry
+	}
+	return m, nil
+}
+
+// sync runs one mirror cycle. Only ctx errors and ErrUnauthorized are
+// returned; everything else is reported per file.
+func (m *mirror) sync(ctx context.Context) (MirrorSync, error) {
+	result := MirrorSync{Time: time.Now()}
+	for _, name := range m.files {
+		prev, known := m.versions[name]
+		next, changed, err := m.client.fetchIfChanged(ctx, name, prev)
+		switch {
+		case ctx.Err() != nil:
+			return result, ctx.Err()
+		case errors.Is(err, ErrUnauthorized):
+			return result, err
+		case errors.Is(err, ErrVaultNotFound) && m.opts.RemoveMissing:
+			if known {
+				m.remove(name, &result)
+			}
+			continue
+		case err != nil:
+			result.fail(name, err)
+			continue
+		}
+
+		if known && !changed {
+			entry := m.entries[name]
+			if next.etag != entry.ETag || next.lastModified != entry.LastModified {
+				entry.ETag, entry.LastModified = next.etag, next.lastModified
+				m.entries[name] = entry
+				m.dirty = true
+			}
+			m.versions[name] = next
+			continue
+		}
+
+		err = writeFileAtomic(filepath.Join(m.dir, name), []byte(next.content), m.opts.FileMode)
+		if err != nil {
+			result.fail(name, err)
+			continue
+		}
+		m.versions[name] = next
+		m.entries[name] = MirrorFile{
+			Name:         name,
+			SHA256:       digest(next.content),
+			Size:         len(next.content),
+			ETag:         next.etag,
+			LastModified: next.lastModified,
+			UpdatedAt:    result.Time.UTC(),
+		}
+		m.dirty = true
+		result.Updated = append(result.Updated, name)
+	}
+
+	if m.dirty {
+		if err := m.writeManifest(result.Time); err != nil {
+			result.fail(MirrorManifestName, err)
+		} else {
+			m.dirty = false
+		}
+	}
+	return result, nil
+}
+
+// remove deletes the local copy of name
+func (m *mirror) remove(name string, result *MirrorSync) {
+	err := os.Remove(filepath.Join(m.dir, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		result.fail(name, err)
+		return
+	}
+	delete(m.versions, name)
+	delete(m.entries, name)
+	m.dirty = true
+	result.Removed = append(result.Removed, name)
+}
+
+// writeManifest writes manifest.json for the current entries
+func (m *mirror) writeManifest(now time.Time) error {
+	manifest := MirrorManifest{
+		Source:    m.client.serverURL,
+		UpdatedAt: now.UTC(),
+		Files:     make([]MirrorFile, 0, len(m.entries)),
+	}
+	for _, entry := range m.entries {
+		manifest.Files = append(manifest.Files, entry)
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Name < manifest.Files[j].Name
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.dir, MirrorManifestName),
+		append(data, '\n'), m.opts.FileMode)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers see either the old or the new content
+func writeFileAtomic(path string, data []byte, mode os.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
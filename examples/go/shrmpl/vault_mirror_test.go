@@ -0,0 +1,63 @@
+package shrmpl_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestMirrorConfigsRejectsNonPositiveInterval(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	client := srv.NewClient()
+	dir := filepath.Join(t.TempDir(), "mirror")
+
+	errs := client.MirrorConfigs(context.Background(), []string{"app.conf"}, dir, 0, shrmpl.MirrorOptions{})
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("channel closed without an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error reported")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("mirror dir created for a rejected interval: %v", err)
+	}
+}
+
+func TestMirrorConfigsWritesFiles(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	srv.SetFile("app.conf", "v1")
+	client := srv.NewClient()
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan shrmpl.MirrorSync, 1)
+	errs := client.MirrorConfigs(ctx, []string{"app.conf"}, dir, time.Hour, shrmpl.MirrorOptions{
+		OnSync: func(s shrmpl.MirrorSync) { synced <- s },
+	})
+	select {
+	case s := <-synced:
+		if !s.Changed() || len(s.Errors) != 0 {
+			t.Errorf("first sync = %+v", s)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no sync")
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "app.conf")); err != nil || string(content) != "v1" {
+		t.Errorf("mirrored file = %q, %v", content, err)
+	}
+}
@@ -0,0 +1,69 @@
+package shrmpl
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// signatureHeader carries the vault's signature of a config file
+const signatureHeader = "X-Shrmpl-Signature"
+
+// ErrSignatureInvalid is returned when a config file's signature is
+// missing or does not match its content
+var ErrSignatureInvalid = errors.New("invalid config signature")
+
+// Verifier checks the signature the vault attaches to a config file.
+// signature is the X-Shrmpl-Signature header value, empty if the vault
+// sent none. Verify must return an error wrapping ErrSignatureInvalid when
+// the content is not authentic.
+type Verifier interface {
+	Verify(filename string, body []byte, signature string) error
+}
+
+// Ed25519Verifier verifies base64-encoded Ed25519 signatures of
+// "<filename>\n<body>", binding the content to the name it was served
+// under so signed files cannot be swapped for one another
+type Ed25519Verifier struct {
+	publicKey ed25519.PublicKey
+}
+
+// NewEd25519Verifier creates a verifier for the vault's public key
+func NewEd25519Verifier(publicKey ed25519.PublicKey) (*Ed25519Verifier, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public key must be %d bytes, got %d",
+			ed25519.PublicKeySize, len(publicKey))
+	}
+	return &Ed25519Verifier{publicKey: publicKey}, nil
+}
+
+// Verify checks signature against filename and body
+func (v *Ed25519Verifier) Verify(filename string, body []byte, signature string) error {
+	if signature == "" {
+		return fmt.Errorf("%w: %s is not signed", ErrSignatureInvalid, filename)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s: malformed signature", ErrSignatureInvalid, filename)
+	}
+	if !ed25519.Verify(v.publicKey, signedMessage(filename, body), sig) {
+		return fmt.Errorf("%w: %s", ErrSignatureInvalid, filename)
+	}
+	return nil
+}
+
+// signedMessage returns the bytes covered by a config signature
+func signedMessage(filename string, body []byte) []byte {
+	msg := make([]byte, 0, len(filename)+1+len(body))
+	msg = append(msg, filename...)
+	msg = append(msg, '\n')
+	return append(msg, body...)
+}
+
+// SetVerifier checks every downloaded config file with v before it is
+// returned; files that fail are reported with an error wrapping
+// ErrSignatureInvalid. Signatures are also stored by ExportBundle.
+func (c *VaultClient) SetVerifier(v Verifier) {
+	c.verifier = v
+}
@@ -0,0 +1,130 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigInfo describes a config file without its content. Fields the
+// server does not report are left zero.
+type ConfigInfo struct {
+	Name    string
+	Size    int64
+	ETag    string
+	ModTime time.Time
+}
+
+// StatConfig reports whether filename exists, and its size, ETag and
+// modification time, without downloading it
+func (c *VaultClient) StatConfig(filename string) (ConfigInfo, error) {
+	return c.StatConfigContext(context.Background(), filename)
+}
+
+// StatConfigContext is StatConfig aborting the request when ctx is done.
+// It issues a HEAD request, falling back to a one-byte ranged GET when the
+// server answers 405. shrmpl-vault-srv rejects every HEAD, so after the
+// first 405 the client sends only the GET. Errors are the same as
+// GetConfig's.
+func (c *VaultClient) StatConfigContext(ctx context.Context,
+	filename string) (_ ConfigInfo, err error) {
+	span := startSpan(ctx, c.tracer, "vault.stat_config",
+		map[string]string{"vault.file": filename})
+	defer func() { span.End(err) }()
+
+	var resp *http.Response
+	if !c.headUnsupported.Load() {
+		resp, err = c.do(ctx, http.MethodHead, filename, nil)
+		if err != nil {
+			return ConfigInfo{}, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			c.headUnsupported.Store(true)
+			resp = nil
+		}
+	}
+	if resp == nil {
+		resp, err = c.do(ctx, http.MethodGet, filename,
+			http.Header{"Range": []string{"bytes=0-0"}})
+		if err != nil {
+			return ConfigInfo{}, err
+		}
+		// Only the headers are needed. A server ignoring the range sends
+		// the whole file, which is dropped unread along with the
+		// connection rather than drained.
+		resp.Body.Close()
+	}
+
+	info := ConfigInfo{Name: filename, ETag: resp.Header.Get("ETag")}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		info.Size = max(resp.ContentLength, 0)
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// "bytes 0-0/1234", or "bytes */0" for an empty file
+		size, ok := contentRangeSize(resp.Header.Get("Content-Range"))
+		if !ok {
+			return ConfigInfo{}, fmt.Errorf("invalid Content-Range %q",
+				resp.Header.Get("Content-Range"))
+		}
+		info.Size = size
+	default:
+		return ConfigInfo{}, vaultStatusError(resp.StatusCode)
+	}
+	return info, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+func contentRangeSize(header string) (int64, bool) {
+	_, total, found := strings.Cut(header, "/")
+	if !found {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	return size, err == nil
+}
+
+// StatConfig returns the size and digest of a file in the bundle; the
+// bundle's creation time stands in for the modification time
+func (b *BundleVaultClient) StatConfig(filename string) (ConfigInfo, error) {
+	for _, f := range b.manifest.Files {
+		if f.Name == filename {
+			return ConfigInfo{Name: f.Name, Size: int64(f.Size),
+				ETag: f.SHA256, ModTime: b.manifest.CreatedAt}, nil
+		}
+	}
+	return ConfigInfo{}, ErrVaultNotFound
+}
+
+// StatConfigContext is StatConfig; ctx is unused since no I/O is involved
+func (b *BundleVaultClient) StatConfigContext(_ context.Context,
+	filename string) (ConfigInfo, error) {
+	return b.StatConfig(filename)
+}
+
+// checkExists stats every registered file so misnamed files fail fast,
+// reporting all missing names at once
+func (b *ConfigBundle) checkExists(ctx context.Context) error {
+	var missing []string
+	for _, name := range b.files {
+		_, err := b.client.StatConfigContext(ctx, name)
+		switch {
+		case errors.Is(err, ErrVaultNotFound):
+			missing = append(missing, name)
+		case err != nil:
+			return fmt.Errorf("stat %s: %w", name, err)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrVaultNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package shrmpl_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestStatConfigSkipsHeadAfter405(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	const content = "A=1\nB=2\n"
+	srv.SetFile("app.conf", content)
+	client := srv.NewClient()
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		info, err := client.StatConfig("app.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != "app.conf" || info.Size != int64(len(content)) {
+			t.Errorf("StatConfig = %+v, want size %d", info, len(content))
+		}
+	}
+	if _, err := client.StatConfig("missing.conf"); !errors.Is(err, shrmpl.ErrVaultNotFound) {
+		t.Errorf("StatConfig(missing.conf) = %v, want ErrVaultNotFound", err)
+	}
+	// One rejected HEAD, then a GET per stat
+	if n := srv.Requests(); n != 5 {
+		t.Errorf("server saw %d requests, want 5", n)
+	}
+}
+
+func TestStatConfigUsesHead(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+	client := shrmpl.NewVaultClient(srv.URL, "", "", "secret")
+	// The plain HTTP server needs no client certificate
+	client.SetTLSConfig(&tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		},
+	})
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		info, err := client.StatConfig("app.conf")
+		if err != nil || info.Size != 7 || info.ETag != `"v1"` {
+			t.Errorf("StatConfig = %+v, %v; want size 7 and the ETag", info, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 2 || methods[0] != http.MethodHead || methods[1] != http.MethodHead {
+		t.Errorf("requests = %v, want two HEADs", methods)
+	}
+}
@@ -0,0 +1,129 @@
+package shrmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// configVersion is a fetched config file with the validators and
+// signature the server sent for it
+type configVersion struct {
+	content      string
+	etag         string
+	lastModified string
+	signature    string
+}
+
+// fetchIfChanged downloads filename unless it still matches prev. The
+// request carries If-None-Match and If-Modified-Since when prev has an
+// ETag or Last-Modified, so servers that honor them answer 304 without a
+// body; for servers that do not, the content is compared instead. The
+// signature is verified as by GetConfig.
+func (c *VaultClient) fetchIfChanged(ctx context.Context, filename string,
+	prev configVersion) (configVersion, bool, error) {
+	header := http.Header{}
+	if prev.etag != "" {
+		header.Set("If-None-Match", prev.etag)
+	}
+	if prev.lastModified != "" {
+		header.Set("If-Modified-Since", prev.lastModified)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, filename, header)
+	if err != nil {
+		return prev, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return prev, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return prev, false, vaultStatusError(resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return prev, false, err
+	}
+	signature := resp.Header.Get(signatureHeader)
+	if c.verifier != nil {
+		if err := c.verifier.Verify(filename, content, signature); err != nil {
+			return prev, false, err
+		}
+	}
+
+	next := configVersion{
+		content:      string(content),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		signature:    signature,
+	}
+	return next, next.content != prev.content, nil
+}
+
+// terminalWatchError reports whether err ends a WatchConfig: the file is
+// gone, access was revoked, the content failed verification or its class
+// is not retryable, such as a certificate failure. DNS, dial and timeout
+// errors, rate limiting and server errors are retried on the next poll.
+func terminalWatchError(err error) bool {
+	return errors.Is(err, ErrVaultNotFound) ||
+		errors.Is(err, ErrUnauthorized) ||
+		errors.Is(err, ErrSignatureInvalid) ||
+		!ClassifyVaultError(err).Retryable()
+}
+
+// WatchConfig polls filename every interval and calls fn with the new
+// content whenever it changes. The first poll only records the current
+// version. Polls are conditional requests, so an unchanged file costs no
+// transfer on servers that support ETag or Last-Modified.
+//
+// Watching stops when ctx is done or on a terminal error (ErrVaultNotFound,
+// ErrUnauthorized, ErrSignatureInvalid or a VaultError whose class is not
+// Retryable), which is sent on the returned channel; other errors are
+// retried on the next poll. A non-positive interval is reported the same
+// way without polling. The channel is closed when watching stops. fn runs
+// on the polling goroutine, so the next poll waits for it.
+func (c *VaultClient) WatchConfig(ctx context.Context, filename string,
+	interval time.Duration, fn func(content string)) <-chan error {
+	errs := make(chan error, 1)
+	if interval <= 0 {
+		errs <- fmt.Errorf("watch %s: interval must be positive, got %s", filename, interval)
+		close(errs)
+		return errs
+	}
+	go func() {
+		defer close(errs)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var current configVersion
+		known := false
+		for {
+			next, changed, err := c.fetchIfChanged(ctx, filename, current)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil && terminalWatchError(err):
+				errs <- fmt.Errorf("watch %s: %w", filename, err)
+				return
+			case err == nil:
+				if changed && known {
+					fn(next.content)
+				}
+				current, known = next, true
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return errs
+}
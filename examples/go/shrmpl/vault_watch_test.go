@@ -0,0 +1,66 @@
+package shrmpl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"shrmpl/shrmpltest"
+)
+
+func TestWatchConfigRejectsNonPositiveInterval(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	client := srv.NewClient()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		errs := client.WatchConfig(context.Background(), "app.conf", interval, func(string) {
+			t.Errorf("fn called for interval %s", interval)
+		})
+		select {
+		case err, ok := <-errs:
+			if !ok || err == nil {
+				t.Errorf("interval %s: channel closed without an error", interval)
+			}
+			if _, open := <-errs; open {
+				t.Errorf("interval %s: channel left open", interval)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("interval %s: no error reported", interval)
+		}
+	}
+	if n := srv.Requests(); n != 0 {
+		t.Errorf("%d requests sent, want none", n)
+	}
+}
+
+func TestWatchConfigReportsChanges(t *testing.T) {
+	srv := shrmpltest.NewVaultServer("secret")
+	defer srv.Close()
+	srv.SetFile("app.conf", "v1")
+	client := srv.NewClient()
+	if _, err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 1)
+	errs := client.WatchConfig(ctx, "app.conf", 10*time.Millisecond, func(content string) {
+		changes <- content
+	})
+	time.Sleep(30 * time.Millisecond)
+	srv.SetFile("app.conf", "v2")
+	select {
+	case content := <-changes:
+		if content != "v2" {
+			t.Errorf("fn got %q, want v2", content)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("change not reported")
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("cancelled watch sent %v", err)
+	}
+}
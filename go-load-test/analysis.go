@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Thresholds used by the analysis rules
+const (
+	lockWaitHintRatio  = 0.5
+	cpuSaturationRatio = 0.9
+	errorDominance     = 0.6
+)
+
+// RunStats summarizes a finished run for the analysis rules
+type RunStats struct {
+	SharedConn  bool
+	Total       int
+	Errors      int
+	Elapsed     time.Duration
+	CPUTime     time.Duration
+	NumCPU      int
+	OpTime      time.Duration
+	LockWait    time.Duration
+	ErrorCounts map[string]int
+	Conns       ConnSummary
+}
+
+// Hint is an interpretation of the results along with the evidence for it
+type Hint struct {
+	Message  string
+	Evidence string
+}
+
+// lockWaiter is implemented by clients that track time spent waiting for
+// the shared connection
+type lockWaiter interface {
+	LockWait() time.Duration
+}
+
+// processCPUTime returns the user+system CPU time consumed so far
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// classifyError buckets an error message into a coarse failure class
+func classifyError(msg string) string {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "timeout"):
+		return "timeout"
+	case strings.Contains(lower, "reset"),
+		strings.Contains(lower, "broken pipe"),
+		strings.Contains(lower, "eof"),
+		strings.Contains(lower, "shutting down"),
+		strings.Contains(lower, "terminated"):
+		return "reset"
+	case strings.Contains(lower, "refused"),
+		strings.Contains(lower, "not available"):
+		return "connect"
+	default:
+		return "other"
+	}
+}
+
+// analyze applies simple rules over the run statistics and returns the
+// hints that fired
+func analyze(s RunStats) []Hint {
+	var hints []Hint
+
+	if s.SharedConn && s.OpTime > 0 {
+		ratio := float64(s.LockWait) / float64(s.OpTime)
+		if ratio > lockWaitHintRatio {
+			hints = append(hints, Hint{
+				Message: "Most latency is spent waiting for the shared connection; " +
+					"try --multi or a connection pool",
+				Evidence: fmt.Sprintf("%.0f%% of operation time was lock wait (%s of %s)",
+					ratio*100, s.LockWait.Round(time.Millisecond), s.OpTime.Round(time.Millisecond)),
+			})
+		}
+	}
+
+	if s.Elapsed > 0 && s.NumCPU > 0 {
+		ratio := float64(s.CPUTime) / (float64(s.Elapsed) * float64(s.NumCPU))
+		if ratio > cpuSaturationRatio {
+			hints = append(hints, Hint{
+				Message: "The load generator is CPU bound; results reflect client " +
+					"limits rather than the server",
+				Evidence: fmt.Sprintf("CPU time %s over %s wall on %d CPUs (%.0f%% utilization)",
+					s.CPUTime.Round(time.Millisecond), s.Elapsed.Round(time.Millisecond),
+					s.NumCPU, ratio*100),
+			})
+		}
+	}
+
+	if s.Conns.Opened > s.Conns.Expected {
+		hints = append(hints, Hint{
+			Message: "Clients reconnected during the run; silent reconnect churn " +
+				"adds dial latency to the affected operations",
+			Evidence: fmt.Sprintf("%d connections opened, %d expected (%d reset by peer)",
+				s.Conns.Opened, s.Conns.Expected, s.Conns.Resets),
+		})
+	}
+
+	if s.Errors > 0 {
+		classes := make(map[string]int)
+		for msg, count := range s.ErrorCounts {
+			classes[classifyError(msg)] += count
+		}
+		if share := float64(classes["timeout"]) / float64(s.Errors); share > errorDominance {
+			hints = append(hints, Hint{
+				Message:  "Errors are dominated by timeouts; the server is likely overloaded",
+				Evidence: fmt.Sprintf("%d of %d errors were timeouts", classes["timeout"], s.Errors),
+			})
+		}
+		if share := float64(classes["reset"]) / float64(s.Errors); share > errorDominance {
+			hints = append(hints, Hint{
+				Message:  "Errors are dominated by connection resets; the server may be crashing or restarting",
+				Evidence: fmt.Sprintf("%d of %d errors were resets", classes["reset"], s.Errors),
+			})
+		}
+		if share := float64(classes["connect"]) / float64(s.Errors); share > errorDominance {
+			hints = append(hints, Hint{
+				Message:  "Errors are dominated by connection failures; check the server address and that it is running",
+				Evidence: fmt.Sprintf("%d of %d errors were connect failures", classes["connect"], s.Errors),
+			})
+		}
+	}
+
+	return hints
+}
+
+// printHints prints the analysis section
+func printHints(hints []Hint) {
+	fmt.Println("\nAnalysis:")
+	if len(hints) == 0 {
+		fmt.Println("  No bottlenecks detected")
+		return
+	}
+	for _, h := range hints {
+		fmt.Printf("  - %s\n", h.Message)
+		fmt.Printf("    evidence: %s\n", h.Evidence)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"read tcp 127.0.0.1:7171: i/o timeout", "timeout"},
+		{"read: connection reset by peer", "reset"},
+		{"write: broken pipe", "reset"},
+		{"unexpected EOF", "reset"},
+		{"server shutting down", "reset"},
+		{"dial tcp 127.0.0.1:7171: connect: connection refused", "connect"},
+		{"KV service not available", "connect"},
+		{"ERROR invalid length", "other"},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.msg); got != tt.want {
+			t.Errorf("classifyError(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	// Hints are identified by a word unique to each rule's message
+	tests := []struct {
+		name  string
+		stats RunStats
+		want  []string
+	}{
+		{
+			name:  "healthy run",
+			stats: RunStats{Total: 1000, Elapsed: time.Second, CPUTime: 100 * time.Millisecond, NumCPU: 4},
+		},
+		{
+			name: "lock wait on the shared connection",
+			stats: RunStats{SharedConn: true, Total: 1000, OpTime: 10 * time.Second,
+				LockWait: 8 * time.Second},
+			want: []string{"shared connection"},
+		},
+		{
+			name: "lock wait ignored without a shared connection",
+			stats: RunStats{Total: 1000, OpTime: 10 * time.Second,
+				LockWait: 8 * time.Second},
+		},
+		{
+			name: "lock wait below the threshold",
+			stats: RunStats{SharedConn: true, Total: 1000, OpTime: 10 * time.Second,
+				LockWait: 5 * time.Second},
+		},
+		{
+			name:  "CPU bound load generator",
+			stats: RunStats{Total: 1000, Elapsed: time.Second, CPUTime: 3900 * time.Millisecond, NumCPU: 4},
+			want:  []string{"CPU bound"},
+		},
+		{
+			name:  "reconnect churn",
+			stats: RunStats{Total: 1000, Conns: ConnSummary{Opened: 14, Expected: 10, Resets: 4}},
+			want:  []string{"reconnected"},
+		},
+		{
+			name: "timeouts dominate",
+			stats: RunStats{Total: 1000, Errors: 10, ErrorCounts: map[string]int{
+				"i/o timeout": 8, "connection reset by peer": 2}},
+			want: []string{"timeouts"},
+		},
+		{
+			name: "resets dominate",
+			stats: RunStats{Total: 1000, Errors: 10, ErrorCounts: map[string]int{
+				"connection reset by peer": 5, "unexpected EOF": 2, "i/o timeout": 3}},
+			want: []string{"resets"},
+		},
+		{
+			name: "connect failures dominate",
+			stats: RunStats{Total: 1000, Errors: 10, ErrorCounts: map[string]int{
+				"connection refused": 10}},
+			want: []string{"connection failures"},
+		},
+		{
+			name: "no error class dominates",
+			stats: RunStats{Total: 1000, Errors: 10, ErrorCounts: map[string]int{
+				"i/o timeout": 5, "connection reset by peer": 5}},
+		},
+		{
+			name: "several rules fire",
+			stats: RunStats{SharedConn: true, Total: 1000, Errors: 4,
+				OpTime: 10 * time.Second, LockWait: 9 * time.Second,
+				Conns:       ConnSummary{Opened: 2, Expected: 1},
+				ErrorCounts: map[string]int{"i/o timeout": 4}},
+			want: []string{"shared connection", "reconnected", "timeouts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hints := analyze(tt.stats)
+			if len(hints) != len(tt.want) {
+				t.Fatalf("got %d hints %+v, want %d", len(hints), hints, len(tt.want))
+			}
+			for i, h := range hints {
+				if !strings.Contains(h.Message, tt.want[i]) {
+					t.Errorf("hint %d = %q, want it to mention %q", i, h.Message, tt.want[i])
+				}
+				if h.Evidence == "" {
+					t.Errorf("hint %d has no evidence", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"shrmpl/shrmpltest"
+)
+
+// configSchemaVersion is the config file schema this build reads. Files
+// declaring a newer version are rejected instead of half-understood.
+const configSchemaVersion = 1
+
+// Config file formats
+const (
+	FormatEnv  = "env"
+	FormatJSON = "json"
+)
+
+// setting is one config file key. Each setting can be given as a JSON key
+// or a KEY=VALUE line and is overridden by its flag when that flag is set
+// explicitly.
+type setting struct {
+	key  string // JSON key
+	env  string // KEY=VALUE key
+	flag string // overriding flag
+	// list settings take several values: a JSON array or repeated lines
+	list bool
+	// output settings only name result files, so they are left out of
+	// the configuration hash
+	output bool
+	apply  func(c *TestConfig, values []string) error
+	// value returns the effective setting for --print-effective-config
+	value func(c *TestConfig) any
+}
+
+// settings lists every config file key in the order
+// --print-effective-config prints them
+var settings = []setting{
+	{key: "server", env: "BIND_ADDR", flag: "server",
+		apply: func(c *TestConfig, v []string) error {
+			if _, _, err := parseHostPort(v[0]); err != nil {
+				return err
+			}
+			c.ServerAddr = v[0]
+			return nil
+		},
+		value: func(c *TestConfig) any { return c.ServerAddr }},
+	{key: "servers", env: "SERVERS", flag: "servers", list: true,
+		apply: func(c *TestConfig, v []string) error {
+			servers, err := parseServers(strings.Join(v, ","))
+			if err != nil {
+				return err
+			}
+			c.Servers = servers
+			return nil
+		},
+		value: func(c *TestConfig) any {
+			if len(c.Servers) == 0 {
+				return nil
+			}
+			return c.Servers
+		}},
+	{key: "users", env: "USERS", flag: "users",
+		apply: func(c *TestConfig, v []string) error { return parsePositive(v[0], &c.NumUsers) },
+		value: func(c *TestConfig) any { return c.NumUsers }},
+	{key: "operations", env: "OPERATIONS", flag: "operations",
+		apply: func(c *TestConfig, v []string) error { return parsePositive(v[0], &c.Operations) },
+		value: func(c *TestConfig) any { return c.Operations }},
+	{key: "duration", env: "DURATION", flag: "duration",
+		apply: func(c *TestConfig, v []string) error { return parseDuration(v[0], &c.Duration) },
+		value: func(c *TestConfig) any { return c.Duration.String() }},
+	{key: "rate", env: "RATE", flag: "rate",
+		apply: func(c *TestConfig, v []string) error { return parseNonNegative(v[0], &c.Rate) },
+		value: func(c *TestConfig) any { return c.Rate }},
+	{key: "mode", env: "MODE", flag: "mode",
+		apply: func(c *TestConfig, v []string) error {
+			switch v[0] {
+			case ModeShared, ModeMulti, ModePool:
+				c.Mode = v[0]
+				return nil
+			}
+			return fmt.Errorf("unknown mode %q (shared, multi or pool)", v[0])
+		},
+		value: func(c *TestConfig) any { return c.Mode }},
+	{key: "pool_size", env: "POOL_SIZE", flag: "pool-size",
+		apply: func(c *TestConfig, v []string) error { return parsePositive(v[0], &c.PoolSize) },
+		value: func(c *TestConfig) any { return c.PoolSize }},
+	{key: "max_conns", env: "MAX_CONNS", flag: "max-conns",
+		apply: func(c *TestConfig, v []string) error { return parseCount(v[0], &c.MaxConns) },
+		value: func(c *TestConfig) any { return c.MaxConns }},
+	{key: "mix", env: "MIX", flag: "full",
+		apply: func(c *TestConfig, v []string) error {
+			switch v[0] {
+			case "full":
+				c.FullTest = true
+			case "batch":
+				c.FullTest = false
+			default:
+				return fmt.Errorf("unknown mix %q (full or batch)", v[0])
+			}
+			return nil
+		},
+		value: func(c *TestConfig) any {
+			if c.FullTest {
+				return "full"
+			}
+			return "batch"
+		}},
+	{key: "batch_templates", env: "BATCH_TEMPLATE", flag: "batch-template", list: true,
+		apply: func(c *TestConfig, v []string) error {
+			c.BatchTemplates = nil
+			for _, source := range v {
+				c.BatchTemplates = append(c.BatchTemplates, BatchTemplate{Source: source})
+			}
+			return nil
+		},
+		value: func(c *TestConfig) any {
+			sources := make([]string, len(c.BatchTemplates))
+			for i, t := range c.BatchTemplates {
+				sources[i] = t.Source
+			}
+			return sources
+		}},
+	{key: "value_size", env: "VALUE_SIZE", flag: "value-size",
+		apply: func(c *TestConfig, v []string) error {
+			if err := parseCount(v[0], &c.ValueSize); err != nil {
+				return err
+			}
+			if c.ValueSize > maxValueLength {
+				return fmt.Errorf("must be at most %d", maxValueLength)
+			}
+			return nil
+		},
+		value: func(c *TestConfig) any { return c.ValueSize }},
+	{key: "ttls", env: "TTL", flag: "ttl", list: true,
+		apply: func(c *TestConfig, v []string) error {
+			ttls, err := ParseTTLs(strings.Join(v, ","))
+			if err != nil {
+				return err
+			}
+			c.TTLs = ttls
+			return nil
+		},
+		value: func(c *TestConfig) any {
+			specs := make([]string, len(c.TTLs))
+			for i, t := range c.TTLs {
+				specs[i] = t.String()
+			}
+			return specs
+		}},
+	{key: "think_time", env: "THINK_TIME", flag: "think-time",
+		apply: func(c *TestConfig, v []string) error { return parseDuration(v[0], &c.ThinkTime) },
+		value: func(c *TestConfig) any { return c.ThinkTime.String() }},
+	{key: "warmup", env: "WARMUP", flag: "warmup",
+		apply: func(c *TestConfig, v []string) error { return parseCount(v[0], &c.Warmup) },
+		value: func(c *TestConfig) any { return c.Warmup }},
+	{key: "seed", env: "SEED", flag: "seed",
+		apply: func(c *TestConfig, v []string) error {
+			seed, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("expected an integer, got %q", v[0])
+			}
+			c.Seed = seed
+			return nil
+		},
+		value: func(c *TestConfig) any { return c.Seed }},
+	{key: "verify_sample", env: "VERIFY_SAMPLE", flag: "verify-sample",
+		apply: func(c *TestConfig, v []string) error {
+			if err := parseNonNegative(v[0], &c.VerifySample); err != nil {
+				return err
+			}
+			if c.VerifySample > 1 {
+				return fmt.Errorf("must be between 0 and 1")
+			}
+			return nil
+		},
+		value: func(c *TestConfig) any { return c.VerifySample }},
+	{key: "shared_keys", env: "SHARED_KEYS", flag: "shared-keys",
+		apply: func(c *TestConfig, v []string) error { return parseCount(v[0], &c.SharedKeys) },
+		value: func(c *TestConfig) any { return c.SharedKeys }},
+	{key: "inject", env: "INJECT", flag: "inject",
+		apply: func(c *TestConfig, v []string) error {
+			faults, err := shrmpltest.ParseFaults(v[0])
+			if err != nil {
+				return err
+			}
+			c.Faults = faults
+			return nil
+		},
+		value: func(c *TestConfig) any {
+			faults := make([]string, len(c.Faults))
+			for i, f := range c.Faults {
+				faults[i] = f.String()
+			}
+			return strings.Join(faults, ",")
+		}},
+	{key: "max_error_rate", env: "MAX_ERROR_RATE", flag: "max-error-rate",
+		apply: func(c *TestConfig, v []string) error {
+			rate, err := parseRate(v[0])
+			if err != nil {
+				return err
+			}
+			c.Thresholds.MaxErrorRate = &rate
+			return nil
+		},
+		value: func(c *TestConfig) any { return c.Thresholds.MaxErrorRate }},
+	{key: "max_p99", env: "MAX_P99", flag: "max-p99",
+		apply: func(c *TestConfig, v []string) error { return parseDuration(v[0], &c.Thresholds.MaxP99) },
+		value: func(c *TestConfig) any { return c.Thresholds.MaxP99.String() }},
+	{key: "min_throughput", env: "MIN_THROUGHPUT", flag: "min-throughput",
+		apply: func(c *TestConfig, v []string) error {
+			return parseNonNegative(v[0], &c.Thresholds.MinThroughput)
+		},
+		value: func(c *TestConfig) any { return c.Thresholds.MinThroughput }},
+	{key: "json_output", env: "JSON_OUTPUT", flag: "json", output: true,
+		apply: func(c *TestConfig, v []string) error { c.JSONPath = v[0]; return nil },
+		value: func(c *TestConfig) any { return c.JSONPath }},
+	{key: "hdr_output", env: "HDR_OUTPUT", flag: "hdr", output: true,
+		apply: func(c *TestConfig, v []string) error { c.HDRPath = v[0]; return nil },
+		value: func(c *TestConfig) any { return c.HDRPath }},
+}
+
+// Keys handled before the settings table: the schema version, and the
+// preset, which the other settings override
+const (
+	versionKey = "version"
+	presetKey  = "preset"
+)
+
+// fileValue is a setting read from the config file and where it was found
+type fileValue struct {
+	values []string
+	pos    string // "path:line"
+}
+
+// fileConfig holds the settings read from the config file, keyed by JSON
+// key
+type fileConfig struct {
+	Path   string
+	Format string
+	values map[string]fileValue
+}
+
+// configError reports a problem with a config file key at its location
+func configError(pos, key string, err error) error {
+	return fmt.Errorf("%s: %s: %v", pos, key, err)
+}
+
+// loadConfig reads a config file, auto-detecting JSON (a file starting
+// with '{') or KEY=VALUE lines. KEY=VALUE files are usually the server's
+// own env file, so keys the load test does not know are ignored; in JSON
+// files they are errors.
+func loadConfig(configPath string) (fileConfig, error) {
+	cfg := fileConfig{Path: configPath, values: make(map[string]fileValue)}
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		cfg.Format = FormatJSON
+		err = cfg.parseJSON(content)
+	} else {
+		cfg.Format = FormatEnv
+		err = cfg.parseEnv(content)
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if v, ok := cfg.values[versionKey]; ok {
+		version, err := strconv.Atoi(v.values[0])
+		switch {
+		case err != nil || version < 1:
+			return cfg, configError(v.pos, versionKey, fmt.Errorf("invalid version %q", v.values[0]))
+		case version > configSchemaVersion:
+			return cfg, configError(v.pos, versionKey, fmt.Errorf(
+				"unsupported version %d (this build reads version %d)", version, configSchemaVersion))
+		}
+	}
+	return cfg, nil
+}
+
+// findSetting returns the setting with the given JSON or env key
+func findSetting(key string, env bool) (setting, bool) {
+	for _, s := range settings {
+		if (!env && s.key == key) || (env && s.env == key) {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// parseEnv reads KEY=VALUE lines
+func (cfg *fileConfig) parseEnv(content []byte) error {
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		envKey, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", cfg.Path, i+1)
+
+		key := ""
+		list := false
+		switch envKey {
+		case "VERSION":
+			key = versionKey
+		case "PRESET":
+			key = presetKey
+		default:
+			s, found := findSetting(envKey, true)
+			if !found {
+				continue
+			}
+			key, list = s.key, s.list
+		}
+
+		existing, seen := cfg.values[key]
+		switch {
+		case seen && list:
+			existing.values = append(existing.values, value)
+			cfg.values[key] = existing
+		case seen:
+			return configError(pos, envKey, fmt.Errorf("already set at %s", existing.pos))
+		default:
+			cfg.values[key] = fileValue{values: []string{value}, pos: pos}
+		}
+	}
+	return nil
+}
+
+// parseJSON reads a JSON object, recording the line of every key
+func (cfg *fileConfig) parseJSON(content []byte) error {
+	lineAt := func(offset int64) string {
+		return fmt.Sprintf("%s:%d", cfg.Path, bytes.Count(content[:offset], []byte("\n"))+1)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%s: %v", lineAt(dec.InputOffset()), err)
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("%s: %v", lineAt(dec.InputOffset()), err)
+		}
+		key, _ := token.(string)
+		pos := lineAt(dec.InputOffset())
+
+		var raw any
+		if err := dec.Decode(&raw); err != nil {
+			return configError(pos, key, err)
+		}
+		if _, known := findSetting(key, false); !known && key != versionKey && key != presetKey {
+			return configError(pos, key, fmt.Errorf("unknown key"))
+		}
+		if _, seen := cfg.values[key]; seen {
+			return configError(pos, key, fmt.Errorf("duplicate key"))
+		}
+		values, err := jsonValues(raw)
+		if err != nil {
+			return configError(pos, key, err)
+		}
+		cfg.values[key] = fileValue{values: values, pos: pos}
+	}
+	return nil
+}
+
+// jsonValues converts a JSON scalar or array of scalars to setting values
+func jsonValues(raw any) ([]string, error) {
+	scalar := func(v any) (string, error) {
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return "", fmt.Errorf("expected a string, number or boolean")
+	}
+	if list, ok := raw.([]any); ok {
+		values := make([]string, 0, len(list))
+		for _, v := range list {
+			s, err := scalar(v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	s, err := scalar(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// Preset returns the preset named in the file, if any
+func (cfg fileConfig) Preset() (Preset, bool, error) {
+	v, ok := cfg.values[presetKey]
+	if !ok {
+		return Preset{}, false, nil
+	}
+	p, err := findPreset(v.values[0])
+	if err != nil {
+		return p, false, configError(v.pos, cfg.keyName(presetKey, "PRESET"), err)
+	}
+	return p, true, nil
+}
+
+// keyName returns how a key is spelled in the file's format
+func (cfg fileConfig) keyName(key, env string) string {
+	if cfg.Format == FormatEnv {
+		return env
+	}
+	return key
+}
+
+// apply copies the file's settings into config except those whose flags
+// were given explicitly. Errors name the key and its file location.
+func (cfg fileConfig) apply(config *TestConfig, explicit map[string]bool) error {
+	for _, s := range settings {
+		v, ok := cfg.values[s.key]
+		if !ok || explicit[s.flag] || (s.flag == "mode" && explicit["multi"]) {
+			continue
+		}
+		name := cfg.keyName(s.key, s.env)
+		if !s.list && len(v.values) != 1 {
+			return configError(v.pos, name, fmt.Errorf("expected a single value"))
+		}
+		if len(v.values) == 0 {
+			return configError(v.pos, name, fmt.Errorf("expected at least one value"))
+		}
+		if err := s.apply(config, v.values); err != nil {
+			return configError(v.pos, name, err)
+		}
+	}
+	return nil
+}
+
+// printEffectiveConfig writes the merged configuration as a JSON config
+// file that reproduces the run
+func printEffectiveConfig(config TestConfig) {
+	fmt.Print(effectiveConfig(config, true))
+}
+
+// effectiveConfig returns the merged configuration as a JSON config file,
+// without the output settings unless withOutputs is set
+func effectiveConfig(config TestConfig, withOutputs bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "{\n  %q: %d", versionKey, configSchemaVersion)
+	if config.Preset != "" {
+		fmt.Fprintf(&b, ",\n  %q: %q", presetKey, config.Preset)
+	}
+	for _, s := range settings {
+		if s.output && !withOutputs {
+			continue
+		}
+		value := s.value(&config)
+		if value == nil || value == (*float64)(nil) {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, ",\n  %q: %s", s.key, encoded)
+	}
+	b.WriteString("\n}\n")
+	return b.String()
+}
+
+// parsePositive parses an integer of at least 1 into dst
+func parsePositive(s string, dst *int) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("expected a positive integer, got %q", s)
+	}
+	*dst = n
+	return nil
+}
+
+// parseCount parses an integer of at least 0 into dst
+func parseCount(s string, dst *int) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("expected a non-negative integer, got %q", s)
+	}
+	*dst = n
+	return nil
+}
+
+// parseNonNegative parses a number of at least 0 into dst
+func parseNonNegative(s string, dst *float64) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("expected a non-negative number, got %q", s)
+	}
+	*dst = f
+	return nil
+}
+
+// parseDuration parses a non-negative duration such as "30s" into dst
+func parseDuration(s string, dst *time.Duration) error {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return fmt.Errorf("expected a duration such as 30s, got %q", s)
+	}
+	*dst = d
+	return nil
+}
+
+// parseRate parses a fraction such as 0.01 or a percentage such as 1%
+func parseRate(s string) (float64, error) {
+	value, percent := strings.CutSuffix(s, "%")
+	f, err := strconv.ParseFloat(value, 64)
+	if percent {
+		f /= 100
+	}
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("expected a fraction between 0 and 1 or a percentage, got %q", s)
+	}
+	return f, nil
+}
+
+// parseServers parses a comma-separated list of distinct host:port
+// addresses
+func parseServers(s string) ([]string, error) {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if _, _, err := parseHostPort(addr); err != nil {
+			return nil, err
+		}
+		// Clients and per-server results are keyed by address
+		if seen[addr] {
+			return nil, fmt.Errorf("%s is listed twice", addr)
+		}
+		seen[addr] = true
+		servers = append(servers, addr)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("expected at least one host:port")
+	}
+	return servers, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"shrmpl"
+)
+
+// ConnObserver receives connection lifecycle events from the shrmpl
+// client and the scheduling of each operation from KV
+type ConnObserver interface {
+	shrmpl.ConnObserver
+	// Operation is called after every KV operation
+	Operation(op Operation)
+}
+
+// ConnMetrics is a ConnObserver that counts connection events across all
+// clients of a run
+type ConnMetrics struct {
+	mu           sync.Mutex
+	dials        int
+	dialFailures map[string]int
+	resets       int
+	lifetimes    []time.Duration
+	heartbeats   heartbeatMetrics
+	scheduling   schedulingMetrics
+}
+
+// ConnSummary condenses ConnMetrics for printing and JSON output
+type ConnSummary struct {
+	Opened         int            `json:"opened"`
+	Expected       int            `json:"expected"`
+	DialFailures   map[string]int `json:"dial_failures,omitempty"`
+	Resets         int            `json:"resets"`
+	Closed         int            `json:"closed"`
+	LifetimeP50Sec float64        `json:"lifetime_p50_sec"`
+	LifetimeMinSec float64        `json:"lifetime_min_sec"`
+	LifetimeMaxSec float64        `json:"lifetime_max_sec"`
+}
+
+// NewConnMetrics creates an empty set of connection metrics
+func NewConnMetrics() *ConnMetrics {
+	return &ConnMetrics{dialFailures: make(map[string]int)}
+}
+
+// Dialed implements ConnObserver
+func (m *ConnMetrics) Dialed(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.dialFailures[dialFailureReason(err)]++
+		return
+	}
+	m.dials++
+}
+
+// Reset implements ConnObserver
+func (m *ConnMetrics) Reset() {
+	m.mu.Lock()
+	m.resets++
+	m.mu.Unlock()
+}
+
+// Closed implements ConnObserver
+func (m *ConnMetrics) Closed(lifetime time.Duration) {
+	m.mu.Lock()
+	m.lifetimes = append(m.lifetimes, lifetime)
+	m.mu.Unlock()
+}
+
+// fdLimitReason is the dial failure reason for exhausted file descriptors
+const fdLimitReason = "fd-limit"
+
+// dialFailureReason classifies a dial error
+func dialFailureReason(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return fdLimitReason
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// Summary returns the metrics collected so far; expected is the number of
+// connections the mode should open
+func (m *ConnMetrics) Summary(expected int) ConnSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := ConnSummary{
+		Opened:   m.dials,
+		Expected: expected,
+		Resets:   m.resets,
+		Closed:   len(m.lifetimes),
+	}
+	if len(m.dialFailures) > 0 {
+		s.DialFailures = make(map[string]int, len(m.dialFailures))
+		for reason, n := range m.dialFailures {
+			s.DialFailures[reason] = n
+		}
+	}
+	if len(m.lifetimes) > 0 {
+		sorted := append([]time.Duration(nil), m.lifetimes...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.LifetimeMinSec = sorted[0].Seconds()
+		s.LifetimeP50Sec = percentile(sorted, 0.50).Seconds()
+		s.LifetimeMaxSec = sorted[len(sorted)-1].Seconds()
+	}
+	return s
+}
+
+// expectedConns returns how many connections the configured mode opens
+// when nothing reconnects. Shared and pool mode open theirs on every
+// server; multi mode's are spread over the servers with the users.
+func (lt *LoadTest) expectedConns() int {
+	switch lt.config.Mode {
+	case ModeMulti:
+		if lt.limitConns() && lt.config.Warmup > 0 {
+			// Capped users reconnect for the measured run after warmup
+			return 2 * lt.config.NumUsers
+		}
+		return lt.config.NumUsers
+	case ModePool:
+		if lt.config.PoolSize < 1 {
+			return len(lt.targets())
+		}
+		return lt.config.PoolSize * len(lt.targets())
+	default:
+		return len(lt.targets())
+	}
+}
+
+// printConnections prints the Connections section of the report
+func printConnections(s ConnSummary) {
+	fmt.Println("\nConnections:")
+	fmt.Printf("Opened: %d (expected %d)\n", s.Opened, s.Expected)
+	failures := 0
+	for _, n := range s.DialFailures {
+		failures += n
+	}
+	fmt.Printf("Dial failures: %d\n", failures)
+	reasons := make([]string, 0, len(s.DialFailures))
+	for reason := range s.DialFailures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  %s: %d\n", reason, s.DialFailures[reason])
+	}
+	if n := s.DialFailures[fdLimitReason]; n > 0 {
+		fmt.Printf("  %d dials failed on file descriptor limits; lower --max-conns or raise ulimit -n\n", n)
+	}
+	fmt.Printf("Reset by peer: %d\n", s.Resets)
+	fmt.Printf("Lifetime: min %.2fs, p50 %.2fs, max %.2fs (%d closed)\n",
+		s.LifetimeMinSec, s.LifetimeP50Sec, s.LifetimeMaxSec, s.Closed)
+}
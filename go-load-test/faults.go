@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"shrmpl/shrmpltest"
+)
+
+// connDropper is implemented by clients whose connection can be closed
+// from outside, to be re-established by the next operation
+type connDropper interface {
+	DropConnection()
+}
+
+// faultyClient applies a shrmpltest.FaultInjector, the injector exported
+// for applications' own tests, to the load test's client
+type faultyClient struct {
+	next     ThisAppKVInterface
+	injector *shrmpltest.FaultInjector
+}
+
+// newFaultyClient wraps client. Injected disconnects bump drops, which
+// every user of the same connection shares, so operations of other users
+// that the disconnect affected can be tagged too.
+func newFaultyClient(client ThisAppKVInterface, faults []shrmpltest.Fault, seed int64,
+	drops *atomic.Uint64) *faultyClient {
+	injector := shrmpltest.NewFaultInjector(nil, faults, seed)
+	injector.Disconnect = func() {
+		drops.Add(1)
+		if d, ok := client.(connDropper); ok {
+			d.DropConnection()
+		}
+	}
+	return &faultyClient{next: client, injector: injector}
+}
+
+// Get retrieves a value, subject to injected faults
+func (f *faultyClient) Get(key string) (string, error) {
+	if err := f.injector.Inject(); err != nil {
+		return "", err
+	}
+	return f.next.Get(key)
+}
+
+// Set stores a key-value pair, subject to injected faults
+func (f *faultyClient) Set(key, value, ttl string) error {
+	if err := f.injector.Inject(); err != nil {
+		return err
+	}
+	return f.next.Set(key, value, ttl)
+}
+
+// Incr increments a counter, subject to injected faults
+func (f *faultyClient) Incr(key string, ttl string) (int, error) {
+	if err := f.injector.Inject(); err != nil {
+		return 0, err
+	}
+	return f.next.Incr(key, ttl)
+}
+
+// Batch executes multiple commands, subject to injected faults
+func (f *faultyClient) Batch(commands []string) ([]string, error) {
+	if err := f.injector.Inject(); err != nil {
+		return nil, err
+	}
+	return f.next.Batch(commands)
+}
+
+// Close closes the wrapped client
+func (f *faultyClient) Close() {
+	f.next.Close()
+}
+
+// dropCounter returns the injected disconnect count of client's
+// connection, shared by all users of it
+func (lt *LoadTest) dropCounter(client ThisAppKVInterface) *atomic.Uint64 {
+	drops, _ := lt.drops.LoadOrStore(client, new(atomic.Uint64))
+	return drops.(*atomic.Uint64)
+}
+
+// injectedCounts tallies the injected fault kinds over results
+func injectedCounts(results []TestResult) (map[string]int, int, int) {
+	counts := make(map[string]int)
+	affected, failed := 0, 0
+	for _, r := range results {
+		if r.Injected == "" {
+			continue
+		}
+		affected++
+		if !r.Success {
+			failed++
+		}
+		for _, kind := range strings.Split(r.Injected, ",") {
+			counts[kind]++
+		}
+	}
+	return counts, affected, failed
+}
+
+// printInjected prints the fault injection section
+func printInjected(results []TestResult) {
+	counts, affected, failed := injectedCounts(results)
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	fmt.Println("\nInjected Faults (excluded from latency statistics):")
+	for _, kind := range kinds {
+		fmt.Printf("  %s: %d\n", kind, counts[kind])
+	}
+	pct := 0.0
+	if len(results) > 0 {
+		pct = float64(affected) / float64(len(results)) * 100
+	}
+	fmt.Printf("  Operations affected: %d (%.1f%%), failed: %d\n", affected, pct, failed)
+}
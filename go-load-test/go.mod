@@ -1,3 +1,7 @@
 module go-load-test
 
-go 1.21
\ No newline at end of file
+go 1.21
+
+require shrmpl v0.0.0
+
+replace shrmpl => ../examples/go/shrmpl
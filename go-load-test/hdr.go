@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HdrHistogram parameters: microsecond resolution up to one hour with three
+// significant digits. Memory is fixed (about 190KB) however many samples
+// are recorded.
+const (
+	hdrLowest  = 1
+	hdrHighest = int64(time.Hour / time.Microsecond)
+	hdrSigFigs = 3
+	// hdrUnitScale converts recorded microseconds to the milliseconds
+	// of the log's interval maximum
+	hdrUnitScale = 1000.0
+)
+
+// hdrHistogram is a minimal HdrHistogram: a log-linear histogram whose
+// bucket layout matches the reference implementation, so its encoding can
+// be read by the standard HdrHistogram tools
+type hdrHistogram struct {
+	unitMagnitude               int
+	subBucketHalfCountMagnitude int
+	subBucketCount              int
+	subBucketHalfCount          int
+	subBucketMask               int64
+	bucketCount                 int
+	counts                      []int64
+	totalCount                  int64
+	maxValue                    int64
+	// unit is the duration of one recorded value and highest the largest
+	// value recorded; larger ones are clamped
+	unit    time.Duration
+	highest int64
+}
+
+// newHdrHistogram creates an empty histogram tracking values from lowest
+// to highest units with sigFigs significant digits
+func newHdrHistogram(lowest, highest int64, sigFigs int, unit time.Duration) *hdrHistogram {
+	largestSingleUnit := 2 * math.Pow10(sigFigs)
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(largestSingleUnit)))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	if subBucketHalfCountMagnitude < 0 {
+		subBucketHalfCountMagnitude = 0
+	}
+	unitMagnitude := int(math.Floor(math.Log2(float64(lowest))))
+	subBucketCount := 1 << (subBucketHalfCountMagnitude + 1)
+
+	smallestUntrackable := int64(subBucketCount) << unitMagnitude
+	bucketCount := 1
+	for smallestUntrackable < highest {
+		smallestUntrackable <<= 1
+		bucketCount++
+	}
+
+	return &hdrHistogram{
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketCount:              subBucketCount,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               int64(subBucketCount-1) << unitMagnitude,
+		bucketCount:                 bucketCount,
+		counts:                      make([]int64, (bucketCount+1)*(subBucketCount/2)),
+		unit:                        unit,
+		highest:                     highest,
+	}
+}
+
+// newLatencyHistogram creates a histogram for operation latencies
+func newLatencyHistogram() *hdrHistogram {
+	return newHdrHistogram(hdrLowest, hdrHighest, hdrSigFigs, time.Microsecond)
+}
+
+// RecordDuration records d in the histogram's unit, clamping values
+// outside the trackable range. It is safe for concurrent use, so every
+// user records into the same histogram as its operations complete.
+func (h *hdrHistogram) RecordDuration(d time.Duration) {
+	v := int64(d / h.unit)
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highest {
+		v = h.highest
+	}
+	atomic.AddInt64(&h.counts[h.countsIndex(v)], 1)
+	atomic.AddInt64(&h.totalCount, 1)
+	for {
+		max := atomic.LoadInt64(&h.maxValue)
+		if v <= max || atomic.CompareAndSwapInt64(&h.maxValue, max, v) {
+			return
+		}
+	}
+}
+
+// bucketIndex returns the bucket holding v
+func (h *hdrHistogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - h.unitMagnitude - (h.subBucketHalfCountMagnitude + 1)
+}
+
+// countsIndex returns the counts slot for v
+func (h *hdrHistogram) countsIndex(v int64) int {
+	bucket := h.bucketIndex(v)
+	subBucket := int(v >> uint(bucket+h.unitMagnitude))
+	return (bucket+1)<<h.subBucketHalfCountMagnitude + (subBucket - h.subBucketHalfCount)
+}
+
+// valueFromIndex returns the lowest value counted in slot i
+func (h *hdrHistogram) valueFromIndex(i int) int64 {
+	bucket := (i >> h.subBucketHalfCountMagnitude) - 1
+	subBucket := (i & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= h.subBucketHalfCount
+		bucket = 0
+	}
+	return int64(subBucket) << uint(bucket+h.unitMagnitude)
+}
+
+// ValueAtQuantile returns the largest value equivalent to the recorded
+// value at quantile q, ranked like percentile; zero when empty
+func (h *hdrHistogram) ValueAtQuantile(q float64) time.Duration {
+	total := atomic.LoadInt64(&h.totalCount)
+	if total == 0 {
+		return 0
+	}
+	rank := int64(float64(total-1)*q) + 1
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		if cumulative >= rank {
+			// The slot's upper bound is one below the next slot's start
+			return time.Duration(h.valueFromIndex(i+1)-1) * h.unit
+		}
+	}
+	return time.Duration(atomic.LoadInt64(&h.maxValue)) * h.unit
+}
+
+// TotalCount returns the number of recorded values
+func (h *hdrHistogram) TotalCount() int64 {
+	return atomic.LoadInt64(&h.totalCount)
+}
+
+// Cookies of HdrHistogram's V2 encoding, for 8-byte counts
+const (
+	hdrEncodingCookie    = 0x1c849303 | 0x10
+	hdrCompressionCookie = 0x1c849304 | 0x10
+)
+
+// hdrEncodingHeader precedes the counts in the V2 encoding
+type hdrEncodingHeader struct {
+	Cookie                 int32
+	PayloadLength          int32
+	NormalizingIndexOffset int32
+	SignificantFigures     int32
+	LowestTrackableValue   int64
+	HighestTrackableValue  int64
+	IntegerToDoubleRatio   float64
+}
+
+// putZigZag appends v as a ZigZag LEB128 varint of at most 9 bytes, the
+// count encoding of the V2 format
+func putZigZag(buf *bytes.Buffer, v int64) {
+	u := uint64(v<<1) ^ uint64(v>>63)
+	for i := 0; i < 8; i++ {
+		if u < 0x80 {
+			buf.WriteByte(byte(u))
+			return
+		}
+		buf.WriteByte(byte(u) | 0x80)
+		u >>= 7
+	}
+	buf.WriteByte(byte(u))
+}
+
+// Encode returns the histogram in HdrHistogram's compressed V2 encoding,
+// base64 encoded as in histogram logs. Every HdrHistogram implementation
+// can decode it, and histograms of separate runs can be merged.
+func (h *hdrHistogram) Encode() (string, error) {
+	// Counts run up to the maximum; runs of zeros are written as their
+	// negated length
+	var payload bytes.Buffer
+	limit := 0
+	if h.totalCount > 0 {
+		limit = h.countsIndex(h.maxValue) + 1
+	}
+	for i := 0; i < limit; {
+		count := h.counts[i]
+		i++
+		if count == 0 {
+			zeros := int64(1)
+			for i < limit && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				putZigZag(&payload, -zeros)
+				continue
+			}
+		}
+		putZigZag(&payload, count)
+	}
+
+	var encoded bytes.Buffer
+	header := hdrEncodingHeader{
+		Cookie:                hdrEncodingCookie,
+		PayloadLength:         int32(payload.Len()),
+		SignificantFigures:    hdrSigFigs,
+		LowestTrackableValue:  hdrLowest,
+		HighestTrackableValue: hdrHighest,
+		IntegerToDoubleRatio:  1,
+	}
+	if err := binary.Write(&encoded, binary.BigEndian, header); err != nil {
+		return "", err
+	}
+	encoded.Write(payload.Bytes())
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(encoded.Bytes()); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	out := make([]byte, 8, 8+compressed.Len())
+	binary.BigEndian.PutUint32(out, hdrCompressionCookie)
+	binary.BigEndian.PutUint32(out[4:], uint32(compressed.Len()))
+	out = append(out, compressed.Bytes()...)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// WriteLog writes the histogram as a one-interval HdrHistogram log
+// (.hlog) covering the run that started at start and lasted length.
+// HistogramLogProcessor and the other HdrHistogram tools read and merge
+// these logs; the interval maximum is in milliseconds.
+func (h *hdrHistogram) WriteLog(path string, start time.Time, length time.Duration) error {
+	encoded, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	startSecs := float64(start.UnixMilli()) / 1000
+	fmt.Fprintf(w, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(w, "#[StartTime: %.3f (seconds since epoch), %s]\n", startSecs, start.Format(time.RFC1123))
+	fmt.Fprintf(w, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	fmt.Fprintf(w, "%.3f,%.3f,%.3f,%s\n", 0.0, length.Seconds(), float64(h.maxValue)/hdrUnitScale, encoded)
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hdrPathForMode derives the per-mode file name used by --compare-modes,
+// e.g. latency.hlog becomes latency-pool.hlog
+func hdrPathForMode(path, mode string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + mode + ext
+}
+
+// writeLatencies writes the histogram of the last Run to path, if set
+func (lt *LoadTest) writeLatencies(path string) error {
+	if path == "" || lt.latencies == nil {
+		return nil
+	}
+	return lt.latencies.WriteLog(path, lt.start, lt.elapsed)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decodeHdr decodes Encode's output as an HdrHistogram reader would,
+// returning the header and the counts up to the last non-zero one
+func decodeHdr(t *testing.T, s string) (hdrEncodingHeader, []int64) {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := binary.BigEndian.Uint32(raw); cookie != hdrCompressionCookie {
+		t.Fatalf("compression cookie = %#x", cookie)
+	}
+	if n := binary.BigEndian.Uint32(raw[4:]); int(n) != len(raw)-8 {
+		t.Fatalf("compressed length = %d, have %d bytes", n, len(raw)-8)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(raw[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(encoded)
+	var header hdrEncodingHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if int(header.PayloadLength) != r.Len() {
+		t.Fatalf("payload length = %d, have %d bytes", header.PayloadLength, r.Len())
+	}
+	var counts []int64
+	for r.Len() > 0 {
+		var u uint64
+		for shift := 0; ; shift += 7 {
+			b, _ := r.ReadByte()
+			if shift == 56 {
+				u |= uint64(b) << shift
+				break
+			}
+			u |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		v := int64(u>>1) ^ -int64(u&1)
+		if v < 0 {
+			counts = append(counts, make([]int64, -v)...)
+		} else {
+			counts = append(counts, v)
+		}
+	}
+	return header, counts
+}
+
+func TestHdrEncodeRoundTrip(t *testing.T) {
+	h := newLatencyHistogram()
+	for _, d := range []time.Duration{0, time.Microsecond, 250 * time.Microsecond,
+		3 * time.Millisecond, 3 * time.Millisecond, time.Second, 2 * time.Hour} {
+		h.RecordDuration(d)
+	}
+
+	header, counts := decodeHdr(t, mustEncode(t, h))
+	if header.Cookie != hdrEncodingCookie || header.SignificantFigures != hdrSigFigs ||
+		header.LowestTrackableValue != hdrLowest || header.HighestTrackableValue != hdrHighest {
+		t.Errorf("header = %+v", header)
+	}
+	// Counts end at the maximum, which was clamped to the highest value
+	if want := h.countsIndex(hdrHighest) + 1; len(counts) != want {
+		t.Fatalf("%d counts decoded, want %d", len(counts), want)
+	}
+	var total int64
+	for i, c := range counts {
+		if c != h.counts[i] {
+			t.Errorf("count %d = %d, want %d", i, c, h.counts[i])
+		}
+		total += c
+	}
+	if total != 7 {
+		t.Errorf("total = %d, want 7", total)
+	}
+}
+
+func TestHdrEncodeEmpty(t *testing.T) {
+	header, counts := decodeHdr(t, mustEncode(t, newLatencyHistogram()))
+	if header.PayloadLength != 0 || len(counts) != 0 {
+		t.Errorf("empty histogram encoded %d counts", len(counts))
+	}
+}
+
+func TestHdrConcurrentRecording(t *testing.T) {
+	h := newLatencyHistogram()
+	var wg sync.WaitGroup
+	for u := 0; u < 8; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.RecordDuration(time.Duration(u*1000+i) * time.Microsecond)
+			}
+		}(u)
+	}
+	wg.Wait()
+	if h.totalCount != 8000 || h.maxValue != 7999 {
+		t.Errorf("total, max = %d, %d; want 8000, 7999", h.totalCount, h.maxValue)
+	}
+}
+
+func TestHdrWriteLog(t *testing.T) {
+	h := newLatencyHistogram()
+	h.RecordDuration(1500 * time.Microsecond)
+	path := filepath.Join(t.TempDir(), "lat.hlog")
+	if err := h.WriteLog(path, time.Unix(1700000000, 0), 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "#[StartTime: 1700000000.000 ") {
+		t.Fatalf("log = %q", content)
+	}
+	fields := strings.Split(lines[3], ",")
+	if len(fields) != 4 || fields[0] != "0.000" || fields[1] != "90.000" || fields[2] != "1.500" {
+		t.Fatalf("interval = %q", lines[3])
+	}
+	if _, counts := decodeHdr(t, fields[3]); counts[h.countsIndex(1500)] != 1 {
+		t.Errorf("logged histogram lost the sample")
+	}
+}
+
+func mustEncode(t *testing.T, h *hdrHistogram) string {
+	t.Helper()
+	s, err := h.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHdrValueAtQuantile(t *testing.T) {
+	h := newLatencyHistogram()
+	if got := h.ValueAtQuantile(0.5); got != 0 {
+		t.Errorf("empty median = %s", got)
+	}
+	for i := 1; i <= 100; i++ {
+		h.RecordDuration(time.Duration(i) * time.Millisecond)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{{0, time.Millisecond}, {0.5, 50 * time.Millisecond}, {0.99, 99 * time.Millisecond}, {1, 100 * time.Millisecond}} {
+		// Three significant digits
+		got := h.ValueAtQuantile(tt.q)
+		if diff := got - tt.want; diff < 0 || diff > tt.want/1000 {
+			t.Errorf("quantile %v = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"shrmpl"
+)
+
+// opTrace collects the time one user's calls spent reading heartbeats,
+// which --correct-heartbeats takes out of the operation's latency. Only
+// the user's goroutine touches it.
+type opTrace struct {
+	heartbeatRead time.Duration
+}
+
+// tracingClient is implemented by the load test's clients, which add the
+// heartbeat reads of a call to the caller's opTrace
+type tracingClient interface {
+	getTraced(key string, t *opTrace) (string, error)
+	setTraced(key, value, ttl string, t *opTrace) error
+	incrTraced(key string, ttl string, t *opTrace) (int, error)
+	batchTraced(commands []string, t *opTrace) ([]string, error)
+}
+
+// tracedClient is one user's view of a possibly shared client that
+// collects the heartbeat reads of the user's calls in trace
+type tracedClient struct {
+	ThisAppKVInterface
+	tracing tracingClient
+	trace   opTrace
+}
+
+// newTracedClient returns a view of client tracing heartbeat reads, or
+// nil if client cannot attribute them
+func newTracedClient(client ThisAppKVInterface) *tracedClient {
+	tracing, ok := client.(tracingClient)
+	if !ok {
+		return nil
+	}
+	return &tracedClient{ThisAppKVInterface: client, tracing: tracing}
+}
+
+// Get retrieves a value, tracing its heartbeat reads
+func (c *tracedClient) Get(key string) (string, error) {
+	return c.tracing.getTraced(key, &c.trace)
+}
+
+// Set stores a key-value pair, tracing its heartbeat reads
+func (c *tracedClient) Set(key, value, ttl string) error {
+	return c.tracing.setTraced(key, value, ttl, &c.trace)
+}
+
+// Incr increments a counter, tracing its heartbeat reads
+func (c *tracedClient) Incr(key string, ttl string) (int, error) {
+	return c.tracing.incrTraced(key, ttl, &c.trace)
+}
+
+// Batch executes multiple commands, tracing their heartbeat reads
+func (c *tracedClient) Batch(commands []string) ([]string, error) {
+	return c.tracing.batchTraced(commands, &c.trace)
+}
+
+// DropConnection drops the wrapped client's connection, if it can
+func (c *tracedClient) DropConnection() {
+	if d, ok := c.ThisAppKVInterface.(connDropper); ok {
+		d.DropConnection()
+	}
+}
+
+// heartbeatMetrics collects round trips for the Heartbeats section. It is
+// guarded by ConnMetrics.mu. The histograms are allocated on first use.
+type heartbeatMetrics struct {
+	roundTrips int
+	heartbeats int
+	// Latencies of successful round trips without and with heartbeats,
+	// and of the latter less their heartbeat reads
+	unaffected *hdrHistogram
+	affected   *hdrHistogram
+	corrected  *hdrHistogram
+	// readCosts holds the mean heartbeat read time of each affected round
+	// trip, in nanoseconds
+	readCosts *hdrHistogram
+}
+
+// record adds d to *h, allocating it first if needed
+func record(h **hdrHistogram, d time.Duration) {
+	if *h == nil {
+		*h = newLatencyHistogram()
+	}
+	(*h).RecordDuration(d)
+}
+
+// quantile returns h's value at q, zero for a nil h
+func quantile(h *hdrHistogram, q float64) time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.ValueAtQuantile(q)
+}
+
+// HeartbeatSummary condenses heartbeatMetrics for printing and JSON output
+type HeartbeatSummary struct {
+	RoundTrips int `json:"round_trips"`
+	Affected   int `json:"affected"`
+	Heartbeats int `json:"heartbeats"`
+	// ReadCostMs is the median time to read one heartbeat line
+	ReadCostMs      float64 `json:"read_cost_ms"`
+	UnaffectedP50Ms float64 `json:"unaffected_p50_ms"`
+	UnaffectedP99Ms float64 `json:"unaffected_p99_ms"`
+	AffectedP50Ms   float64 `json:"affected_p50_ms"`
+	AffectedP99Ms   float64 `json:"affected_p99_ms"`
+	// Corrected is set by --correct-heartbeats: affected round trips less
+	// the time spent reading their heartbeats
+	Corrected      bool    `json:"corrected,omitempty"`
+	CorrectedP50Ms float64 `json:"corrected_p50_ms,omitempty"`
+	CorrectedP99Ms float64 `json:"corrected_p99_ms,omitempty"`
+}
+
+// RoundTrip implements ConnObserver
+func (m *ConnMetrics) RoundTrip(rt shrmpl.RoundTrip) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &m.heartbeats
+	h.roundTrips++
+	h.heartbeats += rt.Heartbeats
+	if rt.Heartbeats > 0 {
+		if h.readCosts == nil {
+			// Reading a buffered line takes well under a microsecond
+			h.readCosts = newHdrHistogram(1, int64(time.Minute), hdrSigFigs, time.Nanosecond)
+		}
+		h.readCosts.RecordDuration(rt.HeartbeatRead / time.Duration(rt.Heartbeats))
+	}
+	if rt.Err != nil {
+		return
+	}
+	if rt.Heartbeats == 0 {
+		record(&h.unaffected, rt.Duration)
+		return
+	}
+	record(&h.affected, rt.Duration)
+	record(&h.corrected, rt.Duration-rt.HeartbeatRead)
+}
+
+// ResetHeartbeats discards the round trips recorded so far, so warmup
+// does not count toward the Heartbeats section
+func (m *ConnMetrics) ResetHeartbeats() {
+	m.mu.Lock()
+	m.heartbeats = heartbeatMetrics{}
+	m.mu.Unlock()
+}
+
+// Heartbeats returns the heartbeat metrics collected so far, including
+// affected round trips less their heartbeat reads when correct is set
+func (m *ConnMetrics) Heartbeats(correct bool) HeartbeatSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &m.heartbeats
+
+	s := HeartbeatSummary{
+		RoundTrips: h.roundTrips,
+		Heartbeats: h.heartbeats,
+		ReadCostMs: durationMs(quantile(h.readCosts, 0.50)),
+
+		UnaffectedP50Ms: durationMs(quantile(h.unaffected, 0.50)),
+		UnaffectedP99Ms: durationMs(quantile(h.unaffected, 0.99)),
+		AffectedP50Ms:   durationMs(quantile(h.affected, 0.50)),
+		AffectedP99Ms:   durationMs(quantile(h.affected, 0.99)),
+	}
+	if h.affected != nil {
+		s.Affected = int(h.affected.TotalCount())
+	}
+	if correct {
+		s.Corrected = true
+		s.CorrectedP50Ms = durationMs(quantile(h.corrected, 0.50))
+		s.CorrectedP99Ms = durationMs(quantile(h.corrected, 0.99))
+	}
+	return s
+}
+
+// sortedDurations returns a sorted copy of d
+func sortedDurations(d []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// durationMs converts d to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printHeartbeats prints the Heartbeats section of the report
+func printHeartbeats(s HeartbeatSummary) {
+	fmt.Println("\nHeartbeats:")
+	share := 0.0
+	if s.RoundTrips > 0 {
+		share = float64(s.Affected) / float64(s.RoundTrips) * 100
+	}
+	fmt.Printf("UPONG lines read: %d, in %d of %d round trips (%.2f%%)\n",
+		s.Heartbeats, s.Affected, s.RoundTrips, share)
+	fmt.Printf("Read cost per heartbeat: %.3fms (median)\n", s.ReadCostMs)
+	fmt.Printf("Unaffected: p50 %.2fms, p99 %.2fms\n", s.UnaffectedP50Ms, s.UnaffectedP99Ms)
+	fmt.Printf("Affected:   p50 %.2fms, p99 %.2fms\n", s.AffectedP50Ms, s.AffectedP99Ms)
+	if s.Corrected {
+		fmt.Printf("Corrected:  p50 %.2fms, p99 %.2fms\n", s.CorrectedP50Ms, s.CorrectedP99Ms)
+	}
+}
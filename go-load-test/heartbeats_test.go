@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"shrmpl"
+)
+
+// heartbeatServer answers every command with a heartbeat and OK, both
+// sent after delay so the heartbeat read carries the wait
+func heartbeatServer(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					time.Sleep(delay)
+					if _, err := conn.Write([]byte("UPONG\nOK\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTracedClientCollectsHeartbeatReads(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	metrics := NewConnMetrics()
+	kv := NewKV(&KVConfig{HostPort: heartbeatServer(t, delay), Observer: metrics})
+	defer kv.Close()
+	shared := NewSharedClient(kv.(*KV), func() {})
+
+	traced := newTracedClient(shared)
+	if traced == nil {
+		t.Fatal("SharedClient cannot trace heartbeats")
+	}
+	start := time.Now()
+	if err := traced.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if read := traced.trace.heartbeatRead; read < delay || read > elapsed {
+		t.Errorf("heartbeat read = %s, want between %s and the call's %s", read, delay, elapsed)
+	}
+
+	// Untraced calls on the same connection leave the trace alone
+	before := traced.trace
+	if err := shared.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+	if traced.trace != before {
+		t.Errorf("an untraced call changed the trace")
+	}
+
+	s := metrics.Heartbeats(true)
+	if s.RoundTrips != 2 || s.Heartbeats != 2 || s.Affected != 2 {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.CorrectedP99Ms >= s.AffectedP50Ms {
+		t.Errorf("corrected p99 %.3fms not below affected p50 %.3fms", s.CorrectedP99Ms, s.AffectedP50Ms)
+	}
+}
+
+func TestHeartbeatMetricsReset(t *testing.T) {
+	m := NewConnMetrics()
+	m.RoundTrip(shrmpl.RoundTrip{Duration: time.Millisecond})
+	m.RoundTrip(shrmpl.RoundTrip{Duration: 3 * time.Millisecond, Heartbeats: 1, HeartbeatRead: time.Millisecond})
+	s := m.Heartbeats(false)
+	if s.RoundTrips != 2 || s.Affected != 1 || s.Corrected {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.AffectedP50Ms < 2.9 || s.AffectedP50Ms > 3.1 || s.ReadCostMs < 0.99 || s.ReadCostMs > 1.01 {
+		t.Errorf("affected p50 = %.3fms, read cost = %.3fms", s.AffectedP50Ms, s.ReadCostMs)
+	}
+
+	// Warmup round trips are discarded before the measured run
+	m.ResetHeartbeats()
+	if s := m.Heartbeats(true); s.RoundTrips != 0 || s.Heartbeats != 0 || s.AffectedP99Ms != 0 {
+		t.Errorf("after reset summary = %+v", s)
+	}
+}
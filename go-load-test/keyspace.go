@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// maxKeyBase bounds the seed-derived start of the key space so that key
+// indexes stay short
+const maxKeyBase = 1_000_000_000
+
+// KeySpace partitions the keys a run writes. Every user owns a contiguous
+// range of key indexes derived from the seed and its user ID, so no two
+// users ever write the same owned key. Shared keys are a separate pool that
+// templates opt into with {shared} to measure contention.
+type KeySpace struct {
+	base   int64
+	users  int
+	span   int
+	shared int
+}
+
+// NewKeySpace gives each of users span key indexes and places the shared
+// pool after the owned ranges. Runs with different seeds use different
+// ranges, so they do not interfere when pointed at the same server.
+func NewKeySpace(seed int64, users, span, shared int) KeySpace {
+	if span < 1 {
+		span = 1
+	}
+	return KeySpace{
+		base:   rand.New(rand.NewSource(seed)).Int63n(maxKeyBase),
+		users:  users,
+		span:   span,
+		shared: shared,
+	}
+}
+
+// Index returns the key index of slot within the user's owned range
+func (k KeySpace) Index(user, slot int) int64 {
+	return k.base + int64(user)*int64(k.span) + int64(slot%k.span)
+}
+
+// Owned returns the owned key prefix_<index> for slot
+func (k KeySpace) Owned(prefix string, user, slot int) string {
+	return fmt.Sprintf("%s_%d", prefix, k.Index(user, slot))
+}
+
+// Counter returns the user's owned INCR counter key
+func (k KeySpace) Counter(user int) string {
+	return k.Owned("counter", user, 0)
+}
+
+// Shared picks one index from the shared pool
+func (k KeySpace) Shared(rng *rand.Rand) int64 {
+	first := k.base + int64(k.users)*int64(k.span)
+	if k.shared <= 0 {
+		return first
+	}
+	return first + int64(rng.Intn(k.shared))
+}
+
+// ownedModel mirrors the server state of one user's owned counter. Only
+// the owning user writes it, so expectations are exact; after a failed
+// INCR the outcome on the server is unknown and the model resynchronises
+// from the next reply instead of reporting a mismatch.
+type ownedModel struct {
+	counter  int
+	unsynced bool
+}
+
+// incr records an INCR reply and reports whether it matched the model
+func (m *ownedModel) incr(count int) bool {
+	ok := m.unsynced || count == m.counter+1
+	m.counter = count
+	m.unsynced = false
+	return ok
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shrmpl"
+)
+
+// ThisAppKVInterface defines the key-value store interface for this application
+type ThisAppKVInterface interface {
+	Get(key string) (string, error)
+	Set(key, value, ttl string) error
+	Incr(key string, ttl string) (int, error)
+	Batch(commands []string) ([]string, error)
+	Close()
+}
+
+// KV wraps a shrmpl client for the load test, adding the reconnect, lock
+// wait and heartbeat accounting the report needs
+type KV struct {
+	shrmplKVClient *shrmpl.ShrmplKVClient
+	hostPort       string
+	observer       ConnObserver
+	sharedBuffers  bool
+	mu             sync.Mutex
+	lockWaitNanos  atomic.Int64
+	reconnects     atomic.Int64
+	// events is the client's shrmpl.ConnObserver, guarded by mu
+	events connEvents
+
+	// gen counts connection changes (drops, reconnects, address updates)
+	// and is guarded by mu. A reconnect dials without holding mu and only
+	// installs its connection if gen has not moved in the meantime.
+	gen uint64
+}
+
+// parseHostPort parses a "host:port" string into separate
+// host and port components
+func parseHostPort(hostPort string) (string, string, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid host:port format: %s", hostPort)
+	}
+	return host, port, nil
+}
+
+// NewKV creates a key-value store client
+func NewKV(config *KVConfig) ThisAppKVInterface {
+	kv := &KV{
+		hostPort:      config.HostPort,
+		observer:      config.Observer,
+		sharedBuffers: config.SharedReadBuffers,
+	}
+	kv.events.observer = config.Observer
+
+	// Parse the combined host:port string
+	host, portStr, err := parseHostPort(config.HostPort)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse kv_host_port: %s\n", err.Error())
+		return kv
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid port in kv_host_port: %s\n", err.Error())
+		return kv
+	}
+
+	shrmplKV := kv.newClient(host, port)
+	if err := shrmplKV.Connect(); err != nil {
+		// If we can't connect, we'll return a client that logs errors
+		// The operations will fail gracefully
+		fmt.Fprintf(os.Stderr, "Failed to connect to shrmpl-kv: %s\n", err.Error())
+		return kv
+	}
+
+	kv.shrmplKVClient = shrmplKV
+	return kv
+}
+
+// lock acquires the client mutex, recording and returning how long the
+// caller waited
+func (kv *KV) lock() time.Duration {
+	start := time.Now()
+	kv.mu.Lock()
+	wait := time.Since(start)
+	kv.lockWaitNanos.Add(int64(wait))
+	return wait
+}
+
+// observe reports an operation that started at start, waited wait for the
+// mutex and spent wire on its command
+func (kv *KV) observe(start time.Time, wait, wire time.Duration, err error) {
+	if kv.observer != nil {
+		kv.observer.Operation(Operation{
+			LockWait: wait,
+			Wire:     wire,
+			Total:    time.Since(start),
+			Err:      err,
+		})
+	}
+}
+
+// LockWait returns the total time callers spent waiting for the connection
+func (kv *KV) LockWait() time.Duration {
+	return time.Duration(kv.lockWaitNanos.Load())
+}
+
+// Reconnects returns how many times the client re-established its connection
+func (kv *KV) Reconnects() int {
+	return int(kv.reconnects.Load())
+}
+
+// ensureConnected reconnects if the connection was dropped and reports
+// whether one is available. It is called with mu held but releases it
+// while dialing, so several callers may dial at once; the first to finish
+// installs its connection and the others close theirs instead of leaking
+// them.
+func (kv *KV) ensureConnected() bool {
+	if kv.shrmplKVClient != nil {
+		return true
+	}
+	gen, hostPort := kv.gen, kv.hostPort
+	kv.mu.Unlock()
+	client := kv.dial(hostPort)
+	kv.lock()
+
+	if client == nil {
+		return kv.shrmplKVClient != nil
+	}
+	if kv.gen != gen || kv.shrmplKVClient != nil {
+		// Lost the race to another reconnect or an address update
+		client.Close()
+		return kv.shrmplKVClient != nil
+	}
+	kv.shrmplKVClient = client
+	kv.gen++
+	kv.reconnects.Add(1)
+	return true
+}
+
+// dial connects to hostPort, returning nil on failure
+func (kv *KV) dial(hostPort string) *shrmpl.ShrmplKVClient {
+	host, portStr, err := parseHostPort(hostPort)
+	if err != nil {
+		return nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil
+	}
+	client := kv.newClient(host, port)
+	if err := client.Connect(); err != nil {
+		return nil
+	}
+	return client
+}
+
+// newClient returns an unconnected client reporting to kv's observer
+func (kv *KV) newClient(host string, port int) *shrmpl.ShrmplKVClient {
+	client := shrmpl.NewShrmplKVClient(host, port)
+	client.SetConnObserver(&kv.events)
+	client.SetSharedReadBuffers(kv.sharedBuffers)
+	return client
+}
+
+// connEvents forwards a client's connection events to the run's observer
+// and adds the heartbeat reads of the call in progress to its caller's
+// opTrace
+type connEvents struct {
+	observer ConnObserver
+	trace    *opTrace
+}
+
+// Dialed implements shrmpl.ConnObserver
+func (e *connEvents) Dialed(err error) {
+	if e.observer != nil {
+		e.observer.Dialed(err)
+	}
+}
+
+// Reset implements shrmpl.ConnObserver
+func (e *connEvents) Reset() {
+	if e.observer != nil {
+		e.observer.Reset()
+	}
+}
+
+// Closed implements shrmpl.ConnObserver
+func (e *connEvents) Closed(lifetime time.Duration) {
+	if e.observer != nil {
+		e.observer.Closed(lifetime)
+	}
+}
+
+// RoundTrip implements shrmpl.ConnObserver
+func (e *connEvents) RoundTrip(rt shrmpl.RoundTrip) {
+	if e.trace != nil {
+		e.trace.heartbeatRead += rt.HeartbeatRead
+	}
+	if e.observer != nil {
+		e.observer.RoundTrip(rt)
+	}
+}
+
+// opError strips the connection details shrmpl adds to transport errors,
+// so the run's error counts group by cause rather than by connection
+func opError(err error) error {
+	var connErr *shrmpl.ConnError
+	if errors.As(err, &connErr) {
+		return connErr.Err
+	}
+	return err
+}
+
+// drop closes the current connection after a failure. mu must be held.
+func (kv *KV) drop() {
+	if kv.shrmplKVClient != nil {
+		kv.shrmplKVClient.Close()
+		kv.shrmplKVClient = nil
+	}
+	kv.gen++
+}
+
+// UpdateAddress repoints the client at hostPort. The new connection is
+// established while requests continue on the old one and swapped in once
+// the in-flight request finishes; the old connection is then closed. If
+// hostPort cannot be reached the current connection is left untouched.
+func (kv *KV) UpdateAddress(hostPort string) error {
+	host, portStr, err := parseHostPort(hostPort)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+	client := kv.newClient(host, port)
+	if err := client.Connect(); err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	old := kv.shrmplKVClient
+	kv.shrmplKVClient = client
+	kv.hostPort = hostPort
+	kv.gen++
+	kv.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Get retrieves a value from the key-value store
+func (kv *KV) Get(key string) (string, error) {
+	return kv.getTraced(key, nil)
+}
+
+// getTraced is Get, adding the heartbeat reads of the call to t
+func (kv *KV) getTraced(key string, t *opTrace) (string, error) {
+	start := time.Now()
+	wait := kv.lock()
+	defer kv.mu.Unlock()
+
+	if !kv.ensureConnected() {
+		return "", fmt.Errorf("key-value store not available")
+	}
+
+	sent := time.Now()
+	c := kv.shrmplKVClient
+	kv.events.trace = t
+	val, err := c.Get(key)
+	kv.events.trace = nil
+	err = opError(err)
+	kv.observe(start, wait, time.Since(sent), err)
+	if errors.Is(err, shrmpl.ErrKeyEvicted) {
+		return "", err
+	}
+	if err != nil {
+		kv.drop()
+		return "", err
+	}
+	return val, nil
+}
+
+// Set stores a key-value pair with optional TTL
+func (kv *KV) Set(key, value, ttl string) error {
+	return kv.setTraced(key, value, ttl, nil)
+}
+
+// setTraced is Set, adding the heartbeat reads of the call to t
+func (kv *KV) setTraced(key, value, ttl string, t *opTrace) error {
+	start := time.Now()
+	wait := kv.lock()
+	defer kv.mu.Unlock()
+
+	if !kv.ensureConnected() {
+		return fmt.Errorf("key-value store not available")
+	}
+
+	sent := time.Now()
+	c := kv.shrmplKVClient
+	kv.events.trace = t
+	err := opError(c.Set(key, value, ttl))
+	kv.events.trace = nil
+	kv.observe(start, wait, time.Since(sent), err)
+	if err != nil {
+		kv.drop()
+		return err
+	}
+	return nil
+}
+
+// Incr increments a counter and returns the new value
+func (kv *KV) Incr(key string, ttl string) (int, error) {
+	return kv.incrTraced(key, ttl, nil)
+}
+
+// incrTraced is Incr, adding the heartbeat reads of the call to t
+func (kv *KV) incrTraced(key string, ttl string, t *opTrace) (int, error) {
+	start := time.Now()
+	wait := kv.lock()
+	defer kv.mu.Unlock()
+
+	if !kv.ensureConnected() {
+		return 0, fmt.Errorf("key-value store not available")
+	}
+
+	sent := time.Now()
+	c := kv.shrmplKVClient
+	kv.events.trace = t
+	val, err := c.Incr(key, ttl)
+	kv.events.trace = nil
+	err = opError(err)
+	kv.observe(start, wait, time.Since(sent), err)
+	if err != nil {
+		kv.drop()
+		return 0, err
+	}
+	return val, nil
+}
+
+// Batch executes multiple commands in a single call
+func (kv *KV) Batch(commands []string) ([]string, error) {
+	return kv.batchTraced(commands, nil)
+}
+
+// batchTraced is Batch, adding the heartbeat reads of the call to t
+func (kv *KV) batchTraced(commands []string, t *opTrace) ([]string, error) {
+	if len(commands) > 3 {
+		return nil, fmt.Errorf("batch cannot exceed 3 commands")
+	}
+
+	start := time.Now()
+	wait := kv.lock()
+	defer kv.mu.Unlock()
+
+	if !kv.ensureConnected() {
+		return nil, fmt.Errorf("key-value store not available")
+	}
+
+	sent := time.Now()
+	c := kv.shrmplKVClient
+	kv.events.trace = t
+	results, err := c.Batch(commands)
+	kv.events.trace = nil
+	err = opError(err)
+	kv.observe(start, wait, time.Since(sent), err)
+	var serverErr *shrmpl.ServerError
+	if errors.As(err, &serverErr) {
+		return nil, err
+	}
+	if err != nil {
+		kv.drop()
+		return nil, err
+	}
+	return rawResults(results), nil
+}
+
+// rawResults turns batch results back into the response line of each
+// command, the form the templates verify
+func rawResults(results []shrmpl.BatchResult) []string {
+	raw := make([]string, len(results))
+	for i, r := range results {
+		var serverErr *shrmpl.ServerError
+		switch {
+		case errors.As(r.Err, &serverErr):
+			raw[i] = serverErr.Error()
+		case errors.Is(r.Err, shrmpl.ErrKeyEvicted):
+			raw[i] = "*KEY EVICTED*"
+		case r.NotFound:
+			raw[i] = "*KEY NOT FOUND*"
+		default:
+			raw[i] = r.Value
+		}
+	}
+	return raw
+}
+
+// Connected reports whether a connection is available, dialing once if
+// it was dropped
+func (kv *KV) Connected() bool {
+	kv.lock()
+	defer kv.mu.Unlock()
+	return kv.ensureConnected()
+}
+
+// DropConnection closes the current connection; the next operation
+// reconnects
+func (kv *KV) DropConnection() {
+	kv.Close()
+}
+
+// Close closes the underlying KV client connection
+func (kv *KV) Close() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.drop()
+}
+
+// ErrPoolClosed is returned by pool operations once Drain has started
+var ErrPoolClosed = errors.New("connection pool is closed")
+
+// KVPool spreads operations over a fixed set of connections. Each
+// operation checks out an idle connection, waiting if all are busy.
+type KVPool struct {
+	clients       []*KV
+	idle          chan *KV
+	lockWaitNanos atomic.Int64
+
+	// Drain state: checkedOut counts connections in use, draining is
+	// closed when Drain starts and drained once all have been returned
+	mu         sync.Mutex
+	checkedOut int
+	closed     bool
+	draining   chan struct{}
+	drained    chan struct{}
+}
+
+// NewKVPool creates a pool of size connections to the configured server
+func NewKVPool(config *KVConfig, size int) *KVPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &KVPool{
+		idle:     make(chan *KV, size),
+		draining: make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		kv := NewKV(config).(*KV)
+		p.clients = append(p.clients, kv)
+		p.idle <- kv
+	}
+	return p
+}
+
+// acquire checks out an idle connection, recording the wait. It fails
+// with ErrPoolClosed once the pool is draining.
+func (p *KVPool) acquire() (*KV, error) {
+	start := time.Now()
+	select {
+	case kv := <-p.idle:
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			p.idle <- kv
+			return nil, ErrPoolClosed
+		}
+		p.checkedOut++
+		p.mu.Unlock()
+		p.lockWaitNanos.Add(int64(time.Since(start)))
+		return kv, nil
+	case <-p.draining:
+		return nil, ErrPoolClosed
+	}
+}
+
+// release returns a connection to the pool
+func (p *KVPool) release(kv *KV) {
+	p.idle <- kv
+	p.mu.Lock()
+	p.checkedOut--
+	if p.closed && p.checkedOut == 0 {
+		close(p.drained)
+	}
+	p.mu.Unlock()
+}
+
+// Drain stops handing out connections, waits for every checked-out
+// connection to be returned and closes them all. If ctx ends first the
+// remaining connections are closed as their operations finish and ctx's
+// error is returned. Operations started during or after Drain fail with
+// ErrPoolClosed.
+func (p *KVPool) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.draining)
+		if p.checkedOut == 0 {
+			close(p.drained)
+		}
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.drained:
+		p.Close()
+		return nil
+	case <-ctx.Done():
+		go p.Close()
+		return ctx.Err()
+	}
+}
+
+// Get retrieves a value using a pooled connection
+func (p *KVPool) Get(key string) (string, error) {
+	return p.getTraced(key, nil)
+}
+
+// getTraced is Get, adding the heartbeat reads of the call to t
+func (p *KVPool) getTraced(key string, t *opTrace) (string, error) {
+	kv, err := p.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer p.release(kv)
+	return kv.getTraced(key, t)
+}
+
+// Set stores a key-value pair using a pooled connection
+func (p *KVPool) Set(key, value, ttl string) error {
+	return p.setTraced(key, value, ttl, nil)
+}
+
+// setTraced is Set, adding the heartbeat reads of the call to t
+func (p *KVPool) setTraced(key, value, ttl string, t *opTrace) error {
+	kv, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer p.release(kv)
+	return kv.setTraced(key, value, ttl, t)
+}
+
+// Incr increments a counter using a pooled connection
+func (p *KVPool) Incr(key string, ttl string) (int, error) {
+	return p.incrTraced(key, ttl, nil)
+}
+
+// incrTraced is Incr, adding the heartbeat reads of the call to t
+func (p *KVPool) incrTraced(key string, ttl string, t *opTrace) (int, error) {
+	kv, err := p.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer p.release(kv)
+	return kv.incrTraced(key, ttl, t)
+}
+
+// Batch executes multiple commands using a pooled connection
+func (p *KVPool) Batch(commands []string) ([]string, error) {
+	return p.batchTraced(commands, nil)
+}
+
+// batchTraced is Batch, adding the heartbeat reads of the call to t
+func (p *KVPool) batchTraced(commands []string, t *opTrace) ([]string, error) {
+	kv, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.release(kv)
+	return kv.batchTraced(commands, t)
+}
+
+// poolRollInterval spaces out connection swaps in KVPool.UpdateAddress so
+// the new server is not hit by every reconnect at once
+const poolRollInterval = 50 * time.Millisecond
+
+// UpdateAddress moves the pool to hostPort one connection at a time, each
+// swapping once its in-flight operation finishes. If a connection cannot
+// reach hostPort, connections already moved are returned to their old
+// address and the error is returned.
+func (p *KVPool) UpdateAddress(hostPort string) error {
+	for i, kv := range p.clients {
+		if i > 0 {
+			time.Sleep(poolRollInterval)
+		}
+		kv.mu.Lock()
+		previous := kv.hostPort
+		kv.mu.Unlock()
+		if err := kv.UpdateAddress(hostPort); err != nil {
+			for _, moved := range p.clients[:i] {
+				_ = moved.UpdateAddress(previous)
+			}
+			return fmt.Errorf("connection %d of %d: %w", i+1, len(p.clients), err)
+		}
+	}
+	return nil
+}
+
+// DropConnection closes one idle pooled connection; its next operation
+// reconnects
+func (p *KVPool) DropConnection() {
+	kv, err := p.acquire()
+	if err != nil {
+		return
+	}
+	kv.DropConnection()
+	p.release(kv)
+}
+
+// LockWait returns the total time callers spent waiting for a connection
+func (p *KVPool) LockWait() time.Duration {
+	return time.Duration(p.lockWaitNanos.Load())
+}
+
+// Reconnects returns the reconnect count summed over all connections
+func (p *KVPool) Reconnects() int {
+	total := 0
+	for _, kv := range p.clients {
+		total += kv.Reconnects()
+	}
+	return total
+}
+
+// Close closes every pooled connection
+func (p *KVPool) Close() {
+	for _, kv := range p.clients {
+		kv.Close()
+	}
+}
+
+// KVConfig for configuring the KV client
+type KVConfig struct {
+	HostPort string
+	// Observer, when set, is told about every dial, reset and close
+	Observer ConnObserver
+	// SharedReadBuffers reads responses through buffers shared by all
+	// connections instead of a bufio.Reader per connection, so idle
+	// connections hold no read buffer
+	SharedReadBuffers bool
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+func TestKVBatchRawResults(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	srv.Put("a", "1")
+	srv.Put("gone", "x")
+	srv.Evict("gone")
+
+	kv := NewKV(&KVConfig{HostPort: srv.Addr})
+	defer kv.Close()
+
+	// The templates verify the server's own response lines
+	results, err := kv.Batch([]string{"GET a", "GET missing", "GET gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1", "*KEY NOT FOUND*", "*KEY EVICTED*"}
+	if strings.Join(results, ";") != strings.Join(want, ";") {
+		t.Errorf("Batch = %q, want %q", results, want)
+	}
+}
+
+func TestKVSharedReadBuffers(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	metrics := NewConnMetrics()
+	kv := NewKV(&KVConfig{HostPort: srv.Addr, Observer: metrics, SharedReadBuffers: true})
+	defer kv.Close()
+
+	for i, value := range []string{"a", "bb", "ccc"} {
+		if err := kv.Set("k", value, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := kv.Get("k"); err != nil || got != value {
+			t.Fatalf("Get %d = %q, %v; want %q", i, got, err, value)
+		}
+	}
+	if s := metrics.Heartbeats(false); s.RoundTrips != 6 {
+		t.Errorf("round trips = %d, want 6", s.RoundTrips)
+	}
+}
+
+func TestKVErrorsOmitConnection(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	kv := NewKV(&KVConfig{HostPort: srv.Addr})
+	defer kv.Close()
+	if err := kv.Set("k", "v", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Error counts group by message, so the connection details shrmpl
+	// attaches must not reach them
+	srv.DropConnections()
+	_, err := kv.Get("k")
+	if err == nil {
+		t.Fatal("Get on a dropped connection succeeded")
+	}
+	var connErr *shrmpl.ConnError
+	if errors.As(err, &connErr) {
+		t.Errorf("Get = %v, want the transport error without its connection", err)
+	}
+
+	// The next operation reconnects
+	if value, err := kv.Get("k"); err != nil || value != "v" {
+		t.Errorf("Get after reconnecting = %q, %v; want v", value, err)
+	}
+}
+
+func TestKVReconnectsCloseLosingDials(t *testing.T) {
+	srv := shrmpltest.NewKVServer()
+	defer srv.Close()
+	metrics := NewConnMetrics()
+	kv := NewKV(&KVConfig{HostPort: srv.Addr, Observer: metrics}).(*KV)
+
+	// Every round drops the connection and lets several users race to
+	// reconnect; the dials that lose the race must be closed, not leaked
+	const rounds, users = 10, 8
+	for round := 0; round < rounds; round++ {
+		kv.DropConnection()
+		var wg sync.WaitGroup
+		for u := 0; u < users; u++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := kv.Set("k", "v", ""); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	kv.Close()
+
+	// The server counts a dial when it accepts it, which may trail the
+	// client's connect
+	s := metrics.Summary(1)
+	deadline := time.Now().Add(time.Second)
+	for srv.Dials() < s.Opened && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	dials := srv.Dials()
+	if dials < rounds+1 {
+		t.Errorf("server saw %d dials, want at least %d", dials, rounds+1)
+	}
+	if s.Opened != dials || s.Closed != dials {
+		t.Errorf("server saw %d dials, client opened %d and closed %d; want all equal",
+			dials, s.Opened, s.Closed)
+	}
+	if kv.Reconnects() != rounds {
+		t.Errorf("reconnects = %d, want one installed connection per round (%d)",
+			kv.Reconnects(), rounds)
+	}
+}
@@ -1,55 +1,266 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"shrmpl"
+	"shrmpl/shrmpltest"
+)
+
+// Connection modes
+const (
+	ModeShared = "shared"
+	ModeMulti  = "multi"
+	ModePool   = "pool"
 )
 
 type TestConfig struct {
+	// ServerAddr is the server under test; with Servers it is the first
+	// of them
 	ServerAddr string
-	NumUsers   int
-	Operations int
-	SharedConn bool
-	FullTest   bool
-	ConfigFile string
+	// Servers spreads the users over several servers by user index
+	Servers        []string
+	BatchTemplates []BatchTemplate
+	NumUsers       int
+	Operations     int
+	Mode           string
+	PoolSize       int
+	MaxConns       int
+	Warmup         int
+	CoolDown       time.Duration
+	CompareModes   bool
+	FullTest       bool
+	ThinkTime      time.Duration
+	ValueSize      int
+	Preset         string
+	VerifySample   float64
+	Seed           int64
+	NoHints        bool
+	JSONPath       string
+	HDRPath        string
+	Faults         []shrmpltest.Fault
+	SharedKeys     int
+	Progress       bool
+	SharedBuffers  bool
+	ConfigFile     string
+	// Duration runs each user until it elapses instead of for Operations
+	Duration time.Duration
+	// Rate caps total operations per second across all users (0 = no cap)
+	Rate       float64
+	TTLs       []TTLChoice
+	Thresholds Thresholds
+	// RunID identifies the run in the report and the marker keys
+	RunID string
+	// Markers sets the marker keys at phase boundaries; KeepMarkers
+	// leaves them in place afterwards
+	Markers     bool
+	KeepMarkers bool
+	// CorrectHeartbeats subtracts the time spent reading UPONG lines from
+	// the latency of the operations and round trips that read them
+	CorrectHeartbeats bool
 }
 
 type TestResult struct {
 	Duration  time.Duration
 	Success   bool
 	ErrorType string
+	Verified  bool
+	Mismatch  bool
+	Template  string
+	// Injected lists the fault kinds injected during the operation
+	Injected string
+	// Shared marks operations whose template touched the shared key pool
+	Shared bool
+	// Evicted marks verified reads the server answered with an eviction
+	Evicted bool
+	// Server is the address the operation went to in a multi-server run
+	Server string
+}
+
+// measured reports whether the result counts toward latency statistics:
+// successful and not affected by injected faults
+func (r TestResult) measured() bool {
+	return r.Success && r.Injected == ""
 }
 
 type LoadTest struct {
 	config TestConfig
+	// start and elapsed span the measured run
+	start      time.Time
+	elapsed    time.Duration
+	cpuTime    time.Duration
+	lockWait   time.Duration
+	reconnects int
+	conns      *ConnMetrics
+	connSum    ConnSummary
+	heartbeats HeartbeatSummary
+	scheduling SchedulingSummary
+	connSlots  chan struct{}
+	latencies  *hdrHistogram
+	keys       KeySpace
+	progress   *progress
+	// deadline ends the measured run in duration mode
+	deadline time.Time
+	// rtt is the baseline PING round trip measured before the run
+	rtt time.Duration
+	// shared holds the guarded client of each server in shared mode
+	shared []*SharedClient
+	// stopped ends the run early once the server is gone for good
+	stopped atomic.Bool
+	// drops counts the injected disconnects of each client
+	drops sync.Map
+}
+
+// clientMetrics is implemented by clients that expose connection metrics
+type clientMetrics interface {
+	lockWaiter
+	Reconnects() int
 }
 
 func NewLoadTest(config TestConfig) *LoadTest {
-	return &LoadTest{config: config}
+	// Warmup and the measured run write the same owned keys
+	span := max(config.Operations, config.Warmup)
+	return &LoadTest{
+		config: config,
+		keys:   NewKeySpace(config.Seed, config.NumUsers, span, config.SharedKeys),
+	}
 }
 
 func (lt *LoadTest) Run() []TestResult {
-	var results []TestResult
+	// Connection metrics cover the whole run, including warmup, since the
+	// connections are opened before it
+	lt.conns = NewConnMetrics()
+	forUser, clients := lt.newClients()
+
+	// Warmup uses the same clients as the measured run, then the key space
+	// is reset so that every run starts from the same state
+	lt.resetKeySpace()
+	if lt.config.Warmup > 0 {
+		lt.mark(phaseWarmup)
+		lt.runUsers(forUser, lt.config.Warmup)
+		lt.resetKeySpace()
+	}
+	for _, c := range clients {
+		if m, ok := c.(clientMetrics); ok {
+			lt.lockWait -= m.LockWait()
+			lt.reconnects -= m.Reconnects()
+		}
+	}
 
-	if lt.config.SharedConn {
-		// Shared connection mode (like Golang client)
-		results = lt.runSharedConnectionTest()
-	} else {
-		// Multi-connection mode
-		results = lt.runMultiConnectionTest()
+	lt.rtt = lt.measureRTT()
+
+	// Only the measured run is recorded into the HdrHistogram
+	if lt.config.HDRPath != "" {
+		lt.latencies = newLatencyHistogram()
+	}
+
+	// Progress covers the measured run only
+	var stopProgress func()
+	if lt.config.Progress {
+		stopProgress = lt.startProgress()
 	}
 
+	lt.conns.ResetScheduling()
+	lt.conns.ResetHeartbeats()
+	lt.mark(phaseMeasure)
+	lt.start = time.Now()
+	if lt.config.Duration > 0 {
+		lt.deadline = lt.start.Add(lt.config.Duration)
+	}
+	cpuStart := processCPUTime()
+	results := lt.runUsers(forUser, lt.config.Operations)
+	lt.elapsed = time.Since(lt.start)
+	lt.deadline = time.Time{}
+	lt.cpuTime = processCPUTime() - cpuStart
+	if stopProgress != nil {
+		stopProgress()
+	}
+	lt.mark(phaseCooldown)
+
+	for _, c := range clients {
+		if m, ok := c.(clientMetrics); ok {
+			lt.lockWait += m.LockWait()
+			lt.reconnects += m.Reconnects()
+		}
+		c.Close()
+	}
+	lt.connSum = lt.conns.Summary(lt.expectedConns())
+	lt.heartbeats = lt.conns.Heartbeats(lt.config.CorrectHeartbeats)
+	lt.scheduling = lt.conns.Scheduling()
 	return results
 }
 
-func (lt *LoadTest) runSharedConnectionTest() []TestResult {
-	// Create ONE shared client that all goroutines will use (simulates Golang client's queuing)
-	sharedClient := NewKV(&KVConfig{HostPort: lt.config.ServerAddr})
+// newClients opens the connections for the configured mode and returns the
+// client each user should use along with every client that must be closed
+func (lt *LoadTest) newClients() (func(userID int) ThisAppKVInterface, []ThisAppKVInterface) {
+	configFor := func(server string) *KVConfig {
+		return &KVConfig{
+			HostPort:          server,
+			Observer:          lt.conns,
+			SharedReadBuffers: lt.config.SharedBuffers,
+		}
+	}
+
+	switch lt.config.Mode {
+	case ModeMulti:
+		if lt.limitConns() {
+			// Each user dials when it gets a connection slot and closes
+			// its connection when done; see runUsers
+			lt.connSlots = make(chan struct{}, lt.config.MaxConns)
+			return func(userID int) ThisAppKVInterface {
+				return NewKV(configFor(lt.serverFor(userID)))
+			}, nil
+		}
+		// Individual connection per user
+		clients := make([]ThisAppKVInterface, lt.config.NumUsers)
+		for i := range clients {
+			clients[i] = NewKV(configFor(lt.serverFor(i)))
+		}
+		return func(userID int) ThisAppKVInterface { return clients[userID] }, clients
+	case ModePool:
+		// One pool per server
+		pools := make(map[string]ThisAppKVInterface)
+		var clients []ThisAppKVInterface
+		for _, server := range lt.targets() {
+			pools[server] = NewKVPool(configFor(server), lt.config.PoolSize)
+			clients = append(clients, pools[server])
+		}
+		return func(userID int) ThisAppKVInterface { return pools[lt.serverFor(userID)] }, clients
+	default:
+		// Create ONE shared client per server that all of its users'
+		// goroutines will use (simulates Golang client's queuing)
+		shared := make(map[string]*SharedClient)
+		var clients []ThisAppKVInterface
+		for _, server := range lt.targets() {
+			shared[server] = NewSharedClient(NewKV(configFor(server)).(*KV),
+				func() { lt.stopped.Store(true) })
+			lt.shared = append(lt.shared, shared[server])
+			clients = append(clients, shared[server])
+		}
+		return func(userID int) ThisAppKVInterface { return shared[lt.serverFor(userID)] }, clients
+	}
+}
+
+// limitConns reports whether multi mode must cap simultaneous connections
+func (lt *LoadTest) limitConns() bool {
+	return lt.config.Mode == ModeMulti && lt.config.MaxConns > 0 &&
+		lt.config.MaxConns < lt.config.NumUsers
+}
 
+// runUsers runs ops operations for every user concurrently. With a
+// connection cap, users beyond the cap wait for a slot, and each user's
+// connection is closed as soon as it finishes.
+func (lt *LoadTest) runUsers(forUser func(userID int) ThisAppKVInterface, ops int) []TestResult {
 	var allResults []TestResult
 	var resultsMutex sync.Mutex
 	var wg sync.WaitGroup
@@ -58,7 +269,21 @@ func (lt *LoadTest) runSharedConnectionTest() []TestResult {
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
-			results := lt.runUserTestOnClient(sharedClient, id)
+			if lt.connSlots != nil {
+				lt.connSlots <- struct{}{}
+				defer func() { <-lt.connSlots }()
+			}
+			client := forUser(id)
+			if lt.connSlots != nil {
+				defer client.Close()
+			}
+			results := lt.runUserTestOnClient(client, id, ops)
+			if lt.multiServer() {
+				server := lt.serverFor(id)
+				for i := range results {
+					results[i].Server = server
+				}
+			}
 			resultsMutex.Lock()
 			allResults = append(allResults, results...)
 			resultsMutex.Unlock()
@@ -66,119 +291,270 @@ func (lt *LoadTest) runSharedConnectionTest() []TestResult {
 	}
 
 	wg.Wait()
-	sharedClient.Close()
 	return allResults
 }
 
-func (lt *LoadTest) runMultiConnectionTest() []TestResult {
-	var allResults []TestResult
-	var wg sync.WaitGroup
-	resultsChan := make(chan []TestResult, lt.config.NumUsers)
+// controlClient opens a connection to the first server outside the run's
+// clients for setup and measurements, or returns nil if the server cannot
+// be reached
+func (lt *LoadTest) controlClient() *shrmpl.ShrmplKVClient {
+	return dialControl(lt.config.ServerAddr)
+}
 
-	for userID := 0; userID < lt.config.NumUsers; userID++ {
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
-			results := lt.runUserTest(id)
-			resultsChan <- results
-		}(userID)
+// dialControl connects to addr, returning nil on failure
+func dialControl(addr string) *shrmpl.ShrmplKVClient {
+	host, portStr, err := parseHostPort(addr)
+	if err != nil {
+		return nil
 	}
-
-	wg.Wait()
-	close(resultsChan)
-
-	for results := range resultsChan {
-		allResults = append(allResults, results...)
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil
 	}
-
-	return allResults
+	client := shrmpl.NewShrmplKVClient(host, port)
+	if err := client.Connect(); err != nil {
+		return nil
+	}
+	return client
 }
 
-func (lt *LoadTest) runUserTest(userID int) []TestResult {
-	config := &KVConfig{HostPort: lt.config.ServerAddr}
-	client := NewKV(config)
+// rttSamples is the number of PINGs measureRTT times
+const rttSamples = 5
+
+// measureRTT returns the baseline PING round trip on an idle connection
+// to the first server, or zero if it could not be measured
+func (lt *LoadTest) measureRTT() time.Duration {
+	client := lt.controlClient()
+	if client == nil {
+		return 0
+	}
 	defer client.Close()
+	rtt, err := client.RTT(rttSamples)
+	if err != nil {
+		return 0
+	}
+	return rtt
+}
 
-	return lt.runUserTestOnClient(client, userID)
+// resetKeySpace deletes the owned counters, each on its user's server, so
+// INCR verification starts from zero on every run
+func (lt *LoadTest) resetKeySpace() {
+	for _, server := range lt.targets() {
+		client := dialControl(server)
+		if client == nil {
+			continue
+		}
+		for userID := 0; userID < lt.config.NumUsers; userID++ {
+			if lt.serverFor(userID) == server {
+				_, _ = client.Delete(lt.keys.Counter(userID))
+			}
+		}
+		client.Close()
+	}
 }
 
-func (lt *LoadTest) runUserTestOnClient(client ThisAppKVInterface, userID int) []TestResult {
+func (lt *LoadTest) runUserTestOnClient(client ThisAppKVInterface, userID, ops int) []TestResult {
 	var results []TestResult
+	// The connection the user runs on, which other users may share
+	conn := client
+	var traced *tracedClient
+	if lt.config.CorrectHeartbeats {
+		if traced = newTracedClient(client); traced != nil {
+			client = traced
+		}
+	}
+	var faulty *faultyClient
+	var drops *atomic.Uint64
+	var seenDrops uint64
+	if len(lt.config.Faults) > 0 {
+		drops = lt.dropCounter(conn)
+		seenDrops = drops.Load()
+		faulty = newFaultyClient(client, lt.config.Faults, lt.config.Seed+int64(userID), drops)
+		client = faulty
+	}
+	// Per-user source so the sampled operations are identical across runs
+	rng := rand.New(rand.NewSource(lt.config.Seed + int64(userID)))
+	// The counter starts from zero after resetKeySpace
+	var model ownedModel
+	// With a rate cap every user paces its share of the total rate
+	var interval time.Duration
+	if lt.config.Rate > 0 {
+		interval = time.Duration(float64(lt.config.NumUsers) / lt.config.Rate * float64(time.Second))
+	}
+	next := time.Now()
 
-	for op := 0; op < lt.config.Operations; op++ {
+	for op := 0; lt.more(op, ops); op++ {
+		if interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				time.Sleep(wait)
+			}
+			next = next.Add(interval)
+		}
 		start := time.Now()
+		if traced != nil {
+			traced.trace = opTrace{}
+		}
 
 		var success bool
-		var err error
 		var errorType string
+		var outcome verifyOutcome
+
+		template := lt.config.BatchTemplates[op%len(lt.config.BatchTemplates)]
+		// Duration mode can run past ops; {seq} wraps so keys stay within
+		// the lengths the templates were validated for
+		seq := op % ops
 
 		if lt.config.FullTest {
 			// Comprehensive test operations
-			success, errorType = lt.runFullTestOperations(client, userID, op)
+			verify := rng.Float64() < lt.config.VerifySample
+			success, errorType, outcome = lt.runFullTestOperations(client, userID, seq, verify, template, &model, rng)
 		} else {
-			// Simple batch GET test
-			_, err = client.Batch([]string{"GET loginlock-ip-123", "GET loginlock-user-abc"})
-			success = err == nil
-			if !success {
-				errorType = fmt.Sprintf("Batch GET failed: %v", err)
-			}
+			// Simple batch test
+			errorType = lt.runBatch(client, userID, seq, template, rng)
+			success = errorType == ""
 		}
 
 		duration := time.Since(start)
+		if traced != nil {
+			duration -= traced.trace.heartbeatRead
+		}
+		var injected string
+		if faulty != nil {
+			injected = strings.Join(faulty.injector.TakeInjected(), ",")
+			// A disconnect injected by another user of the connection
+			// since this user's last operation made this one reconnect
+			if current := drops.Load(); current != seenDrops {
+				if injected == "" {
+					injected = shrmpltest.FaultDisconnect
+				}
+				seenDrops = current
+			}
+		}
+		// Operations hit by injected faults say nothing about the server
+		if lt.latencies != nil && injected == "" {
+			lt.latencies.RecordDuration(duration)
+		}
 		results = append(results, TestResult{
 			Duration:  duration,
 			Success:   success,
 			ErrorType: errorType,
+			Template:  template.Source,
+			Verified:  outcome == verifyMatch || outcome == verifyMismatch,
+			Mismatch:  outcome == verifyMismatch,
+			Evicted:   outcome == verifyEvicted,
+			Injected:  injected,
+			Shared:    template.Shared(),
 		})
+		if lt.progress != nil {
+			lt.progress.record(success)
+		}
+
+		if lt.config.ThinkTime > 0 {
+			time.Sleep(lt.config.ThinkTime)
+		}
 	}
 
 	return results
 }
 
-func (lt *LoadTest) runFullTestOperations(client ThisAppKVInterface, userID, opNum int) (bool, string) {
-	key := fmt.Sprintf("test_key_%d_%d", userID, opNum)
+// more reports whether a user should start operation op: until the
+// deadline in duration mode, otherwise for ops operations, and never once
+// the run was stopped
+func (lt *LoadTest) more(op, ops int) bool {
+	if lt.stopped.Load() {
+		return false
+	}
+	if !lt.deadline.IsZero() {
+		return time.Now().Before(lt.deadline)
+	}
+	return op < ops
+}
+
+// verifyOutcome records whether an operation's results were checked
+type verifyOutcome int
+
+const (
+	notVerified verifyOutcome = iota
+	verifyMatch
+	verifyMismatch
+	// verifyEvicted means the read-back found the key evicted, so the
+	// value could not be checked
+	verifyEvicted
+)
+
+// runBatch renders template for one operation, sends it and verifies
+// each slot, returning the error description on failure
+func (lt *LoadTest) runBatch(client ThisAppKVInterface, userID, opNum int, template BatchTemplate, rng *rand.Rand) string {
+	commands := template.Render(opNum, userID, lt.keys, rng)
+	results, err := client.Batch(commands)
+	if err != nil {
+		return fmt.Sprintf("Batch failed: %v", err)
+	}
+	if err := template.Verify(commands, results); err != nil {
+		return fmt.Sprintf("Batch verification failed: %v", err)
+	}
+	return ""
+}
+
+// runFullTestOperations runs one SET/GET/INCR/SET-with-TTL/BATCH round on
+// the user's owned keys. When verify is false the read-back GET is skipped
+// and results are not checked, so the operation costs fewer round trips.
+func (lt *LoadTest) runFullTestOperations(client ThisAppKVInterface, userID, opNum int, verify bool, template BatchTemplate, model *ownedModel, rng *rand.Rand) (bool, string, verifyOutcome) {
+	key := lt.keys.Owned("test_key", userID, opNum)
 	value := fmt.Sprintf("%d", userID)
+	if lt.config.ValueSize > len(value) {
+		value += strings.Repeat("v", lt.config.ValueSize-len(value))
+	}
+	outcome := notVerified
+	if verify {
+		outcome = verifyMatch
+	}
 
 	// SET operation
 	err := client.Set(key, value, "")
 	if err != nil {
-		return false, fmt.Sprintf("SET failed: %v", err)
+		return false, fmt.Sprintf("SET failed: %v", err), outcome
 	}
 
-	// GET and verify
-	gotValue, err := client.Get(key)
-	if err != nil {
-		return false, fmt.Sprintf("GET failed: %v", err)
-	}
-	if gotValue != value {
-		return false, fmt.Sprintf("GET verification failed: expected %s, got %s", value, gotValue)
+	// GET and verify. An eviction is a server capacity signal rather than
+	// a failure; the operation continues unverified.
+	if verify {
+		gotValue, err := client.Get(key)
+		switch {
+		case errors.Is(err, shrmpl.ErrKeyEvicted):
+			outcome = verifyEvicted
+		case err != nil:
+			return false, fmt.Sprintf("GET failed: %v", err), outcome
+		case gotValue != value:
+			return false, fmt.Sprintf("GET verification failed: expected %s, got %s", value, gotValue), verifyMismatch
+		}
 	}
 
 	// INCR and verify
-	counterKey := fmt.Sprintf("counter_%d", userID)
+	counterKey := lt.keys.Counter(userID)
 	count, err := client.Incr(counterKey, "")
 	if err != nil {
-		return false, fmt.Sprintf("INCR failed: %v", err)
+		model.unsynced = true
+		return false, fmt.Sprintf("INCR failed: %v", err), outcome
 	}
-	expectedCount := opNum + 1
-	if count != expectedCount {
-		return false, fmt.Sprintf("INCR verification failed: expected %d, got %d", expectedCount, count)
+	expectedCount := model.counter + 1
+	if !model.incr(count) && verify {
+		return false, fmt.Sprintf("INCR verification failed: expected %d, got %d", expectedCount, count), verifyMismatch
 	}
 
 	// SET with TTL
-	ttlKey := fmt.Sprintf("ttl_key_%d_%d", userID, opNum)
-	err = client.Set(ttlKey, "ttl_value", "60s")
+	ttlKey := lt.keys.Owned("ttl_key", userID, opNum)
+	err = client.Set(ttlKey, "ttl_value", pickTTL(lt.config.TTLs, rng))
 	if err != nil {
-		return false, fmt.Sprintf("SET with TTL failed: %v", err)
+		return false, fmt.Sprintf("SET with TTL failed: %v", err), outcome
 	}
 
-	// Batch GET (always test this)
-	_, err = client.Batch([]string{"GET loginlock-ip-123", "GET loginlock-user-abc"})
-	if err != nil {
-		return false, fmt.Sprintf("Batch GET failed: %v", err)
+	// Batch (always test this)
+	if errorType := lt.runBatch(client, userID, opNum, template, rng); errorType != "" {
+		return false, errorType, outcome
 	}
 
-	return true, ""
+	return true, "", outcome
 }
 
 func (lt *LoadTest) PrintResults(results []TestResult) {
@@ -192,9 +568,31 @@ func (lt *LoadTest) PrintResults(results []TestResult) {
 	errors := total - successful
 
 	fmt.Println("\nLoad Test Results:")
+	fmt.Printf("Run ID: %s\n", lt.config.RunID)
 	fmt.Printf("Total Operations: %d\n", total)
 	fmt.Printf("Successful: %d (%.1f%%)\n", successful, float64(successful)/float64(total)*100)
 	fmt.Printf("Errors: %d (%.1f%%)\n", errors, float64(errors)/float64(total)*100)
+	if lt.config.FullTest {
+		verified, mismatched, evicted := 0, 0, 0
+		for _, r := range results {
+			if r.Verified {
+				verified++
+			}
+			if r.Mismatch {
+				mismatched++
+			}
+			if r.Evicted {
+				evicted++
+			}
+		}
+		fmt.Printf("Verified: %d (%.1f%%), Mismatched: %d\n",
+			verified, float64(verified)/float64(total)*100, mismatched)
+		if reads := verified + evicted; reads > 0 {
+			fmt.Printf("Evicted: %d (%.2f%% of verified reads)\n",
+				evicted, float64(evicted)/float64(reads)*100)
+		}
+	}
+	printTermEvents(lt.termEvents(), total)
 
 	if errors > 0 {
 		errorCounts := make(map[string]int)
@@ -209,17 +607,73 @@ func (lt *LoadTest) PrintResults(results []TestResult) {
 		}
 	}
 
-	lt.printTimeDistribution(results, successful)
+	if len(lt.config.Faults) > 0 {
+		printInjected(results)
+	}
+	if lt.config.SharedKeys > 0 {
+		printOwnership(results)
+	}
+	if lt.multiServer() {
+		lt.printServers(results)
+	}
+
+	lt.printTimeDistribution(results)
+	if len(lt.config.BatchTemplates) > 1 {
+		printTemplateLatency(results)
+	}
+
+	printConnections(lt.connSum)
+	if lt.heartbeats.Heartbeats > 0 || lt.config.CorrectHeartbeats {
+		printHeartbeats(lt.heartbeats)
+	}
+	if lt.reportsScheduling() {
+		printScheduling(lt.scheduling)
+	}
+
+	if lt.rtt > 0 {
+		fmt.Printf("\nBaseline RTT (median of %d PINGs): %.3fms\n", rttSamples,
+			float64(lt.rtt)/float64(time.Millisecond))
+	}
+
+	fmt.Printf("\nTotal Test Duration: %.2fs\n", lt.elapsed.Seconds())
 
-	fmt.Printf("\nTotal Test Duration: %.2fs\n", time.Since(time.Now().Add(-time.Duration(len(results))*time.Millisecond)).Seconds())
+	if !lt.config.NoHints {
+		printHints(analyze(lt.stats(results)))
+	}
+}
+
+// stats collects the metrics the analysis rules operate on
+func (lt *LoadTest) stats(results []TestResult) RunStats {
+	s := RunStats{
+		SharedConn:  lt.config.Mode == ModeShared,
+		Total:       len(results),
+		Elapsed:     lt.elapsed,
+		CPUTime:     lt.cpuTime,
+		NumCPU:      runtime.NumCPU(),
+		LockWait:    lt.lockWait,
+		ErrorCounts: make(map[string]int),
+		Conns:       lt.connSum,
+	}
+	for _, r := range results {
+		if r.Injected == "" {
+			s.OpTime += r.Duration
+		}
+		if !r.Success {
+			s.Errors++
+			s.ErrorCounts[r.ErrorType]++
+		}
+	}
+	return s
 }
 
-func (lt *LoadTest) printTimeDistribution(results []TestResult, successful int) {
+func (lt *LoadTest) printTimeDistribution(results []TestResult) {
+	successful := 0
 	buckets := []time.Duration{10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond, 1000 * time.Millisecond}
 	counts := make([]int, len(buckets)+1)
 
 	for _, r := range results {
-		if r.Success {
+		if r.measured() {
+			successful++
 			found := false
 			for i, limit := range buckets {
 				if r.Duration < limit {
@@ -244,72 +698,272 @@ func (lt *LoadTest) printTimeDistribution(results []TestResult, successful int)
 	fmt.Printf(">1s: %d (%.1f%%)\n", counts[6], float64(counts[6])/float64(successful)*100)
 }
 
-func loadConfig(configPath string) (string, error) {
-	content, err := os.ReadFile(configPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read config file: %v", err)
-	}
-
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "BIND_ADDR=") {
-			return strings.TrimPrefix(line, "BIND_ADDR="), nil
-		}
-	}
-
-	return "", fmt.Errorf("BIND_ADDR not found in config")
-}
-
 func main() {
-	var sharedConn = flag.Bool("multi", false, "Use individual connections per user instead of shared connection")
+	var multi = flag.Bool("multi", false, "Use individual connections per user instead of shared connection")
+	var mode = flag.String("mode", "", "Connection mode: shared, multi or pool (overrides --multi)")
+	var poolSize = flag.Int("pool-size", 4, "Number of connections in pool mode")
+	var maxConns = flag.Int("max-conns", 0, "Maximum simultaneous connections in multi mode (0 = one per user)")
 	var fullTest = flag.Bool("full", false, "Run full comprehensive test")
+	var noHints = flag.Bool("no-hints", false, "Print raw numbers only, without the analysis section")
+	var verifySample = flag.Float64("verify-sample", 1.0, "Fraction of full-test operations whose results are verified (0-1)")
+	var seed = flag.Int64("seed", 1, "Seed for sampling decisions, so runs are reproducible")
+	var warmup = flag.Int("warmup", 0, "Untimed warmup operations per user before each run")
+	var compare = flag.Bool("compare-modes", false, "Run the same workload in shared, multi and pool mode and compare")
+	var coolDown = flag.Duration("cool-down", 5*time.Second, "Pause between runs with --compare-modes")
+	var jsonPath = flag.String("json", "", "Write results as JSON to this file")
+	var inject = flag.String("inject", "", "Inject client faults, e.g. \"disconnect:0.1%,slow:1%:500ms,error:0.5%\"")
+	var sharedKeys = flag.Int("shared-keys", 0, "Size of the shared key pool that templates reach with {shared} (0 = owned keys only)")
+	var showProgress = flag.Bool("progress", false, "Print completed operations, throughput and error rate to stderr every 5s while running")
+	var sharedBuffers = flag.Bool("shared-read-buffers", false, "Read responses through buffers shared by all connections instead of one reader per connection")
+	var hdrPath = flag.String("hdr", "", "Write all operation latencies as an HdrHistogram log (.hlog) to this file")
+	var preset = flag.String("preset", "", "Client model: web, worker or batch (explicit flags override its settings)")
+	var printOnly = flag.Bool("print-config", false, "Print the resolved configuration and exit")
+	var thinkTime = flag.Duration("think-time", 0, "Pause between a user's operations, excluded from latency")
+	var valueSize = flag.Int("value-size", 0, "Size in bytes of values written by --full (0 = short default, max 100)")
+	var trendDir = flag.String("trend", "", "Print the trend across the JSON result files in this directory and exit")
+	var server = flag.String("server", "", "Server address, overriding BIND_ADDR from the config file")
+	var servers = flag.String("servers", "", "Comma-separated server addresses to spread the users over by user index, e.g. \"host1:7171,host2:7171\"")
+	var users = flag.Int("users", 5, "Number of concurrent users")
+	var operations = flag.Int("operations", 10000, "Operations per user")
+	var duration = flag.Duration("duration", 0, "Run each user for this long instead of a fixed number of operations")
+	var rate = flag.Float64("rate", 0, "Cap total operations per second across all users (0 = no cap)")
+	var ttl = flag.String("ttl", defaultTTL, "TTL distribution of the full test's SET with TTL, e.g. \"10s:50,5min:30,1h:20\"")
+	var maxErrorRate = flag.String("max-error-rate", "", "Fail if the error rate exceeds this fraction or percentage, e.g. 1%")
+	var maxP99 = flag.Duration("max-p99", 0, "Fail if p99 latency exceeds this duration")
+	var minThroughput = flag.Float64("min-throughput", 0, "Fail if throughput falls below this many operations per second")
+	var printEffective = flag.Bool("print-effective-config", false, "Print the merged configuration as a JSON config file and exit")
+	var runID = flag.String("run-id", "", "ID for this invocation in the report and markers (default: start time and a random suffix)")
+	var markers = flag.Bool("markers", false, "SET loadtest:run-id and loadtest:phase at the warmup, measurement and cooldown boundaries")
+	var keepMarkers = flag.Bool("keep-markers", false, "Leave the --markers keys on the server after the run")
+	var correctHeartbeats = flag.Bool("correct-heartbeats", false, "Subtract the time spent reading heartbeats from operation and round trip latencies")
+	var batchTemplates stringList
+	flag.Var(&batchTemplates, "batch-template", "BATCH template with {seq}, {user}, {key}, {shared} and {rand:N} placeholders (repeatable)")
 	flag.Parse()
 
+	if *trendDir != "" {
+		if err := runTrend(*trendDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Trend failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The config file is optional when --server gives the address
 	args := flag.Args()
-	if len(args) != 1 {
+	if len(args) > 1 || (len(args) == 0 && *server == "" && *servers == "") {
 		fmt.Fprintf(os.Stderr, "Usage: go-load-test [flags] <config-file>\n")
+		fmt.Fprintf(os.Stderr, "       go-load-test [flags] --server host:port\n")
+		fmt.Fprintf(os.Stderr, "       go-load-test [flags] --servers host1:port,host2:port\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	configFile := args[0]
+	var fileCfg fileConfig
+	if len(args) == 1 {
+		var err error
+		fileCfg, err = loadConfig(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	connMode := ModeShared // Default to shared connection mode
+	if *multi {
+		connMode = ModeMulti
+	}
+	switch *mode {
+	case "":
+	case ModeShared, ModeMulti, ModePool:
+		connMode = *mode
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
+		os.Exit(1)
+	}
+
+	config := TestConfig{
+		ServerAddr:        *server,
+		NumUsers:          *users,
+		Operations:        *operations,
+		Mode:              connMode,
+		PoolSize:          *poolSize,
+		MaxConns:          *maxConns,
+		Warmup:            *warmup,
+		CoolDown:          *coolDown,
+		CompareModes:      *compare,
+		FullTest:          *fullTest,
+		ThinkTime:         *thinkTime,
+		ValueSize:         *valueSize,
+		VerifySample:      *verifySample,
+		Seed:              *seed,
+		NoHints:           *noHints,
+		JSONPath:          *jsonPath,
+		HDRPath:           *hdrPath,
+		SharedKeys:        *sharedKeys,
+		Progress:          *showProgress,
+		SharedBuffers:     *sharedBuffers,
+		ConfigFile:        fileCfg.Path,
+		Duration:          *duration,
+		Rate:              *rate,
+		RunID:             *runID,
+		Markers:           *markers,
+		KeepMarkers:       *keepMarkers,
+		CorrectHeartbeats: *correctHeartbeats,
+		Thresholds: Thresholds{
+			MaxP99:        *maxP99,
+			MinThroughput: *minThroughput,
+		},
+	}
+	for _, t := range batchTemplates {
+		config.BatchTemplates = append(config.BatchTemplates, BatchTemplate{Source: t})
+	}
 
-	serverAddr, err := loadConfig(configFile)
+	// Flags whose values need parsing
+	for _, f := range []struct {
+		name  string
+		value string
+		apply func(string) error
+	}{
+		{"servers", *servers, func(v string) error {
+			var err error
+			config.Servers, err = parseServers(v)
+			return err
+		}},
+		{"ttl", *ttl, func(v string) error {
+			var err error
+			config.TTLs, err = ParseTTLs(v)
+			return err
+		}},
+		{"inject", *inject, func(v string) error {
+			var err error
+			config.Faults, err = shrmpltest.ParseFaults(v)
+			return err
+		}},
+		{"max-error-rate", *maxErrorRate, func(v string) error {
+			rate, err := parseRate(v)
+			config.Thresholds.MaxErrorRate = &rate
+			return err
+		}},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if err := f.apply(f.value); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --%s: %v\n", f.name, err)
+			os.Exit(1)
+		}
+	}
+
+	// Explicit flags take precedence over the config file, which takes
+	// precedence over the preset
+	explicit := setFlags()
+	p, found, err := fileCfg.Preset()
+	if explicit["preset"] || !found {
+		found = *preset != ""
+		if found {
+			p, err = findPreset(*preset)
+		}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	if found {
+		for _, source := range applyPreset(&config, p, explicit) {
+			config.BatchTemplates = append(config.BatchTemplates, BatchTemplate{Source: source})
+		}
+	}
+	if err := fileCfg.apply(&config, explicit); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if len(config.Servers) > 0 {
+		config.ServerAddr = config.Servers[0]
+	}
 
-	config := TestConfig{
-		ServerAddr: serverAddr,
-		NumUsers:   5,
-		Operations: 10000,
-		SharedConn: !*sharedConn, // Default to shared connection mode
-		FullTest:   *fullTest,
-		ConfigFile: configFile,
-	}
-
-	fmt.Println("Load Test Configuration:")
-	fmt.Printf("├── Concurrent Users: %d\n", config.NumUsers)
-	fmt.Printf("├── Operations per User: %d\n", config.Operations)
-	fmt.Printf("├── Total Operations: %d\n", config.NumUsers*config.Operations)
-	connMode := "shared"
-	if !config.SharedConn {
-		connMode = "multi"
-	}
-	fmt.Printf("├── Connection Mode: %s\n", connMode)
-	testMode := "batch GET only"
-	if config.FullTest {
-		testMode = "full comprehensive"
-	}
-	fmt.Printf("├── Test Mode: %s\n", testMode)
-	fmt.Printf("└── Server: %s\n", config.ServerAddr)
+	switch {
+	case config.ServerAddr == "":
+		fmt.Fprintf(os.Stderr, "No server address: set BIND_ADDR (or \"server\") in the config file, --server or --servers\n")
+		os.Exit(1)
+	case config.NumUsers < 1 || config.Operations < 1:
+		fmt.Fprintf(os.Stderr, "Users and operations must be at least 1\n")
+		os.Exit(1)
+	case config.ValueSize < 0 || config.ValueSize > maxValueLength:
+		fmt.Fprintf(os.Stderr, "Value size must be between 0 and %d\n", maxValueLength)
+		os.Exit(1)
+	}
+	if config.RunID == "" {
+		config.RunID = newRunID()
+	} else if err := validateRunID(config.RunID); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --run-id: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(config.BatchTemplates) == 0 {
+		config.BatchTemplates = []BatchTemplate{{Source: defaultBatchTemplate}}
+	}
+	for i, t := range config.BatchTemplates {
+		template, err := ParseBatchTemplate(t.Source, config.NumUsers, config.Operations)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid batch template: %v\n", err)
+			os.Exit(1)
+		}
+		if template.Shared() && config.SharedKeys <= 0 {
+			fmt.Fprintf(os.Stderr, "Batch template %q uses {shared}; set --shared-keys\n", t.Source)
+			os.Exit(1)
+		}
+		config.BatchTemplates[i] = template
+	}
+
+	if *printEffective {
+		printEffectiveConfig(config)
+		return
+	}
+	printConfig(config)
+	if *printOnly {
+		return
+	}
+	metadata := newMetadata(config)
+	printMetadata(metadata)
 	fmt.Println()
 	fmt.Println("Starting test execution...")
 
-	loadTest := NewLoadTest(config)
-	results := loadTest.Run()
-	loadTest.PrintResults(results)
+	report := Report{RunID: config.RunID, Metadata: metadata}
+	if config.CompareModes {
+		cmp := compareModes(config)
+		printComparison(cmp)
+		report.Comparison = cmp
+	} else {
+		loadTest := NewLoadTest(config)
+		results := loadTest.Run()
+		loadTest.PrintResults(results)
+		report.Runs = append(report.Runs, loadTest.summarize(results))
+		if err := loadTest.writeLatencies(config.HDRPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write HdrHistogram: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	clearMarkers(config)
+
+	// Thresholds are checked before the report is written so that it
+	// records the failures
+	passed := true
+	if config.Thresholds.enabled() {
+		runs := report.Runs
+		if report.Comparison != nil {
+			runs = report.Comparison.Runs
+		}
+		passed = checkThresholds(config.Thresholds, runs)
+	}
+
+	if config.JSONPath != "" {
+		if err := writeReport(config.JSONPath, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write JSON report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if !passed || serverTerminated(report) {
+		os.Exit(1)
+	}
 }
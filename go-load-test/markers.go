@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Marker keys written with --markers so server logs and metrics can be
+// lined up with the run
+const (
+	markerRunIDKey = "loadtest:run-id"
+	markerPhaseKey = "loadtest:phase"
+)
+
+// Run phases announced by the phase marker
+const (
+	phaseWarmup   = "warmup"
+	phaseMeasure  = "measure"
+	phaseCooldown = "cooldown"
+)
+
+// runIDPattern limits run IDs to characters that survive the line
+// protocol and file names, leaving room for per-mode suffixes
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// newRunID returns an ID for this invocation: the UTC start time and a
+// random suffix, e.g. 20261016T194544Z-3fa9
+func newRunID() string {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// validateRunID checks a --run-id
+func validateRunID(id string) error {
+	if !runIDPattern.MatchString(id) {
+		return fmt.Errorf("run ID %q: use up to 64 letters, digits, '.', '_', ':' or '-'", id)
+	}
+	return nil
+}
+
+// subRunID returns the run ID of one run of a multi-run invocation
+func subRunID(runID, suffix string) string {
+	return runID + "-" + suffix
+}
+
+// mark announces phase on the marker keys of every server under test.
+// Markers are best effort: a failure is reported and the run continues.
+func (lt *LoadTest) mark(phase string) {
+	if !lt.config.Markers {
+		return
+	}
+	for _, server := range lt.targets() {
+		lt.markServer(server, phase)
+	}
+}
+
+// markServer announces phase on the marker keys of one server
+func (lt *LoadTest) markServer(server, phase string) {
+	client := dialControl(server)
+	if client == nil {
+		fmt.Fprintf(os.Stderr, "Marker %s skipped: %s not reachable\n", phase, server)
+		return
+	}
+	defer client.Close()
+
+	results, err := client.Batch([]string{
+		fmt.Sprintf("SET %s %s", markerRunIDKey, lt.config.RunID),
+		fmt.Sprintf("SET %s %s", markerPhaseKey, phase),
+	})
+	if err == nil {
+		if raw := strings.Join(rawResults(results), ";"); raw != "OK;OK" {
+			err = fmt.Errorf("unexpected response: %s", raw)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Marker %s failed on %s: %v\n", phase, server, err)
+	}
+}
+
+// clearMarkers deletes the marker keys on every server after the last
+// run, unless --keep-markers asks to leave them for later inspection
+func clearMarkers(config TestConfig) {
+	if !config.Markers || config.KeepMarkers {
+		return
+	}
+	for _, server := range configTargets(config) {
+		clearServerMarkers(server)
+	}
+}
+
+// clearServerMarkers deletes the marker keys of one server
+func clearServerMarkers(server string) {
+	client := dialControl(server)
+	if client == nil {
+		fmt.Fprintf(os.Stderr, "Marker cleanup skipped: %s not reachable\n", server)
+		return
+	}
+	defer client.Close()
+
+	for _, key := range []string{markerRunIDKey, markerPhaseKey} {
+		if _, err := client.Delete(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Marker cleanup failed on %s: %v\n", server, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// version identifies the build in result files; set it with
+// go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// Metadata describes the environment of a run, so result files from
+// different machines and builds can be told apart
+type Metadata struct {
+	ToolVersion string   `json:"tool_version"`
+	GoVersion   string   `json:"go_version"`
+	OS          string   `json:"os"`
+	Arch        string   `json:"arch"`
+	CPUs        int      `json:"cpus"`
+	Hostname    string   `json:"hostname"`
+	Servers     []string `json:"servers"`
+	// ConfigHash identifies the effective configuration without the
+	// output file settings; see configHash
+	ConfigHash string `json:"config_hash"`
+}
+
+// newMetadata collects the metadata of a run of config
+func newMetadata(config TestConfig) *Metadata {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &Metadata{
+		ToolVersion: version,
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUs:        runtime.NumCPU(),
+		Hostname:    hostname,
+		Servers:     configTargets(config),
+		ConfigHash:  configHash(config),
+	}
+}
+
+// configHash returns the first 12 hex digits of the SHA-256 of the
+// effective configuration as --print-effective-config prints it, less the
+// output file settings, which do not change the workload
+func configHash(config TestConfig) string {
+	sum := sha256.Sum256([]byte(effectiveConfig(config, false)))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// metadataDifferences lists the fields in which cur differs from prev, by
+// JSON name
+func metadataDifferences(prev, cur *Metadata) []string {
+	if prev == nil || cur == nil {
+		return nil
+	}
+	var diffs []string
+	check := func(name string, differs bool) {
+		if differs {
+			diffs = append(diffs, name)
+		}
+	}
+	check("config_hash", prev.ConfigHash != cur.ConfigHash)
+	check("tool_version", prev.ToolVersion != cur.ToolVersion)
+	check("go_version", prev.GoVersion != cur.GoVersion)
+	check("os", prev.OS != cur.OS || prev.Arch != cur.Arch)
+	check("cpus", prev.CPUs != cur.CPUs)
+	check("hostname", prev.Hostname != cur.Hostname)
+	check("servers", strings.Join(prev.Servers, ",") != strings.Join(cur.Servers, ","))
+	return diffs
+}
+
+// printMetadata prints the environment banner before the run
+func printMetadata(m *Metadata) {
+	fmt.Println("Environment:")
+	fmt.Printf("├── Tool Version: %s (%s)\n", m.ToolVersion, m.GoVersion)
+	fmt.Printf("├── Platform: %s/%s, %d CPUs\n", m.OS, m.Arch, m.CPUs)
+	fmt.Printf("├── Host: %s\n", m.Hostname)
+	fmt.Printf("└── Config Hash: %s\n", m.ConfigHash)
+}
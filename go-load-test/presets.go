@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Preset is a named bundle of settings modeling one kind of production
+// client. Flags given explicitly on the command line override the preset.
+type Preset struct {
+	Name        string
+	Description string
+	Mode        string
+	PoolSize    int
+	// FullTest selects the SET/GET/INCR/BATCH mix instead of batch GETs
+	FullTest       bool
+	ThinkTime      time.Duration
+	ValueSize      int
+	BatchTemplates []string
+}
+
+// presets are the client models selectable with --preset. Adding a client
+// model only needs a new entry here.
+var presets = []Preset{
+	{
+		Name:        "web",
+		Description: "request handlers: pooled connections, mixed reads and writes, short pauses between requests",
+		Mode:        ModePool,
+		PoolSize:    8,
+		FullTest:    true,
+		ThinkTime:   5 * time.Millisecond,
+		ValueSize:   32,
+		BatchTemplates: []string{
+			"GET loginlock-ip-{rand:1000};GET loginlock-user-{user}",
+			"GET session-{rand:5000}",
+		},
+	},
+	{
+		Name:        "worker",
+		Description: "background job runners: one shared connection, back-to-back writes with counters",
+		Mode:        ModeShared,
+		PoolSize:    4,
+		FullTest:    true,
+		ValueSize:   100,
+		BatchTemplates: []string{
+			"INCR jobs-done-{user};GET job-{rand:500}",
+		},
+	},
+	{
+		Name:        "batch",
+		Description: "report generators: a connection per client, wide batched reads without pauses",
+		Mode:        ModeMulti,
+		PoolSize:    4,
+		BatchTemplates: []string{
+			"GET report-{rand:10000};GET report-{rand:10000};GET report-{rand:10000}",
+		},
+	},
+}
+
+// findPreset returns the preset called name
+func findPreset(name string) (Preset, error) {
+	var names []string
+	for _, p := range presets {
+		if p.Name == name {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return Preset{}, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// setFlags returns the names of the flags given on the command line
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// applyPreset copies the preset's settings into config except those whose
+// flags were given explicitly. It returns the preset's batch templates
+// unless --batch-template was given.
+func applyPreset(config *TestConfig, p Preset, explicit map[string]bool) []string {
+	config.Preset = p.Name
+	if !explicit["mode"] && !explicit["multi"] {
+		config.Mode = p.Mode
+	}
+	if !explicit["pool-size"] {
+		config.PoolSize = p.PoolSize
+	}
+	if !explicit["full"] {
+		config.FullTest = p.FullTest
+	}
+	if !explicit["think-time"] {
+		config.ThinkTime = p.ThinkTime
+	}
+	if !explicit["value-size"] {
+		config.ValueSize = p.ValueSize
+	}
+	if explicit["batch-template"] {
+		return nil
+	}
+	return p.BatchTemplates
+}
+
+// printConfig prints the fully resolved configuration
+func printConfig(config TestConfig) {
+	fmt.Println("Load Test Configuration:")
+	if config.Preset != "" {
+		fmt.Printf("├── Preset: %s (resolved settings below)\n", config.Preset)
+	}
+	fmt.Printf("├── Concurrent Users: %d\n", config.NumUsers)
+	if config.Duration > 0 {
+		fmt.Printf("├── Duration: %s\n", config.Duration)
+	} else {
+		fmt.Printf("├── Operations per User: %d\n", config.Operations)
+		fmt.Printf("├── Total Operations: %d\n", config.NumUsers*config.Operations)
+	}
+	if config.Rate > 0 {
+		fmt.Printf("├── Rate Limit: %.0f op/s\n", config.Rate)
+	}
+	if config.CompareModes {
+		fmt.Printf("├── Connection Mode: compare (shared, multi, pool)\n")
+	} else {
+		fmt.Printf("├── Connection Mode: %s\n", config.Mode)
+	}
+	if config.Mode == ModePool || config.CompareModes {
+		fmt.Printf("├── Pool Size: %d\n", config.PoolSize)
+	}
+	if config.SharedBuffers {
+		fmt.Printf("├── Read Buffers: shared\n")
+	}
+	if config.MaxConns > 0 && (config.Mode == ModeMulti || config.CompareModes) {
+		fmt.Printf("├── Max Connections: %d\n", config.MaxConns)
+	}
+	if config.Warmup > 0 {
+		fmt.Printf("├── Warmup per User: %d\n", config.Warmup)
+	}
+	testMode := "batch GET only"
+	if config.FullTest {
+		testMode = "full comprehensive"
+		if config.VerifySample < 1 {
+			testMode += fmt.Sprintf(", verifying %.0f%%", config.VerifySample*100)
+		}
+	}
+	fmt.Printf("├── Test Mode: %s\n", testMode)
+	fmt.Printf("├── Think Time: %s\n", config.ThinkTime)
+	if config.FullTest {
+		valueSize := "default"
+		if config.ValueSize > 0 {
+			valueSize = fmt.Sprintf("%d bytes", config.ValueSize)
+		}
+		fmt.Printf("├── Value Size: %s\n", valueSize)
+		ttls := make([]string, len(config.TTLs))
+		for i, t := range config.TTLs {
+			ttls[i] = t.String()
+		}
+		if len(ttls) > 0 {
+			fmt.Printf("├── TTLs: %s\n", strings.Join(ttls, ","))
+		}
+	}
+	for _, t := range config.BatchTemplates {
+		fmt.Printf("├── Batch Template: %s\n", t.Source)
+	}
+	if config.SharedKeys > 0 {
+		fmt.Printf("├── Shared Keys: %d\n", config.SharedKeys)
+	}
+	if len(config.Faults) > 0 {
+		faults := make([]string, len(config.Faults))
+		for i, f := range config.Faults {
+			faults[i] = f.String()
+		}
+		fmt.Printf("├── Injected Faults: %s\n", strings.Join(faults, ","))
+	}
+	if t := config.Thresholds; t.enabled() {
+		var limits []string
+		if t.MaxErrorRate != nil {
+			limits = append(limits, fmt.Sprintf("error rate <= %.2f%%", *t.MaxErrorRate*100))
+		}
+		if t.MaxP99 > 0 {
+			limits = append(limits, fmt.Sprintf("p99 <= %s", t.MaxP99))
+		}
+		if t.MinThroughput > 0 {
+			limits = append(limits, fmt.Sprintf("throughput >= %.0f op/s", t.MinThroughput))
+		}
+		fmt.Printf("├── Thresholds: %s\n", strings.Join(limits, ", "))
+	}
+	if config.ConfigFile != "" {
+		fmt.Printf("├── Config File: %s\n", config.ConfigFile)
+	}
+	if config.RunID != "" {
+		runID := config.RunID
+		if config.CompareModes {
+			runID += " (suffixed with -<mode> per run)"
+		}
+		fmt.Printf("├── Run ID: %s\n", runID)
+	}
+	if config.Markers {
+		markers := "loadtest:run-id, loadtest:phase"
+		if config.KeepMarkers {
+			markers += " (kept)"
+		}
+		fmt.Printf("├── Markers: %s\n", markers)
+	}
+	if config.CorrectHeartbeats {
+		fmt.Printf("├── Heartbeat Correction: enabled\n")
+	}
+	if len(config.Servers) > 1 {
+		fmt.Printf("└── Servers: %s (users assigned round-robin)\n", strings.Join(config.Servers, ", "))
+		return
+	}
+	fmt.Printf("└── Server: %s\n", config.ServerAddr)
+}
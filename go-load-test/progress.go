@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often --progress prints a tally
+const progressInterval = 5 * time.Second
+
+// progress counts completed operations while a run is in flight. User
+// goroutines update it without locking; a ticker in Run reads it.
+type progress struct {
+	completed atomic.Int64
+	errors    atomic.Int64
+}
+
+// record counts one finished operation
+func (p *progress) record(success bool) {
+	p.completed.Add(1)
+	if !success {
+		p.errors.Add(1)
+	}
+}
+
+// startProgress prints a running tally of the measured run to stderr every
+// progressInterval until the returned stop function is called
+func (lt *LoadTest) startProgress() (stop func()) {
+	lt.progress = &progress{}
+	total := lt.config.NumUsers * lt.config.Operations
+	ticker := time.NewTicker(progressInterval)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		var last int64
+		start := time.Now()
+		lastTick := start
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				completed := lt.progress.completed.Load()
+				errors := lt.progress.errors.Load()
+				throughput := float64(completed-last) / now.Sub(lastTick).Seconds()
+				errorRate := 0.0
+				if completed > 0 {
+					errorRate = float64(errors) / float64(completed) * 100
+				}
+				status := fmt.Sprintf("%d/%d ops (%.1f%%)",
+					completed, total, float64(completed)/float64(total)*100)
+				if lt.config.Duration > 0 {
+					elapsed := now.Sub(start).Truncate(time.Second)
+					status = fmt.Sprintf("%d ops in %s/%s", completed, elapsed, lt.config.Duration)
+				}
+				fmt.Fprintf(os.Stderr, "Progress: %s, %.0f op/s, errors: %d (%.1f%%)\n",
+					status, throughput, errors, errorRate)
+				last, lastTick = completed, now
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+	}
+}
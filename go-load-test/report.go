@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// RunSummary condenses one run into the metrics used for comparison and
+// JSON output
+type RunSummary struct {
+	RunID      string  `json:"run_id,omitempty"`
+	Mode       string  `json:"mode"`
+	Operations int     `json:"operations"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Throughput float64 `json:"throughput_ops_per_sec"`
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	// RTTMs is the baseline PING round trip measured before the run
+	RTTMs      float64 `json:"rtt_ms,omitempty"`
+	Reconnects int     `json:"reconnects"`
+	Verified   int     `json:"verified"`
+	Mismatched int     `json:"mismatched"`
+	// Evicted counts verify reads the server answered with an eviction;
+	// EvictionRate is their share of all verify reads
+	Evicted      int         `json:"evicted,omitempty"`
+	EvictionRate float64     `json:"eviction_rate,omitempty"`
+	DurationSec  float64     `json:"duration_sec"`
+	Connections  ConnSummary `json:"connections"`
+	// Heartbeats is set when UPONG lines were read during round trips or
+	// --correct-heartbeats is given
+	Heartbeats *HeartbeatSummary `json:"heartbeats,omitempty"`
+	// Scheduling splits operation time into lock wait and wire time; it
+	// is omitted in pool mode
+	Scheduling *SchedulingSummary `json:"scheduling,omitempty"`
+	// Injected counts injected faults by kind
+	Injected map[string]int `json:"injected,omitempty"`
+	// Ownership splits operations on owned and shared keys
+	Ownership map[string]KeyClassSummary `json:"ownership,omitempty"`
+	// Servers breaks a multi-server run down by server address
+	Servers map[string]ServerSummary `json:"servers,omitempty"`
+	// ServerTerminated is set when the server sent TERM and did not come
+	// back, ending the run early; ServerRestarts counts TERMs after which
+	// the shared connection was re-established
+	ServerTerminated bool `json:"server_terminated,omitempty"`
+	ServerRestarts   int  `json:"server_restarts,omitempty"`
+	// ThresholdFailures lists the thresholds the run violated
+	ThresholdFailures []string `json:"threshold_failures,omitempty"`
+}
+
+// KeyClassSummary holds the latency and errors of operations on one class
+// of keys, owned or shared
+type KeyClassSummary struct {
+	Operations int     `json:"operations"`
+	Errors     int     `json:"errors"`
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+}
+
+// Report is the JSON document written by -json
+type Report struct {
+	Version    int          `json:"version"`
+	Timestamp  time.Time    `json:"timestamp"`
+	RunID      string       `json:"run_id,omitempty"`
+	Metadata   *Metadata    `json:"metadata,omitempty"`
+	Runs       []RunSummary `json:"runs,omitempty"`
+	Comparison *Comparison  `json:"comparison,omitempty"`
+}
+
+// Comparison holds one run per connection mode and the winner per metric
+type Comparison struct {
+	Runs    []RunSummary      `json:"runs"`
+	Winners map[string]string `json:"winners"`
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+// summarize builds a RunSummary from the results of the last Run
+func (lt *LoadTest) summarize(results []TestResult) RunSummary {
+	var durations []time.Duration
+	errors := 0
+	verified, mismatched, evicted := 0, 0, 0
+	for _, r := range results {
+		if r.measured() {
+			durations = append(durations, r.Duration)
+		}
+		if !r.Success {
+			errors++
+		}
+		if r.Verified {
+			verified++
+		}
+		if r.Mismatch {
+			mismatched++
+		}
+		if r.Evicted {
+			evicted++
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	s := RunSummary{
+		RunID:       lt.config.RunID,
+		Mode:        lt.config.Mode,
+		Operations:  len(results),
+		Errors:      errors,
+		P50Ms:       float64(percentile(durations, 0.50)) / float64(time.Millisecond),
+		P99Ms:       float64(percentile(durations, 0.99)) / float64(time.Millisecond),
+		RTTMs:       float64(lt.rtt) / float64(time.Millisecond),
+		Reconnects:  lt.reconnects,
+		Verified:    verified,
+		Mismatched:  mismatched,
+		Evicted:     evicted,
+		DurationSec: lt.elapsed.Seconds(),
+		Connections: lt.connSum,
+	}
+	s.ServerTerminated = lt.terminated()
+	for _, e := range lt.termEvents() {
+		if e.Reconnected {
+			s.ServerRestarts++
+		}
+	}
+	if lt.heartbeats.Heartbeats > 0 || lt.config.CorrectHeartbeats {
+		heartbeats := lt.heartbeats
+		s.Heartbeats = &heartbeats
+	}
+	if lt.reportsScheduling() {
+		scheduling := lt.scheduling
+		s.Scheduling = &scheduling
+	}
+	if len(lt.config.Faults) > 0 {
+		s.Injected, _, _ = injectedCounts(results)
+	}
+	if lt.config.SharedKeys > 0 {
+		s.Ownership = ownershipSummary(results)
+	}
+	if lt.multiServer() {
+		s.Servers = lt.serverSummary(results)
+	}
+	if len(results) > 0 {
+		s.ErrorRate = float64(errors) / float64(len(results))
+	}
+	if evicted > 0 {
+		s.EvictionRate = float64(evicted) / float64(verified+evicted)
+	}
+	if lt.elapsed > 0 {
+		s.Throughput = float64(len(results)) / lt.elapsed.Seconds()
+	}
+	return s
+}
+
+// compareModes runs the identical workload once per connection mode,
+// pausing for the configured cool-down between runs
+func compareModes(config TestConfig) *Comparison {
+	modes := []string{ModeShared, ModeMulti, ModePool}
+	cmp := &Comparison{}
+
+	for i, mode := range modes {
+		if i > 0 && config.CoolDown > 0 {
+			fmt.Printf("Cooling down for %s...\n", config.CoolDown)
+			time.Sleep(config.CoolDown)
+		}
+		runConfig := config
+		runConfig.Mode = mode
+		runConfig.RunID = subRunID(config.RunID, mode)
+		fmt.Printf("Running %s mode (run ID %s)...\n", mode, runConfig.RunID)
+		lt := NewLoadTest(runConfig)
+		results := lt.Run()
+		printTermEvents(lt.termEvents(), len(results))
+		cmp.Runs = append(cmp.Runs, lt.summarize(results))
+		if config.HDRPath != "" {
+			path := hdrPathForMode(config.HDRPath, mode)
+			if err := lt.writeLatencies(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write HdrHistogram %s: %v\n", path, err)
+			}
+		}
+	}
+
+	cmp.Winners = pickWinners(cmp.Runs)
+	return cmp
+}
+
+// pickWinners selects the best mode for each metric
+func pickWinners(runs []RunSummary) map[string]string {
+	winners := make(map[string]string)
+	best := func(metric string, value func(RunSummary) float64, higherIsBetter bool) {
+		if len(runs) == 0 {
+			return
+		}
+		w := runs[0]
+		for _, other := range runs[1:] {
+			if (higherIsBetter && value(other) > value(w)) ||
+				(!higherIsBetter && value(other) < value(w)) {
+				w = other
+			}
+		}
+		winners[metric] = w.Mode
+	}
+	best("throughput", func(r RunSummary) float64 { return r.Throughput }, true)
+	best("p50", func(r RunSummary) float64 { return r.P50Ms }, false)
+	best("p99", func(r RunSummary) float64 { return r.P99Ms }, false)
+	best("error_rate", func(r RunSummary) float64 { return r.ErrorRate }, false)
+	best("reconnects", func(r RunSummary) float64 { return float64(r.Reconnects) }, false)
+	return winners
+}
+
+// printComparison prints a side-by-side table of the compared runs
+func printComparison(cmp *Comparison) {
+	fmt.Println("\nMode Comparison:")
+	fmt.Printf("%-12s", "Metric")
+	for _, r := range cmp.Runs {
+		fmt.Printf("%14s", r.Mode)
+	}
+	fmt.Printf("%10s\n", "Winner")
+
+	row := func(label, metric string, format func(RunSummary) string) {
+		fmt.Printf("%-12s", label)
+		for _, r := range cmp.Runs {
+			fmt.Printf("%14s", format(r))
+		}
+		fmt.Printf("%10s\n", cmp.Winners[metric])
+	}
+	row("Throughput", "throughput", func(r RunSummary) string { return fmt.Sprintf("%.0f op/s", r.Throughput) })
+	row("p50", "p50", func(r RunSummary) string { return fmt.Sprintf("%.2fms", r.P50Ms) })
+	row("p99", "p99", func(r RunSummary) string { return fmt.Sprintf("%.2fms", r.P99Ms) })
+	row("Error rate", "error_rate", func(r RunSummary) string { return fmt.Sprintf("%.1f%%", r.ErrorRate*100) })
+	row("Reconnects", "reconnects", func(r RunSummary) string { return fmt.Sprintf("%d", r.Reconnects) })
+}
+
+// serverTerminated reports whether any run of report was cut short by a
+// server shutdown
+func serverTerminated(report Report) bool {
+	runs := report.Runs
+	if report.Comparison != nil {
+		runs = report.Comparison.Runs
+	}
+	for _, r := range runs {
+		if r.ServerTerminated {
+			return true
+		}
+	}
+	return false
+}
+
+// writeReport writes the JSON report to path
+func writeReport(path string, report Report) error {
+	report.Version = reportVersion
+	report.Timestamp = time.Now().UTC()
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// printTemplateLatency prints latency percentiles for each batch template
+func printTemplateLatency(results []TestResult) {
+	byTemplate := make(map[string][]time.Duration)
+	var order []string
+	for _, r := range results {
+		if !r.measured() {
+			continue
+		}
+		if _, ok := byTemplate[r.Template]; !ok {
+			order = append(order, r.Template)
+		}
+		byTemplate[r.Template] = append(byTemplate[r.Template], r.Duration)
+	}
+
+	fmt.Println("\nLatency by Batch Template (successful operations):")
+	for _, template := range order {
+		durations := byTemplate[template]
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		fmt.Printf("  %s\n", template)
+		fmt.Printf("    count: %d, p50: %s, p99: %s\n", len(durations),
+			percentile(durations, 0.50).Round(time.Microsecond),
+			percentile(durations, 0.99).Round(time.Microsecond))
+	}
+}
+
+// ownershipSummary splits results by whether they touched shared keys
+func ownershipSummary(results []TestResult) map[string]KeyClassSummary {
+	durations := make(map[string][]time.Duration)
+	summary := make(map[string]KeyClassSummary)
+	for _, r := range results {
+		class := "owned"
+		if r.Shared {
+			class = "shared"
+		}
+		c := summary[class]
+		c.Operations++
+		if !r.Success {
+			c.Errors++
+		}
+		summary[class] = c
+		if r.measured() {
+			durations[class] = append(durations[class], r.Duration)
+		}
+	}
+	for class, c := range summary {
+		d := durations[class]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		c.P50Ms = float64(percentile(d, 0.50)) / float64(time.Millisecond)
+		c.P99Ms = float64(percentile(d, 0.99)) / float64(time.Millisecond)
+		summary[class] = c
+	}
+	return summary
+}
+
+// printOwnership prints latency and errors for owned and shared keys,
+// whose contention characteristics differ
+func printOwnership(results []TestResult) {
+	summary := ownershipSummary(results)
+	fmt.Println("\nKey Ownership:")
+	for _, class := range []string{"owned", "shared"} {
+		c, ok := summary[class]
+		if !ok {
+			continue
+		}
+		fmt.Printf("  %s: %d operations, errors: %d (%.1f%%), p50: %.2fms, p99: %.2fms\n",
+			class, c.Operations, c.Errors, float64(c.Errors)/float64(c.Operations)*100,
+			c.P50Ms, c.P99Ms)
+	}
+}
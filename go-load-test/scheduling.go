@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Operation describes where one KV operation spent its time, reported to
+// ConnObserver.Operation. Total runs from the call to the response;
+// whatever is neither lock wait nor wire time went to reconnecting.
+type Operation struct {
+	// LockWait is the time spent waiting for the client mutex, which in
+	// shared mode means queueing behind other users' operations
+	LockWait time.Duration
+	// Wire is the time from sending the command to reading its response
+	Wire  time.Duration
+	Total time.Duration
+	Err   error
+}
+
+// schedulingMetrics collects successful operations for the Scheduling
+// section. It is guarded by ConnMetrics.mu.
+type schedulingMetrics struct {
+	lockWaits []time.Duration
+	wires     []time.Duration
+	lockWait  time.Duration
+	total     time.Duration
+}
+
+// SchedulingSummary condenses schedulingMetrics for printing and JSON
+// output
+type SchedulingSummary struct {
+	Operations    int     `json:"operations"`
+	LockWaitP50Ms float64 `json:"lock_wait_p50_ms"`
+	LockWaitP99Ms float64 `json:"lock_wait_p99_ms"`
+	WireP50Ms     float64 `json:"wire_p50_ms"`
+	WireP99Ms     float64 `json:"wire_p99_ms"`
+	// QueueingShare is the fraction of total operation time spent waiting
+	// for the client mutex
+	QueueingShare float64 `json:"queueing_share"`
+}
+
+// Operation implements ConnObserver
+func (m *ConnMetrics) Operation(op Operation) {
+	if op.Err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &m.scheduling
+	s.lockWaits = append(s.lockWaits, op.LockWait)
+	s.wires = append(s.wires, op.Wire)
+	s.lockWait += op.LockWait
+	s.total += op.Total
+}
+
+// ResetScheduling discards the operations recorded so far, so warmup does
+// not count toward the Scheduling section
+func (m *ConnMetrics) ResetScheduling() {
+	m.mu.Lock()
+	m.scheduling = schedulingMetrics{}
+	m.mu.Unlock()
+}
+
+// Scheduling returns the scheduling metrics collected so far
+func (m *ConnMetrics) Scheduling() SchedulingSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &m.scheduling
+
+	lockWaits := sortedDurations(s.lockWaits)
+	wires := sortedDurations(s.wires)
+	summary := SchedulingSummary{
+		Operations:    len(s.lockWaits),
+		LockWaitP50Ms: durationMs(percentile(lockWaits, 0.50)),
+		LockWaitP99Ms: durationMs(percentile(lockWaits, 0.99)),
+		WireP50Ms:     durationMs(percentile(wires, 0.50)),
+		WireP99Ms:     durationMs(percentile(wires, 0.99)),
+	}
+	if s.total > 0 {
+		summary.QueueingShare = float64(s.lockWait) / float64(s.total)
+	}
+	return summary
+}
+
+// reportsScheduling reports whether the run has a Scheduling section. Pool
+// mode queues for a free connection before the operation rather than on
+// the client mutex, so its lock wait would always read zero.
+func (lt *LoadTest) reportsScheduling() bool {
+	return lt.config.Mode != ModePool && lt.scheduling.Operations > 0
+}
+
+// printScheduling prints the Scheduling section of the report
+func printScheduling(s SchedulingSummary) {
+	fmt.Println("\nScheduling:")
+	fmt.Printf("Operations: %d\n", s.Operations)
+	fmt.Printf("Lock wait: p50 %.3fms, p99 %.3fms\n", s.LockWaitP50Ms, s.LockWaitP99Ms)
+	fmt.Printf("Wire time: p50 %.3fms, p99 %.3fms\n", s.WireP50Ms, s.WireP99Ms)
+	fmt.Printf("Client-side queueing: %.1f%% of operation time\n", s.QueueingShare*100)
+}
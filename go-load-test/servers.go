@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ServerSummary holds the throughput, errors and latency of the users
+// assigned to one server
+type ServerSummary struct {
+	Users      int     `json:"users"`
+	Operations int     `json:"operations"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Throughput float64 `json:"throughput_ops_per_sec"`
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+}
+
+// targets returns the servers under test
+func (lt *LoadTest) targets() []string {
+	return configTargets(lt.config)
+}
+
+// configTargets returns the servers config tests: the --servers list, or
+// the single --server
+func configTargets(config TestConfig) []string {
+	if len(config.Servers) > 0 {
+		return config.Servers
+	}
+	return []string{config.ServerAddr}
+}
+
+// serverFor returns the server userID is assigned to. Users are spread
+// round-robin by index, so every server gets the same share give or take
+// one.
+func (lt *LoadTest) serverFor(userID int) string {
+	targets := lt.targets()
+	return targets[userID%len(targets)]
+}
+
+// multiServer reports whether the run spreads users over several servers
+func (lt *LoadTest) multiServer() bool {
+	return len(lt.config.Servers) > 1
+}
+
+// serverSummary breaks the results down by server
+func (lt *LoadTest) serverSummary(results []TestResult) map[string]ServerSummary {
+	durations := make(map[string][]time.Duration)
+	summary := make(map[string]ServerSummary)
+	for userID := 0; userID < lt.config.NumUsers; userID++ {
+		s := summary[lt.serverFor(userID)]
+		s.Users++
+		summary[lt.serverFor(userID)] = s
+	}
+	for _, r := range results {
+		s := summary[r.Server]
+		s.Operations++
+		if !r.Success {
+			s.Errors++
+		}
+		summary[r.Server] = s
+		if r.measured() {
+			durations[r.Server] = append(durations[r.Server], r.Duration)
+		}
+	}
+	for server, s := range summary {
+		d := sortedDurations(durations[server])
+		s.P50Ms = durationMs(percentile(d, 0.50))
+		s.P99Ms = durationMs(percentile(d, 0.99))
+		if s.Operations > 0 {
+			s.ErrorRate = float64(s.Errors) / float64(s.Operations)
+		}
+		if lt.elapsed > 0 {
+			s.Throughput = float64(s.Operations) / lt.elapsed.Seconds()
+		}
+		summary[server] = s
+	}
+	return summary
+}
+
+// printServers prints the per-server breakdown of a multi-server run, in
+// the order the servers were given
+func (lt *LoadTest) printServers(results []TestResult) {
+	summary := lt.serverSummary(results)
+	fmt.Println("\nServers:")
+	for _, server := range lt.targets() {
+		s := summary[server]
+		fmt.Printf("  %s: %d users, %d operations, %.2f ops/sec, errors: %d (%.1f%%), p50: %.2fms, p99: %.2fms\n",
+			server, s.Users, s.Operations, s.Throughput, s.Errors, s.ErrorRate*100,
+			s.P50Ms, s.P99Ms)
+	}
+}
+
+// termEvents returns the TERMs seen on the shared connections, in time
+// order
+func (lt *LoadTest) termEvents() []TermEvent {
+	var events []TermEvent
+	for _, shared := range lt.shared {
+		events = append(events, shared.Events()...)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events
+}
+
+// terminated reports whether a server was lost for good
+func (lt *LoadTest) terminated() bool {
+	for _, shared := range lt.shared {
+		if shared.Terminated() {
+			return true
+		}
+	}
+	return false
+}
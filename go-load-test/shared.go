@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shrmpl"
+)
+
+// ErrServerTerminated is returned for operations started after the shared
+// connection was lost to a TERM and could not be re-established
+var ErrServerTerminated = errors.New("server terminated")
+
+// The coordinated reconnect after a TERM retries dialing every
+// termRetryInterval for up to termReconnectWindow, which covers a quick
+// restart
+const (
+	termReconnectWindow = 5 * time.Second
+	termRetryInterval   = 250 * time.Millisecond
+)
+
+// TermEvent records a TERM received on the shared connection
+type TermEvent struct {
+	At time.Time
+	// Reconnected is false when the server could not be reached again
+	// within termReconnectWindow and the run was stopped
+	Reconnected bool
+	// Downtime is how long the reconnect took
+	Downtime time.Duration
+}
+
+// SharedClient guards the single connection of shared mode against server
+// shutdown. Without it a TERM fails the operation in flight and every
+// later operation of every user fails again on its own, which buries the
+// run in identical errors. Instead the first operation to see TERM makes
+// one coordinated reconnect while new operations wait for its outcome. If
+// the server does not come back, stop is called and further operations
+// fail fast with ErrServerTerminated.
+type SharedClient struct {
+	kv   *KV
+	stop func()
+
+	mu sync.Mutex
+	// reconnecting is closed when the reconnect in progress finishes; nil
+	// when none is
+	reconnecting chan struct{}
+	events       []TermEvent
+	dead         atomic.Bool
+}
+
+// NewSharedClient wraps the shared connection; stop is called once if the
+// server is lost for good
+func NewSharedClient(kv *KV, stop func()) *SharedClient {
+	return &SharedClient{kv: kv, stop: stop}
+}
+
+// Events returns the TERMs seen so far
+func (s *SharedClient) Events() []TermEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TermEvent(nil), s.events...)
+}
+
+// Terminated reports whether the server was lost for good
+func (s *SharedClient) Terminated() bool {
+	return s.dead.Load()
+}
+
+// wait blocks while a reconnect is in progress and reports whether
+// operations may proceed
+func (s *SharedClient) wait() error {
+	s.mu.Lock()
+	reconnecting := s.reconnecting
+	s.mu.Unlock()
+	if reconnecting != nil {
+		<-reconnecting
+	}
+	if s.dead.Load() {
+		return ErrServerTerminated
+	}
+	return nil
+}
+
+// observe starts the coordinated reconnect when err is a TERM, unless one
+// is already running
+func (s *SharedClient) observe(err error) {
+	if !errors.Is(err, shrmpl.ErrServerTerminating) || s.dead.Load() {
+		return
+	}
+	s.mu.Lock()
+	if s.reconnecting != nil {
+		s.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	s.reconnecting = done
+	s.mu.Unlock()
+
+	event := TermEvent{At: time.Now()}
+	deadline := event.At.Add(termReconnectWindow)
+	for {
+		if s.kv.Connected() {
+			event.Reconnected = true
+			break
+		}
+		if time.Now().Add(termRetryInterval).After(deadline) {
+			break
+		}
+		time.Sleep(termRetryInterval)
+	}
+	event.Downtime = time.Since(event.At)
+
+	if !event.Reconnected {
+		s.dead.Store(true)
+		s.stop()
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.reconnecting = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+// Get retrieves a value over the shared connection
+func (s *SharedClient) Get(key string) (string, error) {
+	return s.getTraced(key, nil)
+}
+
+// getTraced is Get, adding the heartbeat reads of the call to t
+func (s *SharedClient) getTraced(key string, t *opTrace) (string, error) {
+	if err := s.wait(); err != nil {
+		return "", err
+	}
+	val, err := s.kv.getTraced(key, t)
+	s.observe(err)
+	return val, err
+}
+
+// Set stores a key-value pair over the shared connection
+func (s *SharedClient) Set(key, value, ttl string) error {
+	return s.setTraced(key, value, ttl, nil)
+}
+
+// setTraced is Set, adding the heartbeat reads of the call to t
+func (s *SharedClient) setTraced(key, value, ttl string, t *opTrace) error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	err := s.kv.setTraced(key, value, ttl, t)
+	s.observe(err)
+	return err
+}
+
+// Incr increments a counter over the shared connection
+func (s *SharedClient) Incr(key string, ttl string) (int, error) {
+	return s.incrTraced(key, ttl, nil)
+}
+
+// incrTraced is Incr, adding the heartbeat reads of the call to t
+func (s *SharedClient) incrTraced(key string, ttl string, t *opTrace) (int, error) {
+	if err := s.wait(); err != nil {
+		return 0, err
+	}
+	val, err := s.kv.incrTraced(key, ttl, t)
+	s.observe(err)
+	return val, err
+}
+
+// Batch executes commands over the shared connection
+func (s *SharedClient) Batch(commands []string) ([]string, error) {
+	return s.batchTraced(commands, nil)
+}
+
+// batchTraced is Batch, adding the heartbeat reads of the call to t
+func (s *SharedClient) batchTraced(commands []string, t *opTrace) ([]string, error) {
+	if err := s.wait(); err != nil {
+		return nil, err
+	}
+	results, err := s.kv.batchTraced(commands, t)
+	s.observe(err)
+	return results, err
+}
+
+// DropConnection closes the shared connection, as an injected disconnect
+func (s *SharedClient) DropConnection() {
+	s.kv.DropConnection()
+}
+
+// LockWait returns the time spent waiting for the shared connection
+func (s *SharedClient) LockWait() time.Duration {
+	return s.kv.LockWait()
+}
+
+// Reconnects returns how often the shared connection was re-established
+func (s *SharedClient) Reconnects() int {
+	return s.kv.Reconnects()
+}
+
+// Close closes the shared connection
+func (s *SharedClient) Close() {
+	s.kv.Close()
+}
+
+// printTermEvents describes the TERMs seen on the shared connection
+func printTermEvents(events []TermEvent, operations int) {
+	for _, e := range events {
+		at := e.At.Format("15:04:05.000")
+		if e.Reconnected {
+			fmt.Printf("Server restart: TERM at %s, reconnected after %s\n",
+				at, e.Downtime.Round(time.Millisecond))
+			continue
+		}
+		fmt.Printf("Server terminated: TERM at %s, no reconnect within %s; "+
+			"run stopped early after %d operations\n",
+			at, termReconnectWindow, operations)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Server limits enforced when validating templates
+const (
+	maxBatchCommands = 3
+	maxKeyLength     = 100
+	maxValueLength   = 100
+)
+
+// defaultBatchTemplate is the batch sent when no template is configured
+const defaultBatchTemplate = "GET loginlock-ip-123;GET loginlock-user-abc"
+
+// placeholderPattern matches {seq}, {user}, {key}, {shared} and {rand:N}
+var placeholderPattern = regexp.MustCompile(`\{(seq|user|key|shared|rand:(\d+))\}`)
+
+// widestKeyIndex stands in for {key} and {shared} when checking lengths
+const widestKeyIndex = "9223372036854775807"
+
+// BatchTemplate is a BATCH composition whose placeholders are expanded
+// per operation
+type BatchTemplate struct {
+	Source   string
+	commands []string
+	shared   bool
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// ParseBatchTemplate parses a ';'-separated template and validates that
+// every expansion respects the server's batch and key-length limits for
+// the given number of users and operations
+func ParseBatchTemplate(source string, numUsers, operations int) (BatchTemplate, error) {
+	t := BatchTemplate{Source: source}
+	for _, cmd := range strings.Split(source, ";") {
+		cmd = strings.TrimSpace(cmd)
+		if cmd != "" {
+			t.commands = append(t.commands, cmd)
+		}
+	}
+	if len(t.commands) == 0 {
+		return t, fmt.Errorf("template %q has no commands", source)
+	}
+	if len(t.commands) > maxBatchCommands {
+		return t, fmt.Errorf("template %q has %d commands, the server allows %d",
+			source, len(t.commands), maxBatchCommands)
+	}
+
+	// Render with the widest possible substitutions to check lengths
+	widest := func(match string) string {
+		sub := placeholderPattern.FindStringSubmatch(match)
+		switch {
+		case sub[1] == "seq":
+			return strconv.Itoa(operations)
+		case sub[1] == "user":
+			return strconv.Itoa(numUsers)
+		case sub[1] == "key", sub[1] == "shared":
+			return widestKeyIndex
+		default:
+			return sub[2]
+		}
+	}
+	for _, cmd := range t.commands {
+		if strings.Contains(cmd, "{shared}") {
+			t.shared = true
+		}
+		parts := strings.Fields(placeholderPattern.ReplaceAllStringFunc(cmd, widest))
+		switch parts[0] {
+		case "GET", "SET", "INCR", "DEL":
+		default:
+			return t, fmt.Errorf("template %q: unsupported command %s", source, parts[0])
+		}
+		if len(parts) < 2 {
+			return t, fmt.Errorf("template %q: %s requires a key", source, parts[0])
+		}
+		for _, arg := range parts[1:] {
+			if len(arg) > maxKeyLength {
+				return t, fmt.Errorf("template %q: %q exceeds %d characters",
+					source, arg, maxKeyLength)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Shared reports whether the template touches the shared key pool
+func (t BatchTemplate) Shared() bool {
+	return t.shared
+}
+
+// Render expands the template's placeholders for one operation. {key} is
+// the user's owned key for seq and {shared} a random shared key.
+func (t BatchTemplate) Render(seq, user int, keys KeySpace, rng *rand.Rand) []string {
+	rendered := make([]string, len(t.commands))
+	for i, cmd := range t.commands {
+		rendered[i] = placeholderPattern.ReplaceAllStringFunc(cmd, func(match string) string {
+			sub := placeholderPattern.FindStringSubmatch(match)
+			switch {
+			case sub[1] == "seq":
+				return strconv.Itoa(seq)
+			case sub[1] == "user":
+				return strconv.Itoa(user)
+			case sub[1] == "key":
+				return strconv.FormatInt(keys.Index(user, seq), 10)
+			case sub[1] == "shared":
+				return strconv.FormatInt(keys.Shared(rng), 10)
+			default:
+				n, _ := strconv.Atoi(sub[2])
+				if n <= 0 {
+					return "0"
+				}
+				return strconv.Itoa(rng.Intn(n))
+			}
+		})
+	}
+	return rendered
+}
+
+// Verify checks each slot's result against what its command should return
+func (t BatchTemplate) Verify(commands, results []string) error {
+	if len(results) != len(commands) {
+		return fmt.Errorf("expected %d results, got %d", len(commands), len(results))
+	}
+	for i, cmd := range commands {
+		result := results[i]
+		if strings.HasPrefix(result, "ERROR") {
+			return fmt.Errorf("slot %d (%s): %s", i, cmd, result)
+		}
+		switch strings.Fields(cmd)[0] {
+		case "INCR":
+			if _, err := strconv.Atoi(result); err != nil {
+				return fmt.Errorf("slot %d (%s): expected integer, got %s", i, cmd, result)
+			}
+		case "SET":
+			if result != "OK" && !strings.HasPrefix(result, "OK ") {
+				return fmt.Errorf("slot %d (%s): expected OK, got %s", i, cmd, result)
+			}
+		case "DEL":
+			if result != "OK" && result != "*KEY NOT FOUND*" {
+				return fmt.Errorf("slot %d (%s): expected OK, got %s", i, cmd, result)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Thresholds are pass/fail limits checked against each run's summary.
+// Zero values disable a check; MaxErrorRate is a pointer because a limit
+// of zero errors is meaningful.
+type Thresholds struct {
+	MaxErrorRate  *float64
+	MaxP99        time.Duration
+	MinThroughput float64
+}
+
+// enabled reports whether any threshold is set
+func (t Thresholds) enabled() bool {
+	return t.MaxErrorRate != nil || t.MaxP99 > 0 || t.MinThroughput > 0
+}
+
+// Check returns a description of every threshold s violates
+func (t Thresholds) Check(s RunSummary) []string {
+	var failures []string
+	if t.MaxErrorRate != nil && s.ErrorRate > *t.MaxErrorRate {
+		failures = append(failures, fmt.Sprintf("error rate %.2f%% above max %.2f%%",
+			s.ErrorRate*100, *t.MaxErrorRate*100))
+	}
+	maxP99Ms := float64(t.MaxP99) / float64(time.Millisecond)
+	if t.MaxP99 > 0 && s.P99Ms > maxP99Ms {
+		failures = append(failures, fmt.Sprintf("p99 %.2fms above max %.2fms", s.P99Ms, maxP99Ms))
+	}
+	if t.MinThroughput > 0 && s.Throughput < t.MinThroughput {
+		failures = append(failures, fmt.Sprintf("throughput %.0f op/s below min %.0f op/s",
+			s.Throughput, t.MinThroughput))
+	}
+	return failures
+}
+
+// checkThresholds records threshold failures in every run and prints
+// them, returning false if any run failed
+func checkThresholds(t Thresholds, runs []RunSummary) bool {
+	passed := true
+	fmt.Println("\nThresholds:")
+	for i := range runs {
+		runs[i].ThresholdFailures = t.Check(runs[i])
+		if len(runs[i].ThresholdFailures) == 0 {
+			fmt.Printf("  %s: pass\n", runs[i].Mode)
+			continue
+		}
+		passed = false
+		for _, f := range runs[i].ThresholdFailures {
+			fmt.Printf("  %s: FAIL %s\n", runs[i].Mode, f)
+		}
+	}
+	return passed
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportVersion is the result file schema written by this version.
+// Version 0 files predate the version and timestamp fields.
+const reportVersion = 1
+
+// trendFile is the name of the trend output written into the archive
+// directory; it is skipped when loading results
+const trendFile = "trend.json"
+
+// TrendPoint is one run in the trend, with changes relative to the
+// previous run in the same mode. Changes are nil for the first run, when
+// the previous value was zero and when the configuration hashes differ.
+// Mismatch lists the metadata fields that differ from the previous run,
+// for files that both carry metadata.
+type TrendPoint struct {
+	File             string    `json:"file"`
+	Timestamp        time.Time `json:"timestamp"`
+	Mode             string    `json:"mode"`
+	Throughput       float64   `json:"throughput_ops_per_sec"`
+	P50Ms            float64   `json:"p50_ms"`
+	P99Ms            float64   `json:"p99_ms"`
+	ErrorRate        float64   `json:"error_rate"`
+	ThroughputChange *float64  `json:"throughput_change_pct,omitempty"`
+	P50Change        *float64  `json:"p50_change_pct,omitempty"`
+	P99Change        *float64  `json:"p99_change_pct,omitempty"`
+	ErrorRateChange  *float64  `json:"error_rate_change_pct,omitempty"`
+	Mismatch         []string  `json:"mismatch,omitempty"`
+
+	metadata *Metadata
+}
+
+// Trend is the document written to trend.json
+type Trend struct {
+	Version int          `json:"version"`
+	Points  []TrendPoint `json:"points"`
+}
+
+// loadReport reads a result file and migrates it to the current schema
+func loadReport(path string) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("not a result file: %v", err)
+	}
+	if len(report.Runs) == 0 && report.Comparison == nil {
+		return report, fmt.Errorf("not a result file: no runs")
+	}
+
+	switch report.Version {
+	case 0:
+		// Version 0 files carry no timestamp; the file time is the best
+		// approximation of when the run finished
+		info, err := os.Stat(path)
+		if err != nil {
+			return report, err
+		}
+		report.Timestamp = info.ModTime()
+		report.Version = reportVersion
+	case reportVersion:
+	default:
+		return report, fmt.Errorf("unsupported result version %d", report.Version)
+	}
+	return report, nil
+}
+
+// percentChange returns the change from prev to cur in percent
+func percentChange(prev, cur float64) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	change := (cur - prev) / prev * 100
+	return &change
+}
+
+// buildTrend loads every result file in dir, skipping incompatible ones
+// with a warning, and orders the runs by timestamp
+func buildTrend(dir string) (Trend, error) {
+	trend := Trend{Version: reportVersion}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return trend, err
+	}
+
+	for _, path := range paths {
+		if filepath.Base(path) == trendFile {
+			continue
+		}
+		report, err := loadReport(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+			continue
+		}
+		runs := report.Runs
+		if report.Comparison != nil {
+			runs = append(runs, report.Comparison.Runs...)
+		}
+		for _, r := range runs {
+			trend.Points = append(trend.Points, TrendPoint{
+				File:       filepath.Base(path),
+				Timestamp:  report.Timestamp,
+				Mode:       r.Mode,
+				Throughput: r.Throughput,
+				P50Ms:      r.P50Ms,
+				P99Ms:      r.P99Ms,
+				ErrorRate:  r.ErrorRate,
+				metadata:   report.Metadata,
+			})
+		}
+	}
+
+	sort.SliceStable(trend.Points, func(i, j int) bool {
+		return trend.Points[i].Timestamp.Before(trend.Points[j].Timestamp)
+	})
+	previous := make(map[string]TrendPoint)
+	for i := range trend.Points {
+		p := &trend.Points[i]
+		if prev, ok := previous[p.Mode]; ok {
+			p.Mismatch = metadataDifferences(prev.metadata, p.metadata)
+			if prev.metadata != nil && p.metadata != nil &&
+				prev.metadata.ConfigHash != p.metadata.ConfigHash {
+				// Deltas between different workloads would mislead
+				previous[p.Mode] = *p
+				continue
+			}
+			p.ThroughputChange = percentChange(prev.Throughput, p.Throughput)
+			p.P50Change = percentChange(prev.P50Ms, p.P50Ms)
+			p.P99Change = percentChange(prev.P99Ms, p.P99Ms)
+			p.ErrorRateChange = percentChange(prev.ErrorRate, p.ErrorRate)
+		}
+		previous[p.Mode] = *p
+	}
+	return trend, nil
+}
+
+// formatChange renders a change column entry
+func formatChange(change *float64) string {
+	if change == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f%%)", *change)
+}
+
+// printTrend prints one row per run with changes versus the previous run
+// in the same mode
+func printTrend(trend Trend) {
+	fmt.Println("Trend:")
+	fmt.Printf("%-20s %-7s %22s %20s %20s %18s\n",
+		"Timestamp", "Mode", "Throughput", "p50", "p99", "Error rate")
+	for _, p := range trend.Points {
+		fmt.Printf("%-20s %-7s %22s %20s %20s %18s\n",
+			p.Timestamp.Local().Format("2006-01-02 15:04:05"), p.Mode,
+			fmt.Sprintf("%.0f op/s%s", p.Throughput, formatChange(p.ThroughputChange)),
+			fmt.Sprintf("%.2fms%s", p.P50Ms, formatChange(p.P50Change)),
+			fmt.Sprintf("%.2fms%s", p.P99Ms, formatChange(p.P99Change)),
+			fmt.Sprintf("%.1f%%%s", p.ErrorRate*100, formatChange(p.ErrorRateChange)))
+		if len(p.Mismatch) > 0 {
+			fmt.Printf("  ! differs from the previous %s run in %s\n",
+				p.Mode, strings.Join(p.Mismatch, ", "))
+		}
+	}
+}
+
+// runTrend prints the trend for the result files in dir and writes it to
+// dir/trend.json
+func runTrend(dir string) error {
+	trend, err := buildTrend(dir)
+	if err != nil {
+		return err
+	}
+	if len(trend.Points) == 0 {
+		return fmt.Errorf("no result files found in %s", dir)
+	}
+	printTrend(trend)
+
+	data, err := json.MarshalIndent(trend, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, trendFile), append(data, '\n'), 0o644)
+}
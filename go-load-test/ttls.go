@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// defaultTTL is the expiration of the full test's SET with TTL
+const defaultTTL = "60s"
+
+// TTLChoice is one expiration of a TTL distribution and its relative weight
+type TTLChoice struct {
+	TTL    string
+	Weight float64
+}
+
+func (t TTLChoice) String() string {
+	if t.Weight == 1 {
+		return t.TTL
+	}
+	return fmt.Sprintf("%s:%s", t.TTL, strconv.FormatFloat(t.Weight, 'f', -1, 64))
+}
+
+// validTTL reports whether ttl is in a form the server accepts: a whole
+// number of seconds, minutes or hours such as 30s, 5min or 2h
+func validTTL(ttl string) bool {
+	for _, unit := range []string{"min", "s", "h"} {
+		if n, ok := strings.CutSuffix(ttl, unit); ok {
+			v, err := strconv.ParseUint(n, 10, 32)
+			return err == nil && v > 0
+		}
+	}
+	return false
+}
+
+// ParseTTLs parses a TTL distribution such as "10s:50,5min:30,1h:20". The
+// weight after each TTL is relative and defaults to 1.
+func ParseTTLs(spec string) ([]TTLChoice, error) {
+	var ttls []TTLChoice
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ttl, weight, hasWeight := strings.Cut(part, ":")
+		if !validTTL(ttl) {
+			return nil, fmt.Errorf("ttl %q: expected a whole number of s, min or h", ttl)
+		}
+		choice := TTLChoice{TTL: ttl, Weight: 1}
+		if hasWeight {
+			w, err := strconv.ParseFloat(weight, 64)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("ttl %q: invalid weight %q", part, weight)
+			}
+			choice.Weight = w
+		}
+		ttls = append(ttls, choice)
+	}
+	if len(ttls) == 0 {
+		return nil, fmt.Errorf("no TTLs in %q", spec)
+	}
+	return ttls, nil
+}
+
+// pickTTL chooses a TTL by weight. A single TTL does not draw from rng, so
+// runs without a distribution sample exactly as before.
+func pickTTL(ttls []TTLChoice, rng *rand.Rand) string {
+	if len(ttls) == 0 {
+		return defaultTTL
+	}
+	if len(ttls) == 1 {
+		return ttls[0].TTL
+	}
+	total := 0.0
+	for _, t := range ttls {
+		total += t.Weight
+	}
+	r := rng.Float64() * total
+	for _, t := range ttls {
+		if r < t.Weight {
+			return t.TTL
+		}
+		r -= t.Weight
+	}
+	return ttls[len(ttls)-1].TTL
+}